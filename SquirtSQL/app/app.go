@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"os"
-	"sort"
 	"strings"
 	"v4/database"
 	"v4/database/actions"
@@ -27,14 +26,15 @@ func init() {
 
 type App struct {
 	DB      *actions.Database
-	Storage *storage.CSVStorage
+	Storage *storage.Storage
+
+	tx *actions.Database
 }
 
-func NewApp() *App {
-	stor := storage.NewCSVStorage("data")
+func NewApp(stor *storage.Storage) *App {
 	db := actions.NewDatabase(stor)
 
-	fmt.Println(bold("SQUIRTSQL - простая база данных на основе CSV"))
+	fmt.Println(bold("SQUIRTSQL - простая база данных"))
 	fmt.Println("Введите", helpColor("/help"), "для просмотра функционала")
 	fmt.Println("Введите", exitColor("exit"), "чтобы выйти")
 	fmt.Println("---------------------------------------------")
@@ -63,6 +63,26 @@ func (a *App) Run() {
 		}
 
 		a.handleQuery(input)
+		if err := a.DB.MaybeCheckpoint(); err != nil {
+			fmt.Printf("Error контрольной точки: %v\n", err)
+		}
+	}
+	a.Close()
+}
+
+// Close discards an unfinished transaction and writes a final checkpoint
+// so that the next start does not have to replay the log.
+func (a *App) Close() {
+	if a.tx != nil {
+		_ = a.tx.Rollback()
+		a.tx = nil
+		fmt.Println("Незавершённая транзакция отменена")
+	}
+	if err := a.DB.Checkpoint(); err != nil {
+		fmt.Printf("Error контрольной точки: %v\n", err)
+	}
+	if err := a.Storage.Close(); err != nil {
+		fmt.Printf("Error: %v\n", err)
 	}
 }
 
@@ -78,12 +98,34 @@ func (a *App) handleQuery(input string) {
 		a.HandleCreateTable(query)
 	case parser.QuerySelect:
 		a.handleSelect(query)
+	case parser.QueryExplain:
+		a.handleExplain(query)
 	case parser.QueryUpdate:
 		a.handleUpdate(query)
 	case parser.QueryInsert:
 		a.handleInsert(query)
 	case parser.QueryDelete:
 		a.handleDelete(query)
+	case parser.QueryCreateIndex:
+		a.handleCreateIndex(query)
+	case parser.QueryDropIndex:
+		a.handleDropIndex(query)
+	case parser.QueryAlterTable:
+		a.handleAlterTable(query)
+	case parser.QueryDropTable:
+		a.handleDropTable(query)
+	case parser.QueryTruncate:
+		a.handleTruncate(query)
+	case parser.QueryShowTables:
+		a.handleShowTables()
+	case parser.QueryDescribe:
+		a.handleDescribe(query)
+	case parser.QueryBegin:
+		a.handleBegin()
+	case parser.QueryCommit:
+		a.handleCommit()
+	case parser.QueryRollback:
+		a.handleRollback()
 	case parser.QueryHelp:
 		a.handleHelp()
 	default:
@@ -91,178 +133,378 @@ func (a *App) handleQuery(input string) {
 	}
 }
 
-func (a *App) HandleCreateTable(query *parser.Query) {
-	if a.Storage.TableExist(query.Table) {
-		fmt.Printf("Error: таблица %s уже существует\n", query.Table)
+// db returns the active transaction, if any, so that its changes stay
+// buffered until COMMIT.
+func (a *App) db() *actions.Database {
+	if a.tx != nil {
+		return a.tx
+	}
+	return a.DB
+}
+
+// tableExist reports whether the table is visible to the active
+// transaction. All tables are loaded by LoadTables at start, so a table
+// that is not in memory does not exist, e.g. after DROP TABLE in a
+// transaction.
+func (a *App) tableExist(name string) bool {
+	_, exist := a.db().Tables[name]
+	return exist
+}
+
+func (a *App) handleBegin() {
+	if a.tx != nil {
+		fmt.Printf("Error: %v\n", actions.ErrNestedTransaction)
 		return
 	}
-	err := a.DB.CreateTable(query.Table, query.Fields)
+	tx, err := a.DB.Begin()
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
+	a.tx = tx
+	fmt.Println("Транзакция начата")
+}
 
-	table, _ := a.DB.SelectAll(query.Table)
-	err = a.Storage.SaveTable(&database.Table{
-		NextID:  1,
-		Name:    query.Table,
-		Fields:  query.Fields,
-		Records: table,
-	})
+func (a *App) handleCommit() {
+	if a.tx == nil {
+		fmt.Printf("Error: %v\n", actions.ErrNoTransaction)
+		return
+	}
+	tx := a.tx
+	a.tx = nil
+	if err := tx.Commit(); err != nil {
+		fmt.Printf("Error: транзакция не применена: %v\n", err)
+		return
+	}
+	fmt.Println("Транзакция зафиксирована")
+}
+
+func (a *App) handleRollback() {
+	if a.tx == nil {
+		fmt.Printf("Error: %v\n", actions.ErrNoTransaction)
+		return
+	}
+	_ = a.tx.Rollback()
+	a.tx = nil
+	fmt.Println("Транзакция отменена")
+}
+
+func (a *App) HandleCreateTable(query *parser.Query) {
+	if a.tableExist(query.Table) {
+		fmt.Printf("Error: таблица %s уже существует\n", query.Table)
+		return
+	}
+	columns := query.Columns
+	if columns == nil {
+		columns = database.TextColumns(query.Fields)
+	}
+	err := a.db().CreateTableSchema(query.Table, columns)
 	if err != nil {
-		fmt.Printf("Error: таблица не сохранена: %v\n", err)
-	} else {
-		fmt.Println("Таблица успешно создана")
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
+
+	fmt.Println("Таблица успешно создана")
 }
 
 func (a *App) handleSelect(query *parser.Query) {
-	if !a.Storage.TableExist(query.Table) {
-		fmt.Printf("Error: таблица %s не найдена\n", query.Table)
+	if !a.requireQueryTables(query) {
 		return
 	}
 
-	if _, exist := a.DB.SelectAll(query.Table); exist != nil {
-		table, err := a.Storage.LoadTable(query.Table)
-		if err != nil {
-			fmt.Printf("Error загрузки таблицы: %v\n", err)
-			return
-		}
-
-		_ = a.DB.CreateTable(table.Name, table.Fields)
-		for _, record := range table.Records {
-			values := make([]string, len(table.Fields))
-			for i, field := range table.Fields {
-				values[i] = record[field]
-			}
-			_, _ = a.DB.Insert(table.Name, values)
-		}
+	rows, err := a.db().Query(query)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
-	if query.ID == -1 {
-		records, err := a.DB.SelectAll(query.Table)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
-		}
-		if len(records) == 0 {
-			fmt.Println("Записи не найдены")
-			return
-		}
+	defer rows.Close()
 
-		ids := make([]int, 0, len(records))
-		for id := range records {
-			ids = append(ids, id)
-		}
-		sort.Ints(ids)
+	// Rows are printed as they are found, so a LIMIT stops the scan early.
+	printed := printRows(rows)
+	if err := rows.Err(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if printed == 0 {
+		fmt.Println("Записи не найдены")
+	}
+}
 
-		for _, id := range ids {
-			record := records[id]
-			fmt.Printf("%d: ", id)
+func (a *App) handleExplain(query *parser.Query) {
+	if !a.requireQueryTables(query) {
+		return
+	}
 
-			fields := make([]string, 0, len(record))
-			for fieldName := range record {
-				fields = append(fields, fieldName)
-			}
-			sort.Strings(fields)
+	plan, err := a.db().Explain(query)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	for _, line := range plan.Lines() {
+		fmt.Println(line)
+	}
+}
 
-			for i, fieldName := range fields {
-				fmt.Printf("%s:%s", fieldName, record[fieldName])
-				if i < len(fields)-1 {
-					fmt.Printf(" ")
-				}
-			}
-			fmt.Println()
-		}
-	} else {
-		record, err := a.DB.Select(query.Table, query.ID)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
-		}
-		if len(record) == 0 {
-			fmt.Println("Записи не найдены")
-			return
-		}
-		fields := make([]string, 0, len(record))
-		for fieldName := range record {
-			fields = append(fields, fieldName)
+func (a *App) requireQueryTables(query *parser.Query) bool {
+	tables := []string{query.Table}
+	for _, join := range query.Joins {
+		tables = append(tables, join.Table)
+	}
+	for _, name := range tables {
+		if !a.requireTable(name) {
+			return false
 		}
-		sort.Strings(fields)
-		fmt.Printf("%d: ", query.ID)
-		for i, fieldName := range fields {
-			fmt.Printf("%s: %s", fieldName, record[fieldName])
-			if i < len(fields)-1 {
+	}
+	return true
+}
+
+func (a *App) requireTable(name string) bool {
+	if !a.tableExist(name) {
+		fmt.Printf("Error: таблица %s не найдена\n", name)
+		return false
+	}
+	return true
+}
+
+func printRows(rows *actions.Rows) int {
+	n := 0
+	for ; rows.Next(); n++ {
+		row := rows.Row()
+		fmt.Printf("%d: ", row.ID)
+		for i, column := range rows.Columns {
+			fmt.Printf("%s:%s", column, row.Values[i])
+			if i < len(rows.Columns)-1 {
 				fmt.Printf(" ")
 			}
 		}
 		fmt.Println()
 	}
+	return n
 }
 
 func (a *App) handleUpdate(query *parser.Query) {
-	if !a.Storage.TableExist(query.Table) {
+	if !a.tableExist(query.Table) {
 		fmt.Printf("Error: таблица %s не найдена\n", query.Table)
 		return
 	}
-	err := a.DB.Update(query.Table, query.ID, query.Fields)
+	if query.ID == -1 {
+		a.handleUpdateWhere(query)
+		return
+	}
+	err := a.db().Update(query.Table, query.ID, query.Fields)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	table := a.DB.Tables[query.Table]
-	err = a.Storage.SaveTable(table)
+	fmt.Println("Таблица успешно сохранена")
+}
 
+func (a *App) handleUpdateWhere(query *parser.Query) {
+	count, err := a.db().UpdateWhere(query.Table, query.Set, query.Where)
 	if err != nil {
-		fmt.Printf("Error сохранения таблицы: %v\n", err)
-	} else {
-		fmt.Println("Таблица успешно сохранена")
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
+	fmt.Printf("Обновлено записей: %d\n", count)
 }
 
 func (a *App) handleInsert(query *parser.Query) {
-	if !a.Storage.TableExist(query.Table) {
+	if !a.tableExist(query.Table) {
 		fmt.Printf("Error: таблица %s не найдена\n", query.Table)
 		return
 	}
 
-	_, err := a.DB.Insert(query.Table, query.Fields)
+	if query.Values != nil {
+		a.handleInsertRows(query)
+		return
+	}
+
+	_, err := a.db().Insert(query.Table, query.Fields)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	table := a.DB.Tables[query.Table]
-	err = a.Storage.SaveTable(table)
+	fmt.Println("Данные успешно вставлены в таблицу")
+}
+
+func (a *App) handleInsertRows(query *parser.Query) {
+	ids, err := a.db().InsertRows(query.Table, query.Targets, query.Values)
 	if err != nil {
-		fmt.Printf("Error сохранения таблицы: %v\n", err)
-	} else {
-		fmt.Println("Данные успешно вставлены в таблицу")
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
+
+	fmt.Printf("Добавлено записей: %d\n", len(ids))
 }
 
 func (a *App) handleDelete(query *parser.Query) {
-	if !a.Storage.TableExist(query.Table) {
+	if !a.tableExist(query.Table) {
 		fmt.Printf("Error: таблица %s не найдена\n", query.Table)
 		return
 	}
-	err := a.DB.Delete(query.Table, query.ID)
+	if query.ID == -1 {
+		a.handleDeleteWhere(query)
+		return
+	}
+	err := a.db().Delete(query.Table, query.ID)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
 
-	table, _ := a.DB.SelectAll(query.Table)
-	err = a.Storage.SaveTable(&database.Table{
-		Name:    query.Table,
-		Fields:  a.DB.Tables[query.Table].Fields,
-		Records: table,
-	})
+	fmt.Println("Таблица успешно сохранена")
+}
+
+func (a *App) handleDeleteWhere(query *parser.Query) {
+	count, err := a.db().DeleteWhere(query.Table, query.Where)
 	if err != nil {
-		fmt.Printf("Error сохранения таблицы: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Удалено записей: %d\n", count)
+}
+
+func (a *App) handleCreateIndex(query *parser.Query) {
+	if !a.requireTable(query.Table) {
+		return
+	}
+	err := a.db().CreateIndex(query.Table, query.Index, query.Fields[0], query.Using)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Индекс %s создан\n", query.Index)
+}
+
+func (a *App) handleDropIndex(query *parser.Query) {
+	_, err := a.db().DropIndex(query.Index)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Индекс %s удалён\n", query.Index)
+}
+
+func (a *App) handleAlterTable(query *parser.Query) {
+	if !a.requireTable(query.Table) {
+		return
+	}
+
+	var err error
+	switch query.Alter {
+	case parser.AlterAddColumn:
+		err = a.db().AddColumn(query.Table, query.Columns[0])
+	case parser.AlterDropColumn:
+		err = a.db().DropColumn(query.Table, query.Fields[0])
+	case parser.AlterRenameColumn:
+		err = a.db().RenameColumn(query.Table, query.Fields[0], query.NewName)
+	case parser.AlterRenameTable:
+		err = a.db().RenameTable(query.Table, query.NewName)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	if !a.checkpointSchema() {
+		return
+	}
+	if query.Alter == parser.AlterRenameTable {
+		fmt.Printf("Таблица %s переименована в %s\n", query.Table, query.NewName)
 	} else {
-		fmt.Println("Таблица успешно сохранена")
+		fmt.Printf("Таблица %s изменена\n", query.Table)
+	}
+}
+
+// checkpointSchema rewrites the files right after a schema change instead
+// of waiting for the next checkpoint, so the disk matches the new schema.
+// Inside a transaction this happens after COMMIT.
+func (a *App) checkpointSchema() bool {
+	if a.tx != nil {
+		return true
+	}
+	if err := a.DB.Checkpoint(); err != nil {
+		fmt.Printf("Error сохранения таблицы: %v\n", err)
+		return false
+	}
+	return true
+}
+
+func (a *App) handleDropTable(query *parser.Query) {
+	if !a.tableExist(query.Table) {
+		if query.IfExists {
+			fmt.Printf("Таблица %s не существует, пропущено\n", query.Table)
+		} else {
+			fmt.Printf("Error: таблица %s не найдена\n", query.Table)
+		}
+		return
+	}
+	if !a.requireTable(query.Table) {
+		return
 	}
+	if err := a.db().DropTable(query.Table); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if !a.checkpointSchema() {
+		return
+	}
+	fmt.Printf("Таблица %s удалена\n", query.Table)
+}
+
+func (a *App) handleTruncate(query *parser.Query) {
+	if !a.requireTable(query.Table) {
+		return
+	}
+	count, err := a.db().Truncate(query.Table)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Удалено записей: %d\n", count)
+}
+
+func (a *App) handleShowTables() {
+	names := a.db().TableNames()
+	if len(names) == 0 {
+		fmt.Println("Таблицы не найдены")
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+func (a *App) handleDescribe(query *parser.Query) {
+	if !a.requireTable(query.Table) {
+		return
+	}
+	info, err := a.db().Describe(query.Table)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Таблица %s\n", info.Name)
+	fmt.Println("  id INT PRIMARY KEY")
+	for _, column := range info.Columns {
+		line := fmt.Sprintf("  %s %s", column.Name, column.Type)
+		if constraints := column.Constraints(); constraints != "" {
+			line += " " + constraints
+		}
+		fmt.Println(line)
+	}
+	if len(info.Indexes) > 0 {
+		fmt.Println("Индексы:")
+		for _, index := range info.Indexes {
+			fmt.Printf("  %s %s (%s)\n", index.Name, index.Kind, index.Column)
+		}
+	}
+	fmt.Printf("Записей: %d, NextID: %d\n", info.Rows, info.NextID)
 }
 
 func (a *App) handleHelp() {
@@ -270,31 +512,97 @@ func (a *App) handleHelp() {
 Доступные команды:
 
 1. Создание таблицы:
-   CREATE TABLE <имя_таблицы> <поле1>,<поле2>,...
-   Пример: CREATE TABLE users name,email,age
+   CREATE TABLE <имя_таблицы> <поле1> [тип],<поле2> [тип],...
+   Типы: TEXT (по умолчанию), INT, FLOAT, BOOL, DATE (ГГГГ-ММ-ДД)
+   Ограничения: NOT NULL, UNIQUE, DEFAULT <значение>, CHECK (<условие>)
+   Примеры:
+     CREATE TABLE users name,email,age
+     CREATE TABLE users name TEXT, age INT, score FLOAT, active BOOL, born DATE
+     CREATE TABLE users name TEXT NOT NULL, email UNIQUE, age INT DEFAULT 18 CHECK (age >= 0)
 
 2. Добавление данных:
    INSERT <имя_таблицы> <значение1>,<значение2>,...
-   Пример: INSERT users kolya,test@mail.ru,22
+   INSERT INTO <имя_таблицы> [(<поле1>,...)] VALUES (<значение1>,...),...
+   Пропущенные поля получают DEFAULT или пустое значение
+   Примеры:
+     INSERT users kolya,test@mail.ru,22
+     INSERT INTO users (name, age) VALUES ('a', 3), ('b', 4)
 
 3. Чтение данных:
-   SELECT <имя_таблицы> <id|*>
+   SELECT <имя_таблицы> <id|*> [WHERE <условие>]
    Примеры:
      SELECT users *       - все записи
      SELECT users 1       - запись с ID=1
+     SELECT users * WHERE age > 20 AND name = 'kolya'
+   SELECT <поле1>[ AS <псевдоним>],... FROM <имя_таблицы> [WHERE <условие>]
+   Примеры:
+     SELECT name,email FROM users
+     SELECT name AS имя, age FROM users WHERE age > 20
+   Сортировка и постраничный вывод:
+     ... ORDER BY <поле> [ASC|DESC],... LIMIT <n> OFFSET <m>
+     SELECT name FROM users ORDER BY age DESC, name LIMIT 10 OFFSET 20
+   Агрегаты и группировка:
+     COUNT(*), COUNT(<поле>), SUM, AVG, MIN, MAX
+     ... GROUP BY <поле>,... HAVING <условие>
+     SELECT city, COUNT(*) AS n, AVG(age) FROM users GROUP BY city HAVING COUNT(*) > 1 ORDER BY n DESC
+   Соединение таблиц:
+     ... FROM <таблица> [псевдоним] [INNER|LEFT] JOIN <таблица> [псевдоним] ON <условие>
+     SELECT u.name, o.total FROM users u LEFT JOIN orders o ON o.user_id = u.id
+   Условия: =, !=, <, <=, >, >=, LIKE, IN (...), AND, OR, NOT и скобки
+   План выполнения:
+     EXPLAIN SELECT ...   - шаги запроса с оценкой стоимости и числа записей
 
 4. Обновление данных:
    UPDATE <имя_таблицы> <id> <новое_значение1>,<новое_значение2>,...
-   Пример: UPDATE users 1 NewName,new@email.com,23
+   UPDATE <имя_таблицы> SET <поле>=<значение>,... [WHERE <условие>]
+   Примеры:
+     UPDATE users 1 NewName,new@email.com,23
+     UPDATE users SET email='x@mail.ru' WHERE name='kolya'
 
 5. Удаление данных:
    DELETE <имя_таблицы> <id>
-   Пример: DELETE users 1
-
-6. Справка:
+   DELETE <имя_таблицы> WHERE <условие>
+   Примеры:
+     DELETE users 1
+     DELETE users WHERE age < 18
+
+6. Индексы:
+   CREATE INDEX <индекс> ON <имя_таблицы> [USING HASH|BTREE] (<поле>)
+   DROP INDEX <индекс>
+   HASH ускоряет поиск по равенству, BTREE (по умолчанию) - также по диапазону
+   Индексы используются в WHERE автоматически
+   Примеры:
+     CREATE INDEX users_email ON users USING HASH (email)
+     CREATE INDEX users_age ON users(age)
+
+7. Управление таблицами:
+   ALTER TABLE <имя_таблицы> ADD [COLUMN] <поле> [тип] [ограничения]
+   ALTER TABLE <имя_таблицы> DROP [COLUMN] <поле>
+   ALTER TABLE <имя_таблицы> RENAME [COLUMN] <поле> TO <новое_имя>
+   ALTER TABLE <имя_таблицы> RENAME TO <новое_имя>
+   Примеры:
+     ALTER TABLE users ADD COLUMN active BOOL DEFAULT true
+     ALTER TABLE users RENAME COLUMN email TO mail
+   DROP TABLE [IF EXISTS] <имя_таблицы>  - удалить таблицу
+   TRUNCATE [TABLE] <имя_таблицы>        - удалить все записи
+   SHOW TABLES                           - список таблиц
+   DESCRIBE <имя_таблицы>                - поля, типы, ограничения, индексы и число записей
+
+8. Транзакции:
+   BEGIN                - начать транзакцию
+   COMMIT               - применить и сохранить все изменения транзакции
+   ROLLBACK             - отменить изменения транзакции
+   До COMMIT изменения видны только внутри транзакции и не сохраняются на диск
+   Пример:
+     BEGIN
+     UPDATE accounts SET balance=90 WHERE id=1
+     UPDATE accounts SET balance=110 WHERE id=2
+     COMMIT
+
+9. Справка:
    /help - вывести это сообщение
 
-7. Выход:
+10. Выход:
    exit - завершить программу
 `
 	fmt.Println(helpText)
@@ -3,9 +3,11 @@ package app
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"strings"
 	"testing"
+	"v4/database"
 	"v4/database/actions"
 	"v4/database/parser"
 	"v4/storage"
@@ -118,6 +120,16 @@ func TestHandleSelect(t *testing.T) {
 			input:     "SELECT users *",
 			wantError: false,
 		},
+		{
+			name:      "Select with WHERE - valid",
+			input:     "SELECT users * WHERE name = 'Kolya'",
+			wantError: false,
+		},
+		{
+			name:      "Select projection - valid",
+			input:     "SELECT email AS mail, name FROM users",
+			wantError: false,
+		},
 		{
 			name:      "Select by ID - valid",
 			input:     "SELECT users 1",
@@ -416,3 +428,256 @@ func TestHandleHelp(t *testing.T) {
 
 	assert.NotPanics(t, notPanics)
 }
+
+func TestHandleBulkUpdateAndDelete(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.HandleCreateTable(&parser.Query{
+		Type:   parser.QueryCreateTable,
+		Table:  "users",
+		Fields: []string{"name", "age"},
+	})
+	for _, values := range [][]string{{"kolya", "22"}, {"anna", "16"}, {"petya", "12"}} {
+		_, _ = app.DB.Insert("users", values)
+	}
+
+	query, err := parser.ParseQuery("UPDATE users SET name='teen' WHERE age < 18")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	app.handleUpdate(query)
+
+	query, err = parser.ParseQuery("DELETE users WHERE name = 'kolya'")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	app.handleDelete(query)
+
+	saved, err := app.Storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if len(saved.Records) != 2 {
+		t.Fatalf("Expected 2 saved records, got %d", len(saved.Records))
+	}
+	for id, record := range saved.Records {
+		if record["name"] != "teen" {
+			t.Errorf("Record %d name = %s, want teen", id, record["name"])
+		}
+	}
+}
+
+func TestHandleInsertRows(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.HandleCreateTable(&parser.Query{
+		Type:   parser.QueryCreateTable,
+		Table:  "users",
+		Fields: []string{"name", "age"},
+	})
+
+	query, err := parser.ParseQuery("INSERT INTO users (name, age) VALUES ('a', 3), ('b', 4), ('c', 5)")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	app.handleInsert(query)
+
+	saved, err := app.Storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if len(saved.Records) != 3 {
+		t.Errorf("Expected 3 saved records, got %d", len(saved.Records))
+	}
+	if saved.Records[2]["name"] != "b" || saved.Records[2]["age"] != "4" {
+		t.Errorf("Record 2 = %v, want name b, age 4", saved.Records[2])
+	}
+}
+
+func TestHandleInsertQuotedValues(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.HandleCreateTable(&parser.Query{
+		Type:   parser.QueryCreateTable,
+		Table:  "users",
+		Fields: []string{"name", "note"},
+	})
+
+	query, err := parser.ParseQuery(`INSERT users "Smith, John", 'two  spaces, "quotes"'`)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	app.handleInsert(query)
+
+	saved, err := app.Storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	record := saved.Records[1]
+	if record["name"] != "Smith, John" || record["note"] != `two  spaces, "quotes"` {
+		t.Errorf("Saved record = %v", record)
+	}
+}
+
+func TestHandleIndexes(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.handleQuery("CREATE TABLE users email, age INT")
+	app.handleQuery("INSERT users a@mail.ru,22")
+	app.handleQuery("CREATE INDEX users_email ON users USING HASH (email)")
+
+	saved, err := app.Storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	index, ok := saved.Indexes["users_email"]
+	if !ok || index.Kind != database.IndexHash {
+		t.Fatalf("saved indexes = %v, want users_email HASH", saved.Indexes)
+	}
+	if ids, _ := index.Lookup("a@mail.ru"); len(ids) != 1 {
+		t.Errorf("rebuilt index Lookup() = %v, want one id", ids)
+	}
+
+	app.handleQuery("DROP INDEX users_email")
+	saved, err = app.Storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if len(saved.Indexes) != 0 {
+		t.Errorf("saved indexes after DROP INDEX = %v, want none", saved.Indexes)
+	}
+}
+
+func TestHandleTransactions(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.handleQuery("CREATE TABLE accounts owner, balance INT")
+	app.handleQuery("INSERT accounts a,100")
+
+	app.handleQuery("BEGIN")
+	assert.True(t, app.db().InTransaction())
+	app.handleQuery("UPDATE accounts SET balance=50 WHERE owner='a'")
+	app.handleQuery("CREATE TABLE log note")
+	app.handleQuery("INSERT log transfer")
+
+	saved, err := app.Storage.LoadTable("accounts")
+	assert.NoError(t, err)
+	assert.Equal(t, "100", saved.Records[1]["balance"])
+	assert.False(t, app.Storage.TableExist("log"))
+	assert.Equal(t, "100", app.DB.Tables["accounts"].Records[1]["balance"])
+
+	app.handleQuery("ROLLBACK")
+	assert.False(t, app.db().InTransaction())
+	assert.Equal(t, "100", app.DB.Tables["accounts"].Records[1]["balance"])
+	_, exist := app.DB.Tables["log"]
+	assert.False(t, exist)
+
+	app.handleQuery("BEGIN")
+	app.handleQuery("UPDATE accounts SET balance=50 WHERE owner='a'")
+	app.handleQuery("COMMIT")
+	assert.Nil(t, app.tx)
+
+	saved, err = app.Storage.LoadTable("accounts")
+	assert.NoError(t, err)
+	assert.Equal(t, "50", saved.Records[1]["balance"])
+	assert.Equal(t, "50", app.DB.Tables["accounts"].Records[1]["balance"])
+
+	// A table dropped in the transaction is gone for it, although its
+	// files are still there.
+	app.handleQuery("BEGIN")
+	app.handleQuery("DROP TABLE accounts")
+	assert.Contains(t, captureOutput(t, func() { app.handleQuery("SELECT accounts *") }), "не найдена")
+	assert.Contains(t, captureOutput(t, func() { app.handleQuery("INSERT accounts b,1") }), "не найдена")
+	app.handleQuery("ROLLBACK")
+	assert.Len(t, app.DB.Tables["accounts"].Records, 1)
+}
+
+func TestHandleAlterTable(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.handleQuery("CREATE TABLE users name, email")
+	app.handleQuery("INSERT users kolya,k@mail.ru")
+	app.handleQuery("ALTER TABLE users ADD COLUMN age INT DEFAULT 18")
+	app.handleQuery("ALTER TABLE users DROP COLUMN email")
+	app.handleQuery("ALTER TABLE users RENAME COLUMN name TO login")
+
+	data, err := os.ReadFile(tempDir + "/users.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "id,login,age", strings.SplitN(string(data), "\n", 2)[0])
+	assert.Equal(t, database.Record{"login": "kolya", "age": "18"}, app.DB.Tables["users"].Records[1])
+
+	app.handleQuery("ALTER TABLE users RENAME TO accounts")
+	assert.False(t, app.Storage.TableExist("users"))
+	_, err = os.Stat(tempDir + "/users.csv")
+	assert.True(t, os.IsNotExist(err))
+
+	saved, err := app.Storage.LoadTable("accounts")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login", "age"}, saved.Fields)
+	assert.Equal(t, database.TypeInt, saved.Column("age").Type)
+
+	app.handleQuery("BEGIN")
+	app.handleQuery("ALTER TABLE accounts ADD COLUMN note")
+	app.handleQuery("ROLLBACK")
+	assert.Equal(t, []string{"login", "age"}, app.DB.Tables["accounts"].Fields)
+}
+
+func captureOutput(t *testing.T, run func()) string {
+	t.Helper()
+	stdout := os.Stdout
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	run()
+	_ = writer.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	return string(data)
+}
+
+func TestHandleTableManagement(t *testing.T) {
+	app, tempDir := setupTestApp(t)
+	defer cleanupTestApp(tempDir)
+
+	app.handleQuery("CREATE TABLE users name TEXT NOT NULL, age INT DEFAULT 18")
+	app.handleQuery("CREATE TABLE orders total FLOAT")
+	app.handleQuery("INSERT users kolya,22")
+	app.handleQuery("INSERT users vasya,30")
+	app.handleQuery("CREATE INDEX users_age ON users(age)")
+
+	assert.Equal(t, "orders\nusers\n", captureOutput(t, func() { app.handleQuery("SHOW TABLES") }))
+
+	described := captureOutput(t, func() { app.handleQuery("DESCRIBE users") })
+	assert.Contains(t, described, "name TEXT NOT NULL")
+	assert.Contains(t, described, "age INT DEFAULT '18'")
+	assert.Contains(t, described, "users_age ORDERED (age)")
+	assert.Contains(t, described, "Записей: 2, NextID: 3")
+
+	explained := captureOutput(t, func() { app.handleQuery("EXPLAIN SELECT * FROM users WHERE age > 25 ORDER BY name") })
+	assert.Contains(t, explained, "Sort by name")
+	assert.Contains(t, explained, "-> Filter: age > 25")
+	assert.Contains(t, explained, "Seq Scan on users")
+
+	assert.Contains(t, captureOutput(t, func() { app.handleQuery("TRUNCATE users") }), "Удалено записей: 2")
+	assert.Empty(t, app.DB.Tables["users"].Records)
+
+	app.handleQuery("DROP TABLE orders")
+	assert.False(t, app.Storage.TableExist("orders"))
+	_, err := os.Stat(tempDir + "/orders.csv")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Contains(t, captureOutput(t, func() { app.handleQuery("DROP TABLE IF EXISTS orders") }), "пропущено")
+	assert.Contains(t, captureOutput(t, func() { app.handleQuery("DROP TABLE orders") }), "Error")
+}
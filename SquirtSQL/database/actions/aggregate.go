@@ -0,0 +1,282 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"v4/database"
+	"v4/database/parser"
+)
+
+type group struct {
+	rows   []database.Row
+	values database.Record
+}
+
+func compileGrouped(table *database.Table, query *parser.Query) (*selectSpec, error) {
+	if len(query.Select) == 0 {
+		return nil, errors.New("SELECT * нельзя использовать с GROUP BY и агрегатными функциями")
+	}
+	if err := validateExpr(table, query.Where); err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[string]bool)
+	for i := range query.GroupBy {
+		if err := validateExpr(table, &query.GroupBy[i]); err != nil {
+			return nil, err
+		}
+		grouped[query.GroupBy[i].Name] = true
+	}
+
+	var aggregates []*parser.AggregateExpr
+	addAggregate := func(agg *parser.AggregateExpr) error {
+		if err := validateAggregate(table, agg); err != nil {
+			return err
+		}
+		aggregates = append(aggregates, agg)
+		return nil
+	}
+
+	keys := make([]string, len(query.Select))
+	aliases := make(map[string]string)
+	for i, column := range query.Select {
+		if column.Aggregate != nil {
+			if err := addAggregate(column.Aggregate); err != nil {
+				return nil, err
+			}
+			keys[i] = column.Aggregate.String()
+		} else {
+			if err := validateExpr(table, &parser.ColumnRef{Table: column.Table, Name: column.Name}); err != nil {
+				return nil, err
+			}
+			if !grouped[column.Name] {
+				return nil, fmt.Errorf("поле %s должно быть в GROUP BY или в агрегатной функции", column.Name)
+			}
+			keys[i] = column.Name
+		}
+		aliases[column.Label()] = keys[i]
+	}
+
+	if err := validateHaving(query.Having, grouped, aliases, addAggregate); err != nil {
+		return nil, err
+	}
+
+	orderKeys := make([]string, len(query.OrderBy))
+	for i, item := range query.OrderBy {
+		switch key, ok := aliases[item.Column]; {
+		case item.Aggregate != nil:
+			if err := addAggregate(item.Aggregate); err != nil {
+				return nil, err
+			}
+			orderKeys[i] = item.Aggregate.String()
+		case ok && item.Table == "":
+			orderKeys[i] = key
+		case grouped[item.Column]:
+			orderKeys[i] = item.Column
+		default:
+			return nil, fmt.Errorf("поле %s для ORDER BY должно быть в GROUP BY или в SELECT", item.Column)
+		}
+	}
+
+	spec := &selectSpec{
+		schema:     table,
+		columns:    make([]string, len(query.Select)),
+		types:      make([]database.ColumnType, len(keys)),
+		keys:       keys,
+		grouped:    true,
+		groupBy:    query.GroupBy,
+		aggregates: aggregates,
+		aliases:    aliases,
+	}
+	for i, column := range query.Select {
+		spec.columns[i] = column.Label()
+		spec.types[i] = keyType(table, keys[i], aggregates)
+	}
+	for i, item := range query.OrderBy {
+		spec.sortKeys = append(spec.sortKeys, sortKey{
+			column:     &parser.ColumnRef{Name: orderKeys[i]},
+			columnType: keyType(table, orderKeys[i], aggregates),
+			desc:       item.Desc,
+		})
+	}
+	return spec, nil
+}
+
+func buildGroups(table *database.Table, groupBy []parser.ColumnRef, rows []database.Row, aggregates []*parser.AggregateExpr) ([]*group, error) {
+	var groups []*group
+	if len(groupBy) == 0 {
+		groups = append(groups, &group{rows: rows})
+	} else {
+		byKey := make(map[string]*group)
+		for _, row := range rows {
+			parts := make([]string, len(groupBy))
+			for i := range groupBy {
+				parts[i] = evalOperand(&groupBy[i], row.ID, row.Record)
+			}
+			key := strings.Join(parts, "\x00")
+			g, exist := byKey[key]
+			if !exist {
+				g = &group{}
+				byKey[key] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, row)
+		}
+	}
+
+	for _, g := range groups {
+		g.values = make(database.Record)
+		if len(g.rows) > 0 {
+			for i := range groupBy {
+				g.values[groupBy[i].Name] = evalOperand(&groupBy[i], g.rows[0].ID, g.rows[0].Record)
+			}
+		}
+		for _, agg := range aggregates {
+			value, err := computeAggregate(table, agg, g.rows)
+			if err != nil {
+				return nil, err
+			}
+			g.values[agg.String()] = value
+		}
+	}
+	return groups, nil
+}
+
+func firstID(g *group) int {
+	if len(g.rows) == 0 {
+		return 0
+	}
+	return g.rows[0].ID
+}
+
+func validateHaving(expr parser.Expr, grouped map[string]bool, aliases map[string]string, addAggregate func(*parser.AggregateExpr) error) error {
+	switch e := expr.(type) {
+	case nil, *parser.Literal:
+		return nil
+	case *parser.AggregateExpr:
+		return addAggregate(e)
+	case *parser.ColumnRef:
+		if _, ok := aliases[e.Name]; (ok && e.Table == "") || grouped[e.Name] {
+			return nil
+		}
+		return fmt.Errorf("поле %s в HAVING должно быть в GROUP BY или в SELECT", e)
+	case *parser.BinaryExpr:
+		if err := validateHaving(e.Left, grouped, aliases, addAggregate); err != nil {
+			return err
+		}
+		return validateHaving(e.Right, grouped, aliases, addAggregate)
+	case *parser.NotExpr:
+		return validateHaving(e.Expr, grouped, aliases, addAggregate)
+	case *parser.InExpr:
+		if err := validateHaving(e.Expr, grouped, aliases, addAggregate); err != nil {
+			return err
+		}
+		for _, value := range e.Values {
+			if err := validateHaving(value, grouped, aliases, addAggregate); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("неподдерживаемое выражение %T", expr)
+	}
+}
+
+func validateAggregate(table *database.Table, agg *parser.AggregateExpr) error {
+	if agg.Arg == nil {
+		return nil
+	}
+	if err := validateExpr(table, agg.Arg); err != nil {
+		return err
+	}
+	columnType := table.Column(agg.Arg.Name).Type
+	if (agg.Func == parser.SUM || agg.Func == parser.AVG) && columnType != database.TypeInt &&
+		columnType != database.TypeFloat && columnType != database.TypeText {
+		return fmt.Errorf("функция %s неприменима к полю %s типа %s", agg.Func, agg.Arg.Name, columnType)
+	}
+	return nil
+}
+
+func aggregateType(table *database.Table, agg *parser.AggregateExpr) database.ColumnType {
+	switch agg.Func {
+	case parser.COUNT:
+		return database.TypeInt
+	case parser.AVG:
+		return database.TypeFloat
+	}
+	columnType := table.Column(agg.Arg.Name).Type
+	if agg.Func == parser.SUM && columnType != database.TypeInt {
+		return database.TypeFloat
+	}
+	return columnType
+}
+
+func keyType(table *database.Table, key string, aggregates []*parser.AggregateExpr) database.ColumnType {
+	for _, agg := range aggregates {
+		if agg.String() == key {
+			return aggregateType(table, agg)
+		}
+	}
+	return table.Column(key).Type
+}
+
+func computeAggregate(table *database.Table, agg *parser.AggregateExpr, rows []database.Row) (string, error) {
+	if agg.Arg == nil {
+		return strconv.Itoa(len(rows)), nil
+	}
+
+	var values []string
+	for _, row := range rows {
+		if value := evalOperand(agg.Arg, row.ID, row.Record); value != "" {
+			values = append(values, value)
+		}
+	}
+	if agg.Func == parser.COUNT {
+		return strconv.Itoa(len(values)), nil
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	columnType := table.Column(agg.Arg.Name).Type
+	switch agg.Func {
+	case parser.SUM, parser.AVG:
+		if agg.Func == parser.SUM && columnType == database.TypeInt {
+			var sum int64
+			for _, value := range values {
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return "", fmt.Errorf("%w: %s ожидает числа, получено %q", database.ErrTypeMismatch, agg, value)
+				}
+				sum += n
+			}
+			return strconv.FormatInt(sum, 10), nil
+		}
+		var sum float64
+		for _, value := range values {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return "", fmt.Errorf("%w: %s ожидает числа, получено %q", database.ErrTypeMismatch, agg, value)
+			}
+			sum += n
+		}
+		if agg.Func == parser.AVG {
+			sum /= float64(len(values))
+		}
+		return strconv.FormatFloat(sum, 'g', -1, 64), nil
+	default:
+		best := values[0]
+		for _, value := range values[1:] {
+			cmp, err := columnType.Compare(value, best)
+			if err != nil {
+				return "", err
+			}
+			if (agg.Func == parser.MIN && cmp < 0) || (agg.Func == parser.MAX && cmp > 0) {
+				best = value
+			}
+		}
+		return best, nil
+	}
+}
@@ -0,0 +1,154 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"v4/database"
+	"v4/database/parser"
+	"v4/storage"
+)
+
+func (db *Database) AddColumn(tableName string, column database.Column) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(tableName)
+	if !exist {
+		return database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if column.Name == "id" {
+		return errors.New("поле 'id' зарезервированно системой")
+	}
+	if table.HasField(column.Name) {
+		return fmt.Errorf("поле %s уже существует", column.Name)
+	}
+	if column.HasDefault {
+		value, err := column.Type.Normalize(column.Default)
+		if err != nil {
+			return fmt.Errorf("DEFAULT поля %s: %w", column.Name, err)
+		}
+		column.Default = value
+	}
+
+	return db.migrate(table, append(table.Schema(), column), nil)
+}
+
+func (db *Database) DropColumn(tableName, name string) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(tableName)
+	if !exist {
+		return database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if name == "id" {
+		return errors.New("поле 'id' нельзя удалить")
+	}
+	if !table.HasField(name) {
+		return fmt.Errorf("%w %s", database.ErrUnknownField, name)
+	}
+	if len(table.Fields) == 1 {
+		return fmt.Errorf("нельзя удалить единственное поле таблицы %s", table.Name)
+	}
+
+	var columns []database.Column
+	for _, column := range table.Schema() {
+		if column.Name != name {
+			columns = append(columns, column)
+		}
+	}
+	return db.migrate(table, columns, nil)
+}
+
+func (db *Database) RenameColumn(tableName, name, newName string) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(tableName)
+	if !exist {
+		return database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if name == "id" || newName == "id" {
+		return errors.New("поле 'id' зарезервированно системой")
+	}
+	if !table.HasField(name) {
+		return fmt.Errorf("%w %s", database.ErrUnknownField, name)
+	}
+	if table.HasField(newName) {
+		return fmt.Errorf("поле %s уже существует", newName)
+	}
+
+	columns := table.Schema()
+	for i := range columns {
+		if columns[i].Name == name {
+			columns[i].Name = newName
+		}
+		if columns[i].Check == "" {
+			continue
+		}
+		check, err := parser.ParseExpr(columns[i].Check)
+		if err != nil {
+			return fmt.Errorf("CHECK поля %s: %w", columns[i].Name, err)
+		}
+		parser.RenameColumn(check, name, newName)
+		columns[i].Check = check.String()
+	}
+	return db.migrate(table, columns, map[string]string{name: newName})
+}
+
+// migrate validates the table with its new columns, logs the change and
+// replaces the table. Callers hold db.Mu and the table lock.
+func (db *Database) migrate(table *database.Table, columns []database.Column, renamed map[string]string) error {
+	migrated := table.Migrate(columns, renamed)
+	if err := validateSchema(migrated); err != nil {
+		return err
+	}
+	if err := db.checkConstraints(migrated, migrated.Records); err != nil {
+		return err
+	}
+	if err := db.log(storage.AlterTableChange(migrated, renamed)); err != nil {
+		return err
+	}
+	db.Tables[table.Name] = migrated
+	return nil
+}
+
+func (db *Database) RenameTable(name, newName string) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	if _, exist := db.Tables[name]; !exist {
+		return database.ErrTableNotFound
+	}
+	if _, exist := db.Tables[newName]; exist {
+		return fmt.Errorf("таблица %s уже существует", newName)
+	}
+
+	table, _ := db.writableTable(name)
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if err := db.log(storage.RenameTableChange(name, newName)); err != nil {
+		return err
+	}
+	renamed := table.Migrate(table.Schema(), nil)
+	renamed.Name = newName
+	delete(db.Tables, name)
+	db.Tables[newName] = renamed
+	if db.parent != nil {
+		db.dirty[newName] = true
+	}
+	return nil
+}
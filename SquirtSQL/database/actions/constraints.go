@@ -0,0 +1,142 @@
+package actions
+
+import (
+	"fmt"
+	"v4/database"
+	"v4/database/parser"
+)
+
+// validateSchema checks the defaults and CHECK expressions of the table.
+func validateSchema(table *database.Table) error {
+	for _, field := range table.Fields {
+		column := table.Columns[field]
+		if column.HasDefault {
+			value, err := column.Type.Normalize(column.Default)
+			if err != nil {
+				return fmt.Errorf("DEFAULT поля %s: %w", field, err)
+			}
+			column.Default = value
+		}
+	}
+	_, err := parseChecks(table)
+	return err
+}
+
+// parseChecks parses the CHECK expressions of the table by column.
+func parseChecks(table *database.Table) (map[string]parser.Expr, error) {
+	checks := make(map[string]parser.Expr)
+	for _, field := range table.Fields {
+		column := table.Columns[field]
+		if column.Check == "" {
+			continue
+		}
+		check, err := parser.ParseExpr(column.Check)
+		if err != nil {
+			return nil, fmt.Errorf("CHECK поля %s: %w", field, err)
+		}
+		if err := validateExpr(table, check); err != nil {
+			return nil, fmt.Errorf("CHECK поля %s: %w", field, err)
+		}
+		checks[field] = check
+	}
+	return checks, nil
+}
+
+// checkCache holds the parsed CHECK expressions of a table.
+type checkCache struct {
+	table  *database.Table
+	checks map[string]parser.Expr
+}
+
+// tableChecks returns the parsed CHECK expressions of a table, parsing
+// them once for each version of its schema. Callers hold the table's lock.
+func (db *Database) tableChecks(table *database.Table) (map[string]parser.Expr, error) {
+	db.checksMu.Lock()
+	defer db.checksMu.Unlock()
+
+	if cached, exist := db.checks[table.Name]; exist && cached.table == table {
+		return cached.checks, nil
+	}
+	checks, err := parseChecks(table)
+	if err != nil {
+		return nil, err
+	}
+	if db.checks == nil {
+		db.checks = make(map[string]checkCache)
+	}
+	db.checks[table.Name] = checkCache{table: table, checks: checks}
+	return checks, nil
+}
+
+func applyDefaults(table *database.Table, record database.Record) {
+	for _, field := range table.Fields {
+		column := table.Column(field)
+		if record[field] == "" && column.HasDefault {
+			record[field] = column.Default
+		}
+	}
+}
+
+func (db *Database) checkConstraints(table *database.Table, changed map[int]database.Record) error {
+	checks, err := db.tableChecks(table)
+	if err != nil {
+		return err
+	}
+
+	for id, record := range changed {
+		for _, field := range table.Fields {
+			if table.Column(field).NotNull && record[field] == "" {
+				return fmt.Errorf("%w: поле %s", database.ErrNotNull, field)
+			}
+		}
+		for field, check := range checks {
+			if hasNullColumn(check, record) {
+				continue
+			}
+			ok, err := matchRecord(table, check, id, record)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: поле %s (%s)", database.ErrCheck, field, check)
+			}
+		}
+	}
+
+	return checkUnique(table, changed)
+}
+
+func checkUnique(table *database.Table, changed map[int]database.Record) error {
+	for _, field := range table.Fields {
+		if !table.Column(field).Unique {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, record := range changed {
+			value := record[field]
+			if value == "" {
+				continue
+			}
+			if seen[value] {
+				return fmt.Errorf("%w: поле %s, значение %q", database.ErrUnique, field, value)
+			}
+			seen[value] = true
+			for _, id := range table.WithValue(field, value) {
+				if _, ok := changed[id]; !ok {
+					return fmt.Errorf("%w: поле %s, значение %q", database.ErrUnique, field, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasNullColumn(expr parser.Expr, record database.Record) bool {
+	for _, column := range parser.Columns(expr) {
+		if column != "id" && record[column] == "" {
+			return true
+		}
+	}
+	return false
+}
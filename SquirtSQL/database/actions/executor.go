@@ -0,0 +1,470 @@
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"v4/database"
+	"v4/database/parser"
+)
+
+// operator is a step of the executor. Open prepares it, Next returns the
+// rows one at a time until ok is false, and Close releases its children.
+// Sorting, grouping and joining read their inputs whole in Open; the
+// other steps pull just as many rows as asked of them.
+type operator interface {
+	Open() error
+	Next() (row database.Row, ok bool, err error)
+	Close()
+}
+
+// scanOp reads the rows of a table in id order, one at a time as Next
+// asks for them. It reads a snapshot of the records taken when the plan
+// was built, so the table is not locked meanwhile and later changes are
+// not seen. A scan of a join source names the columns <source>.<column>
+// and numbers the rows it returns.
+type scanOp struct {
+	records map[int]database.Record
+	release func()
+	source  string
+
+	// A table with few deleted rows is walked by id up to end. The ids of
+	// others are sorted on the first call to Next, and index scans are
+	// given theirs.
+	walk   bool
+	end    int
+	sorted bool
+	ids    []int
+
+	next int
+	read int
+}
+
+// newScan takes a snapshot of the table; the caller holds its lock.
+func newScan(table *database.Table, source string) *scanOp {
+	records, release := table.Snapshot()
+	return &scanOp{
+		records: records,
+		release: release,
+		source:  source,
+		walk:    table.NextID <= 2*len(records)+1,
+		end:     table.NextID,
+	}
+}
+
+func (s *scanOp) Open() error {
+	s.next, s.read = 0, 0
+	return nil
+}
+
+func (s *scanOp) Next() (database.Row, bool, error) {
+	if !s.walk && !s.sorted {
+		s.ids = make([]int, 0, len(s.records))
+		for id := range s.records {
+			s.ids = append(s.ids, id)
+		}
+		sort.Ints(s.ids)
+		s.sorted = true
+	}
+	for {
+		var id int
+		if s.walk {
+			if s.next+1 >= s.end {
+				return database.Row{}, false, nil
+			}
+			id = s.next + 1
+		} else {
+			if s.next >= len(s.ids) {
+				return database.Row{}, false, nil
+			}
+			id = s.ids[s.next]
+		}
+		s.next++
+
+		record, exist := s.records[id]
+		if !exist {
+			continue
+		}
+		s.read++
+		if s.source == "" {
+			return database.Row{ID: id, Record: record}, true, nil
+		}
+		prefixed := make(database.Record, len(record)+1)
+		prefixed[s.source+".id"] = strconv.Itoa(id)
+		for field, value := range record {
+			prefixed[s.source+"."+field] = value
+		}
+		return database.Row{ID: s.read, Record: prefixed}, true, nil
+	}
+}
+
+func (s *scanOp) Close() {
+	if s.release != nil {
+		s.release()
+	}
+	s.records, s.ids = nil, nil
+}
+
+// newIndexScan reads the rows an index finds for a conjunct, in id order;
+// the caller holds the table's lock. Only the lookup is done here, the
+// rows are read and checked as they are asked for. Without a usable index
+// the whole table is scanned and filtered.
+func newIndexScan(table *database.Table, cond parser.Expr) operator {
+	ids, ok := conjunctCandidates(table, cond)
+	if !ok {
+		return &filterOp{child: newScan(table, ""), cond: cond, schema: table}
+	}
+	sort.Ints(ids)
+
+	records, release := table.Snapshot()
+	scan := &scanOp{records: records, release: release, sorted: true, ids: ids}
+	return &filterOp{child: scan, cond: cond, schema: table}
+}
+
+// filterOp passes on the rows that satisfy its condition.
+type filterOp struct {
+	child  operator
+	cond   parser.Expr
+	schema *database.Table
+}
+
+func (f *filterOp) Open() error {
+	return f.child.Open()
+}
+
+func (f *filterOp) Next() (database.Row, bool, error) {
+	for {
+		row, ok, err := f.child.Next()
+		if !ok || err != nil {
+			return row, ok, err
+		}
+		matched, err := matchRecord(f.schema, f.cond, row.ID, row.Record)
+		if err != nil {
+			return database.Row{}, false, err
+		}
+		if matched {
+			return row, true, nil
+		}
+	}
+}
+
+func (f *filterOp) Close() {
+	f.child.Close()
+}
+
+// joinOp joins each row of the left input with the rows of the right one
+// that satisfy the condition. With a key it finds them in a hash table of
+// the right rows, otherwise it tries them all. A left join also returns
+// the left rows without a match.
+type joinOp struct {
+	left, right operator
+	schema      *database.Table
+	cond        parser.Expr
+	outer       bool
+
+	leftKey, rightKey string
+	keyType           database.ColumnType
+
+	rightRows  []database.Record
+	index      map[string][]database.Record
+	current    database.Record
+	candidates []database.Record
+	matched    bool
+	n          int
+}
+
+func (j *joinOp) Open() error {
+	if err := j.right.Open(); err != nil {
+		return err
+	}
+	rows, err := drain(j.right)
+	j.right.Close()
+	if err != nil {
+		return err
+	}
+
+	j.rightRows = make([]database.Record, len(rows))
+	for i, row := range rows {
+		j.rightRows[i] = row.Record
+	}
+	if j.leftKey != "" {
+		j.index = make(map[string][]database.Record)
+		for _, row := range j.rightRows {
+			if key, ok := j.keyType.Key(row[j.rightKey]); ok {
+				j.index[key] = append(j.index[key], row)
+			}
+		}
+	}
+	j.current, j.candidates, j.n = nil, nil, 0
+	return j.left.Open()
+}
+
+func (j *joinOp) Next() (database.Row, bool, error) {
+	for {
+		for len(j.candidates) > 0 {
+			candidate := j.candidates[0]
+			j.candidates = j.candidates[1:]
+			combined := make(database.Record, len(j.current)+len(candidate))
+			for field, value := range j.current {
+				combined[field] = value
+			}
+			for field, value := range candidate {
+				combined[field] = value
+			}
+			ok, err := matchRecord(j.schema, j.cond, 0, combined)
+			if err != nil {
+				return database.Row{}, false, err
+			}
+			if ok {
+				j.matched = true
+				return j.emit(combined), true, nil
+			}
+		}
+		if j.current != nil && !j.matched && j.outer {
+			unmatched := j.current
+			j.current = nil
+			return j.emit(unmatched), true, nil
+		}
+
+		row, ok, err := j.left.Next()
+		if !ok || err != nil {
+			return row, ok, err
+		}
+		j.current, j.matched = row.Record, false
+		j.candidates = j.rightRows
+		if j.index != nil {
+			key, ok := j.keyType.Key(row.Record[j.leftKey])
+			j.candidates = nil
+			if ok {
+				j.candidates = j.index[key]
+			}
+		}
+	}
+}
+
+func (j *joinOp) emit(record database.Record) database.Row {
+	j.n++
+	return database.Row{ID: j.n, Record: record}
+}
+
+func (j *joinOp) Close() {
+	j.left.Close()
+	j.rightRows, j.index, j.candidates = nil, nil, nil
+}
+
+// aggregateOp groups its input and returns a row per group holding the
+// grouped columns, the aggregates and their aliases.
+type aggregateOp struct {
+	child operator
+	spec  *selectSpec
+
+	groups []*group
+}
+
+func (a *aggregateOp) Open() error {
+	if err := a.child.Open(); err != nil {
+		return err
+	}
+	rows, err := drain(a.child)
+	a.child.Close()
+	if err != nil {
+		return err
+	}
+
+	a.groups, err = buildGroups(a.spec.schema, a.spec.groupBy, rows, a.spec.aggregates)
+	if err != nil {
+		return err
+	}
+	for _, g := range a.groups {
+		for alias, key := range a.spec.aliases {
+			g.values[alias] = g.values[key]
+		}
+	}
+	return nil
+}
+
+func (a *aggregateOp) Next() (database.Row, bool, error) {
+	if len(a.groups) == 0 {
+		return database.Row{}, false, nil
+	}
+	g := a.groups[0]
+	a.groups = a.groups[1:]
+	return database.Row{ID: firstID(g), Record: g.values}, true, nil
+}
+
+func (a *aggregateOp) Close() {
+	a.groups = nil
+}
+
+// sortOp reads its input whole and returns it in the order of the keys.
+// The sort is stable, so rows with equal keys keep their order.
+type sortOp struct {
+	child operator
+	keys  []sortKey
+
+	rows []database.Row
+}
+
+type sortKey struct {
+	column     *parser.ColumnRef
+	columnType database.ColumnType
+	desc       bool
+}
+
+func (s *sortOp) Open() error {
+	if err := s.child.Open(); err != nil {
+		return err
+	}
+	rows, err := drain(s.child)
+	s.child.Close()
+	if err != nil {
+		return err
+	}
+
+	var sortErr error
+	sort.SliceStable(rows, func(a, b int) bool {
+		for _, key := range s.keys {
+			cmp, err := key.columnType.Compare(
+				evalOperand(key.column, rows[a].ID, rows[a].Record),
+				evalOperand(key.column, rows[b].ID, rows[b].Record),
+			)
+			if err != nil {
+				if sortErr == nil {
+					sortErr = fmt.Errorf("ORDER BY %s: %w", key.column, err)
+				}
+				return false
+			}
+			if cmp == 0 {
+				continue
+			}
+			if key.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+	if sortErr != nil {
+		return sortErr
+	}
+	s.rows = rows
+	return nil
+}
+
+func (s *sortOp) Next() (database.Row, bool, error) {
+	if len(s.rows) == 0 {
+		return database.Row{}, false, nil
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, true, nil
+}
+
+func (s *sortOp) Close() {
+	s.rows = nil
+}
+
+// limitOp skips offset rows and stops after limit more, without reading
+// the rest of its input. A zero limit returns all of them.
+type limitOp struct {
+	child         operator
+	limit, offset int
+
+	n int
+}
+
+func (l *limitOp) Open() error {
+	l.n = 0
+	return l.child.Open()
+}
+
+func (l *limitOp) Next() (database.Row, bool, error) {
+	for ; l.n < l.offset; l.n++ {
+		if _, ok, err := l.child.Next(); !ok || err != nil {
+			return database.Row{}, false, err
+		}
+	}
+	if l.limit > 0 && l.n >= l.offset+l.limit {
+		return database.Row{}, false, nil
+	}
+	row, ok, err := l.child.Next()
+	if ok {
+		l.n++
+	}
+	return row, ok, err
+}
+
+func (l *limitOp) Close() {
+	l.child.Close()
+}
+
+func drain(op operator) ([]database.Row, error) {
+	var rows []database.Row
+	for {
+		row, ok, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// build turns a plan into the operators that run it. sources are the
+// tables by the names the plan gives them; the caller holds their locks
+// while the scans take their snapshots.
+func (s *selectSpec) build(plan *Plan, sources map[string]*database.Table) (operator, error) {
+	children := make([]operator, 0, len(plan.Children))
+	for _, child := range plan.Children {
+		op, err := s.build(child, sources)
+		if err != nil {
+			for _, built := range children {
+				built.Close()
+			}
+			return nil, err
+		}
+		children = append(children, op)
+	}
+
+	source := plan.Table
+	if plan.Alias != "" {
+		source = plan.Alias
+	}
+	switch plan.Kind {
+	case PlanSeqScan:
+		if s.joined {
+			return newScan(sources[source], source), nil
+		}
+		return newScan(sources[source], ""), nil
+	case PlanIndexScan:
+		return newIndexScan(sources[source], plan.Cond), nil
+	case PlanFilter:
+		return &filterOp{child: children[0], cond: plan.Cond, schema: s.schema}, nil
+	case PlanHashJoin, PlanNestedLoop:
+		join := &joinOp{left: children[0], right: children[1], schema: s.schema, cond: plan.Cond, outer: plan.Left}
+		right := plan.Children[1].Table
+		if plan.Children[1].Alias != "" {
+			right = plan.Children[1].Alias
+		}
+		if leftKey, rightKey, rest, ok := splitEquiJoin(plan.Cond, right); ok {
+			join.leftKey, join.rightKey, join.cond = leftKey.Name, rightKey.Name, rest
+			join.keyType = s.schema.Column(leftKey.Name).Type
+			if join.keyType == database.TypeText {
+				join.keyType = s.schema.Column(rightKey.Name).Type
+			}
+		}
+		return join, nil
+	case PlanAggregate:
+		return &aggregateOp{child: children[0], spec: s}, nil
+	case PlanSort:
+		return &sortOp{child: children[0], keys: s.sortKeys}, nil
+	case PlanLimit:
+		return &limitOp{child: children[0], limit: plan.Limit, offset: plan.Offset}, nil
+	}
+	for _, child := range children {
+		child.Close()
+	}
+	return nil, fmt.Errorf("неизвестный шаг плана %s", plan.Kind)
+}
@@ -0,0 +1,395 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"v4/database"
+	"v4/database/parser"
+	"v4/storage"
+)
+
+func (db *Database) SelectWhere(tableName string, where parser.Expr) ([]database.Row, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	table, exist := db.Tables[tableName]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	if err := validateExpr(table, where); err != nil {
+		return nil, err
+	}
+
+	ids, err := db.matchingIDs(table, where)
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(ids)
+
+	rows := make([]database.Row, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, database.Row{ID: id, Record: table.Records[id]})
+	}
+	return rows, nil
+}
+
+func (db *Database) UpdateWhere(tableName string, set []parser.Assignment, where parser.Expr) (int, error) {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(tableName)
+	if !exist {
+		return 0, database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if err := validateExpr(table, where); err != nil {
+		return 0, err
+	}
+	values := make(map[string]string, len(set))
+	for _, assignment := range set {
+		if assignment.Column == "id" {
+			return 0, errors.New("поле 'id' зарезервированно системой")
+		}
+		value, err := table.NormalizeValue(assignment.Column, assignment.Value)
+		if err != nil {
+			return 0, err
+		}
+		values[assignment.Column] = value
+	}
+
+	ids, err := db.matchingIDs(table, where)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := make(map[int]database.Record, len(ids))
+	for _, id := range ids {
+		updated := make(database.Record, len(table.Fields))
+		for field, value := range table.Records[id] {
+			updated[field] = value
+		}
+		for field, value := range values {
+			updated[field] = value
+		}
+		changed[id] = updated
+	}
+	if err := db.checkConstraints(table, changed); err != nil {
+		return 0, err
+	}
+	if err := db.log(putChanges(table, changed)...); err != nil {
+		return 0, err
+	}
+	for id, record := range changed {
+		table.SetRecord(id, record)
+	}
+	return len(ids), nil
+}
+
+func (db *Database) DeleteWhere(tableName string, where parser.Expr) (int, error) {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(tableName)
+	if !exist {
+		return 0, database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if err := validateExpr(table, where); err != nil {
+		return 0, err
+	}
+
+	ids, err := db.matchingIDs(table, where)
+	if err != nil {
+		return 0, err
+	}
+	sort.Ints(ids)
+	changes := make([]storage.Change, len(ids))
+	for i, id := range ids {
+		changes[i] = storage.DeleteChange(tableName, id)
+	}
+	if err := db.log(changes...); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		table.DeleteRecord(id)
+	}
+	return len(ids), nil
+}
+
+// matchingIDs returns the IDs of the records that satisfy where, reading
+// them the way chooseAccess finds cheapest.
+func (db *Database) matchingIDs(table *database.Table, where parser.Expr) ([]int, error) {
+	stats, err := db.tableStats(table)
+	if err != nil {
+		return nil, err
+	}
+	path := chooseAccess(table, stats, where)
+	if path.conjunct == nil {
+		return scanIDs(table, where)
+	}
+	if candidates, ok := conjunctCandidates(table, path.conjunct); ok {
+		var ids []int
+		for _, id := range candidates {
+			ok, err := matchRecord(table, where, id, table.Records[id])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+	return scanIDs(table, where)
+}
+
+func scanIDs(table *database.Table, where parser.Expr) ([]int, error) {
+	var ids []int
+	for id, record := range table.Records {
+		ok, err := matchRecord(table, where, id, record)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func validateExpr(table *database.Table, expr parser.Expr) error {
+	switch e := expr.(type) {
+	case nil, *parser.Literal:
+		return nil
+	case *parser.ColumnRef:
+		if (e.Table != "" && e.Table != table.Name) || !table.HasField(e.Name) {
+			return fmt.Errorf("%w %s", database.ErrUnknownField, e)
+		}
+		return nil
+	case *parser.BinaryExpr:
+		if err := validateExpr(table, e.Left); err != nil {
+			return err
+		}
+		return validateExpr(table, e.Right)
+	case *parser.NotExpr:
+		return validateExpr(table, e.Expr)
+	case *parser.AggregateExpr:
+		return fmt.Errorf("агрегатная функция %s допустима только в SELECT, HAVING и ORDER BY", e)
+	case *parser.InExpr:
+		if err := validateExpr(table, e.Expr); err != nil {
+			return err
+		}
+		for _, value := range e.Values {
+			if err := validateExpr(table, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("неподдерживаемое выражение %T", expr)
+	}
+}
+
+// truth is the value of a condition in SQL's three-valued logic. A
+// comparison with NULL is unknown, NOT of unknown stays unknown, and only
+// true conditions match.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthUnknown
+)
+
+func truthOf(ok bool) truth {
+	if ok {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+func (t truth) not() truth {
+	switch t {
+	case truthTrue:
+		return truthFalse
+	case truthFalse:
+		return truthTrue
+	}
+	return truthUnknown
+}
+
+func matchRecord(table *database.Table, expr parser.Expr, id int, record database.Record) (bool, error) {
+	result, err := evalCondition(table, expr, id, record)
+	return result == truthTrue, err
+}
+
+func evalCondition(table *database.Table, expr parser.Expr, id int, record database.Record) (truth, error) {
+	switch e := expr.(type) {
+	case nil:
+		return truthTrue, nil
+	case *parser.NotExpr:
+		result, err := evalCondition(table, e.Expr, id, record)
+		return result.not(), err
+	case *parser.InExpr:
+		result := truthFalse
+		for _, value := range e.Values {
+			cmp, ok, err := compareOperands(table, e.Expr, value, id, record)
+			if err != nil {
+				return truthFalse, err
+			}
+			if !ok {
+				result = truthUnknown
+			} else if cmp == 0 {
+				result = truthTrue
+				break
+			}
+		}
+		if e.Not {
+			return result.not(), nil
+		}
+		return result, nil
+	case *parser.BinaryExpr:
+		switch e.Op {
+		case parser.OpAnd, parser.OpOr:
+			left, err := evalCondition(table, e.Left, id, record)
+			if err != nil {
+				return truthFalse, err
+			}
+			if e.Op == parser.OpAnd && left == truthFalse {
+				return truthFalse, nil
+			}
+			if e.Op == parser.OpOr && left == truthTrue {
+				return truthTrue, nil
+			}
+			right, err := evalCondition(table, e.Right, id, record)
+			if err != nil {
+				return truthFalse, err
+			}
+			if right == left {
+				return right, nil
+			}
+			// The sides differ and neither decides alone, so one is unknown.
+			if e.Op == parser.OpAnd && right == truthFalse {
+				return truthFalse, nil
+			}
+			if e.Op == parser.OpOr && right == truthTrue {
+				return truthTrue, nil
+			}
+			return truthUnknown, nil
+		case parser.OpLike:
+			value := evalOperand(e.Left, id, record)
+			if value == "" && operandType(table, e.Left) != database.TypeText {
+				return truthUnknown, nil
+			}
+			return truthOf(likeMatch(value, evalOperand(e.Right, id, record))), nil
+		}
+
+		cmp, ok, err := compareOperands(table, e.Left, e.Right, id, record)
+		if err != nil {
+			return truthFalse, err
+		}
+		if !ok {
+			return truthUnknown, nil
+		}
+		switch e.Op {
+		case parser.OpEq:
+			return truthOf(cmp == 0), nil
+		case parser.OpNe:
+			return truthOf(cmp != 0), nil
+		case parser.OpLt:
+			return truthOf(cmp < 0), nil
+		case parser.OpLe:
+			return truthOf(cmp <= 0), nil
+		case parser.OpGt:
+			return truthOf(cmp > 0), nil
+		case parser.OpGe:
+			return truthOf(cmp >= 0), nil
+		}
+		return truthFalse, fmt.Errorf("неизвестный оператор %s", e.Op)
+	default:
+		return truthFalse, fmt.Errorf("выражение %T не является условием", expr)
+	}
+}
+
+func compareOperands(table *database.Table, left, right parser.Expr, id int, record database.Record) (int, bool, error) {
+	columnType := operandType(table, left)
+	if columnType == database.TypeText {
+		columnType = operandType(table, right)
+	}
+
+	a, b := evalOperand(left, id, record), evalOperand(right, id, record)
+	if columnType != database.TypeText && (a == "" || b == "") {
+		return 0, false, nil
+	}
+	cmp, err := columnType.Compare(a, b)
+	if err != nil {
+		return 0, false, err
+	}
+	return cmp, true, nil
+}
+
+func operandType(table *database.Table, expr parser.Expr) database.ColumnType {
+	switch e := expr.(type) {
+	case *parser.ColumnRef:
+		return table.Column(e.Name).Type
+	case *parser.AggregateExpr:
+		return aggregateType(table, e)
+	}
+	return database.TypeText
+}
+
+func evalOperand(expr parser.Expr, id int, record database.Record) string {
+	switch e := expr.(type) {
+	case *parser.ColumnRef:
+		if e.Name == "id" {
+			return strconv.Itoa(id)
+		}
+		return record[e.Name]
+	case *parser.Literal:
+		return e.Value
+	case *parser.AggregateExpr:
+		return record[e.String()]
+	}
+	return ""
+}
+
+func likeMatch(value, pattern string) bool {
+	s, p := []rune(value), []rune(pattern)
+	si, pi := 0, 0
+	starP, starS := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(p) && p[pi] == '%':
+			starP, starS = pi, si
+			pi++
+		case pi+1 < len(p) && p[pi] == '\\' && p[pi+1] == s[si]:
+			si++
+			pi += 2
+		case pi < len(p) && p[pi] != '\\' && (p[pi] == '_' || p[pi] == s[si]):
+			si++
+			pi++
+		case starP != -1:
+			starS++
+			si = starS
+			pi = starP + 1
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
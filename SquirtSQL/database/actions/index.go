@@ -0,0 +1,225 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"v4/database"
+	"v4/database/parser"
+	"v4/storage"
+)
+
+func (db *Database) CreateIndex(tableName, name, column string, kind database.IndexKind) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	if _, exist := db.Tables[tableName]; !exist {
+		return database.ErrTableNotFound
+	}
+	for _, other := range db.Tables {
+		if _, exist := other.Indexes[name]; exist {
+			return fmt.Errorf("%w: %s", database.ErrIndexExists, name)
+		}
+	}
+
+	table, _ := db.writableTable(tableName)
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if column == "id" {
+		return errors.New("поле 'id' уже индексировано")
+	}
+	if !table.HasField(column) {
+		return fmt.Errorf("%w %s", database.ErrUnknownField, column)
+	}
+	index := database.NewIndex(name, column, kind, table.Column(column).Type)
+	if err := db.log(storage.CreateIndexChange(tableName, index)); err != nil {
+		return err
+	}
+	table.AddIndex(index)
+	return nil
+}
+
+// DropIndex removes the index and returns the name of its table.
+func (db *Database) DropIndex(name string) (string, error) {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	for tableName, table := range db.Tables {
+		table.Mu.RLock()
+		_, exist := table.Indexes[name]
+		table.Mu.RUnlock()
+		if !exist {
+			continue
+		}
+		if err := db.log(storage.DropIndexChange(tableName, name)); err != nil {
+			return "", err
+		}
+		table, _ = db.writableTable(tableName)
+		table.Mu.Lock()
+		table.RemoveIndex(name)
+		table.Mu.Unlock()
+		return tableName, nil
+	}
+	return "", fmt.Errorf("%w: %s", database.ErrIndexNotFound, name)
+}
+
+func conjuncts(expr parser.Expr) []parser.Expr {
+	if bin, ok := expr.(*parser.BinaryExpr); ok && bin.Op == parser.OpAnd {
+		return append(conjuncts(bin.Left), conjuncts(bin.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []parser.Expr{expr}
+}
+
+// comparison splits a conjunct that compares a column with literals: a
+// comparison operator, or IN with a list of literals as OpEq.
+func comparison(expr parser.Expr) (*parser.ColumnRef, parser.Operator, []string, bool) {
+	switch e := expr.(type) {
+	case *parser.InExpr:
+		column, ok := e.Expr.(*parser.ColumnRef)
+		if !ok || e.Not {
+			return nil, "", nil, false
+		}
+		values := make([]string, len(e.Values))
+		for i, value := range e.Values {
+			literal, ok := value.(*parser.Literal)
+			if !ok {
+				return nil, "", nil, false
+			}
+			values[i] = literal.Value
+		}
+		return column, parser.OpEq, values, true
+	case *parser.BinaryExpr:
+		column, columnOk := e.Left.(*parser.ColumnRef)
+		literal, literalOk := e.Right.(*parser.Literal)
+		op := e.Op
+		if !columnOk || !literalOk {
+			column, columnOk = e.Right.(*parser.ColumnRef)
+			literal, literalOk = e.Left.(*parser.Literal)
+			op = flipOperator(op)
+		}
+		if !columnOk || !literalOk {
+			return nil, "", nil, false
+		}
+		switch op {
+		case parser.OpEq, parser.OpNe, parser.OpLt, parser.OpLe, parser.OpGt, parser.OpGe:
+			return column, op, []string{literal.Value}, true
+		}
+	}
+	return nil, "", nil, false
+}
+
+// indexFor returns the name of an index that can answer the conjunct, or
+// "id" for a lookup by primary key.
+func indexFor(table *database.Table, conjunct parser.Expr) (string, bool) {
+	column, op, values, ok := comparison(conjunct)
+	if !ok || op == parser.OpNe {
+		return "", false
+	}
+	if column.Name == "id" {
+		for _, value := range values {
+			if _, err := strconv.Atoi(value); err != nil || op != parser.OpEq {
+				return "", false
+			}
+		}
+		return "id", true
+	}
+
+	for _, index := range table.IndexList() {
+		if index.Column != column.Name || (op != parser.OpEq && index.Kind != database.IndexOrdered) {
+			continue
+		}
+		usable := true
+		for _, value := range values {
+			if _, ok := index.Type.Key(value); !ok {
+				usable = false
+			}
+		}
+		if usable {
+			return index.Name, true
+		}
+	}
+	return "", false
+}
+
+// conjunctCandidates returns the IDs the indexes allow for the conjunct.
+// The caller still evaluates the whole condition on each candidate.
+func conjunctCandidates(table *database.Table, conjunct parser.Expr) ([]int, bool) {
+	column, op, values, ok := comparison(conjunct)
+	if !ok {
+		return nil, false
+	}
+
+	switch op {
+	case parser.OpEq:
+		var ids []int
+		seen := make(map[int]bool)
+		for _, value := range values {
+			matched, ok := lookupIndex(table, column, value)
+			if !ok {
+				return nil, false
+			}
+			for _, id := range matched {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		return ids, true
+	case parser.OpLt, parser.OpLe:
+		return rangeIndex(table, column, nil, &database.Bound{Value: values[0], Inclusive: op == parser.OpLe})
+	case parser.OpGt, parser.OpGe:
+		return rangeIndex(table, column, &database.Bound{Value: values[0], Inclusive: op == parser.OpGe}, nil)
+	}
+	return nil, false
+}
+
+func flipOperator(op parser.Operator) parser.Operator {
+	switch op {
+	case parser.OpLt:
+		return parser.OpGt
+	case parser.OpLe:
+		return parser.OpGe
+	case parser.OpGt:
+		return parser.OpLt
+	case parser.OpGe:
+		return parser.OpLe
+	}
+	return op
+}
+
+func lookupIndex(table *database.Table, column *parser.ColumnRef, value string) ([]int, bool) {
+	if column.Name == "id" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, false
+		}
+		if _, exist := table.Records[id]; !exist {
+			return nil, true
+		}
+		return []int{id}, true
+	}
+	for _, index := range table.IndexList() {
+		if index.Column == column.Name {
+			if ids, ok := index.Lookup(value); ok {
+				return ids, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func rangeIndex(table *database.Table, column *parser.ColumnRef, lower, upper *database.Bound) ([]int, bool) {
+	for _, index := range table.IndexList() {
+		if index.Column == column.Name {
+			if ids, ok := index.Range(lower, upper); ok {
+				return ids, true
+			}
+		}
+	}
+	return nil, false
+}
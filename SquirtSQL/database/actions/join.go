@@ -0,0 +1,300 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"v4/database"
+	"v4/database/parser"
+)
+
+type joinSource struct {
+	name  string
+	table *database.Table
+}
+
+type joinScope []joinSource
+
+// prepareJoin plans a query over joined tables. The operators join the
+// rows into records whose columns are named <table>.<column>, and the
+// query is rewritten to those names.
+func (db *Database) prepareJoin(query *parser.Query) (*selectSpec, operator, error) {
+	scope, err := db.newJoinScope(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer scope.lock()()
+
+	plan, rewritten, err := db.planJoin(scope, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := compileSelect(scope.schema(query.Table), rewritten)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec.joined = true
+
+	sources := make(map[string]*database.Table, len(scope))
+	for _, source := range scope {
+		sources[source.name] = source.table
+	}
+	root, err := spec.build(plan, sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, root, nil
+}
+
+func (db *Database) newJoinScope(query *parser.Query) (joinScope, error) {
+	base, exist := db.Tables[query.Table]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+	name := query.Alias
+	if name == "" {
+		name = query.Table
+	}
+	scope := joinScope{{name: name, table: base}}
+	for _, join := range query.Joins {
+		table, exist := db.Tables[join.Table]
+		if !exist {
+			return nil, fmt.Errorf("%w: %s", database.ErrTableNotFound, join.Table)
+		}
+		scope = append(scope, joinSource{name: join.Name(), table: table})
+	}
+
+	names := make(map[string]bool)
+	for _, source := range scope {
+		if names[source.name] {
+			return nil, fmt.Errorf("таблица %s указана дважды, задайте псевдоним", source.name)
+		}
+		names[source.name] = true
+	}
+	return scope, nil
+}
+
+// lock read-locks each table of the scope once and returns the unlock.
+func (s joinScope) lock() func() {
+	locked := make(map[*database.Table]bool)
+	for _, source := range s {
+		if !locked[source.table] {
+			source.table.Mu.RLock()
+			locked[source.table] = true
+		}
+	}
+	return func() {
+		for table := range locked {
+			table.Mu.RUnlock()
+		}
+	}
+}
+
+// schema describes the columns of the joined rows.
+func (s joinScope) schema(name string) *database.Table {
+	var columns []database.Column
+	for _, source := range s {
+		columns = append(columns, database.Column{Name: source.name + ".id", Type: database.TypeInt})
+		for _, column := range source.table.Schema() {
+			columns = append(columns, database.Column{Name: source.name + "." + column.Name, Type: column.Type})
+		}
+	}
+	return database.NewTableFromColumns(name, columns)
+}
+
+// splitEquiJoin looks for a column = column conjunct linking the joined
+// table with the tables before it, which allows a hash join.
+func splitEquiJoin(on parser.Expr, right string) (*parser.ColumnRef, *parser.ColumnRef, parser.Expr, bool) {
+	var conjuncts []parser.Expr
+	var flatten func(parser.Expr)
+	flatten = func(expr parser.Expr) {
+		if bin, ok := expr.(*parser.BinaryExpr); ok && bin.Op == parser.OpAnd {
+			flatten(bin.Left)
+			flatten(bin.Right)
+			return
+		}
+		conjuncts = append(conjuncts, expr)
+	}
+	flatten(on)
+
+	prefix := right + "."
+	for i, conjunct := range conjuncts {
+		bin, ok := conjunct.(*parser.BinaryExpr)
+		if !ok || bin.Op != parser.OpEq {
+			continue
+		}
+		a, okA := bin.Left.(*parser.ColumnRef)
+		b, okB := bin.Right.(*parser.ColumnRef)
+		if !okA || !okB {
+			continue
+		}
+		if strings.HasPrefix(a.Name, prefix) {
+			a, b = b, a
+		}
+		if strings.HasPrefix(a.Name, prefix) || !strings.HasPrefix(b.Name, prefix) {
+			continue
+		}
+
+		var rest parser.Expr
+		for j, other := range conjuncts {
+			switch {
+			case j == i:
+			case rest == nil:
+				rest = other
+			default:
+				rest = &parser.BinaryExpr{Op: parser.OpAnd, Left: rest, Right: other}
+			}
+		}
+		return a, b, rest, true
+	}
+	return nil, nil, nil, false
+}
+
+func (s joinScope) resolve(ref *parser.ColumnRef) (*parser.ColumnRef, error) {
+	if ref.Table != "" {
+		for _, source := range s {
+			if source.name == ref.Table && source.table.HasField(ref.Name) {
+				return &parser.ColumnRef{Name: ref.String()}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w %s", database.ErrUnknownField, ref)
+	}
+
+	var resolved *parser.ColumnRef
+	for _, source := range s {
+		if source.table.HasField(ref.Name) {
+			if resolved != nil {
+				return nil, fmt.Errorf("неоднозначное поле %s, укажите таблицу", ref.Name)
+			}
+			resolved = &parser.ColumnRef{Name: source.name + "." + ref.Name}
+		}
+	}
+	if resolved == nil {
+		return nil, fmt.Errorf("%w %s", database.ErrUnknownField, ref.Name)
+	}
+	return resolved, nil
+}
+
+func (s joinScope) rewrite(expr parser.Expr, aliases map[string]bool) (parser.Expr, error) {
+	switch e := expr.(type) {
+	case nil:
+		return nil, nil
+	case *parser.Literal:
+		return e, nil
+	case *parser.ColumnRef:
+		if e.Table == "" && aliases[e.Name] {
+			return e, nil
+		}
+		return s.resolve(e)
+	case *parser.AggregateExpr:
+		if e.Arg == nil {
+			return e, nil
+		}
+		arg, err := s.resolve(e.Arg)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.AggregateExpr{Func: e.Func, Arg: arg}, nil
+	case *parser.BinaryExpr:
+		left, err := s.rewrite(e.Left, aliases)
+		if err != nil {
+			return nil, err
+		}
+		right, err := s.rewrite(e.Right, aliases)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.BinaryExpr{Op: e.Op, Left: left, Right: right}, nil
+	case *parser.NotExpr:
+		inner, err := s.rewrite(e.Expr, aliases)
+		if err != nil {
+			return nil, err
+		}
+		return &parser.NotExpr{Expr: inner}, nil
+	case *parser.InExpr:
+		inner, err := s.rewrite(e.Expr, aliases)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]parser.Expr, len(e.Values))
+		for i, value := range e.Values {
+			if values[i], err = s.rewrite(value, aliases); err != nil {
+				return nil, err
+			}
+		}
+		return &parser.InExpr{Expr: inner, Values: values, Not: e.Not}, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемое выражение %T", expr)
+	}
+}
+
+func (s joinScope) rewriteQuery(query *parser.Query) (*parser.Query, error) {
+	rewritten := &parser.Query{
+		Type:   query.Type,
+		Table:  query.Table,
+		ID:     -1,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}
+
+	aliases := make(map[string]bool)
+	if len(query.Select) == 0 {
+		for _, source := range s {
+			for _, field := range source.table.Fields {
+				rewritten.Select = append(rewritten.Select, parser.SelectColumn{Name: source.name + "." + field})
+			}
+		}
+	}
+	for _, column := range query.Select {
+		if column.Alias != "" {
+			aliases[column.Alias] = true
+		}
+		if column.Aggregate != nil {
+			agg, err := s.rewrite(column.Aggregate, nil)
+			if err != nil {
+				return nil, err
+			}
+			rewritten.Select = append(rewritten.Select, parser.SelectColumn{Aggregate: agg.(*parser.AggregateExpr), Alias: column.Alias})
+			continue
+		}
+		ref, err := s.resolve(&parser.ColumnRef{Table: column.Table, Name: column.Name})
+		if err != nil {
+			return nil, err
+		}
+		rewritten.Select = append(rewritten.Select, parser.SelectColumn{Name: ref.Name, Alias: column.Alias})
+	}
+
+	for i := range query.GroupBy {
+		ref, err := s.resolve(&query.GroupBy[i])
+		if err != nil {
+			return nil, err
+		}
+		rewritten.GroupBy = append(rewritten.GroupBy, *ref)
+	}
+	for _, item := range query.OrderBy {
+		switch {
+		case item.Aggregate != nil:
+			agg, err := s.rewrite(item.Aggregate, nil)
+			if err != nil {
+				return nil, err
+			}
+			item.Aggregate = agg.(*parser.AggregateExpr)
+		case item.Table == "" && aliases[item.Column]:
+		default:
+			ref, err := s.resolve(&parser.ColumnRef{Table: item.Table, Name: item.Column})
+			if err != nil {
+				return nil, err
+			}
+			item.Table, item.Column = "", ref.Name
+		}
+		rewritten.OrderBy = append(rewritten.OrderBy, item)
+	}
+
+	var err error
+	if rewritten.Where, err = s.rewrite(query.Where, nil); err != nil {
+		return nil, err
+	}
+	if rewritten.Having, err = s.rewrite(query.Having, aliases); err != nil {
+		return nil, err
+	}
+	return rewritten, nil
+}
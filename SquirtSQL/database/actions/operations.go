@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/fatih/color"
+	"io"
+	"os"
 	"sort"
 	"sync"
 	"v4/database"
@@ -13,18 +15,39 @@ import (
 type Database struct {
 	Tables  map[string]*database.Table
 	Mu      sync.RWMutex
-	Storage *storage.CSVStorage
+	Storage *storage.Storage
+	// CheckpointSize is the log size that triggers MaybeCheckpoint.
+	CheckpointSize int64
+	// Out receives the messages of LoadTables.
+	Out io.Writer
+
+	parent  *Database
+	dirty   map[string]bool
+	base    map[string]txBase
+	pending []storage.Change
+
+	statsMu sync.Mutex
+	stats   map[string]*TableStats
+
+	checksMu sync.Mutex
+	checks   map[string]checkCache
 }
 
-func NewDatabase(storage *storage.CSVStorage) *Database {
+func NewDatabase(storage *storage.Storage) *Database {
 	db := &Database{
-		Tables:  make(map[string]*database.Table),
-		Storage: storage,
+		Tables:         make(map[string]*database.Table),
+		Storage:        storage,
+		CheckpointSize: DefaultCheckpointSize,
+		Out:            os.Stdout,
 	}
 	return db
 }
 
 func (db *Database) CreateTable(name string, userFields []string) error {
+	return db.CreateTableSchema(name, database.TextColumns(userFields))
+}
+
+func (db *Database) CreateTableSchema(name string, columns []database.Column) error {
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
@@ -32,45 +55,104 @@ func (db *Database) CreateTable(name string, userFields []string) error {
 		return fmt.Errorf("таблица %s уже существует", name)
 	}
 
-	for _, field := range userFields {
-		if field == "id" {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if column.Name == "id" {
 			return errors.New("поле 'id' зарезервированно системой")
 		}
+		if seen[column.Name] {
+			return fmt.Errorf("поле %s указано несколько раз", column.Name)
+		}
+		seen[column.Name] = true
 	}
 
-	db.Tables[name] = &database.Table{
-		Name:    name,
-		Fields:  userFields,
-		Records: make(map[int]database.Record),
-		NextID:  1,
+	table := database.NewTableFromColumns(name, columns)
+	if err := validateSchema(table); err != nil {
+		return err
+	}
+	if err := db.log(storage.CreateTableChange(table)); err != nil {
+		return err
+	}
+	db.Tables[name] = table
+	if db.parent != nil {
+		db.dirty[name] = true
 	}
 	return nil
 }
 
 func (db *Database) Insert(tableName string, values []string) (int, error) {
+	ids, err := db.InsertRows(tableName, nil, [][]string{values})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (db *Database) InsertRows(tableName string, fields []string, rows [][]string) ([]int, error) {
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	table, ok := db.Tables[tableName]
+	table, ok := db.writableTable(tableName)
 	if !ok {
-		return 0, database.ErrTableNotFound
-	}
-	if !table.ValidateFields(values) {
-		return 0, database.ErrMissFieldCount
+		return nil, database.ErrTableNotFound
 	}
 
 	table.Mu.Lock()
 	defer table.Mu.Unlock()
 
-	id := table.NextID
-	record := make(database.Record)
+	if fields == nil {
+		fields = table.Fields
+	}
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field == "id" {
+			return nil, errors.New("поле 'id' зарезервированно системой")
+		}
+		if !table.HasField(field) {
+			return nil, fmt.Errorf("%w %s", database.ErrUnknownField, field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("поле %s указано несколько раз", field)
+		}
+		seen[field] = true
+	}
 
-	for i, field := range table.Fields {
-		record[field] = values[i]
+	ids := make([]int, 0, len(rows))
+	changed := make(map[int]database.Record, len(rows))
+	for i, values := range rows {
+		if len(values) != len(fields) {
+			return nil, database.ErrMissFieldCount
+		}
+
+		record := make(database.Record, len(table.Fields))
+		for _, field := range table.Fields {
+			record[field] = ""
+		}
+		for j, field := range fields {
+			value, err := table.NormalizeValue(field, values[j])
+			if err != nil {
+				return nil, err
+			}
+			record[field] = value
+		}
+		applyDefaults(table, record)
+
+		id := table.NextID + i
+		changed[id] = record
+		ids = append(ids, id)
+	}
+	if err := db.checkConstraints(table, changed); err != nil {
+		return nil, err
+	}
+	if err := db.log(putChanges(table, changed)...); err != nil {
+		return nil, err
 	}
-	table.Records[id] = record
-	table.NextID++
-	return id, nil
+
+	for id, record := range changed {
+		table.SetRecord(id, record)
+	}
+	table.NextID += len(rows)
+	return ids, nil
 }
 
 func (db *Database) Select(tableName string, id int) (database.Record, error) {
@@ -97,7 +179,7 @@ func (db *Database) Select(tableName string, id int) (database.Record, error) {
 	return record, nil
 }
 
-func (db *Database) SelectAll(tableName string) (map[int]database.Record, error) {
+func (db *Database) SelectAll(tableName string) ([]database.Row, error) {
 	db.Mu.RLock()
 	defer db.Mu.RUnlock()
 
@@ -109,25 +191,16 @@ func (db *Database) SelectAll(tableName string) (map[int]database.Record, error)
 	table.Mu.RLock()
 	defer table.Mu.RUnlock()
 
-	sortedCopy := make(map[int]database.Record)
-	ids := make([]int, 0, len(table.Records))
-	for id := range table.Records {
-		ids = append(ids, id)
-	}
-	sort.Ints(ids)
-
-	for _, id := range ids {
-		sortedCopy[id] = table.Records[id]
-	}
-
-	return sortedCopy, nil
+	scan := newScan(table, "")
+	defer scan.Close()
+	return drain(scan)
 }
 
 func (db *Database) Update(tableName string, id int, values []string) error {
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	table, exist := db.Tables[tableName]
+	table, exist := db.writableTable(tableName)
 	if !exist {
 		return database.ErrTableNotFound
 	}
@@ -139,15 +212,25 @@ func (db *Database) Update(tableName string, id int, values []string) error {
 	table.Mu.Lock()
 	defer table.Mu.Unlock()
 
-	record, exists := table.Records[id]
-	if !exists {
+	if _, exists := table.Records[id]; !exists {
 		return database.ErrRecordNotFound
 	}
 
-	for i := 0; i < len(table.Fields); i++ {
-		fieldName := table.Fields[i]
-		record[fieldName] = values[i]
+	updated := make(database.Record, len(table.Fields))
+	for i, field := range table.Fields {
+		value, err := table.NormalizeValue(field, values[i])
+		if err != nil {
+			return err
+		}
+		updated[field] = value
+	}
+	if err := db.checkConstraints(table, map[int]database.Record{id: updated}); err != nil {
+		return err
+	}
+	if err := db.log(storage.PutChange(tableName, id, updated)); err != nil {
+		return err
 	}
+	table.SetRecord(id, updated)
 
 	return nil
 }
@@ -156,7 +239,7 @@ func (db *Database) Delete(tableName string, id int) error {
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	table, exist := db.Tables[tableName]
+	table, exist := db.writableTable(tableName)
 	if !exist {
 		return database.ErrTableNotFound
 	}
@@ -168,7 +251,10 @@ func (db *Database) Delete(tableName string, id int) error {
 		return database.ErrRecordNotFound
 	}
 
-	delete(table.Records, id)
+	if err := db.log(storage.DeleteChange(tableName, id)); err != nil {
+		return err
+	}
+	table.DeleteRecord(id)
 	return nil
 }
 
@@ -176,22 +262,44 @@ func (db *Database) LoadTables() error {
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	tableNames, err := db.Storage.ListTables()
+	removed, err := db.Storage.RemoveTempFiles()
 	if err != nil {
 		return err
 	}
+	for _, name := range removed {
+		fmt.Fprintf(db.Out, "Удалён незавершённый файл сохранения %s\n", name)
+	}
 
-	for _, name := range tableNames {
-		table, err := db.Storage.LoadTable(name)
+	snapshots, err := db.Storage.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, name := range snapshots {
+		table, err := db.Storage.LoadSnapshot(name)
 		if err != nil {
-			fmt.Printf("Ошибка загрузки таблицы %s : %v\n", name, err)
+			fmt.Fprintf(db.Out, "Ошибка загрузки таблицы %s : %v\n", name, err)
 			continue
 		}
 		db.Tables[name] = table
-		TableColor := color.New(color.FgBlue).SprintFunc()
-		valid := fmt.Sprintf("Таблица %s загружена", name)
-		fmt.Println(TableColor(valid))
+	}
+	if err := db.Storage.Replay(db.Tables); err != nil {
+		return fmt.Errorf("восстановление из журнала: %w", err)
 	}
 
+	names := make([]string, 0, len(db.Tables))
+	for name := range db.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	TableColor := color.New(color.FgBlue).SprintFunc()
+	for _, name := range names {
+		if err := validateSchema(db.Tables[name]); err != nil {
+			fmt.Fprintf(db.Out, "Ошибка загрузки таблицы %s : %v\n", name, err)
+			delete(db.Tables, name)
+			continue
+		}
+		valid := fmt.Sprintf("Таблица %s загружена", name)
+		fmt.Fprintln(db.Out, TableColor(valid))
+	}
 	return nil
 }
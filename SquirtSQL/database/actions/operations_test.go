@@ -1,8 +1,16 @@
 package actions
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"v4/database"
+	"v4/database/parser"
 	"v4/storage"
 )
 
@@ -179,14 +187,14 @@ func TestSelectAll(t *testing.T) {
 	}
 
 	lastID := 0
-	for id, record := range records {
-		if id <= lastID {
+	for _, row := range records {
+		if row.ID <= lastID {
 			t.Error("Records are not sorted by ID")
 		}
-		lastID = id
+		lastID = row.ID
 
-		if len(record) != len(fields) {
-			t.Errorf("Record %d has wrong field count", id)
+		if len(row.Record) != len(fields) {
+			t.Errorf("Record %d has wrong field count", row.ID)
 		}
 	}
 }
@@ -319,3 +327,1895 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectWhere(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	tableName := "users"
+	err := db.CreateTable(tableName, []string{"name", "age", "email"})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	testData := [][]string{
+		{"kolya", "22", "kolya@mail.ru"},
+		{"anna", "19", "anna@gmail.com"},
+		{"petya", "35", "petya@mail.ru"},
+		{"kolya", "9", "small@ya.ru"},
+	}
+	for _, values := range testData {
+		if _, err := db.Insert(tableName, values); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		where   string
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "numeric comparison", where: "age > 20", wantIDs: []int{1, 3}},
+		{name: "numeric not lexical", where: "age < 20", wantIDs: []int{2, 4}},
+		{name: "AND", where: "age > 20 AND name = 'kolya'", wantIDs: []int{1}},
+		{name: "OR with parentheses", where: "(name = 'anna' OR name = 'petya') AND age >= 19", wantIDs: []int{2, 3}},
+		{name: "NOT", where: "NOT name = 'kolya'", wantIDs: []int{2, 3}},
+		{name: "not equal", where: "age != 22", wantIDs: []int{2, 3, 4}},
+		{name: "LIKE", where: "email LIKE '%@mail.ru'", wantIDs: []int{1, 3}},
+		{name: "LIKE single char", where: "name LIKE 'a_na'", wantIDs: []int{2}},
+		{name: "NOT LIKE", where: "email NOT LIKE '%mail%'", wantIDs: []int{4}},
+		{name: "LIKE underscore wildcard", where: `email LIKE 'small_ya%'`, wantIDs: []int{4}},
+		{name: "LIKE escaped underscore", where: `email LIKE 'small\_ya%'`, wantIDs: nil},
+		{name: "LIKE escaped percent", where: `name LIKE 'kol\%'`, wantIDs: nil},
+		{name: "double quoted literal", where: `name = "anna"`, wantIDs: []int{2}},
+		{name: "IN", where: "name IN ('anna', 'petya')", wantIDs: []int{2, 3}},
+		{name: "NOT IN", where: "age NOT IN (22, 9)", wantIDs: []int{2, 3}},
+		{name: "by id", where: "id <= 2", wantIDs: []int{1, 2}},
+		{name: "no matches", where: "age > 100", wantIDs: []int{}},
+		{name: "unknown field", where: "salary > 10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tt.where)
+			if err != nil {
+				t.Fatalf("Failed to parse condition: %v", err)
+			}
+
+			records, err := db.SelectWhere(tableName, expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(records) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d records, got %d", len(tt.wantIDs), len(records))
+			}
+			for i, id := range tt.wantIDs {
+				if records[i].ID != id {
+					t.Errorf("Record %d = %d, want %d", i, records[i].ID, id)
+				}
+			}
+		})
+	}
+
+	if _, err := db.SelectWhere("unknown", nil); err == nil {
+		t.Error("Expected error for unknown table, got nil")
+	}
+}
+
+func TestSelectWhereNulls(t *testing.T) {
+	db := NewDatabase(nil)
+	columns := []database.Column{{Name: "name", Type: database.TypeText}, {Name: "age", Type: database.TypeInt}}
+	if err := db.CreateTableSchema("users", columns); err != nil {
+		t.Fatalf("CreateTableSchema() error = %v", err)
+	}
+	if _, err := db.InsertRows("users", nil, [][]string{{"a", "1"}, {"b", ""}, {"c", "3"}}); err != nil {
+		t.Fatalf("InsertRows() error = %v", err)
+	}
+
+	// A comparison with NULL is unknown, and NOT of unknown is unknown too.
+	tests := []struct {
+		where   string
+		wantIDs []int
+	}{
+		{where: "age = 1", wantIDs: []int{1}},
+		{where: "NOT (age = 1)", wantIDs: []int{3}},
+		{where: "NOT (age > 1 AND name = 'b')", wantIDs: []int{1, 3}},
+		{where: "NOT (age > 1 OR name = 'b')", wantIDs: []int{1}},
+		{where: "age > 1 OR name = 'b'", wantIDs: []int{2, 3}},
+		{where: "NOT (age < 2 OR age > 2)", wantIDs: nil},
+		{where: "age NOT IN (1, 2)", wantIDs: []int{3}},
+		{where: "NOT (age IN (1, 2))", wantIDs: []int{3}},
+		{where: "age NOT LIKE '1%'", wantIDs: []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			rows, err := db.SelectWhere("users", mustParseExpr(t, tt.where))
+			if err != nil {
+				t.Fatalf("SelectWhere() error = %v", err)
+			}
+			var ids []int
+			for _, row := range rows {
+				ids = append(ids, row.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("SelectWhere(%q) = %v, want %v", tt.where, ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteWhere(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	tableName := "users"
+	err := db.CreateTable(tableName, []string{"name", "age", "email"})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	testData := [][]string{
+		{"kolya", "22", "kolya@mail.ru"},
+		{"anna", "17", "anna@gmail.com"},
+		{"kolya", "15", "small@ya.ru"},
+		{"petya", "35", "petya@mail.ru"},
+	}
+	for _, values := range testData {
+		if _, err := db.Insert(tableName, values); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+
+	where, _ := parser.ParseExpr("name = 'kolya'")
+	count, err := db.UpdateWhere(tableName, []parser.Assignment{{Column: "email", Value: "x"}}, where)
+	if err != nil {
+		t.Fatalf("UpdateWhere() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("UpdateWhere() affected %d records, want 2", count)
+	}
+	for _, id := range []int{1, 3} {
+		record, _ := db.Select(tableName, id)
+		if record["email"] != "x" {
+			t.Errorf("Record %d email = %s, want x", id, record["email"])
+		}
+	}
+	if record, _ := db.Select(tableName, 2); record["email"] != "anna@gmail.com" {
+		t.Errorf("Record 2 should not be updated, got email %s", record["email"])
+	}
+
+	if _, err := db.UpdateWhere(tableName, []parser.Assignment{{Column: "salary", Value: "1"}}, nil); err == nil {
+		t.Error("Expected error for unknown field, got nil")
+	}
+	if _, err := db.UpdateWhere(tableName, []parser.Assignment{{Column: "id", Value: "1"}}, nil); err == nil {
+		t.Error("Expected error for id assignment, got nil")
+	}
+
+	where, _ = parser.ParseExpr("age < 18")
+	count, err = db.DeleteWhere(tableName, where)
+	if err != nil {
+		t.Fatalf("DeleteWhere() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("DeleteWhere() affected %d records, want 2", count)
+	}
+	records, _ := db.SelectAll(tableName)
+	if len(records) != 2 {
+		t.Errorf("Expected 2 records after delete, got %d", len(records))
+	}
+
+	count, err = db.DeleteWhere(tableName, where)
+	if err != nil || count != 0 {
+		t.Errorf("Repeated DeleteWhere() = %d, %v, want 0, nil", count, err)
+	}
+}
+
+func TestSelectQuery(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	tableName := "users"
+	err := db.CreateTable(tableName, []string{"name", "email", "age"})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for _, values := range [][]string{{"kolya", "k@mail.ru", "22"}, {"anna", "a@mail.ru", "19"}, {"petya", "p@mail.ru", "9"}} {
+		if _, err := db.Insert(tableName, values); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantColumns []string
+		wantRows    [][]string
+		wantErr     bool
+	}{
+		{
+			name:        "star keeps table order",
+			input:       "SELECT * FROM users WHERE id < 3",
+			wantColumns: []string{"name", "email", "age"},
+			wantRows:    [][]string{{"kolya", "k@mail.ru", "22"}, {"anna", "a@mail.ru", "19"}},
+		},
+		{
+			name:        "projection in requested order",
+			input:       "SELECT age, name FROM users LIMIT 2",
+			wantColumns: []string{"age", "name"},
+			wantRows:    [][]string{{"22", "kolya"}, {"19", "anna"}},
+		},
+		{
+			name:        "alias and id",
+			input:       "SELECT id, email AS mail FROM users WHERE name = 'anna'",
+			wantColumns: []string{"id", "mail"},
+			wantRows:    [][]string{{"2", "a@mail.ru"}},
+		},
+		{
+			name:        "legacy select by id",
+			input:       "SELECT users 1",
+			wantColumns: []string{"name", "email", "age"},
+			wantRows:    [][]string{{"kolya", "k@mail.ru", "22"}},
+		},
+		{
+			name:        "order by descending",
+			input:       "SELECT name FROM users ORDER BY age DESC",
+			wantColumns: []string{"name"},
+			wantRows:    [][]string{{"kolya"}, {"anna"}, {"petya"}},
+		},
+		{
+			name:        "order by several columns and alias",
+			input:       "SELECT name AS n, age FROM users ORDER BY n, age DESC",
+			wantColumns: []string{"n", "age"},
+			wantRows:    [][]string{{"anna", "19"}, {"kolya", "22"}, {"petya", "9"}},
+		},
+		{
+			name:        "numeric order",
+			input:       "SELECT age FROM users ORDER BY age",
+			wantColumns: []string{"age"},
+			wantRows:    [][]string{{"9"}, {"19"}, {"22"}},
+		},
+		{
+			name:        "limit and offset",
+			input:       "SELECT users * ORDER BY id DESC LIMIT 1 OFFSET 1",
+			wantColumns: []string{"name", "email", "age"},
+			wantRows:    [][]string{{"anna", "a@mail.ru", "19"}},
+		},
+		{
+			name:        "offset past end",
+			input:       "SELECT name FROM users OFFSET 10",
+			wantColumns: []string{"name"},
+			wantRows:    nil,
+		},
+		{
+			name:        "qualified names",
+			input:       "SELECT users.name FROM users WHERE users.age > 10 ORDER BY users.age",
+			wantColumns: []string{"name"},
+			wantRows:    [][]string{{"anna"}, {"kolya"}},
+		},
+		{
+			name:    "qualified name of other table",
+			input:   "SELECT orders.name FROM users",
+			wantErr: true,
+		},
+		{
+			name:    "order by unknown column",
+			input:   "SELECT name FROM users ORDER BY salary",
+			wantErr: true,
+		},
+		{
+			name:    "unknown column",
+			input:   "SELECT salary FROM users",
+			wantErr: true,
+		},
+		{
+			name:    "unknown record",
+			input:   "SELECT users 42",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+
+			result, err := db.SelectQuery(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if strings.Join(result.Columns, ",") != strings.Join(tt.wantColumns, ",") {
+				t.Errorf("Columns = %v, want %v", result.Columns, tt.wantColumns)
+			}
+			if len(result.Rows) != len(tt.wantRows) {
+				t.Fatalf("Expected %d rows, got %d", len(tt.wantRows), len(result.Rows))
+			}
+			for i, row := range result.Rows {
+				if strings.Join(row.Values, ",") != strings.Join(tt.wantRows[i], ",") {
+					t.Errorf("Row %d = %v, want %v", i, row.Values, tt.wantRows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectAggregates(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	err := db.CreateTableSchema("users", []database.Column{
+		{Name: "name", Type: database.TypeText},
+		{Name: "city", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+		{Name: "salary", Type: database.TypeFloat},
+		{Name: "active", Type: database.TypeBool},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	testData := [][]string{
+		{"kolya", "msk", "22", "100.5", "true"},
+		{"anna", "spb", "19", "200", "false"},
+		{"petya", "msk", "35", "50", "true"},
+		{"olga", "spb", "", "80", "true"},
+		{"ivan", "kzn", "40", "", "false"},
+	}
+	if _, err := db.InsertRows("users", nil, testData); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantColumns []string
+		wantRows    [][]string
+		wantErr     bool
+	}{
+		{
+			name:        "aggregates over whole table",
+			input:       "SELECT COUNT(*), COUNT(age), SUM(age), AVG(age), MIN(age), MAX(age) FROM users",
+			wantColumns: []string{"COUNT(*)", "COUNT(age)", "SUM(age)", "AVG(age)", "MIN(age)", "MAX(age)"},
+			wantRows:    [][]string{{"5", "4", "116", "29", "19", "40"}},
+		},
+		{
+			name:        "group by with order",
+			input:       "SELECT city, COUNT(*) AS n, SUM(salary) FROM users GROUP BY city ORDER BY city",
+			wantColumns: []string{"city", "n", "SUM(salary)"},
+			wantRows:    [][]string{{"kzn", "1", ""}, {"msk", "2", "150.5"}, {"spb", "2", "280"}},
+		},
+		{
+			name:        "having and order by alias",
+			input:       "SELECT city, COUNT(*) AS n FROM users GROUP BY city HAVING COUNT(*) > 1 ORDER BY n DESC, city DESC",
+			wantColumns: []string{"city", "n"},
+			wantRows:    [][]string{{"spb", "2"}, {"msk", "2"}},
+		},
+		{
+			name:        "having by alias of average",
+			input:       "SELECT city, AVG(age) AS a FROM users GROUP BY city HAVING a >= 30",
+			wantColumns: []string{"city", "a"},
+			wantRows:    [][]string{{"kzn", "40"}},
+		},
+		{
+			name:        "order by aggregate with limit",
+			input:       "SELECT city FROM users GROUP BY city ORDER BY SUM(salary) DESC LIMIT 1",
+			wantColumns: []string{"city"},
+			wantRows:    [][]string{{"spb"}},
+		},
+		{
+			name:        "where before grouping",
+			input:       "SELECT city, COUNT(*) FROM users WHERE active = true GROUP BY city ORDER BY city",
+			wantColumns: []string{"city", "COUNT(*)"},
+			wantRows:    [][]string{{"msk", "2"}, {"spb", "1"}},
+		},
+		{
+			name:        "typed min and max",
+			input:       "SELECT MIN(name), MAX(salary), MIN(active) FROM users",
+			wantColumns: []string{"MIN(name)", "MAX(salary)", "MIN(active)"},
+			wantRows:    [][]string{{"anna", "200", "false"}},
+		},
+		{
+			name:        "no matching rows without group by",
+			input:       "SELECT COUNT(*), MAX(age) FROM users WHERE age > 100",
+			wantColumns: []string{"COUNT(*)", "MAX(age)"},
+			wantRows:    [][]string{{"0", ""}},
+		},
+		{
+			name:        "no matching rows with group by",
+			input:       "SELECT city, COUNT(*) FROM users WHERE age > 100 GROUP BY city",
+			wantColumns: []string{"city", "COUNT(*)"},
+			wantRows:    nil,
+		},
+		{name: "column not in group by", input: "SELECT name, COUNT(*) FROM users GROUP BY city", wantErr: true},
+		{name: "star with group by", input: "SELECT * FROM users GROUP BY city", wantErr: true},
+		{name: "sum of bool", input: "SELECT SUM(active) FROM users", wantErr: true},
+		{name: "sum of text", input: "SELECT SUM(name) FROM users", wantErr: true},
+		{name: "aggregate in where", input: "SELECT city FROM users WHERE COUNT(*) > 1 GROUP BY city", wantErr: true},
+		{name: "having on ungrouped column", input: "SELECT city FROM users GROUP BY city HAVING age > 1", wantErr: true},
+		{name: "order by ungrouped column", input: "SELECT city FROM users GROUP BY city ORDER BY age", wantErr: true},
+		{name: "unknown column in aggregate", input: "SELECT MAX(height) FROM users", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+
+			result, err := db.SelectQuery(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if strings.Join(result.Columns, ",") != strings.Join(tt.wantColumns, ",") {
+				t.Errorf("Columns = %v, want %v", result.Columns, tt.wantColumns)
+			}
+			if len(result.Rows) != len(tt.wantRows) {
+				t.Fatalf("Expected %d rows, got %d: %v", len(tt.wantRows), len(result.Rows), result.Rows)
+			}
+			for i, row := range result.Rows {
+				if strings.Join(row.Values, ",") != strings.Join(tt.wantRows[i], ",") {
+					t.Errorf("Row %d = %v, want %v", i, row.Values, tt.wantRows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectJoin(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	if err := db.CreateTableSchema("users", []database.Column{
+		{Name: "name", Type: database.TypeText},
+		{Name: "city", Type: database.TypeText},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := db.CreateTableSchema("orders", []database.Column{
+		{Name: "user_id", Type: database.TypeInt},
+		{Name: "total", Type: database.TypeFloat},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.InsertRows("users", nil, [][]string{{"kolya", "msk"}, {"anna", "spb"}, {"petya", "msk"}}); err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	if _, err := db.InsertRows("orders", nil, [][]string{{"1", "100"}, {"1", "50.5"}, {"2", "70"}, {"9", "10"}}); err != nil {
+		t.Fatalf("Failed to insert orders: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		wantColumns []string
+		wantRows    [][]string
+		wantErr     bool
+	}{
+		{
+			name:        "inner join",
+			input:       "SELECT u.name, o.total FROM users u JOIN orders o ON o.user_id = u.id ORDER BY o.total",
+			wantColumns: []string{"u.name", "o.total"},
+			wantRows:    [][]string{{"kolya", "50.5"}, {"anna", "70"}, {"kolya", "100"}},
+		},
+		{
+			name:        "left join keeps unmatched rows",
+			input:       "SELECT u.name, o.total FROM users u LEFT JOIN orders o ON u.id = o.user_id ORDER BY u.id",
+			wantColumns: []string{"u.name", "o.total"},
+			wantRows:    [][]string{{"kolya", "100"}, {"kolya", "50.5"}, {"anna", "70"}, {"petya", ""}},
+		},
+		{
+			name:        "join with residual condition",
+			input:       "SELECT u.name, o.total AS sum FROM users u JOIN orders o ON o.user_id = u.id AND o.total > 60",
+			wantColumns: []string{"u.name", "sum"},
+			wantRows:    [][]string{{"kolya", "100"}, {"anna", "70"}},
+		},
+		{
+			name:        "non-equality join",
+			input:       "SELECT u.name, o.total FROM users u JOIN orders o ON o.total > 60 AND o.user_id != u.id ORDER BY u.name, o.total",
+			wantColumns: []string{"u.name", "o.total"},
+			wantRows:    [][]string{{"anna", "100"}, {"kolya", "70"}, {"petya", "70"}, {"petya", "100"}},
+		},
+		{
+			name:        "table names without aliases",
+			input:       "SELECT users.name, orders.total FROM users JOIN orders ON orders.user_id = users.id WHERE users.city = 'spb'",
+			wantColumns: []string{"users.name", "orders.total"},
+			wantRows:    [][]string{{"anna", "70"}},
+		},
+		{
+			name:        "unqualified unique columns",
+			input:       "SELECT name, total FROM users u JOIN orders o ON user_id = u.id ORDER BY total LIMIT 1",
+			wantColumns: []string{"u.name", "o.total"},
+			wantRows:    [][]string{{"kolya", "50.5"}},
+		},
+		{
+			name:        "star over join",
+			input:       "SELECT * FROM users u JOIN orders o ON o.user_id = u.id LIMIT 1",
+			wantColumns: []string{"u.name", "u.city", "o.user_id", "o.total"},
+			wantRows:    [][]string{{"kolya", "msk", "1", "100"}},
+		},
+		{
+			name:        "aggregates over left join",
+			input:       "SELECT u.name, COUNT(o.id) AS n, SUM(o.total) FROM users u LEFT JOIN orders o ON o.user_id = u.id GROUP BY u.name ORDER BY n DESC, u.name",
+			wantColumns: []string{"u.name", "n", "SUM(o.total)"},
+			wantRows:    [][]string{{"kolya", "2", "150.5"}, {"anna", "1", "70"}, {"petya", "0", ""}},
+		},
+		{
+			name:        "self join",
+			input:       "SELECT a.name, b.name FROM users a JOIN users b ON a.city = b.city AND a.id < b.id",
+			wantColumns: []string{"a.name", "b.name"},
+			wantRows:    [][]string{{"kolya", "petya"}},
+		},
+		{
+			name:        "single table alias",
+			input:       "SELECT u.name FROM users u WHERE u.city = 'msk'",
+			wantColumns: []string{"u.name"},
+			wantRows:    [][]string{{"kolya"}, {"petya"}},
+		},
+		{name: "ambiguous column", input: "SELECT id FROM users u JOIN orders o ON o.user_id = u.id", wantErr: true},
+		{name: "unknown alias", input: "SELECT x.name FROM users u JOIN orders o ON o.user_id = u.id", wantErr: true},
+		{name: "table used twice", input: "SELECT * FROM users JOIN users ON users.id = users.id", wantErr: true},
+		{name: "unknown join table", input: "SELECT * FROM users u JOIN payments p ON p.user_id = u.id", wantErr: true},
+		{name: "ON refers to later table", input: "SELECT * FROM users u JOIN orders o ON o.user_id = x.id", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+
+			result, err := db.SelectQuery(query)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if strings.Join(result.Columns, ",") != strings.Join(tt.wantColumns, ",") {
+				t.Errorf("Columns = %v, want %v", result.Columns, tt.wantColumns)
+			}
+			if len(result.Rows) != len(tt.wantRows) {
+				t.Fatalf("Expected %d rows, got %d: %v", len(tt.wantRows), len(result.Rows), result.Rows)
+			}
+			for i, row := range result.Rows {
+				if strings.Join(row.Values, ",") != strings.Join(tt.wantRows[i], ",") {
+					t.Errorf("Row %d = %v, want %v", i, row.Values, tt.wantRows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitEquiJoin(t *testing.T) {
+	on, err := parser.ParseExpr("o.total > 1 AND u.id = o.user_id AND o.id != 3")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	scope := joinScope{
+		{name: "u", table: database.NewTable("users", []string{"name"})},
+		{name: "o", table: database.NewTable("orders", []string{"user_id", "total"})},
+	}
+	on, err = scope.rewrite(on, nil)
+	if err != nil {
+		t.Fatalf("rewrite() error = %v", err)
+	}
+
+	left, right, rest, ok := splitEquiJoin(on, "o")
+	if !ok {
+		t.Fatal("Expected an equality condition for hash join")
+	}
+	if left.Name != "u.id" || right.Name != "o.user_id" {
+		t.Errorf("Keys = %s, %s, want u.id, o.user_id", left.Name, right.Name)
+	}
+	if rest.String() != "(o.total > 1 AND o.id != 3)" {
+		t.Errorf("Residual = %s", rest)
+	}
+
+	on, _ = parser.ParseExpr("o.total > 1")
+	if _, _, _, ok := splitEquiJoin(on, "o"); ok {
+		t.Error("Expected no hash join for a non-equality condition")
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	if err := db.CreateTableSchema("users", []database.Column{
+		{Name: "email", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	var rows [][]string
+	for i := 0; i < 50; i++ {
+		rows = append(rows, []string{fmt.Sprintf("user%d@mail.ru", i), strconv.Itoa(i % 10)})
+	}
+	rows = append(rows, []string{"", ""})
+	if _, err := db.InsertRows("users", nil, rows); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+
+	queries := []string{
+		"email = 'user7@mail.ru'",
+		"age = 3",
+		"age >= 8",
+		"5 > age AND email LIKE '%1%'",
+		"age IN (1, 2, 2)",
+		"age > 3 AND age <= 5",
+		"email = ''",
+		"id = 7",
+		"age < 100 OR email = 'x'",
+	}
+	scanned := make(map[string][]database.Row)
+	for _, where := range queries {
+		expr, err := parser.ParseExpr(where)
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		scanned[where], err = db.SelectWhere("users", expr)
+		if err != nil {
+			t.Fatalf("SelectWhere(%s) error = %v", where, err)
+		}
+	}
+
+	if err := db.CreateIndex("users", "users_email", "email", database.IndexHash); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	if err := db.CreateIndex("users", "users_age", "age", database.IndexOrdered); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	for _, where := range queries {
+		expr, _ := parser.ParseExpr(where)
+		got, err := db.SelectWhere("users", expr)
+		if err != nil {
+			t.Fatalf("SelectWhere(%s) with index error = %v", where, err)
+		}
+		if len(got) != len(scanned[where]) {
+			t.Errorf("%s: index returned %d rows, scan %d", where, len(got), len(scanned[where]))
+		}
+	}
+
+	table := db.Tables["users"]
+	stats, err := db.tableStats(table)
+	if err != nil {
+		t.Fatalf("tableStats() error = %v", err)
+	}
+	for where, want := range map[string]bool{"age >= 8": true, "email = 'a' AND age > 1": true, "id = 3": true, "age < 100 OR email = 'x'": false, "email LIKE 'a%'": false} {
+		expr, _ := parser.ParseExpr(where)
+		if path := chooseAccess(table, stats, expr); (path.conjunct != nil) != want {
+			t.Errorf("chooseAccess(%s) used index = %v, want %v", where, path.index, want)
+		}
+	}
+
+	if err := db.Update("users", 1, []string{"new@mail.ru", "42"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, err := db.UpdateWhere("users", []parser.Assignment{{Column: "age", Value: "77"}}, mustParseExpr(t, "email = 'user2@mail.ru'")); err != nil {
+		t.Fatalf("UpdateWhere() error = %v", err)
+	}
+	if _, err := db.DeleteWhere("users", mustParseExpr(t, "age = 9")); err != nil {
+		t.Fatalf("DeleteWhere() error = %v", err)
+	}
+	if _, err := db.Insert("users", []string{"late@mail.ru", "42"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	for where, want := range map[string]int{
+		"email = 'user0@mail.ru'": 0,
+		"email = 'new@mail.ru'":   1,
+		"age = 42":                2,
+		"age = 77":                1,
+		"age = 9":                 0,
+		"age > 40":                3,
+	} {
+		got, err := db.SelectWhere("users", mustParseExpr(t, where))
+		if err != nil {
+			t.Fatalf("SelectWhere(%s) error = %v", where, err)
+		}
+		if len(got) != want {
+			t.Errorf("%s: got %d rows after changes, want %d", where, len(got), want)
+		}
+	}
+
+	if err := db.CreateIndex("users", "users_email", "age", database.IndexHash); !errors.Is(err, database.ErrIndexExists) {
+		t.Errorf("CreateIndex() duplicate error = %v, want ErrIndexExists", err)
+	}
+	if err := db.CreateIndex("users", "users_x", "height", database.IndexHash); err == nil {
+		t.Error("CreateIndex() on unknown column should fail")
+	}
+	if tableName, err := db.DropIndex("users_email"); err != nil || tableName != "users" {
+		t.Errorf("DropIndex() = %q, %v", tableName, err)
+	}
+	if _, err := db.DropIndex("users_email"); !errors.Is(err, database.ErrIndexNotFound) {
+		t.Errorf("DropIndex() twice error = %v, want ErrIndexNotFound", err)
+	}
+}
+
+func plannerTestDB(t *testing.T) *Database {
+	t.Helper()
+	db := NewDatabase(nil)
+	columns := []database.Column{
+		{Name: "code", Type: database.TypeText},
+		{Name: "flag", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+	}
+	if err := db.CreateTableSchema("items", columns); err != nil {
+		t.Fatalf("CreateTableSchema() error = %v", err)
+	}
+	var rows [][]string
+	for i := 0; i < 1000; i++ {
+		flag := "on"
+		if i%2 == 1 {
+			flag = "off"
+		}
+		rows = append(rows, []string{"c" + strconv.Itoa(i), flag, strconv.Itoa(i % 100)})
+	}
+	if _, err := db.InsertRows("items", nil, rows); err != nil {
+		t.Fatalf("InsertRows() error = %v", err)
+	}
+	for _, index := range []struct {
+		name, column string
+		kind         database.IndexKind
+	}{
+		{"items_code", "code", database.IndexHash},
+		{"items_flag", "flag", database.IndexHash},
+		{"items_age", "age", database.IndexOrdered},
+	} {
+		if err := db.CreateIndex("items", index.name, index.column, index.kind); err != nil {
+			t.Fatalf("CreateIndex() error = %v", err)
+		}
+	}
+	return db
+}
+
+func planSteps(plan *Plan) []string {
+	step := string(plan.Kind)
+	if plan.Index != "" {
+		step += "(" + plan.Index + ")"
+	}
+	steps := []string{step}
+	for _, child := range plan.Children {
+		steps = append(steps, planSteps(child)...)
+	}
+	return steps
+}
+
+func TestExplain(t *testing.T) {
+	db := plannerTestDB(t)
+
+	tests := []struct {
+		query    string
+		steps    string
+		wantRows int
+	}{
+		{"SELECT * FROM items WHERE code = 'c5'", "Index Scan(items_code)", 1},
+		{"SELECT * FROM items WHERE flag = 'on'", "Filter, Seq Scan", 500},
+		{"SELECT * FROM items WHERE age >= 98 AND flag = 'on'", "Filter, Index Scan(items_age)", 6},
+		{"SELECT * FROM items WHERE age >= 2", "Filter, Seq Scan", 980},
+		{"SELECT * FROM items WHERE age < 2 OR code = 'c1'", "Filter, Seq Scan", 22},
+		{"SELECT * FROM items WHERE id IN (3, 4)", "Index Scan(id)", 2},
+		{"SELECT items 7", "Index Scan(id)", 1},
+		{"SELECT flag, COUNT(*) FROM items GROUP BY flag HAVING COUNT(*) > 1 ORDER BY flag LIMIT 1", "Limit, Sort, Filter, Aggregate, Seq Scan", 1},
+		{"SELECT * FROM items a JOIN items b ON a.code = b.code", "Hash Join, Seq Scan, Seq Scan", 1000},
+		{"SELECT * FROM items a LEFT JOIN items b ON a.age < b.age WHERE b.flag = 'on'", "Filter, Nested Loop, Seq Scan, Seq Scan", 166667},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := parser.ParseQuery("EXPLAIN " + tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			plan, err := db.Explain(query)
+			if err != nil {
+				t.Fatalf("Explain() error = %v", err)
+			}
+			if steps := strings.Join(planSteps(plan), ", "); steps != tt.steps {
+				t.Errorf("plan = %s, want %s\n%s", steps, tt.steps, strings.Join(plan.Lines(), "\n"))
+			}
+			if rows := int(math.Ceil(plan.Rows)); rows != tt.wantRows {
+				t.Errorf("estimated rows = %d, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+
+	lines := db.mustExplain(t, "SELECT code FROM items WHERE age > 97 ORDER BY code DESC LIMIT 5 OFFSET 2").Lines()
+	want := []string{
+		"Limit 5 offset 2 (cost=",
+		"-> Sort by code DESC (cost=",
+		"   -> Index Scan on items using items_age: age > 97 (cost=",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Lines() = %q", lines)
+	}
+	for i := range want {
+		if !strings.HasPrefix(lines[i], want[i]) {
+			t.Errorf("line %d = %q, want prefix %q", i, lines[i], want[i])
+		}
+	}
+
+	for _, input := range []string{"SELECT * FROM missing", "SELECT * FROM items WHERE nope = 1", "SELECT * FROM items a JOIN items a ON a.id = a.id"} {
+		query, _ := parser.ParseQuery("EXPLAIN " + input)
+		if _, err := db.Explain(query); err == nil {
+			t.Errorf("Explain(%s) expected error", input)
+		}
+	}
+}
+
+func (db *Database) mustExplain(t *testing.T, input string) *Plan {
+	t.Helper()
+	query, err := parser.ParseQuery("EXPLAIN " + input)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	plan, err := db.Explain(query)
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	return plan
+}
+
+func TestStats(t *testing.T) {
+	db := plannerTestDB(t)
+
+	stats, err := db.Stats("items")
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	age := stats.Columns["age"]
+	if stats.Rows != 1000 || age.Distinct != 100 || age.Min != "0" || age.Max != "99" || age.Nulls != 0 {
+		t.Fatalf("Stats() = %d rows, age %+v", stats.Rows, age)
+	}
+
+	// A few changes keep the statistics, many collect them again.
+	if _, err := db.UpdateWhere("items", []parser.Assignment{{Column: "age", Value: ""}}, mustParseExpr(t, "id <= 10")); err != nil {
+		t.Fatalf("UpdateWhere() error = %v", err)
+	}
+	if again, _ := db.Stats("items"); again != stats {
+		t.Error("Stats() collected again after 10 changes")
+	}
+	if _, err := db.UpdateWhere("items", []parser.Assignment{{Column: "age", Value: "500"}}, mustParseExpr(t, "id > 10 AND id <= 200")); err != nil {
+		t.Fatalf("UpdateWhere() error = %v", err)
+	}
+	stats, _ = db.Stats("items")
+	if age := stats.Columns["age"]; age.Nulls != 10 || age.Max != "500" {
+		t.Errorf("Stats() after updates: age %+v", age)
+	}
+	if plan := db.mustExplain(t, "SELECT * FROM items WHERE age > 400"); math.Round(plan.Rows) != 198 {
+		t.Errorf("estimated rows for age > 400 = %.1f, want 198", plan.Rows)
+	}
+
+	// A transaction publishes a copy of the table, which keeps the
+	// statistics of the original.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if _, err := tx.Insert("items", []string{"new", "on", "1"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if again, _ := db.Stats("items"); again != stats {
+		t.Error("Stats() collected again after a commit")
+	}
+
+	if _, err := db.Truncate("items"); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if stats, _ := db.Stats("items"); stats.Rows != 0 || stats.Columns["age"].Distinct != 0 {
+		t.Errorf("Stats() after TRUNCATE = %+v", stats)
+	}
+	if _, err := db.Stats("missing"); !errors.Is(err, database.ErrTableNotFound) {
+		t.Errorf("Stats(missing) error = %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	db := plannerTestDB(t)
+
+	open := func(t *testing.T, input string) *Rows {
+		t.Helper()
+		query, err := parser.ParseQuery(input)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) error = %v", input, err)
+		}
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("Query(%q) error = %v", input, err)
+		}
+		return rows
+	}
+
+	// A limit stops reading the rows once it has them.
+	rows := open(t, "SELECT code FROM items LIMIT 3 OFFSET 2")
+	var codes []string
+	for rows.Next() {
+		codes = append(codes, rows.Row().Values[0])
+	}
+	if rows.Err() != nil || fmt.Sprint(codes) != "[c2 c3 c4]" {
+		t.Errorf("rows = %v, error %v", codes, rows.Err())
+	}
+	scan := rows.root.(*limitOp).child.(*scanOp)
+	if scan.read != 5 || scan.next != 5 {
+		t.Errorf("LIMIT 3 OFFSET 2 read %d rows of the table and visited %d ids, want 5", scan.read, scan.next)
+	}
+
+	// So does a limit over an index scan: of the 10 rows the index finds,
+	// only those returned are read and checked.
+	rows = open(t, "SELECT code FROM items WHERE age = 4 LIMIT 2")
+	codes = nil
+	for rows.Next() {
+		codes = append(codes, rows.Row().Values[0])
+	}
+	if rows.Err() != nil || fmt.Sprint(codes) != "[c4 c104]" {
+		t.Errorf("rows = %v, error %v", codes, rows.Err())
+	}
+	scan = rows.root.(*limitOp).child.(*filterOp).child.(*scanOp)
+	if scan.read != 2 || scan.next != 2 {
+		t.Errorf("LIMIT 2 over an index read %d rows of the table and visited %d ids, want 2", scan.read, scan.next)
+	}
+
+	// No lock is held between rows, and the rows stay as they were when
+	// the query was opened.
+	rows = open(t, "SELECT code FROM items WHERE flag = 'on'")
+	defer rows.Close()
+	if !rows.Next() || rows.Row().Values[0] != "c0" {
+		t.Fatalf("first row = %v, error %v", rows.Row(), rows.Err())
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.Insert("items", []string{"late", "on", "1"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Insert() is blocked by open rows")
+	}
+	n := 1
+	for rows.Next() {
+		n++
+	}
+	if rows.Err() != nil || n != 500 {
+		t.Errorf("read %d rows, error %v, want 500 without the row inserted meanwhile", n, rows.Err())
+	}
+	if rows.Next() {
+		t.Error("Next() after the end = true")
+	}
+
+	// Closed rows read nothing more.
+	rows = open(t, "SELECT code FROM items")
+	rows.Next()
+	rows.Close()
+	if rows.Next() {
+		t.Error("Next() after Close() = true")
+	}
+
+	session := db.NewSession()
+	defer session.Close()
+	query, err := parser.ParseQuery("SELECT flag, COUNT(*) FROM items GROUP BY flag ORDER BY flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := session.Open(query)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if result.Stream == nil || result.Rows != nil || fmt.Sprint(result.Columns) != "[flag COUNT(*)]" {
+		t.Fatalf("Open() = %+v, want rows left in the stream", result)
+	}
+	var groups []string
+	for result.Stream.Next() {
+		groups = append(groups, fmt.Sprint(result.Stream.Row()))
+	}
+	if fmt.Sprint(groups) != "[{1 [off 500]} {2 [on 501]}]" {
+		t.Errorf("streamed groups = %v", groups)
+	}
+
+	// A table with most of its rows deleted is scanned in id order too.
+	if err := db.CreateTableSchema("sparse", []database.Column{{Name: "age", Type: database.TypeInt}}); err != nil {
+		t.Fatalf("CreateTableSchema() error = %v", err)
+	}
+	var values [][]string
+	for i := 0; i < 100; i++ {
+		values = append(values, []string{strconv.Itoa(i)})
+	}
+	if _, err := db.InsertRows("sparse", nil, values); err != nil {
+		t.Fatalf("InsertRows() error = %v", err)
+	}
+	if _, err := db.DeleteWhere("sparse", mustParseExpr(t, "id < 96 OR id = 98")); err != nil {
+		t.Fatalf("DeleteWhere() error = %v", err)
+	}
+	var ids []int
+	for rows := open(t, "SELECT id FROM sparse"); rows.Next(); {
+		ids = append(ids, rows.Row().ID)
+	}
+	if fmt.Sprint(ids) != "[96 97 99 100]" {
+		t.Errorf("sparse table ids = %v", ids)
+	}
+
+	// Values that cannot be compared fail the sort instead of leaving the
+	// rows in any order.
+	db.Tables["sparse"].SetRecord(97, database.Record{"age": "many"})
+	query, err = parser.ParseQuery("SELECT age FROM sparse ORDER BY age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Query(query); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("Query() with a bad value error = %v, want %v", err, database.ErrTypeMismatch)
+	}
+}
+
+func mustParseExpr(t *testing.T, input string) parser.Expr {
+	t.Helper()
+	expr, err := parser.ParseExpr(input)
+	if err != nil {
+		t.Fatalf("ParseExpr(%s) error = %v", input, err)
+	}
+	return expr
+}
+
+func TestTypedColumns(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	tableName := "users"
+	err := db.CreateTableSchema(tableName, []database.Column{
+		{Name: "name", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+		{Name: "score", Type: database.TypeFloat},
+		{Name: "active", Type: database.TypeBool},
+		{Name: "born", Type: database.TypeDate},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	if _, err := db.Insert(tableName, []string{"kolya", "abc", "1.5", "true", "2000-01-01"}); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("Insert() with bad INT error = %v, want ErrTypeMismatch", err)
+	}
+	if _, err := db.Insert(tableName, []string{"kolya", "22", "1.5", "yes", "01.01.2000"}); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("Insert() with bad DATE error = %v, want ErrTypeMismatch", err)
+	}
+
+	for _, values := range [][]string{
+		{"kolya", "9", "4.5", "1", "2000-01-01"},
+		{"anna", "10", "3.25", "false", "1999-05-20"},
+		{"petya", "", "", "", ""},
+	} {
+		if _, err := db.Insert(tableName, values); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+
+	record, _ := db.Select(tableName, 1)
+	if record["active"] != "true" {
+		t.Errorf("BOOL value not normalized, got %q", record["active"])
+	}
+
+	if err := db.Update(tableName, 1, []string{"kolya", "x", "1", "true", "2000-01-01"}); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("Update() with bad INT error = %v, want ErrTypeMismatch", err)
+	}
+	if record, _ := db.Select(tableName, 1); record["name"] != "kolya" || record["age"] != "9" {
+		t.Errorf("Failed Update() must not change the record, got %v", record)
+	}
+	if _, err := db.UpdateWhere(tableName, []parser.Assignment{{Column: "score", Value: "high"}}, nil); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("UpdateWhere() with bad FLOAT error = %v, want ErrTypeMismatch", err)
+	}
+
+	tests := []struct {
+		where   string
+		wantIDs []int
+		wantErr bool
+	}{
+		{where: "age < 10", wantIDs: []int{1}},
+		{where: "score > 3.5", wantIDs: []int{1}},
+		{where: "active = true", wantIDs: []int{1}},
+		{where: "born < '2000-01-01'", wantIDs: []int{2}},
+		{where: "age IN (10, 11)", wantIDs: []int{2}},
+		{where: "age != 9", wantIDs: []int{2}},
+		{where: "age > 'abc'", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tt.where)
+			if err != nil {
+				t.Fatalf("Failed to parse condition: %v", err)
+			}
+			rows, err := db.SelectWhere(tableName, expr)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(rows) != len(tt.wantIDs) {
+				t.Fatalf("Expected %d records, got %d", len(tt.wantIDs), len(rows))
+			}
+			for i, id := range tt.wantIDs {
+				if rows[i].ID != id {
+					t.Errorf("Record %d = %d, want %d", i, rows[i].ID, id)
+				}
+			}
+		})
+	}
+
+	query, _ := parser.ParseQuery("SELECT name FROM users ORDER BY age DESC")
+	result, err := db.SelectQuery(query)
+	if err != nil {
+		t.Fatalf("SelectQuery() error = %v", err)
+	}
+	if got := result.Rows[0].Values[0]; got != "anna" {
+		t.Errorf("INT column should sort numerically, first row = %s, want anna", got)
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	query, err := parser.ParseQuery("CREATE TABLE users name NOT NULL, email UNIQUE, age INT DEFAULT 18 CHECK (age >= 0), role DEFAULT 'user'")
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if err := db.CreateTableSchema(query.Table, query.Columns); err != nil {
+		t.Fatalf("CreateTableSchema() error = %v", err)
+	}
+
+	id, err := db.Insert("users", []string{"kolya", "k@mail.ru", "", ""})
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	record, _ := db.Select("users", id)
+	if record["age"] != "18" || record["role"] != "user" {
+		t.Errorf("Defaults not applied, got %v", record)
+	}
+	if _, err := db.Insert("users", []string{"anna", "", "20", "admin"}); err != nil {
+		t.Fatalf("Insert() with NULL unique value error = %v", err)
+	}
+	if _, err := db.Insert("users", []string{"petya", "", "30", "admin"}); err != nil {
+		t.Fatalf("Insert() with second NULL unique value error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr error
+	}{
+		{name: "not null", values: []string{"", "x@mail.ru", "20", ""}, wantErr: database.ErrNotNull},
+		{name: "unique", values: []string{"vasya", "k@mail.ru", "20", ""}, wantErr: database.ErrUnique},
+		{name: "check", values: []string{"vasya", "v@mail.ru", "-1", ""}, wantErr: database.ErrCheck},
+		{name: "type before constraints", values: []string{"vasya", "v@mail.ru", "old", ""}, wantErr: database.ErrTypeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(db.Tables["users"].Records)
+			if _, err := db.Insert("users", tt.values); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Insert() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(db.Tables["users"].Records) != before {
+				t.Error("Failed Insert() must not add a record")
+			}
+		})
+	}
+
+	if err := db.Update("users", id, []string{"kolya", "k@mail.ru", "19", "user"}); err != nil {
+		t.Errorf("Update() keeping own unique value error = %v", err)
+	}
+	if err := db.Update("users", id, []string{"kolya", "k@mail.ru", "-5", "user"}); !errors.Is(err, database.ErrCheck) {
+		t.Errorf("Update() error = %v, want ErrCheck", err)
+	}
+	if err := db.Update("users", id, []string{"", "k@mail.ru", "19", "user"}); !errors.Is(err, database.ErrNotNull) {
+		t.Errorf("Update() error = %v, want ErrNotNull", err)
+	}
+
+	where, _ := parser.ParseExpr("role = 'admin'")
+	_, err = db.UpdateWhere("users", []parser.Assignment{{Column: "email", Value: "same@mail.ru"}}, where)
+	if !errors.Is(err, database.ErrUnique) {
+		t.Errorf("UpdateWhere() error = %v, want ErrUnique", err)
+	}
+	if record, _ := db.Select("users", 2); record["email"] != "" {
+		t.Error("Failed UpdateWhere() must not change any record")
+	}
+
+	if err := db.Update("users", id, []string{"kolya", "new@mail.ru", "19", "user"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if _, err := db.Insert("users", []string{"vasya", "k@mail.ru", "20", ""}); err != nil {
+		t.Errorf("Insert() with a freed unique value error = %v", err)
+	}
+	if _, err := db.Insert("users", []string{"misha", "new@mail.ru", "20", ""}); !errors.Is(err, database.ErrUnique) {
+		t.Errorf("Insert() error = %v, want ErrUnique", err)
+	}
+	if err := db.Delete("users", id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := db.Insert("users", []string{"misha", "new@mail.ru", "20", ""}); err != nil {
+		t.Errorf("Insert() with the value of a deleted record error = %v", err)
+	}
+
+	// The CHECK is parsed once for the table and again once it changes.
+	cached := db.checks["users"]
+	if cached.table != db.Tables["users"] || cached.checks["age"] == nil {
+		t.Errorf("parsed checks = %+v", cached)
+	}
+	if err := db.AddColumn("users", database.Column{Name: "note", Type: database.TypeText}); err != nil {
+		t.Fatalf("AddColumn() error = %v", err)
+	}
+	if _, exist := db.checks["users"]; exist {
+		t.Error("checks of the table before ALTER are kept")
+	}
+	if _, err := db.Insert("users", []string{"sasha", "", "-1", "", ""}); !errors.Is(err, database.ErrCheck) {
+		t.Errorf("Insert() after ALTER error = %v, want ErrCheck", err)
+	}
+	if db.checks["users"].table != db.Tables["users"] {
+		t.Error("checks not parsed again for the altered table")
+	}
+
+	if err := db.CreateTableSchema("bad", []database.Column{{Name: "age", Type: database.TypeInt, HasDefault: true, Default: "old"}}); !errors.Is(err, database.ErrTypeMismatch) {
+		t.Errorf("CreateTableSchema() with bad DEFAULT error = %v, want ErrTypeMismatch", err)
+	}
+	if err := db.CreateTableSchema("bad", []database.Column{{Name: "age", Type: database.TypeInt, Check: "salary > 0"}}); !errors.Is(err, database.ErrUnknownField) {
+		t.Errorf("CreateTableSchema() with bad CHECK error = %v, want ErrUnknownField", err)
+	}
+}
+
+func TestInsertRows(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	err := db.CreateTableSchema("users", []database.Column{
+		{Name: "name", Type: database.TypeText, NotNull: true},
+		{Name: "email", Type: database.TypeText, Unique: true},
+		{Name: "age", Type: database.TypeInt, HasDefault: true, Default: "18"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	ids, err := db.InsertRows("users", []string{"age", "name"}, [][]string{{"3", "a"}, {"", "b"}})
+	if err != nil {
+		t.Fatalf("InsertRows() error = %v", err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("InsertRows() ids = %v, want [1 2]", ids)
+	}
+	record, _ := db.Select("users", 2)
+	if record["name"] != "b" || record["age"] != "18" || record["email"] != "" {
+		t.Errorf("Missing fields should be filled with defaults, got %v", record)
+	}
+
+	tests := []struct {
+		name    string
+		fields  []string
+		rows    [][]string
+		wantErr error
+	}{
+		{name: "unknown field", fields: []string{"salary"}, rows: [][]string{{"1"}}, wantErr: database.ErrUnknownField},
+		{name: "count mismatch", fields: []string{"name"}, rows: [][]string{{"c", "d"}}, wantErr: database.ErrMissFieldCount},
+		{name: "not null on missing field", fields: []string{"email"}, rows: [][]string{{"c@mail.ru"}}, wantErr: database.ErrNotNull},
+		{name: "unique inside batch", fields: []string{"name", "email"}, rows: [][]string{{"c", "x@mail.ru"}, {"d", "x@mail.ru"}}, wantErr: database.ErrUnique},
+		{name: "type in second row", fields: []string{"name", "age"}, rows: [][]string{{"c", "1"}, {"d", "old"}}, wantErr: database.ErrTypeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.InsertRows("users", tt.fields, tt.rows)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("InsertRows() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(db.Tables["users"].Records) != 2 || db.Tables["users"].NextID != 3 {
+				t.Error("Failed InsertRows() must not insert any row")
+			}
+		})
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	if err := db.CreateTableSchema("accounts", []database.Column{
+		{Name: "owner", Type: database.TypeText},
+		{Name: "balance", Type: database.TypeInt},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.InsertRows("accounts", nil, [][]string{{"a", "100"}, {"b", "100"}}); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	if err := db.Storage.SaveTable(db.Tables["accounts"]); err != nil {
+		t.Fatalf("SaveTable() error = %v", err)
+	}
+	balance := func(db *Database, id int) string {
+		record, err := db.Select("accounts", id)
+		if err != nil {
+			t.Fatalf("Select() error = %v", err)
+		}
+		return record["balance"]
+	}
+	transfer := func(tx *Database) {
+		if err := tx.Update("accounts", 1, []string{"a", "90"}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if err := tx.Update("accounts", 2, []string{"b", "110"}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	t.Run("rollback discards changes", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		transfer(tx)
+		if got := balance(tx, 1); got != "90" {
+			t.Errorf("balance inside transaction = %s, want 90", got)
+		}
+		if got := balance(db, 1); got != "100" {
+			t.Errorf("balance outside transaction = %s, want 100", got)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+		if got := balance(db, 1); got != "100" {
+			t.Errorf("balance after rollback = %s, want 100", got)
+		}
+		if err := tx.Rollback(); !errors.Is(err, ErrNoTransaction) {
+			t.Errorf("second Rollback() error = %v, want %v", err, ErrNoTransaction)
+		}
+	})
+
+	t.Run("commit publishes and saves changes", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		if _, err := tx.Begin(); !errors.Is(err, ErrNestedTransaction) {
+			t.Errorf("nested Begin() error = %v, want %v", err, ErrNestedTransaction)
+		}
+		transfer(tx)
+		if err := tx.CreateTable("log", []string{"note"}); err != nil {
+			t.Fatalf("CreateTable() error = %v", err)
+		}
+		if got := tx.Changed(); strings.Join(got, ",") != "accounts,log" {
+			t.Errorf("Changed() = %v, want [accounts log]", got)
+		}
+		if _, exist := db.Tables["log"]; exist {
+			t.Error("table created in transaction is visible before commit")
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+		if got := balance(db, 1) + "," + balance(db, 2); got != "90,110" {
+			t.Errorf("balances after commit = %s, want 90,110", got)
+		}
+		saved, err := db.Storage.LoadTable("accounts")
+		if err != nil {
+			t.Fatalf("LoadTable() error = %v", err)
+		}
+		if saved.Records[2]["balance"] != "110" {
+			t.Errorf("saved balance = %s, want 110", saved.Records[2]["balance"])
+		}
+		if !db.Storage.TableExist("log") {
+			t.Error("table created in transaction was not saved")
+		}
+	})
+
+	t.Run("concurrent change aborts commit", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		transfer(tx)
+		if err := db.Delete("accounts", 2); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, ErrTxConflict) {
+			t.Fatalf("Commit() error = %v, want %v", err, ErrTxConflict)
+		}
+		if _, err := db.Select("accounts", 2); !errors.Is(err, database.ErrRecordNotFound) {
+			t.Errorf("conflicting commit overwrote the table")
+		}
+	})
+
+	t.Run("indexes are copied with the table", func(t *testing.T) {
+		if err := db.CreateIndex("accounts", "accounts_owner", "owner", database.IndexHash); err != nil {
+			t.Fatalf("CreateIndex() error = %v", err)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		if err := tx.Update("accounts", 1, []string{"c", "90"}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		if ids, _ := db.Tables["accounts"].Indexes["accounts_owner"].Lookup("c"); len(ids) != 0 {
+			t.Errorf("transaction changed the committed index: %v", ids)
+		}
+		rows, err := tx.SelectWhere("accounts", mustParseExpr(t, "owner = 'c'"))
+		if err != nil || len(rows) != 1 {
+			t.Errorf("SelectWhere() in transaction = %v, %v, want one row", rows, err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+	})
+}
+
+func TestWriteAheadLogRecovery(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	if err := db.CreateTableSchema("users", []database.Column{
+		{Name: "name", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.InsertRows("users", nil, [][]string{{"a", "20"}, {"b", "30"}, {"c", "40"}}); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	if _, err := db.UpdateWhere("users", []parser.Assignment{{Column: "age", Value: "21"}}, mustParseExpr(t, "name = 'a'")); err != nil {
+		t.Fatalf("UpdateWhere() error = %v", err)
+	}
+	if err := db.Delete("users", 2); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := db.CreateIndex("users", "users_age", "age", database.IndexOrdered); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if _, err := tx.Insert("users", []string{"rolled back", "1"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if _, err := tx.Insert("users", []string{"d", "50"}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if _, err := os.Stat(tempDir + "/users.csv"); !os.IsNotExist(err) {
+		t.Fatalf("snapshot written before checkpoint: %v", err)
+	}
+
+	want := map[int]string{1: "a:21", 3: "c:40", 4: "d:50"}
+	check := func(t *testing.T, db *Database) {
+		table := db.Tables["users"]
+		if table == nil {
+			t.Fatal("table users was not recovered")
+		}
+		if len(table.Records) != len(want) {
+			t.Errorf("recovered records = %v, want %v", table.Records, want)
+		}
+		for id, value := range want {
+			record := table.Records[id]
+			if got := record["name"] + ":" + record["age"]; got != value {
+				t.Errorf("record %d = %s, want %s", id, got, value)
+			}
+		}
+		if ids, _ := table.Indexes["users_age"].Lookup("50"); len(ids) != 1 || ids[0] != 4 {
+			t.Errorf("recovered index Lookup() = %v, want [4]", ids)
+		}
+	}
+
+	t.Run("replay after crash", func(t *testing.T) {
+		recovered := NewDatabase(storage.NewCSVStorage(tempDir))
+		if err := recovered.LoadTables(); err != nil {
+			t.Fatalf("LoadTables() error = %v", err)
+		}
+		check(t, recovered)
+	})
+
+	t.Run("checkpoint compacts the log", func(t *testing.T) {
+		db.CheckpointSize = db.Storage.LogSize() + 1
+		if err := db.MaybeCheckpoint(); err != nil {
+			t.Fatalf("MaybeCheckpoint() error = %v", err)
+		}
+		if db.Storage.LogSize() == 0 {
+			t.Fatal("MaybeCheckpoint() ran below the threshold")
+		}
+		db.CheckpointSize = 1
+		if err := db.MaybeCheckpoint(); err != nil {
+			t.Fatalf("MaybeCheckpoint() error = %v", err)
+		}
+		if size := db.Storage.LogSize(); size != 0 {
+			t.Errorf("LogSize() after checkpoint = %d, want 0", size)
+		}
+
+		recovered := NewDatabase(storage.NewCSVStorage(tempDir))
+		if err := recovered.LoadTables(); err != nil {
+			t.Fatalf("LoadTables() error = %v", err)
+		}
+		check(t, recovered)
+	})
+}
+
+func TestLoadTablesSkipsInvalidSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	stor := storage.NewCSVStorage(tempDir)
+	good := database.NewTableFromColumns("good", []database.Column{{Name: "age", Type: database.TypeInt, Check: "age >= 0"}})
+	bad := database.NewTableFromColumns("bad", []database.Column{{Name: "age", Type: database.TypeInt, Check: "salary > 0"}})
+	for _, table := range []*database.Table{good, bad} {
+		if err := stor.SaveTable(table); err != nil {
+			t.Fatalf("SaveTable(%s) error = %v", table.Name, err)
+		}
+	}
+
+	db := NewDatabase(stor)
+	var out strings.Builder
+	db.Out = &out
+	if err := db.LoadTables(); err != nil {
+		t.Fatalf("LoadTables() error = %v", err)
+	}
+	if _, exist := db.Tables["bad"]; exist {
+		t.Error("table with an invalid CHECK was loaded")
+	}
+	if _, exist := db.Tables["good"]; !exist {
+		t.Error("valid table was not loaded")
+	}
+	if !strings.Contains(out.String(), "Ошибка загрузки таблицы bad") || strings.Contains(out.String(), "Таблица bad загружена") {
+		t.Errorf("LoadTables() output = %q", out.String())
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	if err := db.CreateTableSchema("users", []database.Column{
+		{Name: "name", Type: database.TypeText, NotNull: true},
+		{Name: "age", Type: database.TypeInt, Check: "age >= 0"},
+	}); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := db.InsertRows("users", nil, [][]string{{"a", "20"}, {"b", "30"}}); err != nil {
+		t.Fatalf("Failed to insert test data: %v", err)
+	}
+	if err := db.CreateIndex("users", "users_age", "age", database.IndexOrdered); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	errorTests := []struct {
+		name    string
+		alter   func() error
+		errText string
+	}{
+		{"add existing column", func() error {
+			return db.AddColumn("users", database.Column{Name: "age", Type: database.TypeInt})
+		}, "уже существует"},
+		{"add NOT NULL without default", func() error {
+			return db.AddColumn("users", database.Column{Name: "email", Type: database.TypeText, NotNull: true})
+		}, database.ErrNotNull.Error()},
+		{"add UNIQUE with shared default", func() error {
+			return db.AddColumn("users", database.Column{Name: "email", Type: database.TypeText, Unique: true, HasDefault: true, Default: "x"})
+		}, database.ErrUnique.Error()},
+		{"add invalid default", func() error {
+			return db.AddColumn("users", database.Column{Name: "score", Type: database.TypeInt, HasDefault: true, Default: "abc"})
+		}, "DEFAULT поля score"},
+		{"drop column used by CHECK", func() error {
+			if err := db.AddColumn("users", database.Column{Name: "limit_age", Type: database.TypeInt, Check: "limit_age > age"}); err != nil {
+				return err
+			}
+			defer func() { _ = db.DropColumn("users", "limit_age") }()
+			return db.DropColumn("users", "age")
+		}, database.ErrUnknownField.Error()},
+		{"drop unknown column", func() error { return db.DropColumn("users", "email") }, database.ErrUnknownField.Error()},
+		{"drop id", func() error { return db.DropColumn("users", "id") }, "нельзя удалить"},
+		{"rename to existing column", func() error { return db.RenameColumn("users", "name", "age") }, "уже существует"},
+		{"rename unknown table", func() error { return db.RenameTable("ghosts", "people") }, database.ErrTableNotFound.Error()},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := db.Tables["users"]
+			err := tt.alter()
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Fatalf("error = %v, want %q", err, tt.errText)
+			}
+			if len(db.Tables["users"].Fields) != len(before.Fields) {
+				t.Errorf("failed ALTER changed fields to %v", db.Tables["users"].Fields)
+			}
+		})
+	}
+
+	if err := db.AddColumn("users", database.Column{Name: "active", Type: database.TypeBool, HasDefault: true, Default: "yes"}); err != nil {
+		t.Fatalf("AddColumn() error = %v", err)
+	}
+	if err := db.RenameColumn("users", "age", "years"); err != nil {
+		t.Fatalf("RenameColumn() error = %v", err)
+	}
+	if err := db.DropColumn("users", "name"); err != nil {
+		t.Fatalf("DropColumn() error = %v", err)
+	}
+	if err := db.RenameTable("users", "people"); err != nil {
+		t.Fatalf("RenameTable() error = %v", err)
+	}
+
+	check := func(t *testing.T, db *Database) {
+		table := db.Tables["people"]
+		if table == nil || db.Tables["users"] != nil {
+			t.Fatalf("tables = %v, want only people", db.Tables)
+		}
+		if strings.Join(table.Fields, ",") != "years,active" {
+			t.Errorf("Fields = %v, want [years active]", table.Fields)
+		}
+		if record := table.Records[2]; record["years"] != "30" || record["active"] != "true" || len(record) != 2 {
+			t.Errorf("record 2 = %v", record)
+		}
+		if check := table.Column("years").Check; check != "years >= 0" {
+			t.Errorf("CHECK = %q, want years >= 0", check)
+		}
+		index := table.Indexes["users_age"]
+		if index == nil || index.Column != "years" {
+			t.Fatalf("index after rename = %+v", index)
+		}
+		if ids, _ := index.Lookup("20"); len(ids) != 1 || ids[0] != 1 {
+			t.Errorf("index Lookup() = %v, want [1]", ids)
+		}
+	}
+	check(t, db)
+	if _, err := db.Insert("people", []string{"-1", "false"}); !errors.Is(err, database.ErrCheck) {
+		t.Errorf("Insert() violating renamed CHECK error = %v", err)
+	}
+
+	t.Run("replay after crash", func(t *testing.T) {
+		recovered := NewDatabase(storage.NewCSVStorage(tempDir))
+		if err := recovered.LoadTables(); err != nil {
+			t.Fatalf("LoadTables() error = %v", err)
+		}
+		check(t, recovered)
+	})
+
+	t.Run("checkpoint removes the old snapshot", func(t *testing.T) {
+		// The snapshot of users predates the rename.
+		if err := db.Storage.SaveTable(database.NewTable("users", []string{"name"})); err != nil {
+			t.Fatalf("SaveTable() error = %v", err)
+		}
+		if err := db.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint() error = %v", err)
+		}
+		if db.Storage.TableExist("users") {
+			t.Error("snapshot of the renamed table survived the checkpoint")
+		}
+		tables, err := db.Storage.ListSnapshots()
+		if err != nil || len(tables) != 1 || tables[0] != "people" {
+			t.Errorf("ListSnapshots() = %v, %v, want [people]", tables, err)
+		}
+		recovered := NewDatabase(storage.NewCSVStorage(tempDir))
+		if err := recovered.LoadTables(); err != nil {
+			t.Fatalf("LoadTables() error = %v", err)
+		}
+		check(t, recovered)
+	})
+}
+
+func TestDropAndTruncateTable(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	for _, name := range []string{"users", "orders"} {
+		if err := db.CreateTableSchema(name, []database.Column{{Name: "value", Type: database.TypeInt, Unique: true}}); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		if _, err := db.InsertRows(name, nil, [][]string{{"1"}, {"2"}}); err != nil {
+			t.Fatalf("Failed to insert test data: %v", err)
+		}
+	}
+	if err := db.CreateIndex("orders", "orders_value", "value", database.IndexHash); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+
+	count, err := db.Truncate("orders")
+	if err != nil || count != 2 {
+		t.Fatalf("Truncate() = %d, %v, want 2", count, err)
+	}
+	if ids, _ := db.Tables["orders"].Indexes["orders_value"].Lookup("1"); len(ids) != 0 {
+		t.Errorf("index after Truncate() = %v", ids)
+	}
+	if id, err := db.Insert("orders", []string{"1"}); err != nil || id != 1 {
+		t.Errorf("Insert() after Truncate() = %d, %v, want id 1", id, err)
+	}
+
+	info, err := db.Describe("orders")
+	if err != nil {
+		t.Fatalf("Describe() error = %v", err)
+	}
+	if info.Rows != 1 || info.NextID != 2 || len(info.Columns) != 1 || !info.Columns[0].Unique {
+		t.Errorf("Describe() = %+v", info)
+	}
+	if len(info.Indexes) != 1 || info.Indexes[0] != (IndexInfo{Name: "orders_value", Column: "value", Kind: database.IndexHash}) {
+		t.Errorf("Describe() indexes = %+v", info.Indexes)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := tx.DropTable("users"); err != nil {
+		t.Fatalf("DropTable() error = %v", err)
+	}
+	if names := db.TableNames(); strings.Join(names, ",") != "orders,users" {
+		t.Errorf("TableNames() before commit = %v", names)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if names := db.TableNames(); strings.Join(names, ",") != "orders" {
+		t.Errorf("TableNames() after commit = %v, want [orders]", names)
+	}
+	if err := db.DropTable("users"); !errors.Is(err, database.ErrTableNotFound) {
+		t.Errorf("DropTable() of a dropped table error = %v", err)
+	}
+	if _, err := db.Describe("users"); !errors.Is(err, database.ErrTableNotFound) {
+		t.Errorf("Describe() of a dropped table error = %v", err)
+	}
+
+	check := func(t *testing.T, db *Database) {
+		if names := db.TableNames(); strings.Join(names, ",") != "orders" {
+			t.Errorf("TableNames() = %v, want [orders]", names)
+		}
+		if table := db.Tables["orders"]; table == nil || len(table.Records) != 1 || table.NextID != 2 {
+			t.Errorf("orders = %+v", table)
+		}
+	}
+	recovered := NewDatabase(storage.NewCSVStorage(tempDir))
+	if err := recovered.LoadTables(); err != nil {
+		t.Fatalf("LoadTables() error = %v", err)
+	}
+	check(t, recovered)
+
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	if tables, _ := db.Storage.ListSnapshots(); strings.Join(tables, ",") != "orders" {
+		t.Errorf("ListSnapshots() after checkpoint = %v, want [orders]", tables)
+	}
+	recovered = NewDatabase(storage.NewCSVStorage(tempDir))
+	if err := recovered.LoadTables(); err != nil {
+		t.Fatalf("LoadTables() error = %v", err)
+	}
+	check(t, recovered)
+}
+
+func TestSession(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer cleanupTestDB(tempDir)
+
+	first, second := db.NewSession(), db.NewSession()
+	defer first.Close()
+	defer second.Close()
+
+	run := func(t *testing.T, s *Session, input string) *Result {
+		t.Helper()
+		query, err := parser.ParseQuery(input)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) error = %v", input, err)
+		}
+		result, err := s.Execute(query)
+		if err != nil {
+			t.Fatalf("Execute(%q) error = %v", input, err)
+		}
+		return result
+	}
+
+	tests := []struct {
+		input    string
+		command  string
+		affected int
+	}{
+		{input: "CREATE TABLE users name TEXT, age INT", command: "CREATE TABLE"},
+		{input: "INSERT INTO users (name, age) VALUES ('a', 1), ('b', 2)", command: "INSERT", affected: 2},
+		{input: "INSERT users c, 3", command: "INSERT", affected: 1},
+		{input: "UPDATE users SET age = 5 WHERE age < 3", command: "UPDATE", affected: 2},
+		{input: "DELETE users WHERE name = 'c'", command: "DELETE", affected: 1},
+		{input: "SELECT name, age FROM users", command: "SELECT", affected: 2},
+		{input: "DROP TABLE IF EXISTS missing", command: "DROP TABLE"},
+		{input: "DESCRIBE users", command: "DESCRIBE", affected: 3},
+		{input: "EXPLAIN SELECT * FROM users WHERE age = 5", command: "EXPLAIN", affected: 2},
+	}
+	for _, tt := range tests {
+		result := run(t, first, tt.input)
+		if result.Command != tt.command || result.Affected != tt.affected {
+			t.Errorf("Execute(%q) = %s %d, want %s %d", tt.input, result.Command, result.Affected, tt.command, tt.affected)
+		}
+	}
+
+	result := run(t, first, "SELECT name, age, COUNT(*) AS n FROM users GROUP BY name, age")
+	want := []database.ColumnType{database.TypeText, database.TypeInt, database.TypeInt}
+	if fmt.Sprint(result.Types) != fmt.Sprint(want) {
+		t.Errorf("Result.Types = %v, want %v", result.Types, want)
+	}
+
+	// Columns checks the query but does not run it, so a missing id is
+	// not found.
+	query, _ := parser.ParseQuery("SELECT users 99")
+	result, err := first.Columns(query)
+	if err != nil {
+		t.Fatalf("Columns() error = %v", err)
+	}
+	if fmt.Sprint(result.Columns, result.Types, result.Stream) != "[name age] [TEXT INT] <nil>" {
+		t.Errorf("Columns() = %v %v, stream %v", result.Columns, result.Types, result.Stream)
+	}
+	query, _ = parser.ParseQuery("DESCRIBE missing")
+	if result, err := first.Columns(query); err != nil || len(result.Columns) != 3 {
+		t.Errorf("Columns(DESCRIBE) = %v, %v", result, err)
+	}
+	query, _ = parser.ParseQuery("SELECT salary FROM users")
+	if _, err := first.Columns(query); !errors.Is(err, database.ErrUnknownField) {
+		t.Errorf("Columns() error = %v, want %v", err, database.ErrUnknownField)
+	}
+
+	run(t, first, "BEGIN")
+	if !first.InTransaction() || second.InTransaction() {
+		t.Fatalf("InTransaction() = %v, %v, want true, false", first.InTransaction(), second.InTransaction())
+	}
+	run(t, first, "DELETE users WHERE age > 0")
+	if rows := run(t, second, "SELECT * FROM users").Rows; len(rows) != 2 {
+		t.Errorf("other session sees %d rows before COMMIT, want 2", len(rows))
+	}
+	run(t, first, "COMMIT")
+	if rows := run(t, second, "SELECT * FROM users").Rows; len(rows) != 0 {
+		t.Errorf("other session sees %d rows after COMMIT, want 0", len(rows))
+	}
+
+	run(t, second, "BEGIN")
+	run(t, second, "INSERT users d, 4")
+	second.Close()
+	if rows := run(t, first, "SELECT * FROM users").Rows; len(rows) != 0 {
+		t.Errorf("Close() kept %d rows of an open transaction", len(rows))
+	}
+
+	if _, err := first.Execute(&parser.Query{Type: parser.QueryHelp}); !errors.Is(err, ErrUnsupportedQuery) {
+		t.Errorf("Execute(help) error = %v, want %v", err, ErrUnsupportedQuery)
+	}
+	if _, err := first.Execute(&parser.Query{Type: parser.QueryRollback}); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("Execute(ROLLBACK) error = %v, want %v", err, ErrNoTransaction)
+	}
+}
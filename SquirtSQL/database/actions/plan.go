@@ -0,0 +1,471 @@
+package actions
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"v4/database"
+	"v4/database/parser"
+)
+
+// PlanKind names a step of a query plan.
+type PlanKind string
+
+const (
+	PlanSeqScan    PlanKind = "Seq Scan"
+	PlanIndexScan  PlanKind = "Index Scan"
+	PlanFilter     PlanKind = "Filter"
+	PlanHashJoin   PlanKind = "Hash Join"
+	PlanNestedLoop PlanKind = "Nested Loop"
+	PlanAggregate  PlanKind = "Aggregate"
+	PlanSort       PlanKind = "Sort"
+	PlanLimit      PlanKind = "Limit"
+)
+
+// Plan is a step of a query plan fed by the rows of its children. Rows and
+// Cost are the planner's estimates; Cost includes the children.
+type Plan struct {
+	Kind     PlanKind
+	Table    string
+	Alias    string
+	Index    string
+	Cond     parser.Expr
+	Left     bool
+	Keys     []string
+	Limit    int
+	Offset   int
+	Rows     float64
+	Cost     float64
+	Children []*Plan
+}
+
+// The costs are in units of reading one row in a full scan. Rows found
+// through an index are fetched one by one and sorted back into order,
+// which makes an index worth it only for a small share of the table.
+const (
+	scanRowCost     = 1.0
+	indexLookupCost = 2.0
+	indexRowCost    = 3.0
+	filterRowCost   = 0.25
+	hashRowCost     = 1.0
+	compareCost     = 0.1
+
+	defaultSelectivity = 1.0 / 3
+	equalSelectivity   = 0.1
+)
+
+// estimator gives the planner what is known about a table or the columns
+// of joined tables.
+type estimator struct {
+	rows   float64
+	column func(name string) (database.ColumnType, ColumnStats, bool)
+}
+
+func tableEstimator(table *database.Table, stats *TableStats) estimator {
+	return estimator{
+		rows: float64(len(table.Records)),
+		column: func(name string) (database.ColumnType, ColumnStats, bool) {
+			if stats == nil {
+				return "", ColumnStats{}, false
+			}
+			if name == "id" {
+				return database.TypeInt, idStats(table), true
+			}
+			columnStats, exist := stats.Columns[name]
+			return table.Column(name).Type, columnStats, exist
+		},
+	}
+}
+
+func idStats(table *database.Table) ColumnStats {
+	return ColumnStats{Distinct: len(table.Records), Min: "1", Max: strconv.Itoa(table.NextID - 1), rows: len(table.Records)}
+}
+
+// selectivity estimates the share of rows that satisfy the condition.
+func (e estimator) selectivity(expr parser.Expr) float64 {
+	switch x := expr.(type) {
+	case nil:
+		return 1
+	case *parser.NotExpr:
+		return 1 - e.selectivity(x.Expr)
+	case *parser.BinaryExpr:
+		switch x.Op {
+		case parser.OpAnd:
+			return e.selectivity(x.Left) * e.selectivity(x.Right)
+		case parser.OpOr:
+			left, right := e.selectivity(x.Left), e.selectivity(x.Right)
+			return left + right - left*right
+		}
+	}
+
+	column, op, values, ok := comparison(expr)
+	if !ok {
+		return defaultSelectivity
+	}
+	columnType, stats, known := e.column(column.Name)
+	if !known {
+		if op == parser.OpEq {
+			return math.Min(1, float64(len(values))*equalSelectivity)
+		}
+		return defaultSelectivity
+	}
+	nonNull := 1.0
+	if stats.rows > 0 {
+		nonNull = 1 - float64(stats.Nulls)/float64(stats.rows)
+	}
+
+	switch op {
+	case parser.OpEq, parser.OpNe:
+		equal := 0.0
+		if stats.Distinct > 0 {
+			equal = math.Min(1, float64(len(values))/float64(stats.Distinct)) * nonNull
+		}
+		if op == parser.OpNe {
+			return nonNull - equal
+		}
+		return equal
+	}
+	return rangeSelectivity(columnType, stats, op, values[0]) * nonNull
+}
+
+// rangeSelectivity interpolates the value between the minimum and the
+// maximum of a numeric column.
+func rangeSelectivity(columnType database.ColumnType, stats ColumnStats, op parser.Operator, value string) float64 {
+	if columnType != database.TypeInt && columnType != database.TypeFloat {
+		return defaultSelectivity
+	}
+	low, errLow := strconv.ParseFloat(stats.Min, 64)
+	high, errHigh := strconv.ParseFloat(stats.Max, 64)
+	v, err := strconv.ParseFloat(value, 64)
+	if errLow != nil || errHigh != nil || err != nil {
+		return defaultSelectivity
+	}
+	if high <= low {
+		if (v >= low) == (op == parser.OpGt || op == parser.OpGe) {
+			return 1
+		}
+		return 0
+	}
+
+	below := math.Max(0, math.Min(1, (v-low)/(high-low)))
+	if op == parser.OpLt || op == parser.OpLe {
+		return below
+	}
+	return 1 - below
+}
+
+// access is how a table is read: a full scan, or an index lookup of one
+// conjunct of the condition.
+type access struct {
+	conjunct parser.Expr
+	index    string
+	rows     float64
+	cost     float64
+}
+
+// chooseAccess compares a full scan with a lookup of each indexed conjunct
+// and returns the cheapest.
+func chooseAccess(table *database.Table, stats *TableStats, where parser.Expr) access {
+	e := tableEstimator(table, stats)
+	filter := 0.0
+	if where != nil {
+		filter = filterRowCost
+	}
+	best := access{rows: e.rows, cost: e.rows * (scanRowCost + filter)}
+	for _, conjunct := range conjuncts(where) {
+		index, ok := indexFor(table, conjunct)
+		if !ok {
+			continue
+		}
+		rows := e.rows * e.selectivity(conjunct)
+		if cost := indexLookupCost + rows*(indexRowCost+filter); cost < best.cost {
+			best = access{conjunct: conjunct, index: index, rows: rows, cost: cost}
+		}
+	}
+	return best
+}
+
+// Explain returns the plan the select query would run with.
+func (db *Database) Explain(query *parser.Query) (*Plan, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	if len(query.Joins) > 0 || query.Alias != "" {
+		scope, err := db.newJoinScope(query)
+		if err != nil {
+			return nil, err
+		}
+		defer scope.lock()()
+
+		plan, _, err := db.planJoin(scope, query)
+		return plan, err
+	}
+
+	table, exist := db.Tables[query.Table]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	return db.planTable(table, query)
+}
+
+func (db *Database) planTable(table *database.Table, query *parser.Query) (*Plan, error) {
+	if err := validateExpr(table, query.Where); err != nil {
+		return nil, err
+	}
+	for _, column := range query.Select {
+		if column.Aggregate == nil {
+			if err := validateExpr(table, &parser.ColumnRef{Table: column.Table, Name: column.Name}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	stats, err := db.tableStats(table)
+	if err != nil {
+		return nil, err
+	}
+	e := tableEstimator(table, stats)
+	if query.ID != -1 {
+		cond := &parser.BinaryExpr{Op: parser.OpEq, Left: &parser.ColumnRef{Name: "id"}, Right: &parser.Literal{Value: strconv.Itoa(query.ID)}}
+		plan := &Plan{Kind: PlanIndexScan, Table: table.Name, Index: "id", Cond: cond, Rows: 1, Cost: indexLookupCost}
+		return finishPlan(plan, e, query), nil
+	}
+
+	path := chooseAccess(table, stats, query.Where)
+	plan := &Plan{Kind: PlanSeqScan, Table: table.Name, Rows: e.rows, Cost: e.rows * scanRowCost}
+	residual := query.Where
+	if path.conjunct != nil {
+		plan = &Plan{Kind: PlanIndexScan, Table: table.Name, Index: path.index, Cond: path.conjunct, Rows: path.rows, Cost: indexLookupCost + path.rows*indexRowCost}
+		residual = withoutConjunct(query.Where, path.conjunct)
+	}
+	if residual != nil {
+		plan = &Plan{
+			Kind:     PlanFilter,
+			Cond:     residual,
+			Rows:     e.rows * e.selectivity(query.Where),
+			Cost:     plan.Cost + plan.Rows*filterRowCost,
+			Children: []*Plan{plan},
+		}
+	}
+	return finishPlan(plan, e, query), nil
+}
+
+func withoutConjunct(where, used parser.Expr) parser.Expr {
+	var rest parser.Expr
+	for _, conjunct := range conjuncts(where) {
+		switch {
+		case conjunct == used:
+		case rest == nil:
+			rest = conjunct
+		default:
+			rest = &parser.BinaryExpr{Op: parser.OpAnd, Left: rest, Right: conjunct}
+		}
+	}
+	return rest
+}
+
+// planJoin plans a query over the locked tables of the scope and returns
+// it rewritten to the names of the joined columns.
+func (db *Database) planJoin(scope joinScope, query *parser.Query) (*Plan, *parser.Query, error) {
+	stats := make(map[string]*TableStats, len(scope))
+	sourceStats := make(map[string]estimator, len(scope))
+	for _, source := range scope {
+		var err error
+		if stats[source.name], err = db.tableStats(source.table); err != nil {
+			return nil, nil, err
+		}
+		sourceStats[source.name] = tableEstimator(source.table, stats[source.name])
+	}
+	e := estimator{column: func(name string) (database.ColumnType, ColumnStats, bool) {
+		dot := strings.LastIndex(name, ".")
+		if dot == -1 {
+			return "", ColumnStats{}, false
+		}
+		source, exist := sourceStats[name[:dot]]
+		if !exist {
+			return "", ColumnStats{}, false
+		}
+		return source.column(name[dot+1:])
+	}}
+
+	scan := func(source joinSource) *Plan {
+		rows := sourceStats[source.name].rows
+		plan := &Plan{Kind: PlanSeqScan, Table: source.table.Name, Rows: rows, Cost: rows * scanRowCost}
+		if source.name != source.table.Name {
+			plan.Alias = source.name
+		}
+		return plan
+	}
+	plan := scan(scope[0])
+	for i, join := range query.Joins {
+		on, err := scope[:i+2].rewrite(join.On, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		right := scan(scope[i+1])
+		plan = joinPlan(e, plan, right, on, scope[i+1].name, join.Left)
+	}
+
+	rewritten, err := scope.rewriteQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rewritten.Where != nil {
+		plan = &Plan{
+			Kind:     PlanFilter,
+			Cond:     rewritten.Where,
+			Rows:     plan.Rows * e.selectivity(rewritten.Where),
+			Cost:     plan.Cost + plan.Rows*filterRowCost,
+			Children: []*Plan{plan},
+		}
+	}
+	return finishPlan(plan, e, rewritten), rewritten, nil
+}
+
+func joinPlan(e estimator, left, right *Plan, on parser.Expr, rightName string, outer bool) *Plan {
+	plan := &Plan{Kind: PlanNestedLoop, Cond: on, Left: outer, Children: []*Plan{left, right}}
+	pairs := left.Rows * right.Rows
+	if leftKey, rightKey, rest, ok := splitEquiJoin(on, rightName); ok {
+		plan.Kind = PlanHashJoin
+		distinct := math.Max(1, math.Min(left.Rows, right.Rows))
+		_, leftStats, leftKnown := e.column(leftKey.Name)
+		_, rightStats, rightKnown := e.column(rightKey.Name)
+		if leftKnown && rightKnown {
+			distinct = math.Max(1, float64(max(leftStats.Distinct, rightStats.Distinct)))
+		}
+		plan.Rows = pairs / distinct * e.selectivity(rest)
+		plan.Cost = left.Cost + right.Cost + (left.Rows+right.Rows)*hashRowCost
+	} else {
+		plan.Rows = pairs * e.selectivity(on)
+		plan.Cost = left.Cost + right.Cost + pairs*filterRowCost
+	}
+	if outer {
+		plan.Rows = math.Max(plan.Rows, left.Rows)
+	}
+	return plan
+}
+
+// finishPlan adds the steps after the rows are found: grouping, sorting
+// and the limit.
+func finishPlan(plan *Plan, e estimator, query *parser.Query) *Plan {
+	if query.IsAggregate() {
+		groups := 1.0
+		keys := make([]string, len(query.GroupBy))
+		for i, column := range query.GroupBy {
+			keys[i] = column.Name
+			if _, stats, known := e.column(column.Name); known {
+				groups *= math.Max(1, float64(stats.Distinct))
+			} else {
+				groups *= math.Max(1, plan.Rows*equalSelectivity)
+			}
+		}
+		if len(keys) > 0 {
+			groups = math.Min(groups, math.Max(1, plan.Rows))
+		}
+		plan = &Plan{Kind: PlanAggregate, Keys: keys, Rows: groups, Cost: plan.Cost + plan.Rows*hashRowCost, Children: []*Plan{plan}}
+		if query.Having != nil {
+			plan = &Plan{Kind: PlanFilter, Cond: query.Having, Rows: plan.Rows * defaultSelectivity, Cost: plan.Cost + plan.Rows*filterRowCost, Children: []*Plan{plan}}
+		}
+	}
+
+	if len(query.OrderBy) > 0 {
+		keys := make([]string, len(query.OrderBy))
+		for i, item := range query.OrderBy {
+			keys[i] = item.Column
+			if item.Aggregate != nil {
+				keys[i] = item.Aggregate.String()
+			}
+			if item.Desc {
+				keys[i] += " DESC"
+			}
+		}
+		cost := plan.Rows * math.Log2(plan.Rows+1) * compareCost
+		plan = &Plan{Kind: PlanSort, Keys: keys, Rows: plan.Rows, Cost: plan.Cost + cost, Children: []*Plan{plan}}
+	}
+
+	if query.Limit > 0 || query.Offset > 0 {
+		rows := math.Max(0, plan.Rows-float64(query.Offset))
+		if query.Limit > 0 {
+			rows = math.Min(rows, float64(query.Limit))
+		}
+		plan = &Plan{Kind: PlanLimit, Limit: query.Limit, Offset: query.Offset, Rows: rows, Cost: plan.Cost, Children: []*Plan{plan}}
+	}
+	return plan
+}
+
+func (p *Plan) describe() string {
+	var b strings.Builder
+	kind := string(p.Kind)
+	if p.Left {
+		kind = strings.Replace(kind, " Join", "", 1) + " Left Join"
+	}
+	b.WriteString(kind)
+	switch p.Kind {
+	case PlanSeqScan:
+		fmt.Fprintf(&b, " on %s", p.Table)
+		if p.Alias != "" {
+			fmt.Fprintf(&b, " %s", p.Alias)
+		}
+	case PlanIndexScan:
+		fmt.Fprintf(&b, " on %s using %s: %s", p.Table, p.Index, p.Cond)
+	case PlanFilter:
+		fmt.Fprintf(&b, ": %s", p.Cond)
+	case PlanHashJoin, PlanNestedLoop:
+		if p.Cond != nil {
+			fmt.Fprintf(&b, ": %s", p.Cond)
+		}
+	case PlanAggregate, PlanSort:
+		if len(p.Keys) > 0 {
+			fmt.Fprintf(&b, " by %s", strings.Join(p.Keys, ", "))
+		}
+	case PlanLimit:
+		if p.Limit > 0 {
+			fmt.Fprintf(&b, " %d", p.Limit)
+		}
+		if p.Offset > 0 {
+			fmt.Fprintf(&b, " offset %d", p.Offset)
+		}
+	}
+	fmt.Fprintf(&b, " (cost=%.2f rows=%d)", p.Cost, int(math.Ceil(p.Rows)))
+	return b.String()
+}
+
+// Lines renders the plan as a tree, one step per line.
+func (p *Plan) Lines() []string {
+	var lines []string
+	var walk func(p *Plan, depth int)
+	walk = func(p *Plan, depth int) {
+		line := p.describe()
+		if depth > 0 {
+			line = strings.Repeat("   ", depth-1) + "-> " + line
+		}
+		lines = append(lines, line)
+		for _, child := range p.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(p, 0)
+	return lines
+}
+
+func explainResult(plan *Plan) *Result {
+	result := explainColumns()
+	for i, line := range plan.Lines() {
+		result.Rows = append(result.Rows, ResultRow{ID: i + 1, Values: []string{line}})
+	}
+	result.Affected = len(result.Rows)
+	return result
+}
+
+func explainColumns() *Result {
+	return &Result{
+		Command: "EXPLAIN",
+		Columns: []string{"QUERY PLAN"},
+		Types:   []database.ColumnType{database.TypeText},
+	}
+}
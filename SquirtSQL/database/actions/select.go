@@ -0,0 +1,287 @@
+package actions
+
+import (
+	"strconv"
+	"v4/database"
+	"v4/database/parser"
+)
+
+type ResultRow struct {
+	ID     int
+	Values []string
+}
+
+type Result struct {
+	Columns []string
+	Types   []database.ColumnType
+	Rows    []ResultRow
+
+	// Stream is set instead of Rows by Session.Open for statements that
+	// return rows; the caller reads and closes it.
+	Stream *Rows
+
+	// Command and Affected are set by Session.Execute and name the
+	// statement and the number of rows it touched. LastID is the id of
+	// the last row added by INSERT.
+	Command  string
+	Affected int
+	LastID   int
+}
+
+// Rows is a cursor over the rows of a query. A snapshot of its tables is
+// taken when the query is opened; each call to Next reads only as much of
+// them as it takes to find the next row. The rows are closed when Next
+// returns false; Close stops reading them early.
+type Rows struct {
+	Columns []string
+	Types   []database.ColumnType
+
+	root   operator
+	spec   *selectSpec
+	rows   []ResultRow
+	row    ResultRow
+	n      int
+	err    error
+	closed bool
+}
+
+// resultRows returns a cursor over rows that are already collected.
+func resultRows(result *Result) *Rows {
+	return &Rows{Columns: result.Columns, Types: result.Types, rows: result.Rows}
+}
+
+func (r *Rows) Next() bool {
+	if r.closed {
+		return false
+	}
+	if r.root == nil {
+		if r.n >= len(r.rows) {
+			r.Close()
+			return false
+		}
+		r.row = r.rows[r.n]
+		r.n++
+		return true
+	}
+
+	row, ok, err := r.root.Next()
+	if !ok || err != nil {
+		r.err = err
+		r.Close()
+		return false
+	}
+	r.n++
+	r.row = r.spec.project(row, r.n)
+	return true
+}
+
+// Row returns the row Next moved to.
+func (r *Rows) Row() ResultRow {
+	return r.row
+}
+
+// Err returns the error that ended the rows early, if any.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+func (r *Rows) Close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.root != nil {
+		r.root.Close()
+	}
+}
+
+func (r *Rows) collect() ([]ResultRow, error) {
+	var rows []ResultRow
+	for r.Next() {
+		rows = append(rows, r.Row())
+	}
+	return rows, r.Err()
+}
+
+// selectSpec is a checked select query: what its rows are projected to
+// and what the operators need to group and sort them. schema holds the
+// columns of the rows the plan reads.
+type selectSpec struct {
+	schema  *database.Table
+	joined  bool
+	columns []string
+	types   []database.ColumnType
+	keys    []string
+
+	grouped    bool
+	groupBy    []parser.ColumnRef
+	aggregates []*parser.AggregateExpr
+	aliases    map[string]string
+	sortKeys   []sortKey
+}
+
+func compileSelect(table *database.Table, query *parser.Query) (*selectSpec, error) {
+	if query.IsAggregate() {
+		return compileGrouped(table, query)
+	}
+
+	columns := query.Select
+	if len(columns) == 0 {
+		columns = make([]parser.SelectColumn, len(table.Fields))
+		for i, field := range table.Fields {
+			columns[i] = parser.SelectColumn{Name: field}
+		}
+	}
+	for _, column := range columns {
+		if err := validateExpr(table, &parser.ColumnRef{Table: column.Table, Name: column.Name}); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateExpr(table, query.Where); err != nil {
+		return nil, err
+	}
+
+	spec := &selectSpec{
+		schema:  table,
+		columns: make([]string, len(columns)),
+		types:   make([]database.ColumnType, len(columns)),
+		keys:    make([]string, len(columns)),
+	}
+	for i, column := range columns {
+		spec.columns[i] = column.Label()
+		spec.types[i] = table.Column(column.Name).Type
+		spec.keys[i] = column.Name
+	}
+
+	for _, item := range query.OrderBy {
+		key := item.Column
+		for _, column := range columns {
+			if item.Table == "" && column.Alias != "" && column.Alias == item.Column {
+				key = column.Name
+			}
+		}
+		if err := validateExpr(table, &parser.ColumnRef{Table: item.Table, Name: key}); err != nil {
+			return nil, err
+		}
+		spec.sortKeys = append(spec.sortKeys, sortKey{
+			column:     &parser.ColumnRef{Name: key},
+			columnType: table.Column(key).Type,
+			desc:       item.Desc,
+		})
+	}
+	return spec, nil
+}
+
+// project picks the selected values of the n-th row. Grouped rows are
+// numbered, the others keep their ids.
+func (s *selectSpec) project(row database.Row, n int) ResultRow {
+	result := ResultRow{ID: row.ID, Values: make([]string, len(s.keys))}
+	if s.grouped {
+		result.ID = n
+	}
+	for i, key := range s.keys {
+		if key == "id" && !s.grouped {
+			result.Values[i] = strconv.Itoa(row.ID)
+		} else {
+			result.Values[i] = row.Record[key]
+		}
+	}
+	return result
+}
+
+// Query opens a cursor over the rows of a select query. The query is
+// checked and planned, and its tables are snapshot, under one lock of
+// each; the rows are then read, filtered, joined and projected as the
+// cursor asks for them, without holding any lock between calls to Next.
+func (db *Database) Query(query *parser.Query) (*Rows, error) {
+	spec, root, err := db.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.Open(); err != nil {
+		root.Close()
+		return nil, err
+	}
+	return &Rows{Columns: spec.columns, Types: spec.types, root: root, spec: spec}, nil
+}
+
+// prepare checks and plans a query and builds its operators while its
+// tables are locked.
+func (db *Database) prepare(query *parser.Query) (*selectSpec, operator, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	if len(query.Joins) > 0 || query.Alias != "" {
+		return db.prepareJoin(query)
+	}
+
+	table, exist := db.Tables[query.Table]
+	if !exist {
+		return nil, nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	spec, err := compileSelect(table, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if query.ID != -1 {
+		if _, exist := table.Records[query.ID]; !exist {
+			return nil, nil, database.ErrRecordNotFound
+		}
+	}
+	plan, err := db.planTable(table, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := spec.build(plan, map[string]*database.Table{table.Name: table})
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, root, nil
+}
+
+// compile checks a select query and returns what its rows are projected
+// to, without planning it or reading any rows.
+func (db *Database) compile(query *parser.Query) (*selectSpec, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	if len(query.Joins) > 0 || query.Alias != "" {
+		scope, err := db.newJoinScope(query)
+		if err != nil {
+			return nil, err
+		}
+		defer scope.lock()()
+
+		rewritten, err := scope.rewriteQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		return compileSelect(scope.schema(query.Table), rewritten)
+	}
+
+	table, exist := db.Tables[query.Table]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	return compileSelect(table, query)
+}
+
+func (db *Database) SelectQuery(query *parser.Query) (*Result, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Columns: rows.Columns, Types: rows.Types}
+	if result.Rows, err = rows.collect(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,296 @@
+package actions
+
+import (
+	"errors"
+	"sync"
+	"v4/database"
+	"v4/database/parser"
+)
+
+var ErrUnsupportedQuery = errors.New("неподдерживаемый тип запроса")
+
+// Session runs statements on behalf of one client. Sessions share the
+// database, but each has its own transaction.
+type Session struct {
+	db *Database
+	tx *Database
+	mu sync.Mutex
+}
+
+func (db *Database) NewSession() *Session {
+	return &Session{db: db}
+}
+
+func (s *Session) InTransaction() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tx != nil
+}
+
+// Close discards an unfinished transaction.
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+// Execute runs a parsed statement. Statements that return no rows leave
+// Columns empty and report their row count in Affected.
+func (s *Session) Execute(query *parser.Query) (*Result, error) {
+	result, err := s.Open(query)
+	if err != nil || result.Stream == nil {
+		return result, err
+	}
+	defer result.Stream.Close()
+
+	if result.Rows, err = result.Stream.collect(); err != nil {
+		return nil, err
+	}
+	result.Stream = nil
+	result.Affected = len(result.Rows)
+	return result, nil
+}
+
+// Open runs a statement like Execute, but leaves the rows in Stream for
+// the caller to read one at a time and close. A SELECT reads its tables
+// only as the rows are asked for.
+func (s *Session) Open(query *parser.Query) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch query.Type {
+	case parser.QueryBegin:
+		return s.begin()
+	case parser.QueryCommit:
+		return s.commit()
+	case parser.QueryRollback:
+		return s.rollback()
+	}
+
+	db := s.db
+	if s.tx != nil {
+		db = s.tx
+	}
+
+	result := &Result{}
+	var err error
+	switch query.Type {
+	case parser.QueryCreateTable:
+		result.Command = "CREATE TABLE"
+		columns := query.Columns
+		if columns == nil {
+			columns = database.TextColumns(query.Fields)
+		}
+		err = db.CreateTableSchema(query.Table, columns)
+	case parser.QuerySelect:
+		var rows *Rows
+		rows, err = db.Query(query)
+		if err == nil {
+			result = &Result{Command: "SELECT", Columns: rows.Columns, Types: rows.Types, Stream: rows}
+		}
+	case parser.QueryExplain:
+		var plan *Plan
+		plan, err = db.Explain(query)
+		if err == nil {
+			result = explainResult(plan)
+		}
+	case parser.QueryInsert:
+		result.Command = "INSERT"
+		if query.Values != nil {
+			var ids []int
+			ids, err = db.InsertRows(query.Table, query.Targets, query.Values)
+			result.Affected = len(ids)
+			if len(ids) > 0 {
+				result.LastID = ids[len(ids)-1]
+			}
+		} else {
+			result.LastID, err = db.Insert(query.Table, query.Fields)
+			result.Affected = 1
+		}
+	case parser.QueryUpdate:
+		result.Command = "UPDATE"
+		if query.ID == -1 {
+			result.Affected, err = db.UpdateWhere(query.Table, query.Set, query.Where)
+		} else {
+			err = db.Update(query.Table, query.ID, query.Fields)
+			result.Affected = 1
+		}
+	case parser.QueryDelete:
+		result.Command = "DELETE"
+		if query.ID == -1 {
+			result.Affected, err = db.DeleteWhere(query.Table, query.Where)
+		} else {
+			err = db.Delete(query.Table, query.ID)
+			result.Affected = 1
+		}
+	case parser.QueryCreateIndex:
+		result.Command = "CREATE INDEX"
+		err = db.CreateIndex(query.Table, query.Index, query.Fields[0], query.Using)
+	case parser.QueryDropIndex:
+		result.Command = "DROP INDEX"
+		_, err = db.DropIndex(query.Index)
+	case parser.QueryAlterTable:
+		result.Command = "ALTER TABLE"
+		err = s.alterTable(db, query)
+	case parser.QueryDropTable:
+		result.Command = "DROP TABLE"
+		err = db.DropTable(query.Table)
+		if query.IfExists && errors.Is(err, database.ErrTableNotFound) {
+			return result, nil
+		}
+		if err == nil {
+			err = s.checkpointSchema()
+		}
+	case parser.QueryTruncate:
+		result.Command = "TRUNCATE TABLE"
+		result.Affected, err = db.Truncate(query.Table)
+	case parser.QueryShowTables:
+		result = showTables(db)
+	case parser.QueryDescribe:
+		result, err = describe(db, query.Table)
+	default:
+		err = ErrUnsupportedQuery
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Columns != nil && result.Stream == nil {
+		result.Stream = resultRows(result)
+		result.Rows = nil
+	}
+	return result, nil
+}
+
+func (s *Session) begin() (*Result, error) {
+	if s.tx != nil {
+		return nil, ErrNestedTransaction
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	s.tx = tx
+	return &Result{Command: "BEGIN"}, nil
+}
+
+func (s *Session) commit() (*Result, error) {
+	if s.tx == nil {
+		return nil, ErrNoTransaction
+	}
+	tx := s.tx
+	s.tx = nil
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &Result{Command: "COMMIT"}, nil
+}
+
+func (s *Session) rollback() (*Result, error) {
+	if s.tx == nil {
+		return nil, ErrNoTransaction
+	}
+	_ = s.tx.Rollback()
+	s.tx = nil
+	return &Result{Command: "ROLLBACK"}, nil
+}
+
+func (s *Session) alterTable(db *Database, query *parser.Query) error {
+	var err error
+	switch query.Alter {
+	case parser.AlterAddColumn:
+		err = db.AddColumn(query.Table, query.Columns[0])
+	case parser.AlterDropColumn:
+		err = db.DropColumn(query.Table, query.Fields[0])
+	case parser.AlterRenameColumn:
+		err = db.RenameColumn(query.Table, query.Fields[0], query.NewName)
+	case parser.AlterRenameTable:
+		err = db.RenameTable(query.Table, query.NewName)
+	}
+	if err != nil {
+		return err
+	}
+	return s.checkpointSchema()
+}
+
+// checkpointSchema rewrites the files after a schema change outside a
+// transaction, as the console does.
+func (s *Session) checkpointSchema() error {
+	if s.tx != nil || s.db.Storage == nil {
+		return nil
+	}
+	return s.db.Checkpoint()
+}
+
+// Columns returns the columns of the rows a statement returns without
+// running it. Statements that return no rows get none.
+func (s *Session) Columns(query *parser.Query) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := s.db
+	if s.tx != nil {
+		db = s.tx
+	}
+	switch query.Type {
+	case parser.QuerySelect:
+		spec, err := db.compile(query)
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Command: "SELECT", Columns: spec.columns, Types: spec.types}, nil
+	case parser.QueryExplain:
+		return explainColumns(), nil
+	case parser.QueryShowTables:
+		return showTablesColumns(), nil
+	case parser.QueryDescribe:
+		return describeColumns(), nil
+	}
+	return &Result{}, nil
+}
+
+func showTablesColumns() *Result {
+	return &Result{
+		Command: "SHOW",
+		Columns: []string{"table"},
+		Types:   []database.ColumnType{database.TypeText},
+	}
+}
+
+func describeColumns() *Result {
+	return &Result{
+		Command: "DESCRIBE",
+		Columns: []string{"column", "type", "constraints"},
+		Types:   []database.ColumnType{database.TypeText, database.TypeText, database.TypeText},
+	}
+}
+
+func showTables(db *Database) *Result {
+	result := showTablesColumns()
+	for i, name := range db.TableNames() {
+		result.Rows = append(result.Rows, ResultRow{ID: i + 1, Values: []string{name}})
+	}
+	result.Affected = len(result.Rows)
+	return result
+}
+
+func describe(db *Database, name string) (*Result, error) {
+	info, err := db.Describe(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := describeColumns()
+	result.Rows = []ResultRow{{ID: 1, Values: []string{"id", string(database.TypeInt), "PRIMARY KEY"}}}
+	for _, column := range info.Columns {
+		result.Rows = append(result.Rows, ResultRow{
+			ID:     len(result.Rows) + 1,
+			Values: []string{column.Name, string(column.Type), column.Constraints()},
+		})
+	}
+	result.Affected = len(result.Rows)
+	return result, nil
+}
@@ -0,0 +1,164 @@
+package actions
+
+import (
+	"fmt"
+	"v4/database"
+	"v4/storage"
+)
+
+// statsMinChanges is how many rows must change before the statistics of a
+// small table are collected again; larger tables wait for a tenth of their
+// rows to change.
+const statsMinChanges = 50
+
+// ColumnStats describes the values of a column for the planner.
+type ColumnStats struct {
+	Nulls    int
+	Distinct int
+	Min      string
+	Max      string
+
+	rows int
+}
+
+// TableStats are collected by a scan of the table and kept until enough
+// of its rows change.
+type TableStats struct {
+	Rows    int
+	Columns map[string]ColumnStats
+
+	table   *database.Table
+	changed int
+}
+
+func collectStats(table *database.Table) (*TableStats, error) {
+	stats := &TableStats{
+		Rows:    len(table.Records),
+		Columns: make(map[string]ColumnStats, len(table.Fields)),
+		table:   table,
+	}
+	for _, column := range table.Schema() {
+		columnStats := ColumnStats{rows: len(table.Records)}
+		seen := make(map[string]bool)
+		for _, record := range table.Records {
+			value := record[column.Name]
+			key, ok := column.Type.Key(value)
+			if value == "" || !ok {
+				columnStats.Nulls++
+				continue
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if err := columnStats.widen(column.Type, value); err != nil {
+				return nil, fmt.Errorf("статистика поля %s: %w", column.Name, err)
+			}
+		}
+		columnStats.Distinct = len(seen)
+		stats.Columns[column.Name] = columnStats
+	}
+	return stats, nil
+}
+
+// widen extends the range of the column to the value.
+func (c *ColumnStats) widen(columnType database.ColumnType, value string) error {
+	if c.Min == "" {
+		c.Min, c.Max = value, value
+		return nil
+	}
+	cmp, err := columnType.Compare(value, c.Min)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		c.Min = value
+	}
+	if cmp, err = columnType.Compare(value, c.Max); err != nil {
+		return err
+	}
+	if cmp > 0 {
+		c.Max = value
+	}
+	return nil
+}
+
+func (s *TableStats) stale(table *database.Table) bool {
+	limit := s.Rows / 10
+	if limit < statsMinChanges {
+		limit = statsMinChanges
+	}
+	return s.table != table || s.changed >= limit
+}
+
+// tableStats returns the statistics of a table, collecting them again
+// when they are missing or stale. Callers must hold the table's lock.
+func (db *Database) tableStats(table *database.Table) (*TableStats, error) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	stats, exist := db.stats[table.Name]
+	if !exist || stats.stale(table) {
+		var err error
+		if stats, err = collectStats(table); err != nil {
+			return nil, err
+		}
+		if db.stats == nil {
+			db.stats = make(map[string]*TableStats)
+		}
+		db.stats[table.Name] = stats
+	}
+	return stats, nil
+}
+
+// Stats returns the planner statistics of a table.
+func (db *Database) Stats(name string) (*TableStats, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	table, exist := db.Tables[name]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	return db.tableStats(table)
+}
+
+// noteChanges counts the logged changes against the statistics of their
+// tables and forgets the statistics and checks of tables replaced as a
+// whole.
+func (db *Database) noteChanges(changes []storage.Change) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+	db.checksMu.Lock()
+	defer db.checksMu.Unlock()
+
+	for _, change := range changes {
+		switch change.Op {
+		case storage.OpPut, storage.OpDelete:
+			if stats, exist := db.stats[change.Table]; exist {
+				stats.changed++
+			}
+		case storage.OpCreateIndex, storage.OpDropIndex:
+		default:
+			delete(db.stats, change.Table)
+			delete(db.stats, change.NewName)
+			delete(db.checks, change.Table)
+			delete(db.checks, change.NewName)
+		}
+	}
+}
+
+// moveStats keeps the statistics of a table that a transaction replaced
+// with its copy; the changes were counted when the transaction was logged.
+func (db *Database) moveStats(name string, old, new *database.Table) {
+	db.statsMu.Lock()
+	defer db.statsMu.Unlock()
+
+	if stats, exist := db.stats[name]; exist && stats.table == old {
+		stats.table = new
+	}
+}
@@ -0,0 +1,103 @@
+package actions
+
+import (
+	"sort"
+	"v4/database"
+	"v4/storage"
+)
+
+type IndexInfo struct {
+	Name   string
+	Column string
+	Kind   database.IndexKind
+}
+
+type TableInfo struct {
+	Name    string
+	Columns []database.Column
+	Indexes []IndexInfo
+	Rows    int
+	NextID  int
+}
+
+func (db *Database) DropTable(name string) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.Tables[name]
+	if !exist {
+		return database.ErrTableNotFound
+	}
+
+	if err := db.log(storage.DropTableChange(name)); err != nil {
+		return err
+	}
+	if db.parent != nil && !db.dirty[name] {
+		table.Mu.RLock()
+		db.base[name] = txBase{table: table, version: table.Version}
+		table.Mu.RUnlock()
+		db.dirty[name] = true
+	}
+	delete(db.Tables, name)
+	return nil
+}
+
+// Truncate removes every record of the table and returns how many there
+// were.
+func (db *Database) Truncate(name string) (int, error) {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	table, exist := db.writableTable(name)
+	if !exist {
+		return 0, database.ErrTableNotFound
+	}
+
+	table.Mu.Lock()
+	defer table.Mu.Unlock()
+
+	if err := db.log(storage.TruncateChange(name)); err != nil {
+		return 0, err
+	}
+	return table.Truncate(), nil
+}
+
+func (db *Database) TableNames() []string {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	names := make([]string, 0, len(db.Tables))
+	for name := range db.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (db *Database) Describe(name string) (*TableInfo, error) {
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	table, exist := db.Tables[name]
+	if !exist {
+		return nil, database.ErrTableNotFound
+	}
+
+	table.Mu.RLock()
+	defer table.Mu.RUnlock()
+
+	info := &TableInfo{
+		Name:    table.Name,
+		Columns: table.Schema(),
+		Rows:    len(table.Records),
+		NextID:  table.NextID,
+	}
+	for _, index := range table.IndexList() {
+		info.Indexes = append(info.Indexes, IndexInfo{
+			Name:   index.Name,
+			Column: index.Column,
+			Kind:   index.Kind,
+		})
+	}
+	return info, nil
+}
@@ -0,0 +1,144 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"v4/database"
+)
+
+var (
+	ErrNoTransaction     = errors.New("нет активной транзакции")
+	ErrNestedTransaction = errors.New("транзакция уже начата")
+	ErrTxConflict        = errors.New("конфликт транзакций")
+)
+
+type txBase struct {
+	table   *database.Table
+	version int
+}
+
+// Begin starts a transaction. The returned Database shares unchanged
+// tables with db and copies a table on its first write, so changes stay
+// invisible to db until Commit.
+func (db *Database) Begin() (*Database, error) {
+	if db.parent != nil {
+		return nil, ErrNestedTransaction
+	}
+
+	db.Mu.RLock()
+	defer db.Mu.RUnlock()
+
+	tx := &Database{
+		Tables:  make(map[string]*database.Table, len(db.Tables)),
+		Storage: db.Storage,
+		parent:  db,
+		dirty:   make(map[string]bool),
+		base:    make(map[string]txBase),
+	}
+	for name, table := range db.Tables {
+		tx.Tables[name] = table
+		tx.base[name] = txBase{table: table}
+	}
+	return tx, nil
+}
+
+func (db *Database) InTransaction() bool {
+	return db.parent != nil
+}
+
+// Changed returns the names of the tables written by the transaction.
+func (db *Database) Changed() []string {
+	names := make([]string, 0, len(db.dirty))
+	for name := range db.dirty {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Commit logs the buffered changes as one batch and publishes the changed
+// tables to the parent database. It fails without applying anything if one
+// of those tables was changed outside the transaction after being copied.
+func (db *Database) Commit() error {
+	parent := db.parent
+	if parent == nil {
+		return ErrNoTransaction
+	}
+
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+	db.parent = nil
+
+	parent.Mu.Lock()
+	defer parent.Mu.Unlock()
+
+	names := db.Changed()
+	for _, name := range names {
+		if err := db.checkBase(parent, name); err != nil {
+			return err
+		}
+	}
+	if err := parent.log(db.pending...); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if table, exist := db.Tables[name]; exist {
+			parent.Tables[name] = table
+			parent.moveStats(name, db.base[name].table, table)
+		} else {
+			delete(parent.Tables, name)
+		}
+	}
+	return nil
+}
+
+func (db *Database) Rollback() error {
+	if db.parent == nil {
+		return ErrNoTransaction
+	}
+
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	db.parent = nil
+	db.pending = nil
+	return nil
+}
+
+func (db *Database) checkBase(parent *Database, name string) error {
+	base := db.base[name]
+	current := parent.Tables[name]
+	if current != base.table {
+		return fmt.Errorf("%w: таблица %s изменена другой операцией", ErrTxConflict, name)
+	}
+	if current == nil {
+		return nil
+	}
+
+	current.Mu.RLock()
+	defer current.Mu.RUnlock()
+
+	if current.Version != base.version {
+		return fmt.Errorf("%w: таблица %s изменена другой операцией", ErrTxConflict, name)
+	}
+	return nil
+}
+
+// writableTable returns the table for modification. Inside a transaction
+// the table is copied on first write. Callers must hold db.Mu.
+func (db *Database) writableTable(name string) (*database.Table, bool) {
+	table, exist := db.Tables[name]
+	if !exist || db.parent == nil || db.dirty[name] {
+		return table, exist
+	}
+
+	table.Mu.RLock()
+	clone := table.Clone()
+	table.Mu.RUnlock()
+
+	db.base[name] = txBase{table: table, version: clone.Version}
+	db.Tables[name] = clone
+	db.dirty[name] = true
+	return clone, true
+}
@@ -0,0 +1,99 @@
+package actions
+
+import (
+	"sort"
+	"v4/database"
+	"v4/storage"
+)
+
+const DefaultCheckpointSize = 1 << 20
+
+// log makes the changes durable before the caller applies them. Inside a
+// transaction they are buffered and logged as one batch on commit.
+func (db *Database) log(changes ...storage.Change) error {
+	if db.parent != nil {
+		db.pending = append(db.pending, changes...)
+		db.noteChanges(changes)
+		return nil
+	}
+	if db.Storage != nil {
+		if err := db.Storage.Log(changes...); err != nil {
+			return err
+		}
+	}
+	db.noteChanges(changes)
+	return nil
+}
+
+func putChanges(table *database.Table, records map[int]database.Record) []storage.Change {
+	ids := make([]int, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	changes := make([]storage.Change, len(ids))
+	for i, id := range ids {
+		changes[i] = storage.PutChange(table.Name, id, records[id])
+	}
+	return changes
+}
+
+// Checkpoint writes every table to its snapshot, removes snapshots of
+// renamed tables and empties the log. Engines that apply the log in place
+// write only the changed rows instead.
+func (db *Database) Checkpoint() error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	if applied, err := db.Storage.ApplyLog(); applied || err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(db.Tables))
+	for name := range db.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		table := db.Tables[name]
+		table.Mu.RLock()
+		err := db.Storage.SaveTable(table)
+		table.Mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	// A snapshot is stale only if the log says its table is gone; one that
+	// merely failed to load is kept.
+	live, err := db.Storage.ListTables()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(live))
+	for _, name := range live {
+		exists[name] = true
+	}
+	snapshots, err := db.Storage.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, name := range snapshots {
+		if !exists[name] {
+			if err := db.Storage.RemoveSnapshot(name); err != nil {
+				return err
+			}
+		}
+	}
+	return db.Storage.TruncateLog()
+}
+
+// MaybeCheckpoint runs a checkpoint once the log outgrows CheckpointSize.
+func (db *Database) MaybeCheckpoint() error {
+	if db.Storage == nil || db.Storage.LogSize() < db.CheckpointSize {
+		return nil
+	}
+	return db.Checkpoint()
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ColumnType string
+
+const (
+	TypeText  ColumnType = "TEXT"
+	TypeInt   ColumnType = "INT"
+	TypeFloat ColumnType = "FLOAT"
+	TypeBool  ColumnType = "BOOL"
+	TypeDate  ColumnType = "DATE"
+)
+
+const DateLayout = "2006-01-02"
+
+var ErrTypeMismatch = errors.New("несоответствие типа значения")
+
+type Column struct {
+	Name       string
+	Type       ColumnType
+	NotNull    bool
+	Unique     bool
+	HasDefault bool
+	Default    string
+	Check      string
+}
+
+func (c Column) Constraints() string {
+	var parts []string
+	if c.NotNull {
+		parts = append(parts, "NOT NULL")
+	}
+	if c.Unique {
+		parts = append(parts, "UNIQUE")
+	}
+	if c.HasDefault {
+		parts = append(parts, "DEFAULT '"+c.Default+"'")
+	}
+	if c.Check != "" {
+		parts = append(parts, "CHECK ("+c.Check+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func ParseColumnType(name string) (ColumnType, error) {
+	switch strings.ToUpper(name) {
+	case "TEXT", "STRING", "VARCHAR":
+		return TypeText, nil
+	case "INT", "INTEGER":
+		return TypeInt, nil
+	case "FLOAT", "REAL", "DOUBLE":
+		return TypeFloat, nil
+	case "BOOL", "BOOLEAN":
+		return TypeBool, nil
+	case "DATE":
+		return TypeDate, nil
+	}
+	return "", fmt.Errorf("неизвестный тип %s", name)
+}
+
+func TextColumns(fields []string) []Column {
+	columns := make([]Column, len(fields))
+	for i, field := range fields {
+		columns[i] = Column{Name: field, Type: TypeText}
+	}
+	return columns
+}
+
+func (t ColumnType) Normalize(value string) (string, error) {
+	if value == "" || t == TypeText {
+		return value, nil
+	}
+	switch t {
+	case TypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: ожидалось %s, получено %q", ErrTypeMismatch, t, value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: ожидалось %s, получено %q", ErrTypeMismatch, t, value)
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	case TypeBool:
+		switch strings.ToLower(value) {
+		case "true", "t", "1", "yes":
+			return "true", nil
+		case "false", "f", "0", "no":
+			return "false", nil
+		}
+		return "", fmt.Errorf("%w: ожидалось %s, получено %q", ErrTypeMismatch, t, value)
+	case TypeDate:
+		date, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return "", fmt.Errorf("%w: ожидалось %s в формате ГГГГ-ММ-ДД, получено %q", ErrTypeMismatch, t, value)
+		}
+		return date.Format(DateLayout), nil
+	}
+	return value, nil
+}
+
+func (t ColumnType) Compare(a, b string) (int, error) {
+	if t == TypeText {
+		return compareText(a, b), nil
+	}
+	if a == "" || b == "" {
+		return strings.Compare(a, b), nil
+	}
+
+	normA, err := t.Normalize(a)
+	if err != nil {
+		return 0, err
+	}
+	normB, err := t.Normalize(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch t {
+	case TypeInt:
+		numA, _ := strconv.ParseInt(normA, 10, 64)
+		numB, _ := strconv.ParseInt(normB, 10, 64)
+		return cmp.Compare(numA, numB), nil
+	case TypeFloat:
+		numA, _ := strconv.ParseFloat(normA, 64)
+		numB, _ := strconv.ParseFloat(normB, 64)
+		return compareFloats(numA, numB), nil
+	case TypeBool:
+		return compareText(normA, normB), nil
+	}
+	return strings.Compare(normA, normB), nil
+}
+
+// Key returns a canonical form of value such that equal values (as
+// reported by Compare) get equal keys. NULL and invalid values have no key.
+func (t ColumnType) Key(value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	if t == TypeText {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return strconv.FormatFloat(n, 'g', -1, 64), true
+		}
+		return value, true
+	}
+	normalized, err := t.Normalize(value)
+	return normalized, err == nil
+}
+
+func compareText(a, b string) int {
+	numA, errA := strconv.ParseFloat(a, 64)
+	numB, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return compareFloats(numA, numB)
+	}
+	return strings.Compare(a, b)
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestColumnTypeNormalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		columnType ColumnType
+		value      string
+		want       string
+		wantErr    bool
+	}{
+		{name: "text as is", columnType: TypeText, value: "abc", want: "abc"},
+		{name: "empty is null", columnType: TypeInt, value: "", want: ""},
+		{name: "int", columnType: TypeInt, value: "042", want: "42"},
+		{name: "negative int", columnType: TypeInt, value: "-7", want: "-7"},
+		{name: "invalid int", columnType: TypeInt, value: "abc", wantErr: true},
+		{name: "float into int", columnType: TypeInt, value: "1.5", wantErr: true},
+		{name: "float", columnType: TypeFloat, value: "1.50", want: "1.5"},
+		{name: "invalid float", columnType: TypeFloat, value: "1,5", wantErr: true},
+		{name: "bool true", columnType: TypeBool, value: "TRUE", want: "true"},
+		{name: "bool from digit", columnType: TypeBool, value: "0", want: "false"},
+		{name: "invalid bool", columnType: TypeBool, value: "maybe", wantErr: true},
+		{name: "date", columnType: TypeDate, value: "2001-09-11", want: "2001-09-11"},
+		{name: "invalid date", columnType: TypeDate, value: "11.09.2001", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.columnType.Normalize(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTypeMismatch) {
+					t.Errorf("Normalize() error = %v, want ErrTypeMismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnTypeCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		columnType ColumnType
+		a, b       string
+		want       int
+	}{
+		{name: "int numeric order", columnType: TypeInt, a: "9", b: "10", want: -1},
+		{name: "int above 2^53", columnType: TypeInt, a: "9007199254740993", b: "9007199254740992", want: 1},
+		{name: "int64 limits", columnType: TypeInt, a: "-9223372036854775808", b: "9223372036854775807", want: -1},
+		{name: "float", columnType: TypeFloat, a: "2.5", b: "2.25", want: 1},
+		{name: "date", columnType: TypeDate, a: "2020-01-02", b: "2019-12-31", want: 1},
+		{name: "bool", columnType: TypeBool, a: "false", b: "1", want: -1},
+		{name: "null first", columnType: TypeInt, a: "", b: "-5", want: -1},
+		{name: "text strings", columnType: TypeText, a: "abc", b: "abd", want: -1},
+		{name: "text numbers", columnType: TypeText, a: "9", b: "10", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.columnType.Compare(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("Compare() unexpected error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := TypeInt.Compare("1", "abc"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Compare() error = %v, want ErrTypeMismatch", err)
+	}
+}
+
+func TestParseColumnType(t *testing.T) {
+	for input, want := range map[string]ColumnType{
+		"int": TypeInt, "INTEGER": TypeInt, "text": TypeText, "Float": TypeFloat,
+		"boolean": TypeBool, "DATE": TypeDate,
+	} {
+		got, err := ParseColumnType(input)
+		if err != nil || got != want {
+			t.Errorf("ParseColumnType(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseColumnType("BLOB"); err == nil {
+		t.Error("ParseColumnType(BLOB) expected error, got nil")
+	}
+}
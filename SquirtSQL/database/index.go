@@ -0,0 +1,292 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type IndexKind string
+
+const (
+	IndexHash    IndexKind = "HASH"
+	IndexOrdered IndexKind = "ORDERED"
+)
+
+var (
+	ErrIndexExists   = errors.New("индекс уже существует")
+	ErrIndexNotFound = errors.New("индекс не найден")
+)
+
+type Bound struct {
+	Value     string
+	Inclusive bool
+}
+
+// Index maps column values to record IDs. Hash indexes answer equality
+// lookups, ordered indexes (a skip list) also answer range scans. NULL
+// values are not indexed.
+type Index struct {
+	Name    string
+	Column  string
+	Kind    IndexKind
+	Type    ColumnType
+	hash    map[string]map[int]bool
+	ordered *skipList
+}
+
+func ParseIndexKind(name string) (IndexKind, error) {
+	switch strings.ToUpper(name) {
+	case "HASH":
+		return IndexHash, nil
+	case "ORDERED", "BTREE", "SKIPLIST":
+		return IndexOrdered, nil
+	}
+	return "", fmt.Errorf("неизвестный тип индекса %s", name)
+}
+
+func NewIndex(name, column string, kind IndexKind, columnType ColumnType) *Index {
+	index := &Index{Name: name, Column: column, Kind: kind, Type: columnType}
+	if kind == IndexHash {
+		index.hash = make(map[string]map[int]bool)
+	} else {
+		index.ordered = newSkipList(func(a, b string) int {
+			return compareKeys(columnType, a, b)
+		})
+	}
+	return index
+}
+
+// compareKeys orders canonical keys. Text keys put numbers first so that
+// the order stays total for columns mixing numbers and words.
+func compareKeys(columnType ColumnType, a, b string) int {
+	if columnType != TypeText {
+		cmp, _ := columnType.Compare(a, b)
+		return cmp
+	}
+	numA, errA := strconv.ParseFloat(a, 64)
+	numB, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return compareFloats(numA, numB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func (i *Index) Add(id int, value string) {
+	key, ok := i.Type.Key(value)
+	if !ok {
+		return
+	}
+	if i.hash == nil {
+		i.ordered.insert(key, id)
+		return
+	}
+	if i.hash[key] == nil {
+		i.hash[key] = make(map[int]bool)
+	}
+	i.hash[key][id] = true
+}
+
+func (i *Index) Remove(id int, value string) {
+	key, ok := i.Type.Key(value)
+	if !ok {
+		return
+	}
+	if i.hash == nil {
+		i.ordered.remove(key, id)
+		return
+	}
+	delete(i.hash[key], id)
+	if len(i.hash[key]) == 0 {
+		delete(i.hash, key)
+	}
+}
+
+// Lookup returns the IDs of records whose value equals value. It reports
+// false when the index cannot answer, e.g. for NULL or a mistyped value.
+func (i *Index) Lookup(value string) ([]int, bool) {
+	key, ok := i.Type.Key(value)
+	if !ok {
+		return nil, false
+	}
+	if i.hash == nil {
+		return i.ordered.get(key), true
+	}
+	return appendIDs(nil, i.hash[key]), true
+}
+
+// Range returns the IDs of records between the bounds, nil meaning
+// unbounded. Only ordered indexes over non-text columns support it, since
+// text comparison is not a total order.
+func (i *Index) Range(lower, upper *Bound) ([]int, bool) {
+	if i.ordered == nil || i.Type == TypeText {
+		return nil, false
+	}
+	lower, okLower := i.boundKey(lower)
+	upper, okUpper := i.boundKey(upper)
+	if !okLower || !okUpper {
+		return nil, false
+	}
+	return i.ordered.scan(lower, upper), true
+}
+
+func (i *Index) boundKey(bound *Bound) (*Bound, bool) {
+	if bound == nil {
+		return nil, true
+	}
+	key, ok := i.Type.Key(bound.Value)
+	if !ok {
+		return nil, false
+	}
+	return &Bound{Value: key, Inclusive: bound.Inclusive}, true
+}
+
+func (t *Table) AddIndex(index *Index) {
+	for id, record := range t.Records {
+		index.Add(id, record[index.Column])
+	}
+	t.Indexes[index.Name] = index
+	t.Version++
+}
+
+func (t *Table) RemoveIndex(name string) bool {
+	if _, exist := t.Indexes[name]; !exist {
+		return false
+	}
+	delete(t.Indexes, name)
+	t.Version++
+	return true
+}
+
+func (t *Table) IndexList() []*Index {
+	indexes := make([]*Index, 0, len(t.Indexes))
+	for _, index := range t.Indexes {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		return indexes[a].Name < indexes[b].Name
+	})
+	return indexes
+}
+
+// WithValue returns the IDs of records holding exactly value in the column.
+// The table keeps a set of the values of the column from the first call on,
+// so constraint checks do not scan the records.
+func (t *Table) WithValue(column, value string) []int {
+	set, exist := t.values[column]
+	if !exist {
+		if t.values == nil {
+			t.values = make(map[string]map[string]map[int]bool)
+		}
+		set = make(map[string]map[int]bool)
+		for id, record := range t.Records {
+			addValue(set, id, record[column])
+		}
+		t.values[column] = set
+	}
+	return appendIDs(nil, set[value])
+}
+
+func addValue(set map[string]map[int]bool, id int, value string) {
+	if value == "" {
+		return
+	}
+	if set[value] == nil {
+		set[value] = make(map[int]bool)
+	}
+	set[value][id] = true
+}
+
+func removeValue(set map[string]map[int]bool, id int, value string) {
+	delete(set[value], id)
+	if len(set[value]) == 0 {
+		delete(set, value)
+	}
+}
+
+// Snapshot returns the records of the table as they are now and a func
+// that releases them. The caller holds the table's lock; the records can
+// then be read without it, because while a snapshot is open the next
+// change copies the map before it writes.
+func (t *Table) Snapshot() (map[int]Record, func()) {
+	if t.shared.Load() == nil {
+		t.shared.CompareAndSwap(nil, &share{})
+	}
+	s := t.shared.Load()
+	s.open.Add(1)
+
+	var once sync.Once
+	return t.Records, func() {
+		once.Do(func() { s.open.Add(-1) })
+	}
+}
+
+// unshare copies the records if a snapshot still reads them.
+func (t *Table) unshare() {
+	s := t.shared.Load()
+	if s == nil || s.open.Load() == 0 {
+		return
+	}
+	records := make(map[int]Record, len(t.Records))
+	for id, record := range t.Records {
+		records[id] = record
+	}
+	t.Records = records
+	t.shared.Store(nil)
+}
+
+func (t *Table) SetRecord(id int, record Record) {
+	t.unshare()
+	if old, exist := t.Records[id]; exist {
+		for _, index := range t.Indexes {
+			index.Remove(id, old[index.Column])
+		}
+		for column, set := range t.values {
+			removeValue(set, id, old[column])
+		}
+	}
+	t.Records[id] = record
+	for _, index := range t.Indexes {
+		index.Add(id, record[index.Column])
+	}
+	for column, set := range t.values {
+		addValue(set, id, record[column])
+	}
+	t.Version++
+}
+
+func (t *Table) DeleteRecord(id int) {
+	if old, exist := t.Records[id]; exist {
+		t.unshare()
+		for _, index := range t.Indexes {
+			index.Remove(id, old[index.Column])
+		}
+		for column, set := range t.values {
+			removeValue(set, id, old[column])
+		}
+		delete(t.Records, id)
+		t.Version++
+	}
+}
+
+// Truncate removes all records and restarts the IDs.
+func (t *Table) Truncate() int {
+	count := len(t.Records)
+	t.Records = make(map[int]Record)
+	t.shared.Store(nil)
+	t.values = nil
+	for _, index := range t.IndexList() {
+		t.Indexes[index.Name] = NewIndex(index.Name, index.Column, index.Kind, index.Type)
+	}
+	t.NextID = 1
+	t.Version++
+	return count
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func sortedIDs(ids []int) []int {
+	sort.Ints(ids)
+	return ids
+}
+
+func equalIDs(a, b []int) bool {
+	a, b = sortedIDs(a), sortedIDs(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIndexLookup(t *testing.T) {
+	for _, kind := range []IndexKind{IndexHash, IndexOrdered} {
+		t.Run(string(kind), func(t *testing.T) {
+			index := NewIndex("idx", "age", kind, TypeInt)
+			index.Add(1, "22")
+			index.Add(2, "19")
+			index.Add(3, "22")
+			index.Add(4, "")
+
+			if ids, ok := index.Lookup("022"); !ok || !equalIDs(ids, []int{1, 3}) {
+				t.Errorf("Lookup(022) = %v, %v, want [1 3]", ids, ok)
+			}
+			if _, ok := index.Lookup("abc"); ok {
+				t.Error("Lookup of a mistyped value should not be answered by the index")
+			}
+			if _, ok := index.Lookup(""); ok {
+				t.Error("Lookup of NULL should not be answered by the index")
+			}
+
+			index.Remove(1, "22")
+			if ids, _ := index.Lookup("22"); !equalIDs(ids, []int{3}) {
+				t.Errorf("Lookup(22) after Remove = %v, want [3]", ids)
+			}
+			index.Remove(3, "22")
+			if ids, _ := index.Lookup("22"); len(ids) != 0 {
+				t.Errorf("Lookup(22) after removing all = %v, want none", ids)
+			}
+		})
+	}
+}
+
+func TestIndexRange(t *testing.T) {
+	index := NewIndex("idx", "age", IndexOrdered, TypeInt)
+	for id, age := range map[int]string{1: "22", 2: "19", 3: "35", 4: "9", 5: "19"} {
+		index.Add(id, age)
+	}
+
+	tests := []struct {
+		name  string
+		lower *Bound
+		upper *Bound
+		want  []int
+	}{
+		{name: "greater than", lower: &Bound{Value: "19"}, want: []int{1, 3}},
+		{name: "greater or equal", lower: &Bound{Value: "19", Inclusive: true}, want: []int{1, 2, 3, 5}},
+		{name: "less than", upper: &Bound{Value: "19"}, want: []int{4}},
+		{name: "between", lower: &Bound{Value: "10", Inclusive: true}, upper: &Bound{Value: "22", Inclusive: true}, want: []int{1, 2, 5}},
+		{name: "unbounded", want: []int{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, ok := index.Range(tt.lower, tt.upper)
+			if !ok || !equalIDs(ids, tt.want) {
+				t.Errorf("Range() = %v, %v, want %v", ids, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := NewIndex("h", "age", IndexHash, TypeInt).Range(nil, nil); ok {
+		t.Error("hash index should not answer range scans")
+	}
+	if _, ok := NewIndex("t", "name", IndexOrdered, TypeText).Range(nil, nil); ok {
+		t.Error("ordered text index should not answer range scans")
+	}
+}
+
+func TestSkipListMatchesSortedScan(t *testing.T) {
+	index := NewIndex("idx", "n", IndexOrdered, TypeInt)
+	values := make(map[int]int)
+	for id := 1; id <= 500; id++ {
+		values[id] = rand.Intn(100)
+		index.Add(id, strconv.Itoa(values[id]))
+	}
+	for id := 1; id <= 500; id += 3 {
+		index.Remove(id, strconv.Itoa(values[id]))
+		delete(values, id)
+	}
+
+	var want []int
+	for id, value := range values {
+		if value >= 25 && value < 75 {
+			want = append(want, id)
+		}
+	}
+	got, _ := index.Range(&Bound{Value: "25", Inclusive: true}, &Bound{Value: "75"})
+	if !equalIDs(got, want) {
+		t.Errorf("Range() returned %d ids, want %d", len(got), len(want))
+	}
+}
+
+func TestTableMaintainsIndexes(t *testing.T) {
+	table := NewTable("users", []string{"email"})
+	table.Records[1] = Record{"email": "a@mail.ru"}
+	table.AddIndex(NewIndex("users_email", "email", IndexHash, TypeText))
+
+	table.SetRecord(2, Record{"email": "b@mail.ru"})
+	table.SetRecord(1, Record{"email": "c@mail.ru"})
+	table.DeleteRecord(2)
+
+	index := table.Indexes["users_email"]
+	if ids, _ := index.Lookup("a@mail.ru"); len(ids) != 0 {
+		t.Errorf("stale key after update: %v", ids)
+	}
+	if ids, _ := index.Lookup("b@mail.ru"); len(ids) != 0 {
+		t.Errorf("stale key after delete: %v", ids)
+	}
+	if ids, _ := index.Lookup("c@mail.ru"); !equalIDs(ids, []int{1}) {
+		t.Errorf("Lookup(c@mail.ru) = %v, want [1]", ids)
+	}
+}
+
+func TestTableWithValue(t *testing.T) {
+	table := NewTable("users", []string{"email"})
+	table.Records[1] = Record{"email": "a@mail.ru"}
+	table.Records[2] = Record{"email": ""}
+
+	if ids := table.WithValue("email", "a@mail.ru"); !equalIDs(ids, []int{1}) {
+		t.Errorf("WithValue(a@mail.ru) = %v, want [1]", ids)
+	}
+	table.SetRecord(3, Record{"email": "a@mail.ru"})
+	table.SetRecord(1, Record{"email": "b@mail.ru"})
+	if ids := table.WithValue("email", "a@mail.ru"); !equalIDs(ids, []int{3}) {
+		t.Errorf("WithValue(a@mail.ru) after update = %v, want [3]", ids)
+	}
+	table.DeleteRecord(1)
+	if ids := table.WithValue("email", "b@mail.ru"); len(ids) != 0 {
+		t.Errorf("stale value after delete: %v", ids)
+	}
+	if ids := table.WithValue("email", ""); len(ids) != 0 {
+		t.Errorf("WithValue(NULL) = %v, want none", ids)
+	}
+	table.Truncate()
+	if ids := table.WithValue("email", "a@mail.ru"); len(ids) != 0 {
+		t.Errorf("stale value after truncate: %v", ids)
+	}
+}
+
+func TestTableSnapshot(t *testing.T) {
+	table := NewTable("users", []string{"email"})
+	table.SetRecord(1, Record{"email": "a@mail.ru"})
+	table.SetRecord(2, Record{"email": "b@mail.ru"})
+
+	records, release := table.Snapshot()
+	table.SetRecord(1, Record{"email": "c@mail.ru"})
+	table.DeleteRecord(2)
+	table.SetRecord(3, Record{"email": "d@mail.ru"})
+	if len(records) != 2 || records[1]["email"] != "a@mail.ru" || records[2] == nil {
+		t.Errorf("snapshot changed with the table: %v", records)
+	}
+	if len(table.Records) != 2 || table.Records[1]["email"] != "c@mail.ru" {
+		t.Errorf("table records = %v", table.Records)
+	}
+	release()
+	release()
+
+	// Without open snapshots a change writes the records in place.
+	records, release = table.Snapshot()
+	release()
+	table.SetRecord(4, Record{"email": "e@mail.ru"})
+	if len(records) != 3 {
+		t.Errorf("released snapshot has %d records, want the 3 of the table", len(records))
+	}
+}
+
+func TestParseIndexKind(t *testing.T) {
+	for input, want := range map[string]IndexKind{"hash": IndexHash, "BTREE": IndexOrdered, "skiplist": IndexOrdered} {
+		if got, err := ParseIndexKind(input); err != nil || got != want {
+			t.Errorf("ParseIndexKind(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseIndexKind("gist"); err == nil {
+		t.Error("expected error for unknown index kind")
+	}
+}
@@ -2,14 +2,33 @@ package database
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type Record map[string]string
 
+type Row struct {
+	ID     int
+	Record Record
+}
+
 type Table struct {
 	Name    string
 	Fields  []string
+	Columns map[string]*Column
 	Records map[int]Record
+	Indexes map[string]*Index
 	Mu      sync.RWMutex
 	NextID  int
+	// Version grows with every change and lets transactions detect
+	// concurrent writes to the table they copied.
+	Version int
+
+	values map[string]map[string]map[int]bool
+	shared atomic.Pointer[share]
+}
+
+// share counts the open snapshots of the records of a table.
+type share struct {
+	open atomic.Int32
 }
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"v4/database"
+)
+
+// Statement is a parsed statement. Query turns it into the flat form the
+// database runs.
+type Statement interface {
+	stmtNode()
+	Query() *Query
+}
+
+type CreateTableStmt struct {
+	Table   string
+	Columns []database.Column
+}
+
+type CreateIndexStmt struct {
+	Name   string
+	Table  string
+	Column string
+	Using  database.IndexKind
+}
+
+type DropIndexStmt struct {
+	Name string
+}
+
+// AlterTableStmt changes a table. Column is the added column, Name the
+// dropped or renamed one and NewName the new name of the column or table.
+type AlterTableStmt struct {
+	Table   string
+	Kind    AlterKind
+	Column  database.Column
+	Name    string
+	NewName string
+}
+
+type DropTableStmt struct {
+	Table    string
+	IfExists bool
+}
+
+type TruncateStmt struct {
+	Table string
+}
+
+type ShowTablesStmt struct{}
+
+type DescribeStmt struct {
+	Table string
+}
+
+type ExplainStmt struct {
+	Select *SelectStmt
+}
+
+// SelectStmt reads rows of a table. ID picks a single row of the short
+// form SELECT <table> <id>; it is -1 otherwise. An empty Columns selects
+// all of them.
+type SelectStmt struct {
+	Columns []SelectColumn
+	Table   string
+	Alias   string
+	ID      int
+	Joins   []Join
+	Where   Expr
+	GroupBy []ColumnRef
+	Having  Expr
+	OrderBy []OrderItem
+	Limit   int
+	Offset  int
+}
+
+// InsertStmt adds rows. Fields holds the values of the short form
+// INSERT <table> <values>, Targets and Values those of INSERT INTO.
+type InsertStmt struct {
+	Table   string
+	Fields  []string
+	Targets []string
+	Values  [][]string
+}
+
+// UpdateStmt changes rows. The short form UPDATE <table> <id> <values>
+// sets ID and Fields; UPDATE ... SET sets Set and Where and an ID of -1.
+type UpdateStmt struct {
+	Table  string
+	ID     int
+	Fields []string
+	Set    []Assignment
+	Where  Expr
+}
+
+// DeleteStmt removes the row with ID, or the rows matching Where when ID
+// is -1.
+type DeleteStmt struct {
+	Table string
+	ID    int
+	Where Expr
+}
+
+type HelpStmt struct{}
+
+type BeginStmt struct{}
+
+type CommitStmt struct{}
+
+type RollbackStmt struct{}
+
+func (*CreateTableStmt) stmtNode() {}
+func (*CreateIndexStmt) stmtNode() {}
+func (*DropIndexStmt) stmtNode()   {}
+func (*AlterTableStmt) stmtNode()  {}
+func (*DropTableStmt) stmtNode()   {}
+func (*TruncateStmt) stmtNode()    {}
+func (*ShowTablesStmt) stmtNode()  {}
+func (*DescribeStmt) stmtNode()    {}
+func (*ExplainStmt) stmtNode()     {}
+func (*SelectStmt) stmtNode()      {}
+func (*InsertStmt) stmtNode()      {}
+func (*UpdateStmt) stmtNode()      {}
+func (*DeleteStmt) stmtNode()      {}
+func (*HelpStmt) stmtNode()        {}
+func (*BeginStmt) stmtNode()       {}
+func (*CommitStmt) stmtNode()      {}
+func (*RollbackStmt) stmtNode()    {}
+
+func (s *CreateTableStmt) Query() *Query {
+	fields := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		fields[i] = column.Name
+	}
+	return &Query{Type: QueryCreateTable, Table: s.Table, Columns: s.Columns, Fields: fields}
+}
+
+func (s *CreateIndexStmt) Query() *Query {
+	return &Query{Type: QueryCreateIndex, Index: s.Name, Table: s.Table, Using: s.Using, Fields: []string{s.Column}}
+}
+
+func (s *DropIndexStmt) Query() *Query {
+	return &Query{Type: QueryDropIndex, Index: s.Name}
+}
+
+func (s *AlterTableStmt) Query() *Query {
+	query := &Query{Type: QueryAlterTable, Table: s.Table, Alter: s.Kind, NewName: s.NewName}
+	switch s.Kind {
+	case AlterAddColumn:
+		query.Columns = []database.Column{s.Column}
+		query.Fields = []string{s.Column.Name}
+	case AlterDropColumn, AlterRenameColumn:
+		query.Fields = []string{s.Name}
+	}
+	return query
+}
+
+func (s *DropTableStmt) Query() *Query {
+	return &Query{Type: QueryDropTable, Table: s.Table, IfExists: s.IfExists}
+}
+
+func (s *TruncateStmt) Query() *Query {
+	return &Query{Type: QueryTruncate, Table: s.Table}
+}
+
+func (s *ShowTablesStmt) Query() *Query {
+	return &Query{Type: QueryShowTables}
+}
+
+func (s *DescribeStmt) Query() *Query {
+	return &Query{Type: QueryDescribe, Table: s.Table}
+}
+
+func (s *ExplainStmt) Query() *Query {
+	query := s.Select.Query()
+	query.Type = QueryExplain
+	return query
+}
+
+func (s *SelectStmt) Query() *Query {
+	return &Query{
+		Type:    QuerySelect,
+		Table:   s.Table,
+		Alias:   s.Alias,
+		ID:      s.ID,
+		Select:  s.Columns,
+		Joins:   s.Joins,
+		Where:   s.Where,
+		GroupBy: s.GroupBy,
+		Having:  s.Having,
+		OrderBy: s.OrderBy,
+		Limit:   s.Limit,
+		Offset:  s.Offset,
+	}
+}
+
+func (s *InsertStmt) Query() *Query {
+	return &Query{Type: QueryInsert, Table: s.Table, Fields: s.Fields, Targets: s.Targets, Values: s.Values}
+}
+
+func (s *UpdateStmt) Query() *Query {
+	return &Query{Type: QueryUpdate, Table: s.Table, ID: s.ID, Fields: s.Fields, Set: s.Set, Where: s.Where}
+}
+
+func (s *DeleteStmt) Query() *Query {
+	return &Query{Type: QueryDelete, Table: s.Table, ID: s.ID, Where: s.Where}
+}
+
+func (s *HelpStmt) Query() *Query {
+	return &Query{Type: QueryHelp}
+}
+
+func (s *BeginStmt) Query() *Query {
+	return &Query{Type: QueryBegin}
+}
+
+func (s *CommitStmt) Query() *Query {
+	return &Query{Type: QueryCommit}
+}
+
+func (s *RollbackStmt) Query() *Query {
+	return &Query{Type: QueryRollback}
+}
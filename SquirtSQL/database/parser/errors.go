@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type SyntaxError struct {
+	Line int
+	Col  int
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("синтаксическая ошибка (строка %d, позиция %d): %v", e.Line, e.Col, e.Err)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+func errorAt(tok token, err error) error {
+	if _, ok := err.(*SyntaxError); ok {
+		return err
+	}
+	return &SyntaxError{Line: tok.line, Col: tok.col, Err: err}
+}
+
+func (p *queryParser) errorf(tok token, format string, args ...any) error {
+	return errorAt(tok, fmt.Errorf(format, args...))
+}
+
+var reservedWords = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "CREATE": true,
+	"TABLE": true, "FROM": true, "WHERE": true, "SET": true, "INTO": true,
+	"VALUES": true, "ORDER": true, "BY": true, "LIMIT": true, "OFFSET": true,
+	"AND": true, "OR": true, "NOT": true, "IN": true, "LIKE": true,
+	"AS": true, "NULL": true, "TRUE": true, "FALSE": true,
+	"GROUP": true, "HAVING": true, "JOIN": true, "INNER": true, "LEFT": true,
+	"OUTER": true, "ON": true,
+}
+
+func isIdent(word string) bool {
+	if word == "" || reservedWords[strings.ToUpper(word)] {
+		return false
+	}
+	for i, r := range word {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,385 @@
+package parser
+
+import (
+	"strings"
+)
+
+type Operator string
+
+const (
+	OpEq   Operator = "="
+	OpNe   Operator = "!="
+	OpLt   Operator = "<"
+	OpLe   Operator = "<="
+	OpGt   Operator = ">"
+	OpGe   Operator = ">="
+	OpLike Operator = "LIKE"
+	OpAnd  Operator = "AND"
+	OpOr   Operator = "OR"
+)
+
+type Expr interface {
+	exprNode()
+	String() string
+}
+
+type ColumnRef struct {
+	Table string
+	Name  string
+}
+
+type Literal struct {
+	Value string
+}
+
+type BinaryExpr struct {
+	Op    Operator
+	Left  Expr
+	Right Expr
+}
+
+type AggregateExpr struct {
+	Func string
+	Arg  *ColumnRef
+}
+
+type NotExpr struct {
+	Expr Expr
+}
+
+type InExpr struct {
+	Expr   Expr
+	Values []Expr
+	Not    bool
+}
+
+func (*ColumnRef) exprNode()     {}
+func (*Literal) exprNode()       {}
+func (*BinaryExpr) exprNode()    {}
+func (*AggregateExpr) exprNode() {}
+func (*NotExpr) exprNode()       {}
+func (*InExpr) exprNode()        {}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return e.Table + "." + e.Name
+	}
+	return e.Name
+}
+
+func (e *Literal) String() string {
+	if isNumber(e.Value) || e.Value == "true" || e.Value == "false" {
+		return e.Value
+	}
+	return quoteString(e.Value)
+}
+
+func (e *BinaryExpr) String() string {
+	if e.Op == OpAnd || e.Op == OpOr {
+		return "(" + e.Left.String() + " " + string(e.Op) + " " + e.Right.String() + ")"
+	}
+	return e.Left.String() + " " + string(e.Op) + " " + e.Right.String()
+}
+
+func (e *AggregateExpr) String() string {
+	if e.Arg == nil {
+		return e.Func + "(*)"
+	}
+	return e.Func + "(" + e.Arg.String() + ")"
+}
+
+func (e *NotExpr) String() string {
+	return "NOT (" + e.Expr.String() + ")"
+}
+
+func (e *InExpr) String() string {
+	values := make([]string, len(e.Values))
+	for i, value := range e.Values {
+		values[i] = value.String()
+	}
+	op := " IN ("
+	if e.Not {
+		op = " NOT IN ("
+	}
+	return e.Expr.String() + op + strings.Join(values, ", ") + ")"
+}
+
+func Columns(expr Expr) []string {
+	var columns []string
+	walkColumns(expr, func(column *ColumnRef) {
+		columns = append(columns, column.Name)
+	})
+	return columns
+}
+
+// RenameColumn renames every reference to column old in expr in place.
+func RenameColumn(expr Expr, old, new string) {
+	walkColumns(expr, func(column *ColumnRef) {
+		if column.Name == old {
+			column.Name = new
+		}
+	})
+}
+
+func walkColumns(expr Expr, visit func(*ColumnRef)) {
+	switch e := expr.(type) {
+	case *ColumnRef:
+		visit(e)
+	case *BinaryExpr:
+		walkColumns(e.Left, visit)
+		walkColumns(e.Right, visit)
+	case *AggregateExpr:
+		if e.Arg != nil {
+			walkColumns(e.Arg, visit)
+		}
+	case *NotExpr:
+		walkColumns(e.Expr, visit)
+	case *InExpr:
+		walkColumns(e.Expr, visit)
+		for _, value := range e.Values {
+			walkColumns(value, visit)
+		}
+	}
+}
+
+type queryParser struct {
+	source []rune
+	tokens []token
+	pos    int
+}
+
+func newQueryParser(input string) (*queryParser, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	return &queryParser{source: []rune(input), tokens: tokens}, nil
+}
+
+func ParseExpr(input string) (Expr, error) {
+	p, err := newQueryParser(input)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "пустое условие")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "неожиданный токен %q в условии", tok.value)
+	}
+	return expr, nil
+}
+
+func (p *queryParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// peekAt looks offset tokens ahead; past the end it returns EOF.
+func (p *queryParser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *queryParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) keyword(word string) bool {
+	tok := p.peek()
+	if tok.kind == tokIdent && strings.EqualFold(tok.value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpOr, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: OpAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (Expr, error) {
+	if p.keyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Expr: expr}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (Expr, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, p.errorf(tok, "незакрытая скобка в условии")
+		}
+		return expr, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	not := p.keyword("NOT")
+	switch {
+	case p.keyword("LIKE"):
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		var expr Expr = &BinaryExpr{Op: OpLike, Left: left, Right: right}
+		if not {
+			expr = &NotExpr{Expr: expr}
+		}
+		return expr, nil
+	case p.keyword("IN"):
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &InExpr{Expr: left, Values: values, Not: not}, nil
+	case not:
+		return nil, p.errorf(p.peek(), "после NOT ожидается LIKE или IN")
+	}
+
+	tok := p.next()
+	if tok.kind != tokOperator {
+		return nil, p.errorf(tok, "ожидался оператор сравнения, получено %q", tok.value)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{Op: Operator(tok.value), Left: left, Right: right}, nil
+}
+
+func (p *queryParser) parseList() ([]Expr, error) {
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, p.errorf(tok, "после IN ожидается список в скобках")
+	}
+	var values []Expr
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return values, nil
+		}
+		if tok.kind != tokComma {
+			return nil, p.errorf(tok, "незакрытая скобка в списке IN")
+		}
+	}
+}
+
+func (p *queryParser) parseOperand() (Expr, error) {
+	if p.atAggregate() {
+		return p.parseAggregate()
+	}
+	tok := p.next()
+	switch tok.kind {
+	case tokIdent:
+		switch strings.ToUpper(tok.value) {
+		case "TRUE", "FALSE":
+			return &Literal{Value: strings.ToLower(tok.value)}, nil
+		case "NULL":
+			return &Literal{}, nil
+		}
+		table, name, ok := splitQualified(tok.value)
+		if !ok {
+			return nil, p.errorf(tok, "некорректный идентификатор %q", tok.value)
+		}
+		return &ColumnRef{Table: table, Name: name}, nil
+	case tokString, tokNumber, tokBool:
+		return &Literal{Value: tok.value}, nil
+	case tokNull:
+		return &Literal{}, nil
+	case tokEOF:
+		return nil, p.errorf(tok, "неожиданный конец условия")
+	default:
+		return nil, p.errorf(tok, "неожиданный токен %q в условии", tok.value)
+	}
+}
+
+// splitQualified splits a column name of the form table.column.
+func splitQualified(word string) (string, string, bool) {
+	table, name, qualified := strings.Cut(word, ".")
+	if !qualified {
+		return "", word, isIdent(word)
+	}
+	return table, name, isIdent(table) && isIdent(name)
+}
+
+var aggregateFuncs = map[string]bool{COUNT: true, SUM: true, AVG: true, MIN: true, MAX: true}
+
+func (p *queryParser) atAggregate() bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && aggregateFuncs[strings.ToUpper(tok.value)] &&
+		p.tokens[p.pos+1].kind == tokLParen
+}
+
+func (p *queryParser) parseAggregate() (*AggregateExpr, error) {
+	agg := &AggregateExpr{Func: strings.ToUpper(p.next().value)}
+	p.next()
+	if tok := p.peek(); tok.kind == tokStar {
+		if agg.Func != COUNT {
+			return nil, p.errorf(tok, "* допускается только в COUNT")
+		}
+		p.next()
+	} else {
+		table, column, err := p.expectColumnName("ожидалось поле в функции %s", agg.Func)
+		if err != nil {
+			return nil, err
+		}
+		agg.Arg = &ColumnRef{Table: table, Name: column}
+	}
+	if tok := p.next(); tok.kind != tokRParen {
+		return nil, p.errorf(tok, "незакрытая скобка в функции %s", agg.Func)
+	}
+	return agg, nil
+}
@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		check       func(t *testing.T, expr Expr)
+		expectError bool
+		errText     string
+	}{
+		{
+			name:  "simple comparison",
+			input: "age > 20",
+			check: func(t *testing.T, expr Expr) {
+				bin, ok := expr.(*BinaryExpr)
+				if !ok || bin.Op != OpGt {
+					t.Fatalf("expected > expression, got %#v", expr)
+				}
+				if col, ok := bin.Left.(*ColumnRef); !ok || col.Name != "age" {
+					t.Errorf("Left = %#v, want column age", bin.Left)
+				}
+				if lit, ok := bin.Right.(*Literal); !ok || lit.Value != "20" {
+					t.Errorf("Right = %#v, want literal 20", bin.Right)
+				}
+			},
+		},
+		{
+			name:  "AND binds tighter than OR",
+			input: "a = 1 OR b = 2 AND c = 3",
+			check: func(t *testing.T, expr Expr) {
+				bin, ok := expr.(*BinaryExpr)
+				if !ok || bin.Op != OpOr {
+					t.Fatalf("expected OR at top, got %#v", expr)
+				}
+				if right, ok := bin.Right.(*BinaryExpr); !ok || right.Op != OpAnd {
+					t.Errorf("expected AND on the right, got %#v", bin.Right)
+				}
+			},
+		},
+		{
+			name:  "parentheses and NOT",
+			input: "NOT (a = 1 OR b <> 2)",
+			check: func(t *testing.T, expr Expr) {
+				not, ok := expr.(*NotExpr)
+				if !ok {
+					t.Fatalf("expected NOT, got %#v", expr)
+				}
+				bin, ok := not.Expr.(*BinaryExpr)
+				if !ok || bin.Op != OpOr {
+					t.Fatalf("expected OR inside NOT, got %#v", not.Expr)
+				}
+				if right := bin.Right.(*BinaryExpr); right.Op != OpNe {
+					t.Errorf("<> should be parsed as !=, got %s", right.Op)
+				}
+			},
+		},
+		{
+			name:  "qualified column",
+			input: "users.age >= 18",
+			check: func(t *testing.T, expr Expr) {
+				bin := expr.(*BinaryExpr)
+				if col, ok := bin.Left.(*ColumnRef); !ok || col.Table != "users" || col.Name != "age" {
+					t.Errorf("Left = %#v, want column users.age", bin.Left)
+				}
+				if got := expr.String(); got != "users.age >= 18" {
+					t.Errorf("String() = %q", got)
+				}
+			},
+		},
+		{
+			name:  "quoted string with spaces",
+			input: "name = 'kolya t'",
+			check: func(t *testing.T, expr Expr) {
+				bin := expr.(*BinaryExpr)
+				if lit := bin.Right.(*Literal); lit.Value != "kolya t" {
+					t.Errorf("Value = %q, want %q", lit.Value, "kolya t")
+				}
+			},
+		},
+		{
+			name:  "NOT IN list",
+			input: "name NOT IN ('a', 'b', 'c')",
+			check: func(t *testing.T, expr Expr) {
+				in, ok := expr.(*InExpr)
+				if !ok || !in.Not || len(in.Values) != 3 {
+					t.Errorf("expected NOT IN with 3 values, got %#v", expr)
+				}
+			},
+		},
+		{
+			name:  "LIKE",
+			input: "email like '%@mail.ru'",
+			check: func(t *testing.T, expr Expr) {
+				if bin, ok := expr.(*BinaryExpr); !ok || bin.Op != OpLike {
+					t.Errorf("expected LIKE, got %#v", expr)
+				}
+			},
+		},
+		{
+			name:        "empty condition",
+			input:       "",
+			expectError: true,
+			errText:     "пустое условие",
+		},
+		{
+			name:        "unclosed parenthesis",
+			input:       "(a = 1",
+			expectError: true,
+			errText:     "незакрытая скобка",
+		},
+		{
+			name:        "unclosed string",
+			input:       "name = 'kolya",
+			expectError: true,
+			errText:     "незакрытая строка",
+		},
+		{
+			name:        "missing operand",
+			input:       "age >",
+			expectError: true,
+			errText:     "неожиданный конец условия",
+		},
+		{
+			name:        "missing operator",
+			input:       "age 20",
+			expectError: true,
+			errText:     "ожидался оператор сравнения",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpr(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				} else if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			tt.check(t, expr)
+		})
+	}
+}
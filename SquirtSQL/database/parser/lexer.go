@@ -0,0 +1,271 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	// tokBool and tokNull are the literals of bound params; in the text of
+	// a statement TRUE, FALSE and NULL are words.
+	tokBool
+	tokNull
+	tokOperator
+	tokStar
+	tokLParen
+	tokRParen
+	tokComma
+	tokSemicolon
+	tokEOF
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	start int
+	end   int
+	line  int
+	col   int
+}
+
+type lexer struct {
+	input  []rune
+	pos    int
+	line   int
+	col    int
+	tokens []token
+}
+
+const wordBreakers = " \t\r\n(),;'\"=!<>*"
+
+func lex(input string) ([]token, error) {
+	l := &lexer{input: []rune(input), line: 1, col: 1}
+	for {
+		if err := l.skipSpaceAndComments(); err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.input) {
+			l.emit(tokEOF, "", l.pos, l.line, l.col)
+			return l.tokens, nil
+		}
+		if err := l.lexToken(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *lexer) peekRune(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+func (l *lexer) advance() rune {
+	r := l.input[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) emit(kind tokenKind, value string, start, line, col int) {
+	l.tokens = append(l.tokens, token{
+		kind:  kind,
+		value: value,
+		start: start,
+		end:   l.pos,
+		line:  line,
+		col:   col,
+	})
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.input) {
+		switch r := l.peekRune(0); {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			l.advance()
+		case r == '-' && l.peekRune(1) == '-':
+			for l.pos < len(l.input) && l.peekRune(0) != '\n' {
+				l.advance()
+			}
+		case r == '/' && l.peekRune(1) == '*':
+			start := token{line: l.line, col: l.col}
+			l.advance()
+			l.advance()
+			for !(l.peekRune(0) == '*' && l.peekRune(1) == '/') {
+				if l.pos >= len(l.input) {
+					return errorAt(start, errors.New("незакрытый комментарий"))
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) lexToken() error {
+	start, line, col := l.pos, l.line, l.col
+	r := l.advance()
+	switch {
+	case r == '(':
+		l.emit(tokLParen, "(", start, line, col)
+	case r == ')':
+		l.emit(tokRParen, ")", start, line, col)
+	case r == ',':
+		l.emit(tokComma, ",", start, line, col)
+	case r == ';':
+		l.emit(tokSemicolon, ";", start, line, col)
+	case r == '*':
+		l.emit(tokStar, "*", start, line, col)
+	case r == '\'' || r == '"':
+		value, err := l.lexString(r)
+		if err != nil {
+			return errorAt(token{line: line, col: col}, err)
+		}
+		l.emit(tokString, value, start, line, col)
+	case strings.ContainsRune("=!<>", r):
+		op := string(r)
+		if next := l.peekRune(0); next == '=' || (r == '<' && next == '>') {
+			op += string(l.advance())
+		}
+		switch op {
+		case "!":
+			return errorAt(token{line: line, col: col}, errors.New("неизвестный оператор !"))
+		case "<>":
+			op = string(OpNe)
+		case "==":
+			op = string(OpEq)
+		}
+		l.emit(tokOperator, op, start, line, col)
+	default:
+		for l.pos < len(l.input) && !strings.ContainsRune(wordBreakers, l.peekRune(0)) {
+			if l.peekRune(0) == '-' && l.peekRune(1) == '-' {
+				break
+			}
+			l.advance()
+		}
+		word := string(l.input[start:l.pos])
+		kind := tokIdent
+		if isNumber(word) {
+			kind = tokNumber
+		}
+		l.emit(kind, word, start, line, col)
+	}
+	return nil
+}
+
+func (l *lexer) lexString(quote rune) (string, error) {
+	var value strings.Builder
+	for {
+		if l.pos >= len(l.input) {
+			return "", errors.New("незакрытая строка")
+		}
+		r := l.advance()
+		switch {
+		case r == quote && l.peekRune(0) == quote:
+			l.advance()
+			value.WriteRune(quote)
+		case r == quote:
+			return value.String(), nil
+		case r == '\\':
+			if l.pos >= len(l.input) {
+				return "", errors.New("незакрытая строка")
+			}
+			escaped := l.advance()
+			switch escaped {
+			case 'n':
+				value.WriteRune('\n')
+			case 't':
+				value.WriteRune('\t')
+			case 'r':
+				value.WriteRune('\r')
+			case '0':
+				value.WriteRune(0)
+			case '\\', '\'', '"', '%', '_':
+				if escaped == '%' || escaped == '_' {
+					value.WriteRune('\\')
+				}
+				value.WriteRune(escaped)
+			default:
+				return "", fmt.Errorf("неизвестная escape-последовательность \\%c", escaped)
+			}
+		default:
+			value.WriteRune(r)
+		}
+	}
+}
+
+func isNumber(word string) bool {
+	i, n := 0, len(word)
+	if i < n && (word[i] == '-' || word[i] == '+') {
+		i++
+	}
+	digits := 0
+	for i < n && word[i] >= '0' && word[i] <= '9' {
+		i++
+		digits++
+	}
+	if i < n && word[i] == '.' {
+		i++
+		for i < n && word[i] >= '0' && word[i] <= '9' {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < n && (word[i] == 'e' || word[i] == 'E') {
+		i++
+		if i < n && (word[i] == '-' || word[i] == '+') {
+			i++
+		}
+		expDigits := 0
+		for i < n && word[i] >= '0' && word[i] <= '9' {
+			i++
+			expDigits++
+		}
+		if expDigits == 0 {
+			return false
+		}
+	}
+	return i == n
+}
+
+func quoteString(value string) string {
+	var quoted strings.Builder
+	quoted.WriteRune('\'')
+	for _, r := range value {
+		switch r {
+		case '\'':
+			quoted.WriteString("''")
+		case '\\':
+			quoted.WriteString(`\\`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteRune('\'')
+	return quoted.String()
+}
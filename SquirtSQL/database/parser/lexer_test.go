@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantKinds   []tokenKind
+		wantValues  []string
+		expectError bool
+		errText     string
+	}{
+		{
+			name:       "identifiers and punctuation",
+			input:      "SELECT name, email FROM users;",
+			wantKinds:  []tokenKind{tokIdent, tokIdent, tokComma, tokIdent, tokIdent, tokIdent, tokSemicolon, tokEOF},
+			wantValues: []string{"SELECT", "name", ",", "email", "FROM", "users", ";", ""},
+		},
+		{
+			name:       "single and double quoted strings",
+			input:      `'Smith, John' "a b"`,
+			wantKinds:  []tokenKind{tokString, tokString, tokEOF},
+			wantValues: []string{"Smith, John", "a b", ""},
+		},
+		{
+			name:       "doubled quotes and escapes",
+			input:      `'it''s' 'line\nbreak' "say \"hi\"" 'back\\slash'`,
+			wantKinds:  []tokenKind{tokString, tokString, tokString, tokString, tokEOF},
+			wantValues: []string{"it's", "line\nbreak", `say "hi"`, `back\slash`, ""},
+		},
+		{
+			name:       "numeric literals",
+			input:      "42 -7 3.14 .5 1e3 2.5E-2 1e",
+			wantKinds:  []tokenKind{tokNumber, tokNumber, tokNumber, tokNumber, tokNumber, tokNumber, tokIdent, tokEOF},
+			wantValues: []string{"42", "-7", "3.14", ".5", "1e3", "2.5E-2", "1e", ""},
+		},
+		{
+			name:       "operators",
+			input:      "a<>b c<=d e!=f g==h i>j",
+			wantKinds:  []tokenKind{tokIdent, tokOperator, tokIdent, tokIdent, tokOperator, tokIdent, tokIdent, tokOperator, tokIdent, tokIdent, tokOperator, tokIdent, tokIdent, tokOperator, tokIdent, tokEOF},
+			wantValues: []string{"a", "!=", "b", "c", "<=", "d", "e", "!=", "f", "g", "=", "h", "i", ">", "j", ""},
+		},
+		{
+			name:       "comments",
+			input:      "SELECT -- line comment\n* /* block\ncomment */ FROM t",
+			wantKinds:  []tokenKind{tokIdent, tokStar, tokIdent, tokIdent, tokEOF},
+			wantValues: []string{"SELECT", "*", "FROM", "t", ""},
+		},
+		{
+			name:       "email stays one word",
+			input:      "kolya@mail.ru,22",
+			wantKinds:  []tokenKind{tokIdent, tokComma, tokNumber, tokEOF},
+			wantValues: []string{"kolya@mail.ru", ",", "22", ""},
+		},
+		{
+			name:        "unclosed string",
+			input:       "'abc",
+			expectError: true,
+			errText:     "незакрытая строка",
+		},
+		{
+			name:        "unclosed comment",
+			input:       "SELECT /* abc",
+			expectError: true,
+			errText:     "незакрытый комментарий",
+		},
+		{
+			name:        "unknown escape",
+			input:       `'\q'`,
+			expectError: true,
+			errText:     "неизвестная escape-последовательность",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lex(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				} else if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(tokens) != len(tt.wantKinds) {
+				t.Fatalf("Got %d tokens, want %d: %v", len(tokens), len(tt.wantKinds), tokens)
+			}
+			for i, tok := range tokens {
+				if tok.kind != tt.wantKinds[i] || tok.value != tt.wantValues[i] {
+					t.Errorf("Token %d = (%v, %q), want (%v, %q)", i, tok.kind, tok.value, tt.wantKinds[i], tt.wantValues[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLexPositions(t *testing.T) {
+	tokens, err := lex("SELECT *\n  FROM users")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	from := tokens[2]
+	if from.line != 2 || from.col != 3 {
+		t.Errorf("FROM position = %d:%d, want 2:3", from.line, from.col)
+	}
+}
+
+func TestQuoteStringRoundTrip(t *testing.T) {
+	for _, value := range []string{"plain", "it's", `back\slash`, "multi\nline", ""} {
+		tokens, err := lex(quoteString(value))
+		if err != nil {
+			t.Fatalf("lex(%q) error = %v", quoteString(value), err)
+		}
+		if tokens[0].kind != tokString || tokens[0].value != value {
+			t.Errorf("Round trip of %q gave %q", value, tokens[0].value)
+		}
+	}
+}
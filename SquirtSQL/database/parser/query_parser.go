@@ -1,9 +1,9 @@
 package parser
 
 import (
-	"errors"
 	"strconv"
 	"strings"
+	"v4/database"
 )
 
 type QueryType int
@@ -15,131 +15,1053 @@ const (
 	QueryUpdate
 	QueryDelete
 	QueryHelp
+	QueryCreateIndex
+	QueryDropIndex
+	QueryBegin
+	QueryCommit
+	QueryRollback
+	QueryAlterTable
+	QueryDropTable
+	QueryTruncate
+	QueryShowTables
+	QueryDescribe
+	QueryExplain
 )
 
+type AlterKind int
+
 const (
-	CREATE = "CREATE TABLE"
+	AlterAddColumn AlterKind = iota
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+)
+
+const (
+	CREATE = "CREATE"
+	TABLE  = "TABLE"
 	SELECT = "SELECT"
 	INSERT = "INSERT"
 	UPDATE = "UPDATE"
 	DELETE = "DELETE"
 	HELP   = "/HELP"
+	WHERE  = "WHERE"
+	SET    = "SET"
+	FROM   = "FROM"
+	AS     = "AS"
+	ORDER  = "ORDER"
+	BY     = "BY"
+	ASC    = "ASC"
+	DESC   = "DESC"
+	LIMIT  = "LIMIT"
+	OFFSET = "OFFSET"
+	INTO   = "INTO"
+	VALUES = "VALUES"
+	GROUP  = "GROUP"
+	HAVING = "HAVING"
+	COUNT  = "COUNT"
+	SUM    = "SUM"
+	AVG    = "AVG"
+	MIN    = "MIN"
+	MAX    = "MAX"
+	JOIN   = "JOIN"
+	INNER  = "INNER"
+	LEFT   = "LEFT"
+	OUTER  = "OUTER"
+	ON     = "ON"
+	INDEX  = "INDEX"
+	DROP   = "DROP"
+	USING  = "USING"
+
+	BEGIN       = "BEGIN"
+	COMMIT      = "COMMIT"
+	ROLLBACK    = "ROLLBACK"
+	TRANSACTION = "TRANSACTION"
+	WORK        = "WORK"
+
+	ALTER  = "ALTER"
+	ADD    = "ADD"
+	COLUMN = "COLUMN"
+	RENAME = "RENAME"
+	TO     = "TO"
+
+	IF       = "IF"
+	EXISTS   = "EXISTS"
+	TRUNCATE = "TRUNCATE"
+	SHOW     = "SHOW"
+	TABLES   = "TABLES"
+	DESCRIBE = "DESCRIBE"
+	EXPLAIN  = "EXPLAIN"
 )
 
 type Query struct {
-	Type   QueryType
-	Table  string
-	Fields []string
-	ID     int
+	Type     QueryType
+	Table    string
+	Index    string
+	Using    database.IndexKind
+	Alter    AlterKind
+	NewName  string
+	IfExists bool
+	Alias    string
+	Joins    []Join
+	Fields   []string
+	ID       int
+	Where    Expr
+	Set      []Assignment
+	Columns  []database.Column
+	Targets  []string
+	Values   [][]string
+	Select   []SelectColumn
+	GroupBy  []ColumnRef
+	Having   Expr
+	OrderBy  []OrderItem
+	Limit    int
+	Offset   int
+}
+
+type Join struct {
+	Table string
+	Alias string
+	Left  bool
+	On    Expr
+}
+
+func (j Join) Name() string {
+	if j.Alias != "" {
+		return j.Alias
+	}
+	return j.Table
+}
+
+type OrderItem struct {
+	Table     string
+	Column    string
+	Aggregate *AggregateExpr
+	Desc      bool
+}
+
+type SelectColumn struct {
+	Table     string
+	Name      string
+	Aggregate *AggregateExpr
+	Alias     string
+}
+
+func (c SelectColumn) Label() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+	if c.Aggregate != nil {
+		return c.Aggregate.String()
+	}
+	return c.Name
+}
+
+func (q *Query) IsAggregate() bool {
+	if len(q.GroupBy) > 0 || q.Having != nil {
+		return true
+	}
+	for _, column := range q.Select {
+		if column.Aggregate != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type Assignment struct {
+	Column string
+	Value  string
 }
 
 func ParseQuery(input string) (*Query, error) {
-	normalized := strings.Join(strings.Fields(input), " ")
-	parts := strings.SplitN(normalized, " ", 3)
-	if len(parts) < 1 {
-		return nil, errors.New("неверный формат запроса")
-	}
-
-	query := &Query{}
-	if len(parts) > 1 {
-		twoWordCommand := strings.ToUpper(parts[0] + " " + parts[1])
-		switch twoWordCommand {
-		case CREATE:
-			query.Type = QueryCreateTable
-			if len(parts) < 3 {
-				return nil, errors.New("формат: CREATE TABLE <table> <values>")
-			}
-			nameAndFields := strings.SplitN(parts[2], " ", 2)
-			if len(nameAndFields) < 1 {
-				return nil, errors.New("не указано имя таблицы")
-			}
-			query.Table = nameAndFields[0]
-			if len(nameAndFields) < 2 {
-				return nil, errors.New("не указаны поля таблицы")
-			}
-
-			if len(nameAndFields) > 1 {
-				fields := strings.Split(nameAndFields[1], ",")
-				for i := range fields {
-					fields[i] = strings.TrimSpace(fields[i])
+	stmt, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(), nil
+}
+
+// Parse parses a single statement into its syntax tree.
+func Parse(input string) (Statement, error) {
+	p, err := newQueryParser(input)
+	if err != nil {
+		return nil, err
+	}
+	p.dropTrailingSemicolon()
+
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, errorAt(p.peek(), err)
+	}
+	return stmt, nil
+}
+
+func (p *queryParser) parseStatement() (Statement, error) {
+	switch {
+	case p.peekKeyword(CREATE) && p.peekKeywordAt(1, TABLE):
+		p.pos += 2
+		return p.parseCreateTable()
+	case p.peekKeyword(CREATE) && p.peekKeywordAt(1, INDEX):
+		p.pos += 2
+		return p.parseCreateIndex()
+	case p.peekKeyword(ALTER) && p.peekKeywordAt(1, TABLE):
+		p.pos += 2
+		return p.parseAlterTable()
+	case p.peekKeyword(DROP) && p.peekKeywordAt(1, TABLE):
+		p.pos += 2
+		stmt := &DropTableStmt{}
+		if p.peekKeyword(IF) && p.peekKeywordAt(1, EXISTS) {
+			p.pos += 2
+			stmt.IfExists = true
+		}
+		table, err := p.parseTableName("формат: DROP TABLE [IF EXISTS] <table>")
+		if err != nil {
+			return nil, err
+		}
+		stmt.Table = table
+		return stmt, nil
+	case p.keyword(TRUNCATE):
+		p.keyword(TABLE)
+		table, err := p.parseTableName("формат: TRUNCATE [TABLE] <table>")
+		if err != nil {
+			return nil, err
+		}
+		return &TruncateStmt{Table: table}, nil
+	case p.peekKeyword(SHOW) && p.peekKeywordAt(1, TABLES):
+		p.pos += 2
+		return &ShowTablesStmt{}, p.expectEOF()
+	case p.keyword(DESCRIBE) || p.keyword(DESC):
+		table, err := p.parseTableName("формат: DESCRIBE <table>")
+		if err != nil {
+			return nil, err
+		}
+		return &DescribeStmt{Table: table}, nil
+	case p.peekKeyword(DROP) && p.peekKeywordAt(1, INDEX):
+		p.pos += 2
+		name, err := p.expectIdent("формат: DROP INDEX <индекс>")
+		if err != nil {
+			return nil, err
+		}
+		return &DropIndexStmt{Name: name}, p.expectEOF()
+	case p.keyword(EXPLAIN):
+		if !p.keyword(SELECT) {
+			return nil, p.errorf(p.peek(), "формат: EXPLAIN SELECT ...")
+		}
+		stmt, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		return &ExplainStmt{Select: stmt}, nil
+	case p.keyword(SELECT):
+		return p.parseSelect()
+	case p.keyword(INSERT):
+		return p.parseInsert()
+	case p.keyword(UPDATE):
+		return p.parseUpdate()
+	case p.keyword(DELETE):
+		return p.parseDelete()
+	case p.keyword(HELP):
+		return &HelpStmt{}, p.expectEOF()
+	case p.keyword(BEGIN):
+		return &BeginStmt{}, p.parseTransactionTail()
+	case p.keyword(COMMIT):
+		return &CommitStmt{}, p.parseTransactionTail()
+	case p.keyword(ROLLBACK):
+		return &RollbackStmt{}, p.parseTransactionTail()
+	default:
+		return nil, p.errorf(p.peek(), "неизвестный тип запроса")
+	}
+}
+
+// expectIdent consumes a table or column name. Reserved words and missing
+// names are reported with the caller's message.
+func (p *queryParser) expectIdent(format string, args ...any) (string, error) {
+	tok := p.peek()
+	if tok.kind == tokIdent && isIdent(tok.value) {
+		p.next()
+		return tok.value, nil
+	}
+	if tok.kind == tokIdent && !reservedWords[strings.ToUpper(tok.value)] {
+		return "", p.errorf(tok, "некорректный идентификатор %q", tok.value)
+	}
+	return "", p.errorf(tok, format, args...)
+}
+
+func (p *queryParser) expectColumnName(format string, args ...any) (string, string, error) {
+	tok := p.peek()
+	if tok.kind == tokIdent && !reservedWords[strings.ToUpper(tok.value)] {
+		table, name, ok := splitQualified(tok.value)
+		if !ok {
+			return "", "", p.errorf(tok, "некорректный идентификатор %q", tok.value)
+		}
+		p.next()
+		return table, name, nil
+	}
+	return "", "", p.errorf(tok, format, args...)
+}
+
+func (p *queryParser) dropTrailingSemicolon() {
+	last := len(p.tokens) - 2
+	if last >= 0 && p.tokens[last].kind == tokSemicolon {
+		p.tokens = append(p.tokens[:last], p.tokens[last+1:]...)
+	}
+}
+
+func (p *queryParser) peekKeyword(word string) bool {
+	return p.peekKeywordAt(0, word)
+}
+
+func (p *queryParser) peekKeywordAt(offset int, word string) bool {
+	if p.pos+offset >= len(p.tokens) {
+		return false
+	}
+	tok := p.tokens[p.pos+offset]
+	return tok.kind == tokIdent && strings.EqualFold(tok.value, word)
+}
+
+func (p *queryParser) atEOF() bool {
+	return p.peek().kind == tokEOF
+}
+
+func (p *queryParser) expectEOF() error {
+	if tok := p.peek(); tok.kind != tokEOF {
+		return p.errorf(tok, "неожиданный токен %q", tok.value)
+	}
+	return nil
+}
+
+func (p *queryParser) parseTableName(format string) (string, error) {
+	table, err := p.expectIdent("%s", format)
+	if err != nil {
+		return "", err
+	}
+	return table, p.expectEOF()
+}
+
+// parseTransactionTail accepts the optional TRANSACTION or WORK noise word.
+func (p *queryParser) parseTransactionTail() error {
+	if !p.keyword(TRANSACTION) {
+		p.keyword(WORK)
+	}
+	return p.expectEOF()
+}
+
+func (p *queryParser) parseCreateTable() (*CreateTableStmt, error) {
+	table, err := p.expectIdent("формат: CREATE TABLE <table> <values>")
+	if err != nil {
+		return nil, err
+	}
+	if p.atEOF() {
+		return nil, p.errorf(p.peek(), "не указаны поля таблицы")
+	}
+
+	columns, err := p.parseColumnDefs()
+	if err != nil {
+		return nil, err
+	}
+	return &CreateTableStmt{Table: table, Columns: columns}, nil
+}
+
+func (p *queryParser) parseCreateIndex() (*CreateIndexStmt, error) {
+	const format = "формат: CREATE INDEX <индекс> ON <table> [USING HASH|BTREE] (<поле>)"
+	stmt := &CreateIndexStmt{Using: database.IndexOrdered}
+
+	name, err := p.expectIdent(format)
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+	if !p.keyword(ON) {
+		return nil, p.errorf(p.peek(), format)
+	}
+	if stmt.Table, err = p.expectIdent(format); err != nil {
+		return nil, err
+	}
+	if err := p.parseIndexUsing(stmt); err != nil {
+		return nil, err
+	}
+
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, p.errorf(tok, format)
+	}
+	column, err := p.expectIdent("не указано поле индекса")
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != tokRParen {
+		if tok.kind == tokComma {
+			return nil, p.errorf(tok, "поддерживаются только индексы по одному полю")
+		}
+		return nil, p.errorf(tok, "незакрытая скобка в списке полей")
+	}
+	stmt.Column = column
+
+	if err := p.parseIndexUsing(stmt); err != nil {
+		return nil, err
+	}
+	return stmt, p.expectEOF()
+}
+
+func (p *queryParser) parseIndexUsing(stmt *CreateIndexStmt) error {
+	if !p.keyword(USING) {
+		return nil
+	}
+	tok := p.next()
+	kind, err := database.ParseIndexKind(tok.value)
+	if err != nil || tok.kind != tokIdent {
+		return p.errorf(tok, "неизвестный тип индекса %q", tok.value)
+	}
+	stmt.Using = kind
+	return nil
+}
+
+func (p *queryParser) parseSelect() (*SelectStmt, error) {
+	if !p.atShortSelect() {
+		return p.parseSelectFrom()
+	}
+
+	table, err := p.expectIdent("формат: SELECT <table> <id> or <*>")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &SelectStmt{Table: table}
+	target := p.next()
+	if target.kind == tokEOF {
+		return nil, p.errorf(target, "формат: SELECT <table> <id> or <*>")
+	}
+
+	if target.kind == tokStar {
+		stmt.ID = -1
+		if err := p.parseSelectTail(stmt); err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+
+	id, err := strconv.Atoi(target.value)
+	if err != nil {
+		return nil, p.errorf(target, "неподходящий ID в select")
+	}
+	if !p.atEOF() {
+		return nil, p.errorf(p.peek(), "формат: SELECT <table> * WHERE <условие>")
+	}
+	stmt.ID = id
+	return stmt, nil
+}
+
+func (p *queryParser) parseInsert() (*InsertStmt, error) {
+	if p.keyword(INTO) {
+		return p.parseInsertInto()
+	}
+
+	table, err := p.expectIdent("формат: INSERT <table> <values>")
+	if err != nil {
+		return nil, err
+	}
+	if p.atEOF() {
+		return nil, p.errorf(p.peek(), "формат: INSERT <table> <values>")
+	}
+	return &InsertStmt{Table: table, Fields: p.parseRawValues()}, nil
+}
+
+func (p *queryParser) parseUpdate() (*UpdateStmt, error) {
+	table, err := p.expectIdent("формат: UPDATE <table> <id> <values>")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &UpdateStmt{Table: table}
+
+	if p.keyword(SET) {
+		set, where, err := p.parseSetClause()
+		if err != nil {
+			return nil, err
+		}
+		stmt.ID = -1
+		stmt.Set = set
+		stmt.Where = where
+		return stmt, nil
+	}
+
+	target := p.next()
+	if target.kind == tokEOF || p.atEOF() || p.peek().kind == tokOperator {
+		return nil, p.errorf(target, "формат: UPDATE <table> <id> <values>")
+	}
+	id, err := strconv.Atoi(target.value)
+	if err != nil || target.kind != tokNumber {
+		return nil, p.errorf(target, "неподходящий ID в update")
+	}
+	stmt.ID = id
+	stmt.Fields = p.parseRawValues()
+	return stmt, nil
+}
+
+func (p *queryParser) parseDelete() (*DeleteStmt, error) {
+	table, err := p.expectIdent("формат: DELETE <table> <id>")
+	if err != nil {
+		return nil, err
+	}
+	if p.atEOF() {
+		return nil, p.errorf(p.peek(), "формат: DELETE <table> <id>")
+	}
+	stmt := &DeleteStmt{Table: table}
+
+	if p.peekKeyword(WHERE) {
+		where, err := p.parseWhereTail()
+		if err != nil {
+			return nil, err
+		}
+		stmt.ID = -1
+		stmt.Where = where
+		return stmt, nil
+	}
+
+	target := p.next()
+	id, err := strconv.Atoi(target.value)
+	if err != nil || target.kind != tokNumber || !p.atEOF() {
+		return nil, p.errorf(target, "неподходящий ID в delete")
+	}
+	stmt.ID = id
+	return stmt, nil
+}
+
+// parseRawValues reads the legacy comma-separated value list. Each value is
+// either a single quoted literal or the source text between the commas.
+func (p *queryParser) parseRawValues() []string {
+	var values []string
+	for {
+		first := p.pos
+		for p.peek().kind != tokComma && p.peek().kind != tokEOF {
+			p.next()
+		}
+		switch {
+		case p.pos == first, p.pos-first == 1 && p.tokens[first].kind == tokNull:
+			values = append(values, "")
+		case p.pos-first == 1:
+			values = append(values, p.tokens[first].value)
+		default:
+			values = append(values, string(p.source[p.tokens[first].start:p.tokens[p.pos-1].end]))
+		}
+		if p.next().kind == tokEOF {
+			return values
+		}
+	}
+}
+
+func (p *queryParser) parseSetClause() ([]Assignment, Expr, error) {
+	var set []Assignment
+	for {
+		column, err := p.expectIdent("формат: UPDATE <table> SET <поле>=<значение>,... [WHERE <условие>]")
+		if err != nil {
+			return nil, nil, err
+		}
+		if op := p.next(); op.kind != tokOperator || op.value != string(OpEq) {
+			return nil, nil, p.errorf(op, "ожидалось = после поля %s", column)
+		}
+		value, ok := p.parseValue()
+		if !ok {
+			return nil, nil, p.errorf(p.peek(), "не указано значение для поля %s", column)
+		}
+		set = append(set, Assignment{Column: column, Value: value})
+
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+
+	where, err := p.parseWhereTail()
+	if err != nil {
+		return nil, nil, err
+	}
+	return set, where, nil
+}
+
+// atShortSelect tells SELECT <table> <id|*> from SELECT <list> FROM
+// <table>: the short form is a name followed by * or an id, or by nothing.
+func (p *queryParser) atShortSelect() bool {
+	switch tok := p.peek(); {
+	case tok.kind == tokEOF:
+		return true
+	case tok.kind != tokIdent || p.atAggregate():
+		return false
+	}
+	switch p.peekAt(1).kind {
+	case tokStar, tokNumber, tokEOF:
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseSelectFrom() (*SelectStmt, error) {
+	stmt := &SelectStmt{ID: -1}
+	if p.peek().kind == tokStar {
+		p.next()
+	} else {
+		for {
+			selected, err := p.parseSelectColumn()
+			if err != nil {
+				return nil, err
+			}
+			if p.keyword(AS) {
+				alias, err := p.expectIdent("не указан псевдоним для поля %s", selected.Label())
+				if err != nil {
+					return nil, err
 				}
-				query.Fields = fields
+				selected.Alias = alias
+			}
+			stmt.Columns = append(stmt.Columns, selected)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if !p.keyword(FROM) {
+		return nil, p.errorf(p.peek(), "ожидалось FROM, получено %q", p.peek().value)
+	}
+	table, err := p.expectIdent("не указано имя таблицы")
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = table
+	if stmt.Alias, err = p.parseAlias(); err != nil {
+		return nil, err
+	}
+	if err := p.parseJoins(stmt); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseSelectTail(stmt); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *queryParser) parseAlias() (string, error) {
+	if p.keyword(AS) {
+		return p.expectIdent("не указан псевдоним таблицы")
+	}
+	if tok := p.peek(); tok.kind == tokIdent && isIdent(tok.value) {
+		p.next()
+		return tok.value, nil
+	}
+	return "", nil
+}
+
+func (p *queryParser) parseJoins(stmt *SelectStmt) error {
+	for {
+		var join Join
+		switch {
+		case p.keyword(JOIN):
+		case p.keyword(INNER):
+			if !p.keyword(JOIN) {
+				return p.errorf(p.peek(), "ожидалось JOIN после INNER")
+			}
+		case p.keyword(LEFT):
+			p.keyword(OUTER)
+			if !p.keyword(JOIN) {
+				return p.errorf(p.peek(), "ожидалось JOIN после LEFT")
+			}
+			join.Left = true
+		default:
+			return nil
+		}
+
+		table, err := p.expectIdent("не указана таблица для JOIN")
+		if err != nil {
+			return err
+		}
+		join.Table = table
+		if join.Alias, err = p.parseAlias(); err != nil {
+			return err
+		}
+		if !p.keyword(ON) {
+			return p.errorf(p.peek(), "ожидалось ON после JOIN %s", join.Name())
+		}
+		if p.atEOF() {
+			return p.errorf(p.peek(), "не указано условие ON")
+		}
+		if join.On, err = p.parseOr(); err != nil {
+			return err
+		}
+		stmt.Joins = append(stmt.Joins, join)
+	}
+}
+
+func (p *queryParser) parseSelectColumn() (SelectColumn, error) {
+	if p.atAggregate() {
+		agg, err := p.parseAggregate()
+		if err != nil {
+			return SelectColumn{}, err
+		}
+		return SelectColumn{Aggregate: agg}, nil
+	}
+	table, column, err := p.expectColumnName("формат: SELECT <поле1>[ AS <псевдоним>],... FROM <table> [WHERE <условие>]")
+	if err != nil {
+		return SelectColumn{}, err
+	}
+	return SelectColumn{Table: table, Name: column}, nil
+}
+
+func (p *queryParser) parseSelectTail(stmt *SelectStmt) error {
+	where, err := p.parseWhereClause()
+	if err != nil {
+		return err
+	}
+	stmt.Where = where
+
+	if p.keyword(GROUP) {
+		if !p.keyword(BY) {
+			return p.errorf(p.peek(), "ожидалось BY после GROUP")
+		}
+		for {
+			table, column, err := p.expectColumnName("не указано поле для GROUP BY")
+			if err != nil {
+				return err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, ColumnRef{Table: table, Name: column})
+
+			if p.peek().kind != tokComma {
+				break
 			}
-			return query, nil
+			p.next()
 		}
 	}
-	command := strings.ToUpper(parts[0])
-	switch command {
-	case SELECT:
-		if len(parts) < 3 {
-			return nil, errors.New("формат: SELECT <table> <id> or <*>")
+	if p.keyword(HAVING) {
+		if p.atEOF() {
+			return p.errorf(p.peek(), "не указано условие HAVING")
 		}
-		query.Type = QuerySelect
-		query.Table = parts[1]
-		if len(parts) > 2 {
-			if parts[2] == "*" {
-				query.ID = -1
+		having, err := p.parseOr()
+		if err != nil {
+			return err
+		}
+		stmt.Having = having
+	}
+
+	if p.keyword(ORDER) {
+		if !p.keyword(BY) {
+			return p.errorf(p.peek(), "ожидалось BY после ORDER")
+		}
+		for {
+			var item OrderItem
+			if p.atAggregate() {
+				agg, err := p.parseAggregate()
+				if err != nil {
+					return err
+				}
+				item.Aggregate = agg
 			} else {
-				id, err := strconv.Atoi(parts[2])
+				table, column, err := p.expectColumnName("не указано поле для ORDER BY")
 				if err != nil {
-					return nil, errors.New("неподходящий ID в select")
+					return err
 				}
-				query.ID = id
+				item.Table, item.Column = table, column
+			}
+			if p.keyword(DESC) {
+				item.Desc = true
+			} else {
+				p.keyword(ASC)
 			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.keyword(LIMIT) {
+		limit, err := p.parseCount(LIMIT)
+		if err != nil {
+			return err
 		}
-	case INSERT:
-		if len(parts) < 3 {
-			return nil, errors.New("формат: INSERT <table> <values>")
+		if limit == 0 {
+			return p.errorf(p.tokens[p.pos-1], "LIMIT должен быть положительным числом")
 		}
-		query.Type = QueryInsert
-		query.Table = parts[1]
+		stmt.Limit = limit
+	}
+	if p.keyword(OFFSET) {
+		offset, err := p.parseCount(OFFSET)
+		if err != nil {
+			return err
+		}
+		stmt.Offset = offset
+	}
 
-		valuesPart := strings.Join(parts[2:], " ")
-		query.Fields = strings.Split(valuesPart, ",")
+	if tok := p.peek(); tok.kind != tokEOF {
+		return p.errorf(tok, "ожидалось WHERE, GROUP BY, ORDER BY или LIMIT, получено %q", tok.value)
+	}
+	return nil
+}
 
-		for i := range query.Fields {
-			query.Fields[i] = strings.TrimSpace(query.Fields[i])
-		}
+func (p *queryParser) parseCount(clause string) (int, error) {
+	tok := p.next()
+	n, err := strconv.Atoi(tok.value)
+	if tok.kind != tokNumber || err != nil || n < 0 {
+		return 0, p.errorf(tok, "неподходящее значение %s: %q", clause, tok.value)
+	}
+	return n, nil
+}
 
-		if len(query.Fields) == 0 {
-			return nil, errors.New("для вставки необходимо указать значения")
+func (p *queryParser) parseWhereClause() (Expr, error) {
+	if !p.keyword(WHERE) {
+		return nil, nil
+	}
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "не указано условие WHERE")
+	}
+	return p.parseOr()
+}
+
+func (p *queryParser) parseWhereTail() (Expr, error) {
+	if tok := p.peek(); tok.kind != tokEOF && !(tok.kind == tokIdent && strings.EqualFold(tok.value, WHERE)) {
+		return nil, p.errorf(tok, "ожидалось WHERE, получено %q", tok.value)
+	}
+	where, err := p.parseWhereClause()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "неожиданный токен %q в условии", tok.value)
+	}
+	return where, nil
+}
+
+func (p *queryParser) parseColumnDefs() ([]database.Column, error) {
+	var columns []database.Column
+	for {
+		column, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
 		}
-	case UPDATE:
-		newParts := strings.SplitN(normalized, " ", 4)
-		query.Type = QueryUpdate
+		columns = append(columns, column)
 
-		if len(newParts) < 4 {
-			return nil, errors.New("формат: UPDATE <table> <id> <values>")
+		tok := p.next()
+		if tok.kind == tokEOF {
+			return columns, nil
 		}
-		query.Table = newParts[1]
-		id, err := strconv.Atoi(newParts[2])
+		if tok.kind != tokComma {
+			return nil, p.errorf(tok, "неожиданное определение поля %s: %q", column.Name, tok.value)
+		}
+	}
+}
+
+func isConstraintKeyword(word string) bool {
+	switch strings.ToUpper(word) {
+	case "NOT", "UNIQUE", "DEFAULT", "CHECK":
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseColumnDef() (database.Column, error) {
+	name, err := p.expectIdent("пустое имя поля")
+	if err != nil {
+		return database.Column{}, err
+	}
+	column := database.Column{Name: name, Type: database.TypeText}
+
+	if tok := p.peek(); tok.kind == tokIdent && !isConstraintKeyword(tok.value) {
+		columnType, err := database.ParseColumnType(tok.value)
 		if err != nil {
-			return nil, errors.New("неподходящий ID в update")
+			return database.Column{}, errorAt(tok, err)
 		}
-		query.ID = id
+		column.Type = columnType
+		p.next()
+	}
+	if err := p.parseConstraints(&column); err != nil {
+		return database.Column{}, err
+	}
+	return column, nil
+}
+
+func (p *queryParser) parseAlterTable() (*AlterTableStmt, error) {
+	const format = "формат: ALTER TABLE <table> ADD|DROP|RENAME ..."
+	table, err := p.expectIdent(format)
+	if err != nil {
+		return nil, err
+	}
+	stmt := &AlterTableStmt{Table: table}
 
-		valuesPart := strings.Join(newParts[3:], " ")
-		query.Fields = strings.Split(valuesPart, ",")
-		for i := range query.Fields {
-			query.Fields[i] = strings.TrimSpace(query.Fields[i])
+	switch {
+	case p.keyword(ADD):
+		p.keyword(COLUMN)
+		stmt.Kind = AlterAddColumn
+		column, err := p.parseColumnDef()
+		if err != nil {
+			return nil, err
 		}
-	case DELETE:
-		if len(parts) < 3 {
-			return nil, errors.New("формат: DELETE <table> <id>")
+		stmt.Column = column
+	case p.keyword(DROP):
+		p.keyword(COLUMN)
+		stmt.Kind = AlterDropColumn
+		name, err := p.expectIdent("формат: ALTER TABLE <table> DROP COLUMN <поле>")
+		if err != nil {
+			return nil, err
 		}
-		query.Type = QueryDelete
-		query.Table = parts[1]
-
-		id, err := strconv.Atoi(parts[2])
+		stmt.Name = name
+	case p.keyword(RENAME):
+		if p.keyword(TO) {
+			stmt.Kind = AlterRenameTable
+			name, err := p.expectIdent("формат: ALTER TABLE <table> RENAME TO <новое_имя>")
+			if err != nil {
+				return nil, err
+			}
+			stmt.NewName = name
+			break
+		}
+		p.keyword(COLUMN)
+		stmt.Kind = AlterRenameColumn
+		const renameFormat = "формат: ALTER TABLE <table> RENAME COLUMN <поле> TO <новое_имя>"
+		name, err := p.expectIdent(renameFormat)
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(TO) {
+			return nil, p.errorf(p.peek(), "ожидалось TO")
+		}
+		newName, err := p.expectIdent(renameFormat)
 		if err != nil {
-			return nil, errors.New("неподходящий ID в delete")
+			return nil, err
 		}
-		query.ID = id
-	case HELP:
-		query.Type = QueryHelp
-		return query, nil
+		stmt.Name = name
+		stmt.NewName = newName
 	default:
-		return nil, errors.New("неизвестный тип запроса")
+		return nil, p.errorf(p.peek(), format)
+	}
+	return stmt, p.expectEOF()
+}
+
+func (p *queryParser) parseConstraints(column *database.Column) error {
+	for {
+		switch {
+		case p.keyword("NOT"):
+			if !p.keyword("NULL") {
+				return p.errorf(p.peek(), "ожидалось NULL после NOT в поле %s", column.Name)
+			}
+			column.NotNull = true
+		case p.keyword("UNIQUE"):
+			column.Unique = true
+		case p.keyword("DEFAULT"):
+			tok := p.peek()
+			value, err := p.parseOperand()
+			if err != nil {
+				return err
+			}
+			literal, ok := value.(*Literal)
+			if !ok {
+				return p.errorf(tok, "DEFAULT поля %s должен быть значением", column.Name)
+			}
+			column.HasDefault = true
+			column.Default = literal.Value
+		case p.keyword("CHECK"):
+			if p.peek().kind != tokLParen {
+				return p.errorf(p.peek(), "после CHECK ожидается условие в скобках в поле %s", column.Name)
+			}
+			check, err := p.parseComparison()
+			if err != nil {
+				return err
+			}
+			column.Check = check.String()
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *queryParser) parseInsertInto() (*InsertStmt, error) {
+	table, err := p.expectIdent("не указано имя таблицы")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &InsertStmt{Table: table}
+
+	if p.peek().kind == tokLParen {
+		p.next()
+		for {
+			column, err := p.expectIdent("ожидалось имя поля в списке INSERT")
+			if err != nil {
+				return nil, err
+			}
+			stmt.Targets = append(stmt.Targets, column)
+
+			tok := p.next()
+			if tok.kind == tokRParen {
+				break
+			}
+			if tok.kind != tokComma {
+				return nil, p.errorf(tok, "незакрытая скобка в списке полей")
+			}
+		}
+	}
+
+	if !p.keyword(VALUES) {
+		return nil, p.errorf(p.peek(), "формат: INSERT INTO <table> [(<поле1>,...)] VALUES (<значение1>,...),...")
+	}
+	for {
+		start := p.next()
+		if start.kind != tokLParen {
+			return nil, p.errorf(start, "после VALUES ожидается список значений в скобках")
+		}
+		var row []string
+		for {
+			value, ok := p.parseValue()
+			if !ok {
+				return nil, p.errorf(p.peek(), "ожидалось значение, получено %q", p.peek().value)
+			}
+			row = append(row, value)
+
+			tok := p.next()
+			if tok.kind == tokRParen {
+				break
+			}
+			if tok.kind != tokComma {
+				return nil, p.errorf(tok, "незакрытая скобка в списке значений")
+			}
+		}
+		if stmt.Targets != nil && len(row) != len(stmt.Targets) {
+			return nil, p.errorf(start, "%w: ожидалось %d значений, получено %d",
+				database.ErrMissFieldCount, len(stmt.Targets), len(row))
+		}
+		stmt.Values = append(stmt.Values, row)
+
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expectEOF(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (p *queryParser) parseValue() (string, bool) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokString, tokNumber, tokBool:
+		p.next()
+		return tok.value, true
+	case tokNull:
+		p.next()
+		return "", true
+	case tokIdent:
+		switch strings.ToUpper(tok.value) {
+		case WHERE:
+			return "", false
+		case "NULL":
+			p.next()
+			return "", true
+		case "TRUE", "FALSE":
+			p.next()
+			return strings.ToLower(tok.value), true
+		}
+		p.next()
+		return tok.value, true
 	}
-	return query, nil
+	return "", false
 }
@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
+	"v4/database"
 )
 
 func TestParseQuery(t *testing.T) {
@@ -23,6 +26,56 @@ func TestParseQuery(t *testing.T) {
 				Fields: []string{"id", "name", "age"},
 			},
 		},
+		{
+			name:  "valid CREATE TABLE with types",
+			input: "CREATE TABLE users name TEXT, age INT, score float, active BOOL, born DATE, note",
+			expected: &Query{
+				Type:   QueryCreateTable,
+				Table:  "users",
+				Fields: []string{"name", "age", "score", "active", "born", "note"},
+				Columns: []database.Column{
+					{Name: "name", Type: database.TypeText},
+					{Name: "age", Type: database.TypeInt},
+					{Name: "score", Type: database.TypeFloat},
+					{Name: "active", Type: database.TypeBool},
+					{Name: "born", Type: database.TypeDate},
+					{Name: "note", Type: database.TypeText},
+				},
+			},
+		},
+		{
+			name:  "valid CREATE TABLE with constraints",
+			input: "CREATE TABLE users name TEXT NOT NULL, email UNIQUE, age INT DEFAULT 18 CHECK (age >= 0 AND age < 150), status DEFAULT 'new'",
+			expected: &Query{
+				Type:   QueryCreateTable,
+				Table:  "users",
+				Fields: []string{"name", "email", "age", "status"},
+				Columns: []database.Column{
+					{Name: "name", Type: database.TypeText, NotNull: true},
+					{Name: "email", Type: database.TypeText, Unique: true},
+					{Name: "age", Type: database.TypeInt, HasDefault: true, Default: "18", Check: "(age >= 0 AND age < 150)"},
+					{Name: "status", Type: database.TypeText, HasDefault: true, Default: "new"},
+				},
+			},
+		},
+		{
+			name:        "CREATE TABLE NOT without NULL",
+			input:       "CREATE TABLE users name NOT",
+			expectError: true,
+			errText:     "ожидалось NULL после NOT",
+		},
+		{
+			name:        "CREATE TABLE CHECK without parentheses",
+			input:       "CREATE TABLE users age INT CHECK age > 0",
+			expectError: true,
+			errText:     "после CHECK ожидается условие в скобках",
+		},
+		{
+			name:        "CREATE TABLE unknown type",
+			input:       "CREATE TABLE users name BLOB",
+			expectError: true,
+			errText:     "неизвестный тип BLOB",
+		},
 		{
 			name:        "CREATE TABLE missing name",
 			input:       "CREATE TABLE",
@@ -55,6 +108,129 @@ func TestParseQuery(t *testing.T) {
 				ID:    -1,
 			},
 		},
+		{
+			name:  "valid SELECT with WHERE",
+			input: "SELECT users * WHERE age > 20 AND name = 'kolya'",
+			expected: &Query{
+				Type:  QuerySelect,
+				Table: "users",
+				ID:    -1,
+			},
+		},
+		{
+			name:        "SELECT WHERE without condition",
+			input:       "SELECT users * WHERE",
+			expectError: true,
+			errText:     "не указано условие WHERE",
+		},
+		{
+			name:        "SELECT garbage after star",
+			input:       "SELECT users * age > 20",
+			expectError: true,
+			errText:     "ожидалось WHERE",
+		},
+		{
+			name:  "valid SELECT with projection",
+			input: "SELECT name, email AS mail FROM users WHERE age > 20",
+			expected: &Query{
+				Type:   QuerySelect,
+				Table:  "users",
+				ID:     -1,
+				Select: []SelectColumn{{Name: "name"}, {Name: "email", Alias: "mail"}},
+			},
+		},
+		{
+			name:  "valid SELECT star FROM",
+			input: "select * from users",
+			expected: &Query{
+				Type:  QuerySelect,
+				Table: "users",
+				ID:    -1,
+			},
+		},
+		{
+			name:  "valid SELECT with ORDER BY, LIMIT and OFFSET",
+			input: "SELECT name FROM users WHERE age > 1 ORDER BY age DESC, name LIMIT 10 OFFSET 5",
+			expected: &Query{
+				Type:    QuerySelect,
+				Table:   "users",
+				ID:      -1,
+				Select:  []SelectColumn{{Name: "name"}},
+				OrderBy: []OrderItem{{Column: "age", Desc: true}, {Column: "name"}},
+				Limit:   10,
+				Offset:  5,
+			},
+		},
+		{
+			name:  "legacy SELECT with ORDER BY",
+			input: "SELECT users * ORDER BY name ASC LIMIT 3",
+			expected: &Query{
+				Type:    QuerySelect,
+				Table:   "users",
+				ID:      -1,
+				OrderBy: []OrderItem{{Column: "name"}},
+				Limit:   3,
+			},
+		},
+		{
+			name:        "SELECT ORDER without BY",
+			input:       "SELECT * FROM users ORDER name",
+			expectError: true,
+			errText:     "ожидалось BY после ORDER",
+		},
+		{
+			name:        "SELECT invalid LIMIT",
+			input:       "SELECT * FROM users LIMIT ten",
+			expectError: true,
+			errText:     "неподходящее значение LIMIT",
+		},
+		{
+			name:        "SELECT zero LIMIT",
+			input:       "SELECT * FROM users LIMIT 0",
+			expectError: true,
+			errText:     "LIMIT должен быть положительным числом",
+		},
+		{
+			name:        "SELECT FROM without table",
+			input:       "SELECT name FROM",
+			expectError: true,
+			errText:     "не указано имя таблицы",
+		},
+		{
+			name:        "SELECT AS without alias",
+			input:       "SELECT name AS FROM users",
+			expectError: true,
+			errText:     "не указан псевдоним для поля name",
+		},
+		{
+			name:  "SELECT with qualified names",
+			input: "SELECT users.name AS n FROM users WHERE users.age > 1 ORDER BY users.age",
+			expected: &Query{
+				Type:    QuerySelect,
+				Table:   "users",
+				ID:      -1,
+				Select:  []SelectColumn{{Table: "users", Name: "name", Alias: "n"}},
+				OrderBy: []OrderItem{{Table: "users", Column: "age"}},
+			},
+		},
+		{
+			name:        "SELECT reserved word as table",
+			input:       "SELECT * FROM where",
+			expectError: true,
+			errText:     "не указано имя таблицы",
+		},
+		{
+			name:        "SELECT invalid identifier",
+			input:       "SELECT * FROM 1users",
+			expectError: true,
+			errText:     `некорректный идентификатор "1users"`,
+		},
+		{
+			name:        "CREATE TABLE invalid column name",
+			input:       "CREATE TABLE users e-mail",
+			expectError: true,
+			errText:     `некорректный идентификатор "e-mail"`,
+		},
 		{
 			name:        "SELECT missing table",
 			input:       "SELECT",
@@ -78,6 +254,76 @@ func TestParseQuery(t *testing.T) {
 				Fields: []string{"John", "30", "developer"},
 			},
 		},
+		{
+			name:  "valid INSERT INTO with columns and several rows",
+			input: "INSERT INTO users (name, age) VALUES ('Smith John', 3), ('b', NULL)",
+			expected: &Query{
+				Type:    QueryInsert,
+				Table:   "users",
+				Targets: []string{"name", "age"},
+				Values:  [][]string{{"Smith John", "3"}, {"b", ""}},
+			},
+		},
+		{
+			name:  "valid INSERT INTO without columns",
+			input: "insert into users values ('a', TRUE)",
+			expected: &Query{
+				Type:   QueryInsert,
+				Table:  "users",
+				Values: [][]string{{"a", "true"}},
+			},
+		},
+		{
+			name:        "INSERT INTO value count mismatch",
+			input:       "INSERT INTO users (name, age) VALUES ('a')",
+			expectError: true,
+			errText:     "несоответствие количества полей",
+		},
+		{
+			name:        "INSERT INTO missing VALUES",
+			input:       "INSERT INTO users (name) ('a')",
+			expectError: true,
+			errText:     "формат: INSERT INTO",
+		},
+		{
+			name:        "INSERT INTO unclosed values",
+			input:       "INSERT INTO users VALUES ('a', 'b'",
+			expectError: true,
+			errText:     "незакрытая скобка в списке значений",
+		},
+		{
+			name:  "INSERT with quoted values",
+			input: `INSERT users "Smith, John", 'O''Brien  Jr', 30`,
+			expected: &Query{
+				Type:   QueryInsert,
+				Table:  "users",
+				Fields: []string{"Smith, John", "O'Brien  Jr", "30"},
+			},
+		},
+		{
+			name:  "INSERT keeps spaces inside unquoted value",
+			input: "INSERT users John Smith, +7(999)123",
+			expected: &Query{
+				Type:   QueryInsert,
+				Table:  "users",
+				Fields: []string{"John Smith", "+7(999)123"},
+			},
+		},
+		{
+			name:  "INSERT with empty value and comment",
+			input: "INSERT users a,,c -- comment",
+			expected: &Query{
+				Type:   QueryInsert,
+				Table:  "users",
+				Fields: []string{"a", "", "c"},
+			},
+		},
+		{
+			name:        "INSERT unclosed quote",
+			input:       "INSERT users 'abc, 1",
+			expectError: true,
+			errText:     "незакрытая строка",
+		},
 		{
 			name:        "INSERT missing values",
 			input:       "INSERT users",
@@ -102,6 +348,52 @@ func TestParseQuery(t *testing.T) {
 				Fields: []string{"Kolya", "23"},
 			},
 		},
+		{
+			name:  "valid bulk UPDATE",
+			input: "UPDATE users SET email='x', age = 30 WHERE name='kolya'",
+			expected: &Query{
+				Type:  QueryUpdate,
+				Table: "users",
+				ID:    -1,
+				Set:   []Assignment{{Column: "email", Value: "x"}, {Column: "age", Value: "30"}},
+			},
+		},
+		{
+			name:  "bulk UPDATE without WHERE",
+			input: "UPDATE users SET active=1",
+			expected: &Query{
+				Type:  QueryUpdate,
+				Table: "users",
+				ID:    -1,
+				Set:   []Assignment{{Column: "active", Value: "1"}},
+			},
+		},
+		{
+			name:        "bulk UPDATE missing value",
+			input:       "UPDATE users SET email= WHERE name='kolya'",
+			expectError: true,
+			errText:     "не указано значение для поля email",
+		},
+		{
+			name:  "UPDATE with quoted values",
+			input: "UPDATE users 1 'Kolya, T', \"a\\tb\";",
+			expected: &Query{
+				Type:   QueryUpdate,
+				Table:  "users",
+				ID:     1,
+				Fields: []string{"Kolya, T", "a\tb"},
+			},
+		},
+		{
+			name:  "multi-line query with comments",
+			input: "SELECT name /* имя */\nFROM users -- все\nWHERE name = 'a b';",
+			expected: &Query{
+				Type:   QuerySelect,
+				Table:  "users",
+				ID:     -1,
+				Select: []SelectColumn{{Name: "name"}},
+			},
+		},
 		{
 			name:        "UPDATE missing ID",
 			input:       "UPDATE users name=John",
@@ -125,6 +417,21 @@ func TestParseQuery(t *testing.T) {
 				ID:    1,
 			},
 		},
+		{
+			name:  "valid DELETE with WHERE",
+			input: "DELETE users WHERE age < 18",
+			expected: &Query{
+				Type:  QueryDelete,
+				Table: "users",
+				ID:    -1,
+			},
+		},
+		{
+			name:        "DELETE with empty WHERE",
+			input:       "DELETE users WHERE",
+			expectError: true,
+			errText:     "не указано условие WHERE",
+		},
 		{
 			name:        "DELETE missing ID",
 			input:       "DELETE users",
@@ -203,6 +510,67 @@ func TestParseQuery(t *testing.T) {
 				t.Errorf("ID = %v, want %v", actual.ID, tt.expected.ID)
 			}
 
+			if tt.expected.Columns != nil {
+				if len(actual.Columns) != len(tt.expected.Columns) {
+					t.Errorf("Columns length = %v, want %v", len(actual.Columns), len(tt.expected.Columns))
+				} else {
+					for i := range actual.Columns {
+						if actual.Columns[i] != tt.expected.Columns[i] {
+							t.Errorf("Columns[%d] = %v, want %v", i, actual.Columns[i], tt.expected.Columns[i])
+						}
+					}
+				}
+			}
+
+			if strings.Join(actual.Targets, ",") != strings.Join(tt.expected.Targets, ",") {
+				t.Errorf("Targets = %v, want %v", actual.Targets, tt.expected.Targets)
+			}
+
+			if len(actual.Values) != len(tt.expected.Values) {
+				t.Errorf("Values length = %v, want %v", len(actual.Values), len(tt.expected.Values))
+			} else {
+				for i := range actual.Values {
+					if strings.Join(actual.Values[i], ",") != strings.Join(tt.expected.Values[i], ",") {
+						t.Errorf("Values[%d] = %v, want %v", i, actual.Values[i], tt.expected.Values[i])
+					}
+				}
+			}
+
+			if len(actual.Select) != len(tt.expected.Select) {
+				t.Errorf("Select length = %v, want %v", len(actual.Select), len(tt.expected.Select))
+			} else {
+				for i := range actual.Select {
+					if actual.Select[i] != tt.expected.Select[i] {
+						t.Errorf("Select[%d] = %v, want %v", i, actual.Select[i], tt.expected.Select[i])
+					}
+				}
+			}
+
+			if actual.Limit != tt.expected.Limit || actual.Offset != tt.expected.Offset {
+				t.Errorf("Limit/Offset = %v/%v, want %v/%v",
+					actual.Limit, actual.Offset, tt.expected.Limit, tt.expected.Offset)
+			}
+
+			if len(actual.OrderBy) != len(tt.expected.OrderBy) {
+				t.Errorf("OrderBy length = %v, want %v", len(actual.OrderBy), len(tt.expected.OrderBy))
+			} else {
+				for i := range actual.OrderBy {
+					if actual.OrderBy[i] != tt.expected.OrderBy[i] {
+						t.Errorf("OrderBy[%d] = %v, want %v", i, actual.OrderBy[i], tt.expected.OrderBy[i])
+					}
+				}
+			}
+
+			if len(actual.Set) != len(tt.expected.Set) {
+				t.Errorf("Set length = %v, want %v", len(actual.Set), len(tt.expected.Set))
+			} else {
+				for i := range actual.Set {
+					if actual.Set[i] != tt.expected.Set[i] {
+						t.Errorf("Set[%d] = %v, want %v", i, actual.Set[i], tt.expected.Set[i])
+					}
+				}
+			}
+
 			if len(actual.Fields) != len(tt.expected.Fields) {
 				t.Errorf("Fields length = %v, want %v", len(actual.Fields), len(tt.expected.Fields))
 			} else {
@@ -215,3 +583,678 @@ func TestParseQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQuerySyntaxErrorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+		wantCol  int
+		errText  string
+	}{
+		{name: "unknown statement", input: "DROP users", wantLine: 1, wantCol: 1, errText: "неизвестный тип запроса"},
+		{name: "ORDER without BY", input: "SELECT name FROM users\n  ORDER age", wantLine: 2, wantCol: 9, errText: "ожидалось BY после ORDER"},
+		{name: "bad operator in WHERE", input: "SELECT *\nFROM users\nWHERE age 20", wantLine: 3, wantCol: 11, errText: "ожидался оператор сравнения"},
+		{name: "unclosed string", input: "INSERT INTO users VALUES (1,\n 'abc)", wantLine: 2, wantCol: 2, errText: "незакрытая строка"},
+		{name: "bad LIMIT", input: "SELECT * FROM users LIMIT -1", wantLine: 1, wantCol: 27, errText: "неподходящее значение LIMIT"},
+		{name: "unknown type", input: "CREATE TABLE t\n  a INT,\n  b BLOB", wantLine: 3, wantCol: 5, errText: "неизвестный тип BLOB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseQuery(tt.input)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Expected *SyntaxError, got %v", err)
+			}
+			if syntaxErr.Line != tt.wantLine || syntaxErr.Col != tt.wantCol {
+				t.Errorf("Position = %d:%d, want %d:%d (%v)", syntaxErr.Line, syntaxErr.Col, tt.wantLine, tt.wantCol, err)
+			}
+			if !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseQueryKeepsSentinelErrors(t *testing.T) {
+	_, err := ParseQuery("INSERT INTO users (a, b) VALUES (1)")
+	if !errors.Is(err, database.ErrMissFieldCount) {
+		t.Errorf("Expected ErrMissFieldCount, got %v", err)
+	}
+}
+
+func TestParseAggregateQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantLabels  []string
+		wantGroupBy []string
+		wantHaving  string
+		wantOrderBy []string
+		expectError bool
+		errText     string
+	}{
+		{
+			name:       "COUNT star without GROUP BY",
+			input:      "SELECT COUNT(*) FROM users",
+			wantLabels: []string{"COUNT(*)"},
+		},
+		{
+			name:        "GROUP BY with HAVING and ORDER BY aggregate",
+			input:       "select city, count(*) as n, avg(age) from users where age > 0 group by city having count(*) > 1 and avg(age) < 30 order by count(*) desc, city",
+			wantLabels:  []string{"city", "n", "AVG(age)"},
+			wantGroupBy: []string{"city"},
+			wantHaving:  "(COUNT(*) > 1 AND AVG(age) < 30)",
+			wantOrderBy: []string{"COUNT(*)", "city"},
+		},
+		{
+			name:        "several GROUP BY columns",
+			input:       "SELECT city, role, SUM(users.salary) FROM users GROUP BY city, users.role",
+			wantLabels:  []string{"city", "role", "SUM(users.salary)"},
+			wantGroupBy: []string{"city", "users.role"},
+		},
+		{
+			name:        "star in SUM",
+			input:       "SELECT SUM(*) FROM users",
+			expectError: true,
+			errText:     "* допускается только в COUNT",
+		},
+		{
+			name:        "unclosed aggregate",
+			input:       "SELECT MAX(age FROM users",
+			expectError: true,
+			errText:     "незакрытая скобка в функции MAX",
+		},
+		{
+			name:        "GROUP without BY",
+			input:       "SELECT city FROM users GROUP city",
+			expectError: true,
+			errText:     "ожидалось BY после GROUP",
+		},
+		{
+			name:        "HAVING without condition",
+			input:       "SELECT city FROM users GROUP BY city HAVING",
+			expectError: true,
+			errText:     "не указано условие HAVING",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				} else if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !query.IsAggregate() {
+				t.Errorf("IsAggregate() = false, want true")
+			}
+
+			var labels, groupBy, orderBy []string
+			for _, column := range query.Select {
+				labels = append(labels, column.Label())
+			}
+			for _, column := range query.GroupBy {
+				groupBy = append(groupBy, column.String())
+			}
+			for _, item := range query.OrderBy {
+				if item.Aggregate != nil {
+					orderBy = append(orderBy, item.Aggregate.String())
+				} else {
+					orderBy = append(orderBy, item.Column)
+				}
+			}
+			if strings.Join(labels, ",") != strings.Join(tt.wantLabels, ",") {
+				t.Errorf("Labels = %v, want %v", labels, tt.wantLabels)
+			}
+			if strings.Join(groupBy, ",") != strings.Join(tt.wantGroupBy, ",") {
+				t.Errorf("GroupBy = %v, want %v", groupBy, tt.wantGroupBy)
+			}
+			if strings.Join(orderBy, ",") != strings.Join(tt.wantOrderBy, ",") {
+				t.Errorf("OrderBy = %v, want %v", orderBy, tt.wantOrderBy)
+			}
+			having := ""
+			if query.Having != nil {
+				having = query.Having.String()
+			}
+			if having != tt.wantHaving {
+				t.Errorf("Having = %q, want %q", having, tt.wantHaving)
+			}
+		})
+	}
+}
+
+func TestParseJoinQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantAlias   string
+		wantJoins   []string
+		expectError bool
+		errText     string
+	}{
+		{
+			name:      "join with aliases",
+			input:     "SELECT u.name, o.total FROM users u JOIN orders o ON o.user_id = u.id",
+			wantAlias: "u",
+			wantJoins: []string{"INNER orders o ON o.user_id = u.id"},
+		},
+		{
+			name:      "several joins",
+			input:     "SELECT * FROM users AS u INNER JOIN orders AS o ON o.user_id = u.id LEFT OUTER JOIN items ON items.order_id = o.id AND items.price > 0 WHERE u.id = 1",
+			wantAlias: "u",
+			wantJoins: []string{"INNER orders o ON o.user_id = u.id", "LEFT items  ON (items.order_id = o.id AND items.price > 0)"},
+		},
+		{
+			name:      "table alias without join",
+			input:     "SELECT u.name FROM users u ORDER BY u.name",
+			wantAlias: "u",
+		},
+		{
+			name:        "join without ON",
+			input:       "SELECT * FROM users JOIN orders",
+			expectError: true,
+			errText:     "ожидалось ON после JOIN orders",
+		},
+		{
+			name:        "LEFT without JOIN",
+			input:       "SELECT * FROM users LEFT orders ON a = b",
+			expectError: true,
+			errText:     "ожидалось JOIN после LEFT",
+		},
+		{
+			name:        "empty ON",
+			input:       "SELECT * FROM users JOIN orders ON",
+			expectError: true,
+			errText:     "не указано условие ON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				} else if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if query.Alias != tt.wantAlias {
+				t.Errorf("Alias = %q, want %q", query.Alias, tt.wantAlias)
+			}
+
+			var joins []string
+			for _, join := range query.Joins {
+				kind := "INNER"
+				if join.Left {
+					kind = "LEFT"
+				}
+				joins = append(joins, kind+" "+join.Table+" "+join.Alias+" ON "+join.On.String())
+			}
+			if strings.Join(joins, "|") != strings.Join(tt.wantJoins, "|") {
+				t.Errorf("Joins = %v, want %v", joins, tt.wantJoins)
+			}
+		})
+	}
+}
+
+func TestParseIndexQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantType    QueryType
+		wantIndex   string
+		wantTable   string
+		wantFields  []string
+		wantUsing   database.IndexKind
+		expectError bool
+		errText     string
+	}{
+		{
+			name:       "CREATE INDEX defaults to ordered",
+			input:      "CREATE INDEX idx ON users(email)",
+			wantType:   QueryCreateIndex,
+			wantIndex:  "idx",
+			wantTable:  "users",
+			wantFields: []string{"email"},
+			wantUsing:  database.IndexOrdered,
+		},
+		{
+			name:       "CREATE INDEX USING HASH before columns",
+			input:      "create index idx on users using hash (email);",
+			wantType:   QueryCreateIndex,
+			wantIndex:  "idx",
+			wantTable:  "users",
+			wantFields: []string{"email"},
+			wantUsing:  database.IndexHash,
+		},
+		{
+			name:       "CREATE INDEX USING after columns",
+			input:      "CREATE INDEX idx ON users (age) USING BTREE",
+			wantType:   QueryCreateIndex,
+			wantIndex:  "idx",
+			wantTable:  "users",
+			wantFields: []string{"age"},
+			wantUsing:  database.IndexOrdered,
+		},
+		{
+			name:      "DROP INDEX",
+			input:     "DROP INDEX idx",
+			wantType:  QueryDropIndex,
+			wantIndex: "idx",
+		},
+		{
+			name:        "CREATE INDEX without ON",
+			input:       "CREATE INDEX idx users(email)",
+			expectError: true,
+			errText:     "формат: CREATE INDEX",
+		},
+		{
+			name:        "CREATE INDEX on several columns",
+			input:       "CREATE INDEX idx ON users(a, b)",
+			expectError: true,
+			errText:     "поддерживаются только индексы по одному полю",
+		},
+		{
+			name:        "CREATE INDEX unknown kind",
+			input:       "CREATE INDEX idx ON users USING gist (email)",
+			expectError: true,
+			errText:     `неизвестный тип индекса "gist"`,
+		},
+		{
+			name:        "DROP INDEX without name",
+			input:       "DROP INDEX",
+			expectError: true,
+			errText:     "формат: DROP INDEX <индекс>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				} else if !strings.Contains(err.Error(), tt.errText) {
+					t.Errorf("Expected error to contain '%s', got '%s'", tt.errText, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if query.Type != tt.wantType || query.Index != tt.wantIndex || query.Table != tt.wantTable {
+				t.Errorf("Query = %v %q %q, want %v %q %q", query.Type, query.Index, query.Table, tt.wantType, tt.wantIndex, tt.wantTable)
+			}
+			if strings.Join(query.Fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("Fields = %v, want %v", query.Fields, tt.wantFields)
+			}
+			if query.Using != tt.wantUsing {
+				t.Errorf("Using = %v, want %v", query.Using, tt.wantUsing)
+			}
+		})
+	}
+}
+
+func TestParseTransactionQuery(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantType    QueryType
+		expectError bool
+	}{
+		{input: "BEGIN", wantType: QueryBegin},
+		{input: "begin transaction;", wantType: QueryBegin},
+		{input: "COMMIT", wantType: QueryCommit},
+		{input: "COMMIT WORK", wantType: QueryCommit},
+		{input: "rollback", wantType: QueryRollback},
+		{input: "BEGIN users", expectError: true},
+		{input: "COMMIT TRANSACTION WORK", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("ParseQuery(%q) expected error", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error = %v", tt.input, err)
+			}
+			if query.Type != tt.wantType {
+				t.Errorf("Type = %v, want %v", query.Type, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestParseAlterTableQuery(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantAlter   AlterKind
+		wantFields  []string
+		wantColumn  database.Column
+		wantNewName string
+		errText     string
+	}{
+		{
+			input:      "ALTER TABLE users ADD COLUMN age INT DEFAULT 18",
+			wantAlter:  AlterAddColumn,
+			wantFields: []string{"age"},
+			wantColumn: database.Column{Name: "age", Type: database.TypeInt, HasDefault: true, Default: "18"},
+		},
+		{
+			input:      "alter table users add email",
+			wantAlter:  AlterAddColumn,
+			wantFields: []string{"email"},
+			wantColumn: database.Column{Name: "email", Type: database.TypeText},
+		},
+		{
+			input:      "ALTER TABLE users DROP COLUMN email;",
+			wantAlter:  AlterDropColumn,
+			wantFields: []string{"email"},
+		},
+		{
+			input:       "ALTER TABLE users RENAME COLUMN email TO mail",
+			wantAlter:   AlterRenameColumn,
+			wantFields:  []string{"email"},
+			wantNewName: "mail",
+		},
+		{
+			input:       "ALTER TABLE users RENAME TO people",
+			wantAlter:   AlterRenameTable,
+			wantNewName: "people",
+		},
+		{input: "ALTER TABLE users", errText: "формат: ALTER TABLE"},
+		{input: "ALTER TABLE users RENAME email mail", errText: "ожидалось TO"},
+		{input: "ALTER TABLE users ADD COLUMN age NUMBER", errText: "неизвестный тип"},
+		{input: "ALTER TABLE users DROP COLUMN", errText: "DROP COLUMN <поле>"},
+		{input: "ALTER TABLE users RENAME TO select", errText: "RENAME TO <новое_имя>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("ParseQuery(%q) error = %v, want %q", tt.input, err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error = %v", tt.input, err)
+			}
+			if query.Type != QueryAlterTable || query.Table != "users" || query.Alter != tt.wantAlter {
+				t.Errorf("query = %+v", query)
+			}
+			if !reflect.DeepEqual(query.Fields, tt.wantFields) {
+				t.Errorf("Fields = %v, want %v", query.Fields, tt.wantFields)
+			}
+			if tt.wantAlter == AlterAddColumn && query.Columns[0] != tt.wantColumn {
+				t.Errorf("Columns[0] = %+v, want %+v", query.Columns[0], tt.wantColumn)
+			}
+			if query.NewName != tt.wantNewName {
+				t.Errorf("NewName = %q, want %q", query.NewName, tt.wantNewName)
+			}
+		})
+	}
+}
+
+func TestParseTableManagementQuery(t *testing.T) {
+	tests := []struct {
+		input        string
+		wantType     QueryType
+		wantTable    string
+		wantIfExists bool
+		expectError  bool
+	}{
+		{input: "DROP TABLE users", wantType: QueryDropTable, wantTable: "users"},
+		{input: "drop table if exists users;", wantType: QueryDropTable, wantTable: "users", wantIfExists: true},
+		{input: "TRUNCATE users", wantType: QueryTruncate, wantTable: "users"},
+		{input: "TRUNCATE TABLE users", wantType: QueryTruncate, wantTable: "users"},
+		{input: "SHOW TABLES", wantType: QueryShowTables},
+		{input: "DESCRIBE users", wantType: QueryDescribe, wantTable: "users"},
+		{input: "desc users", wantType: QueryDescribe, wantTable: "users"},
+		{input: "EXPLAIN SELECT * FROM users WHERE age > 3", wantType: QueryExplain, wantTable: "users"},
+		{input: "explain select users 1", wantType: QueryExplain, wantTable: "users"},
+		{input: "DROP TABLE", expectError: true},
+		{input: "DROP TABLE IF EXISTS", expectError: true},
+		{input: "SHOW TABLES users", expectError: true},
+		{input: "DESCRIBE users orders", expectError: true},
+		{input: "EXPLAIN DELETE users 1", expectError: true},
+		{input: "EXPLAIN SELECT", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			query, err := ParseQuery(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("ParseQuery(%q) expected error", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error = %v", tt.input, err)
+			}
+			if query.Type != tt.wantType || query.Table != tt.wantTable || query.IfExists != tt.wantIfExists {
+				t.Errorf("query = %+v", query)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Statement
+	}{
+		{
+			name:  "short SELECT",
+			input: "SELECT users 3",
+			want:  &SelectStmt{Table: "users", ID: 3},
+		},
+		{
+			name:  "short SELECT of all rows",
+			input: "SELECT users * WHERE age > 1",
+			want: &SelectStmt{Table: "users", ID: -1, Where: &BinaryExpr{
+				Op: OpGt, Left: &ColumnRef{Name: "age"}, Right: &Literal{Value: "1"},
+			}},
+		},
+		{
+			name:  "single column named like a table",
+			input: "SELECT users FROM users",
+			want:  &SelectStmt{Table: "users", ID: -1, Columns: []SelectColumn{{Name: "users"}}},
+		},
+		{
+			name:  "FROM in a string of the condition",
+			input: "SELECT name FROM users WHERE note = 'FROM'",
+			want: &SelectStmt{Table: "users", ID: -1, Columns: []SelectColumn{{Name: "name"}}, Where: &BinaryExpr{
+				Op: OpEq, Left: &ColumnRef{Name: "note"}, Right: &Literal{Value: "FROM"},
+			}},
+		},
+		{
+			name:  "aggregate list",
+			input: "SELECT COUNT(*) AS n FROM users",
+			want: &SelectStmt{Table: "users", ID: -1, Columns: []SelectColumn{
+				{Aggregate: &AggregateExpr{Func: COUNT}, Alias: "n"},
+			}},
+		},
+		{
+			name:  "EXPLAIN",
+			input: "EXPLAIN SELECT * FROM users",
+			want:  &ExplainStmt{Select: &SelectStmt{Table: "users", ID: -1}},
+		},
+		{
+			name:  "INSERT INTO",
+			input: "INSERT INTO users (name) VALUES ('a'), ('b')",
+			want:  &InsertStmt{Table: "users", Targets: []string{"name"}, Values: [][]string{{"a"}, {"b"}}},
+		},
+		{
+			name:  "UPDATE by id",
+			input: "UPDATE users 2 a,b",
+			want:  &UpdateStmt{Table: "users", ID: 2, Fields: []string{"a", "b"}},
+		},
+		{
+			name:  "DELETE WHERE",
+			input: "DELETE users WHERE id = 1",
+			want: &DeleteStmt{Table: "users", ID: -1, Where: &BinaryExpr{
+				Op: OpEq, Left: &ColumnRef{Name: "id"}, Right: &Literal{Value: "1"},
+			}},
+		},
+		{
+			name:  "ALTER TABLE RENAME COLUMN",
+			input: "ALTER TABLE users RENAME COLUMN name TO login",
+			want:  &AlterTableStmt{Table: "users", Kind: AlterRenameColumn, Name: "name", NewName: "login"},
+		},
+		{
+			name:  "DROP TABLE IF EXISTS",
+			input: "DROP TABLE IF EXISTS users;",
+			want:  &DropTableStmt{Table: "users", IfExists: true},
+		},
+		{
+			name:  "COMMIT",
+			input: "COMMIT WORK",
+			want:  &CommitStmt{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "single", input: "SELECT * FROM t", want: []string{"SELECT * FROM t"}},
+		{name: "trailing semicolon", input: "BEGIN;", want: []string{"BEGIN"}},
+		{
+			name:  "semicolons in strings and comments",
+			input: "INSERT INTO t (a) VALUES ('x;y'); /* ; */ SELECT * FROM t -- ;",
+			want:  []string{"INSERT INTO t (a) VALUES ('x;y')", "SELECT * FROM t"},
+		},
+		{name: "only separators", input: " ; ;-- comment", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitStatements(tt.input)
+			if err != nil {
+				t.Fatalf("SplitStatements() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SplitStatements("SELECT 'open"); err == nil {
+		t.Error("SplitStatements() expected error for an unclosed string")
+	}
+}
+
+func TestParseQueryParams(t *testing.T) {
+	query, err := ParseQueryParams("INSERT INTO t (a, b, c, d) VALUES (?, ?, ?, ?)", []Param{
+		TextParam("x'), ('y"),
+		{Kind: ParamNumber, Value: "-1.5"},
+		{Kind: ParamBool, Value: "true"},
+		{Kind: ParamNull},
+	})
+	if err != nil {
+		t.Fatalf("ParseQueryParams() error = %v", err)
+	}
+	if want := [][]string{{"x'), ('y", "-1.5", "true", ""}}; !reflect.DeepEqual(query.Values, want) {
+		t.Errorf("Values = %q, want %q", query.Values, want)
+	}
+
+	query, err = ParseQueryParams("SELECT * FROM t WHERE a = $2 OR b = '$1' LIMIT $1", []Param{
+		{Kind: ParamNumber, Value: "5"},
+		TextParam("1 OR 1=1"),
+	})
+	if err != nil {
+		t.Fatalf("ParseQueryParams() error = %v", err)
+	}
+	if query.Limit != 5 || query.Where.String() != "(a = '1 OR 1=1' OR b = '$1')" {
+		t.Errorf("query = limit %d, where %s", query.Limit, query.Where)
+	}
+
+	query, err = ParseQueryParams("INSERT t ?, ?, ?", []Param{TextParam("a, b"), {Kind: ParamNumber, Value: "7"}, {Kind: ParamNull}})
+	if err != nil {
+		t.Fatalf("ParseQueryParams() error = %v", err)
+	}
+	if want := []string{"a, b", "7", ""}; !reflect.DeepEqual(query.Fields, want) {
+		t.Errorf("Fields = %q, want %q", query.Fields, want)
+	}
+
+	query, err = ParseQueryParams("UPDATE t SET a = ?, b = ? WHERE c = ? OR d IN (?, 1)", []Param{
+		{Kind: ParamNull},
+		{Kind: ParamBool, Value: "TRUE"},
+		{Kind: ParamNull},
+		{Kind: ParamBool, Value: "false"},
+	})
+	if err != nil {
+		t.Fatalf("ParseQueryParams() error = %v", err)
+	}
+	if want := []Assignment{{Column: "a", Value: ""}, {Column: "b", Value: "true"}}; !reflect.DeepEqual(query.Set, want) {
+		t.Errorf("Set = %q, want %q", query.Set, want)
+	}
+	if got := query.Where.String(); got != "(c = '' OR d IN (false, 1))" {
+		t.Errorf("Where = %s", got)
+	}
+	if _, err := ParseQueryParams("SELECT ? FROM t", []Param{{Kind: ParamNull}}); err == nil {
+		t.Error("ParseQueryParams() expected error for a NULL param in place of a column")
+	}
+
+	errTests := []struct {
+		name    string
+		input   string
+		params  []Param
+		errText string
+	}{
+		{name: "missing", input: "SELECT * FROM t WHERE a = $3", params: []Param{TextParam("1")}, errText: "$3"},
+		{name: "too few", input: "SELECT * FROM t WHERE a = ? AND b = ?", params: []Param{TextParam("1")}, errText: "?"},
+		{name: "too many", input: "SELECT * FROM t WHERE a = ?", params: []Param{TextParam("1"), TextParam("2")}, errText: "передано параметров: 2"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseQueryParams(tt.input, tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("ParseQueryParams() error = %v, want %q", err, tt.errText)
+			}
+		})
+	}
+
+	if n, err := CountParams("SELECT * FROM t WHERE a = ? OR b = ? OR c = '?'"); err != nil || n != 2 {
+		t.Errorf("CountParams() = %d, %v, want 2", n, err)
+	}
+	if n, err := CountParams("SELECT * FROM t WHERE a = $1 OR b = $3"); err != nil || n != 3 {
+		t.Errorf("CountParams() = %d, %v, want 3", n, err)
+	}
+}
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SplitStatements splits input on semicolons outside of strings and
+// comments. Empty statements are dropped.
+func SplitStatements(input string) ([]string, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	source := []rune(input)
+	var statements []string
+	first, last := -1, -1
+	for _, tok := range tokens {
+		switch {
+		case tok.kind != tokSemicolon && tok.kind != tokEOF:
+			if first == -1 {
+				first = tok.start
+			}
+			last = tok.end
+		case first != -1:
+			statements = append(statements, string(source[first:last]))
+			first = -1
+		}
+	}
+	return statements, nil
+}
+
+type ParamKind int
+
+const (
+	ParamText ParamKind = iota
+	ParamNumber
+	ParamBool
+	ParamNull
+)
+
+// Param is a value bound to a placeholder of a statement.
+type Param struct {
+	Kind  ParamKind
+	Value string
+}
+
+func TextParam(value string) Param {
+	return Param{Kind: ParamText, Value: value}
+}
+
+// ParseQueryParams parses a statement with placeholders: each ? takes the
+// next param, $n takes param n. A param replaces its placeholder as a
+// single token after lexing, so no value can change the statement.
+func ParseQueryParams(input string, params []Param) (*Query, error) {
+	p, err := newQueryParser(input)
+	if err != nil {
+		return nil, err
+	}
+
+	next := 0
+	for i, tok := range p.tokens {
+		n, ok := placeholder(tok, &next)
+		if !ok {
+			continue
+		}
+		if n > len(params) {
+			return nil, errorAt(tok, fmt.Errorf("не передано значение параметра %s", tok.value))
+		}
+		p.tokens[i] = paramToken(tok, params[n-1])
+	}
+	if next > 0 && next != len(params) {
+		return nil, fmt.Errorf("передано параметров: %d, ожидалось: %d", len(params), next)
+	}
+
+	p.dropTrailingSemicolon()
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, errorAt(p.peek(), err)
+	}
+	return stmt.Query(), nil
+}
+
+func paramToken(tok token, param Param) token {
+	switch param.Kind {
+	case ParamNumber:
+		if isNumber(param.Value) {
+			tok.kind, tok.value = tokNumber, param.Value
+			return tok
+		}
+	case ParamBool:
+		tok.kind, tok.value = tokBool, strings.ToLower(param.Value)
+		return tok
+	case ParamNull:
+		tok.kind, tok.value = tokNull, "NULL"
+		return tok
+	}
+	tok.kind, tok.value = tokString, param.Value
+	return tok
+}
+
+// CountParams returns how many params the placeholders of input take.
+func CountParams(input string) (int, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return 0, err
+	}
+	count, next := 0, 0
+	for _, tok := range tokens {
+		if n, ok := placeholder(tok, &next); ok && n > count {
+			count = n
+		}
+	}
+	return count, nil
+}
+
+// placeholder returns the param number of a ? or $n token; next counts
+// the ? placeholders seen so far.
+func placeholder(tok token, next *int) (int, bool) {
+	if tok.kind != tokIdent {
+		return 0, false
+	}
+	if tok.value == "?" {
+		*next++
+		return *next, true
+	}
+	if !strings.HasPrefix(tok.value, "$") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(tok.value[1:])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"math/rand"
+)
+
+const skipListMaxLevel = 16
+
+type skipNode struct {
+	key  string
+	ids  map[int]bool
+	next []*skipNode
+}
+
+type skipList struct {
+	head    *skipNode
+	level   int
+	compare func(a, b string) int
+}
+
+func newSkipList(compare func(a, b string) int) *skipList {
+	return &skipList{
+		head:    &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level:   1,
+		compare: compare,
+	}
+}
+
+// path returns, for every level, the last node whose key is less than key.
+func (s *skipList) path(key string) []*skipNode {
+	update := make([]*skipNode, skipListMaxLevel)
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && s.compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	return update
+}
+
+func (s *skipList) insert(key string, id int) {
+	update := s.path(key)
+	if next := update[0].next[0]; next != nil && s.compare(next.key, key) == 0 {
+		next.ids[id] = true
+		return
+	}
+
+	level := 1
+	for level < skipListMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	node := &skipNode{key: key, ids: map[int]bool{id: true}, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (s *skipList) remove(key string, id int) {
+	update := s.path(key)
+	target := update[0].next[0]
+	if target == nil || s.compare(target.key, key) != 0 {
+		return
+	}
+	delete(target.ids, id)
+	if len(target.ids) > 0 {
+		return
+	}
+
+	for i := 0; i < s.level; i++ {
+		if update[i].next[i] == target {
+			update[i].next[i] = target.next[i]
+		}
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+}
+
+func (s *skipList) get(key string) []int {
+	node := s.path(key)[0].next[0]
+	if node == nil || s.compare(node.key, key) != 0 {
+		return nil
+	}
+	return appendIDs(nil, node.ids)
+}
+
+func (s *skipList) scan(lower, upper *Bound) []int {
+	node := s.head.next[0]
+	if lower != nil {
+		node = s.path(lower.Value)[0].next[0]
+		if node != nil && !lower.Inclusive && s.compare(node.key, lower.Value) == 0 {
+			node = node.next[0]
+		}
+	}
+
+	var ids []int
+	for ; node != nil; node = node.next[0] {
+		if upper != nil {
+			cmp := s.compare(node.key, upper.Value)
+			if cmp > 0 || (cmp == 0 && !upper.Inclusive) {
+				break
+			}
+		}
+		ids = appendIDs(ids, node.ids)
+	}
+	return ids
+}
+
+func appendIDs(ids []int, set map[int]bool) []int {
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return ids
+}
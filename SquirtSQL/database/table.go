@@ -2,23 +2,142 @@ package database
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	ErrTableNotFound  = errors.New("таблица не найдена")
 	ErrRecordNotFound = errors.New("запись не найдена")
 	ErrMissFieldCount = errors.New("несоответствие количества полей")
+	ErrUnknownField   = errors.New("неизвестное поле")
+	ErrNotNull        = errors.New("нарушено ограничение NOT NULL")
+	ErrUnique         = errors.New("нарушено ограничение UNIQUE")
+	ErrCheck          = errors.New("нарушено ограничение CHECK")
 )
 
 func NewTable(name string, field []string) *Table {
-	return &Table{
+	return NewTableFromColumns(name, TextColumns(field))
+}
+
+func NewTableFromColumns(name string, columns []Column) *Table {
+	table := &Table{
 		Name:    name,
-		Fields:  field,
+		Fields:  make([]string, len(columns)),
+		Columns: make(map[string]*Column, len(columns)),
 		Records: make(map[int]Record),
+		Indexes: make(map[string]*Index),
 		NextID:  1,
 	}
+	for i, column := range columns {
+		column := column
+		table.Fields[i] = column.Name
+		table.Columns[column.Name] = &column
+	}
+	return table
 }
 
 func (t *Table) ValidateFields(field []string) bool {
 	return len(field) == len(t.Fields)
 }
+
+func (t *Table) HasField(name string) bool {
+	if name == "id" {
+		return true
+	}
+	for _, field := range t.Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) Column(name string) Column {
+	if name == "id" {
+		return Column{Name: name, Type: TypeInt}
+	}
+	if column, ok := t.Columns[name]; ok {
+		return *column
+	}
+	return Column{Name: name, Type: TypeText}
+}
+
+func (t *Table) Schema() []Column {
+	columns := make([]Column, len(t.Fields))
+	for i, field := range t.Fields {
+		columns[i] = t.Column(field)
+	}
+	return columns
+}
+
+func (t *Table) NormalizeValue(field, value string) (string, error) {
+	if !t.HasField(field) {
+		return "", fmt.Errorf("%w %s", ErrUnknownField, field)
+	}
+	normalized, err := t.Column(field).Type.Normalize(value)
+	if err != nil {
+		return "", fmt.Errorf("поле %s: %w", field, err)
+	}
+	return normalized, nil
+}
+
+// Clone returns a deep copy of the table with its indexes rebuilt.
+func (t *Table) Clone() *Table {
+	clone := NewTableFromColumns(t.Name, t.Schema())
+	for id, record := range t.Records {
+		copied := make(Record, len(record))
+		for field, value := range record {
+			copied[field] = value
+		}
+		clone.Records[id] = copied
+	}
+	for _, index := range t.IndexList() {
+		clone.AddIndex(NewIndex(index.Name, index.Column, index.Kind, index.Type))
+	}
+	clone.NextID = t.NextID
+	clone.Version = t.Version
+	return clone
+}
+
+// Migrate returns a copy of the table with the given columns. Values move
+// with their column, following renamed (old name to new name); added
+// columns get their default, and columns missing from the list are dropped
+// together with their indexes.
+func (t *Table) Migrate(columns []Column, renamed map[string]string) *Table {
+	migrated := NewTableFromColumns(t.Name, columns)
+	source := make(map[string]string, len(t.Fields))
+	for _, field := range t.Fields {
+		if target, ok := renamed[field]; ok {
+			source[target] = field
+		} else {
+			source[field] = field
+		}
+	}
+
+	for id, record := range t.Records {
+		moved := make(Record, len(columns))
+		for _, column := range columns {
+			if field, ok := source[column.Name]; ok {
+				moved[column.Name] = record[field]
+			} else if column.HasDefault {
+				moved[column.Name] = column.Default
+			} else {
+				moved[column.Name] = ""
+			}
+		}
+		migrated.Records[id] = moved
+	}
+	for _, index := range t.IndexList() {
+		column := index.Column
+		if target, ok := renamed[column]; ok {
+			column = target
+		}
+		if !migrated.HasField(column) {
+			continue
+		}
+		migrated.AddIndex(NewIndex(index.Name, column, index.Kind, migrated.Column(column).Type))
+	}
+	migrated.NextID = t.NextID
+	migrated.Version = t.Version + 1
+	return migrated
+}
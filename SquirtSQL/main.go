@@ -1,8 +1,142 @@
 package main
 
-import "v4/app"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"v4/app"
+	"v4/database/actions"
+	"v4/rest"
+	"v4/server"
+	"v4/storage"
+)
 
 func main() {
-	cli := app.NewApp()
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		var err error
+		switch os.Args[1] {
+		case "serve":
+			err = serve(os.Args[2:])
+		case "http":
+			err = serveHTTP(os.Args[2:])
+		default:
+			fmt.Printf("Error: неизвестный режим %s, доступны serve и http\n", os.Args[1])
+			os.Exit(2)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	openStorage := storageFlags(flag.CommandLine)
+	flag.Parse()
+	stor, err := openStorage()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	cli := app.NewApp(stor)
 	cli.Run()
 }
+
+// storageFlags adds the -engine, -data and -compact flags to flags. The
+// returned function opens the storage they select once the flags are
+// parsed.
+func storageFlags(flags *flag.FlagSet) func() (*storage.Storage, error) {
+	engine := flags.String("engine", storage.EngineCSV, fmt.Sprintf("движок хранения: %s", strings.Join(storage.Engines, ", ")))
+	dir := flags.String("data", "data", "каталог с данными")
+	compact := flags.Int64("compact", storage.DefaultCompactThreshold, "размер мёртвых записей журнала таблицы в байтах, после которого движок log сжимает его")
+	return func() (*storage.Storage, error) {
+		return storage.Open(*engine, *dir, *compact)
+	}
+}
+
+// service is a network server that listen runs.
+type service interface {
+	Serve(listener net.Listener) error
+	Close() error
+}
+
+// serve runs the PostgreSQL protocol server.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:5432", "адрес для подключений")
+	openStorage := storageFlags(flags)
+	_ = flags.Parse(args)
+
+	return listen(openStorage, *addr, func(db *actions.Database) service {
+		return server.New(db)
+	})
+}
+
+// serveHTTP runs the REST API.
+func serveHTTP(args []string) error {
+	flags := flag.NewFlagSet("http", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "адрес для подключений")
+	openStorage := storageFlags(flags)
+	_ = flags.Parse(args)
+
+	return listen(openStorage, *addr, func(db *actions.Database) service {
+		return httpService{&http.Server{Handler: rest.New(db)}}
+	})
+}
+
+// httpService lets running requests finish on Close.
+type httpService struct {
+	*http.Server
+}
+
+func (s httpService) Close() error {
+	return s.Shutdown(context.Background())
+}
+
+// listen loads the database and serves it on addr until SIGINT or
+// SIGTERM, then writes a final checkpoint.
+func listen(openStorage func() (*storage.Storage, error), addr string, newService func(*actions.Database) service) error {
+	stor, err := openStorage()
+	if err != nil {
+		return err
+	}
+	db := actions.NewDatabase(stor)
+	if err := db.LoadTables(); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := newService(db)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Println("Остановка сервера")
+		_ = srv.Close()
+	}()
+
+	fmt.Printf("SquirtSQL принимает подключения на %s\n", listener.Addr())
+	err = srv.Serve(listener)
+	if errors.Is(err, server.ErrServerClosed) || errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	// Close waits for the clients to finish before the final checkpoint.
+	_ = srv.Close()
+	if cpErr := db.Checkpoint(); cpErr != nil && err == nil {
+		err = cpErr
+	}
+	if closeErr := stor.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
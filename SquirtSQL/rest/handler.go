@@ -0,0 +1,422 @@
+// Package rest exposes the tables of a database as a JSON HTTP API.
+//
+//	GET    /tables                      table names
+//	POST   /tables                      create a table
+//	GET    /tables/{t}/rows             rows, filtered by ?where=, ?limit=, ?offset=
+//	POST   /tables/{t}/rows             insert a row
+//	GET    /tables/{t}/rows/{id}        one row
+//	PUT    /tables/{t}/rows/{id}        replace a row, missing fields become NULL
+//	PATCH  /tables/{t}/rows/{id}        change the given fields of a row
+//	DELETE /tables/{t}/rows/{id}        delete a row
+//	POST   /query                       run SQL statements
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"v4/database"
+	"v4/database/actions"
+	"v4/database/parser"
+)
+
+type Handler struct {
+	DB *actions.Database
+	// ErrorLog receives errors that cannot be sent to a client. Nil means
+	// the standard logger.
+	ErrorLog *log.Logger
+
+	mux *http.ServeMux
+}
+
+func New(db *actions.Database) *Handler {
+	h := &Handler{DB: db, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /tables", h.listTables)
+	h.mux.HandleFunc("POST /tables", h.createTable)
+	h.mux.HandleFunc("GET /tables/{table}/rows", h.listRows)
+	h.mux.HandleFunc("POST /tables/{table}/rows", h.insertRow)
+	h.mux.HandleFunc("GET /tables/{table}/rows/{id}", h.getRow)
+	h.mux.HandleFunc("PUT /tables/{table}/rows/{id}", h.replaceRow)
+	h.mux.HandleFunc("PATCH /tables/{table}/rows/{id}", h.patchRow)
+	h.mux.HandleFunc("DELETE /tables/{table}/rows/{id}", h.deleteRow)
+	h.mux.HandleFunc("POST /query", h.query)
+	return h
+}
+
+// ServeHTTP routes the request and checkpoints the database after
+// requests that may have changed it.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+	if r.Method == http.MethodGet {
+		return
+	}
+	if err := h.DB.MaybeCheckpoint(); err != nil {
+		h.logf("ошибка контрольной точки: %v", err)
+	}
+}
+
+func (h *Handler) listTables(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.DB.TableNames())
+}
+
+type columnJSON struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type,omitempty"`
+	NotNull bool    `json:"not_null,omitempty"`
+	Unique  bool    `json:"unique,omitempty"`
+	Default *string `json:"default,omitempty"`
+	Check   string  `json:"check,omitempty"`
+}
+
+type tableJSON struct {
+	Name    string       `json:"name"`
+	Columns []columnJSON `json:"columns"`
+}
+
+func (h *Handler) createTable(w http.ResponseWriter, r *http.Request) {
+	var body tableJSON
+	if err := readJSON(r, &body); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if body.Name == "" || len(body.Columns) == 0 {
+		h.writeError(w, badRequest("нужны имя таблицы и хотя бы одно поле"))
+		return
+	}
+	if _, err := h.DB.Describe(body.Name); err == nil {
+		h.writeError(w, &httpError{status: http.StatusConflict, err: fmt.Errorf("таблица %s уже существует", body.Name)})
+		return
+	}
+
+	columns := make([]database.Column, len(body.Columns))
+	for i, c := range body.Columns {
+		column := database.Column{Name: c.Name, Type: database.TypeText, NotNull: c.NotNull, Unique: c.Unique, Check: c.Check}
+		if c.Type != "" {
+			columnType, err := database.ParseColumnType(c.Type)
+			if err != nil {
+				h.writeError(w, badRequest(err.Error()))
+				return
+			}
+			column.Type = columnType
+		}
+		if c.Default != nil {
+			value, err := column.Type.Normalize(*c.Default)
+			if err != nil {
+				h.writeError(w, fmt.Errorf("DEFAULT поля %s: %w", c.Name, err))
+				return
+			}
+			column.HasDefault, column.Default = true, value
+		}
+		columns[i] = column
+	}
+	if err := h.DB.CreateTableSchema(body.Name, columns); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/tables/"+body.Name+"/rows")
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func (h *Handler) listRows(w http.ResponseWriter, r *http.Request) {
+	query := &parser.Query{Type: parser.QuerySelect, Table: r.PathValue("table"), ID: -1}
+	params := r.URL.Query()
+	if where := params.Get("where"); where != "" {
+		expr, err := parser.ParseExpr(where)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		query.Where = expr
+	}
+	for name, target := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.writeError(w, badRequest(fmt.Sprintf("неверное значение %s: %q", name, value)))
+			return
+		}
+		*target = n
+	}
+
+	result, err := h.DB.SelectQuery(query)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rowsOf(withID(result)))
+}
+
+func (h *Handler) getRow(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.rowID(w, r)
+	if !ok {
+		return
+	}
+	h.writeRow(w, http.StatusOK, r.PathValue("table"), id)
+}
+
+// writeRow responds with the current state of a row.
+func (h *Handler) writeRow(w http.ResponseWriter, status int, table string, id int) {
+	result, err := h.DB.SelectQuery(&parser.Query{Type: parser.QuerySelect, Table: table, ID: id})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	writeJSON(w, status, rowsOf(withID(result))[0])
+}
+
+func (h *Handler) insertRow(w http.ResponseWriter, r *http.Request) {
+	table := r.PathValue("table")
+	values, err := readRow(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	fields := make([]string, 0, len(values))
+	row := make([]string, 0, len(values))
+	for field, value := range values {
+		fields = append(fields, field)
+		row = append(row, value)
+	}
+	ids, err := h.DB.InsertRows(table, fields, [][]string{row})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/tables/%s/rows/%d", table, ids[0]))
+	h.writeRow(w, http.StatusCreated, table, ids[0])
+}
+
+func (h *Handler) replaceRow(w http.ResponseWriter, r *http.Request) {
+	table := r.PathValue("table")
+	id, ok := h.rowID(w, r)
+	if !ok {
+		return
+	}
+	values, err := readRow(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	info, err := h.DB.Describe(table)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	row := make([]string, len(info.Columns))
+	for i, column := range info.Columns {
+		row[i] = values[column.Name]
+		delete(values, column.Name)
+	}
+	for field := range values {
+		h.writeError(w, fmt.Errorf("%w %s", database.ErrUnknownField, field))
+		return
+	}
+	if err := h.DB.Update(table, id, row); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeRow(w, http.StatusOK, table, id)
+}
+
+func (h *Handler) patchRow(w http.ResponseWriter, r *http.Request) {
+	table := r.PathValue("table")
+	id, ok := h.rowID(w, r)
+	if !ok {
+		return
+	}
+	values, err := readRow(r)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if len(values) == 0 {
+		h.writeRow(w, http.StatusOK, table, id)
+		return
+	}
+
+	set := make([]parser.Assignment, 0, len(values))
+	for field, value := range values {
+		set = append(set, parser.Assignment{Column: field, Value: value})
+	}
+	count, err := h.DB.UpdateWhere(table, set, idEquals(id))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if count == 0 {
+		h.writeError(w, database.ErrRecordNotFound)
+		return
+	}
+	h.writeRow(w, http.StatusOK, table, id)
+}
+
+func (h *Handler) deleteRow(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.rowID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.DB.Delete(r.PathValue("table"), id); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) rowID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		h.writeError(w, &httpError{status: http.StatusNotFound, err: database.ErrRecordNotFound})
+		return 0, false
+	}
+	return id, true
+}
+
+func idEquals(id int) parser.Expr {
+	return &parser.BinaryExpr{
+		Op:    parser.OpEq,
+		Left:  &parser.ColumnRef{Name: "id"},
+		Right: &parser.Literal{Value: strconv.Itoa(id)},
+	}
+}
+
+type queryJSON struct {
+	SQL    string `json:"sql"`
+	Params []any  `json:"params"`
+}
+
+type resultJSON struct {
+	Command  string       `json:"command"`
+	Affected int          `json:"affected"`
+	Columns  []string     `json:"columns,omitempty"`
+	Rows     []orderedRow `json:"rows,omitempty"`
+}
+
+// query runs the statements of the request in one session, so a request
+// may hold a whole transaction. An unfinished one is rolled back.
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	var body queryJSON
+	if err := readJSON(r, &body); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	statements, err := parser.SplitStatements(body.SQL)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if len(statements) == 0 {
+		h.writeError(w, badRequest("пустой запрос"))
+		return
+	}
+	if len(body.Params) > 0 && len(statements) > 1 {
+		h.writeError(w, badRequest("параметры можно передать только с одним оператором"))
+		return
+	}
+	params, err := toParams(body.Params)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	session := h.DB.NewSession()
+	defer session.Close()
+
+	results := make([]resultJSON, 0, len(statements))
+	for _, statement := range statements {
+		query, err := parser.ParseQueryParams(statement, params)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		result, err := session.Execute(query)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		results = append(results, resultJSON{
+			Command:  result.Command,
+			Affected: result.Affected,
+			Columns:  result.Columns,
+			Rows:     rowsOf(result),
+		})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// httpError carries the status for errors that the mapping in statusOf
+// does not know.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func badRequest(message string) error {
+	return &httpError{status: http.StatusBadRequest, err: errors.New(message)}
+}
+
+func statusOf(err error) int {
+	var httpErr *httpError
+	var syntaxErr *parser.SyntaxError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.status
+	case errors.Is(err, database.ErrTableNotFound), errors.Is(err, database.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrUnique), errors.Is(err, actions.ErrTxConflict):
+		return http.StatusConflict
+	case errors.As(err, &syntaxErr),
+		errors.Is(err, database.ErrUnknownField),
+		errors.Is(err, database.ErrMissFieldCount),
+		errors.Is(err, database.ErrTypeMismatch),
+		errors.Is(err, database.ErrNotNull),
+		errors.Is(err, database.ErrCheck),
+		errors.Is(err, actions.ErrNoTransaction),
+		errors.Is(err, actions.ErrNestedTransaction),
+		errors.Is(err, actions.ErrUnsupportedQuery):
+		return http.StatusBadRequest
+	}
+	// Other errors of the database reject the request itself, such as a
+	// bad schema, unless the disk failed.
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	if errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		h.logf("ошибка запроса: %v", err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (h *Handler) logf(format string, args ...any) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,151 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"v4/database/actions"
+	"v4/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	stor := storage.NewCSVStorage(t.TempDir())
+	t.Cleanup(func() { _ = stor.Close() })
+	h := New(actions.NewDatabase(stor))
+	h.ErrorLog = log.New(io.Discard, "", 0)
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// call sends a request and returns the status and the raw body.
+func call(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, strings.TrimSpace(string(data))
+}
+
+func TestTablesAndRows(t *testing.T) {
+	srv := newTestServer(t)
+
+	status, _ := call(t, srv, "POST", "/tables", `{"name": "users", "columns": [
+		{"name": "name", "type": "TEXT", "not_null": true, "unique": true},
+		{"name": "age", "type": "INT"},
+		{"name": "active", "type": "BOOL", "default": "true"}]}`)
+	require.Equal(t, http.StatusCreated, status)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"duplicate table", "POST", "/tables", `{"name": "users", "columns": [{"name": "x"}]}`, http.StatusConflict, ""},
+		{"bad column type", "POST", "/tables", `{"name": "t", "columns": [{"name": "x", "type": "BLOB"}]}`, http.StatusBadRequest, ""},
+		{"list tables", "GET", "/tables", "", http.StatusOK, `["users"]`},
+		{"insert", "POST", "/tables/users/rows", `{"name": "Ann", "age": 30}`, http.StatusCreated,
+			`{"id":1,"name":"Ann","age":30,"active":true}`},
+		{"insert null", "POST", "/tables/users/rows", `{"name": "Bob", "age": null}`, http.StatusCreated,
+			`{"id":2,"name":"Bob","age":null,"active":true}`},
+		{"insert duplicate", "POST", "/tables/users/rows", `{"name": "Ann"}`, http.StatusConflict, ""},
+		{"insert type mismatch", "POST", "/tables/users/rows", `{"name": "Eve", "age": "old"}`, http.StatusBadRequest, ""},
+		{"insert unknown field", "POST", "/tables/users/rows", `{"name": "Eve", "email": "e@x"}`, http.StatusBadRequest, ""},
+		{"insert into missing table", "POST", "/tables/missing/rows", `{"name": "Eve"}`, http.StatusNotFound, ""},
+		{"get", "GET", "/tables/users/rows/1", "", http.StatusOK, `{"id":1,"name":"Ann","age":30,"active":true}`},
+		{"get missing row", "GET", "/tables/users/rows/9", "", http.StatusNotFound, ""},
+		{"get bad id", "GET", "/tables/users/rows/x", "", http.StatusNotFound, ""},
+		{"filter", "GET", "/tables/users/rows?where=age%20%3E%2020", "", http.StatusOK,
+			`[{"id":1,"name":"Ann","age":30,"active":true}]`},
+		{"limit and offset", "GET", "/tables/users/rows?limit=1&offset=1", "", http.StatusOK,
+			`[{"id":2,"name":"Bob","age":null,"active":true}]`},
+		{"bad where", "GET", "/tables/users/rows?where=age%20%3E", "", http.StatusBadRequest, ""},
+		{"bad limit", "GET", "/tables/users/rows?limit=-1", "", http.StatusBadRequest, ""},
+		{"rows of missing table", "GET", "/tables/missing/rows", "", http.StatusNotFound, ""},
+		{"patch", "PATCH", "/tables/users/rows/1", `{"age": 31}`, http.StatusOK,
+			`{"id":1,"name":"Ann","age":31,"active":true}`},
+		{"patch missing row", "PATCH", "/tables/users/rows/9", `{"age": 31}`, http.StatusNotFound, ""},
+		{"put", "PUT", "/tables/users/rows/2", `{"name": "Bob", "active": false}`, http.StatusOK,
+			`{"id":2,"name":"Bob","age":null,"active":false}`},
+		{"put not null", "PUT", "/tables/users/rows/2", `{"age": 5}`, http.StatusBadRequest, ""},
+		{"delete", "DELETE", "/tables/users/rows/2", "", http.StatusNoContent, ""},
+		{"delete again", "DELETE", "/tables/users/rows/2", "", http.StatusNotFound, ""},
+		{"bad JSON", "POST", "/tables/users/rows", `{"name": `, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := call(t, srv, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.wantStatus, status, body)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, body)
+			}
+			if status >= 400 {
+				assert.Contains(t, body, `"error"`)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	srv := newTestServer(t)
+
+	status, body := call(t, srv, "POST", "/query", `{"sql":
+		"CREATE TABLE items name TEXT, price FLOAT; INSERT INTO items (name, price) VALUES ('pen', 1.5)"}`)
+	require.Equal(t, http.StatusOK, status, body)
+
+	status, body = call(t, srv, "POST", "/query",
+		`{"sql": "SELECT name, price FROM items WHERE price > $1 AND name = $2", "params": [1, "pen"]}`)
+	require.Equal(t, http.StatusOK, status, body)
+	var results []struct {
+		Command  string           `json:"command"`
+		Affected int              `json:"affected"`
+		Columns  []string         `json:"columns"`
+		Rows     []map[string]any `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "SELECT", results[0].Command)
+	assert.Equal(t, []string{"name", "price"}, results[0].Columns)
+	assert.Equal(t, []map[string]any{{"name": "pen", "price": 1.5}}, results[0].Rows)
+
+	// An unfinished transaction is rolled back at the end of the request.
+	status, body = call(t, srv, "POST", "/query", `{"sql": "BEGIN; DELETE items WHERE name = 'pen'"}`)
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = call(t, srv, "GET", "/tables/items/rows", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `[{"id":1,"name":"pen","price":1.5}]`, body)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"syntax error", `{"sql": "SELEKT 1"}`, http.StatusBadRequest},
+		{"empty", `{"sql": " ; "}`, http.StatusBadRequest},
+		{"missing table", `{"sql": "SELECT * FROM missing"}`, http.StatusNotFound},
+		{"missing param", `{"sql": "SELECT * FROM items WHERE name = ?"}`, http.StatusBadRequest},
+		{"params with many statements", `{"sql": "SELECT * FROM items; SELECT * FROM items", "params": [1]}`, http.StatusBadRequest},
+		{"commit without begin", `{"sql": "COMMIT"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := call(t, srv, "POST", "/query", tt.body)
+			assert.Equal(t, tt.wantStatus, status, body)
+		})
+	}
+}
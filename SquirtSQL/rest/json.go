@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"v4/database"
+	"v4/database/actions"
+	"v4/database/parser"
+)
+
+const maxBodySize = 1 << 20
+
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest(fmt.Sprintf("неверный JSON: %v", err))
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// readRow reads a JSON object of field values. Values are kept as the
+// text the database stores; null becomes NULL.
+func readRow(r *http.Request) (map[string]string, error) {
+	var body map[string]any
+	if err := readJSON(r, &body); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string, len(body))
+	for field, value := range body {
+		text, err := valueText(value)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("поле %s: %v", field, err))
+		}
+		row[field] = text
+	}
+	return row, nil
+}
+
+func valueText(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("неподдерживаемое значение %v", value)
+}
+
+func toParams(values []any) ([]parser.Param, error) {
+	params := make([]parser.Param, len(values))
+	for i, value := range values {
+		text, err := valueText(value)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("параметр %d: %v", i+1, err))
+		}
+		switch value.(type) {
+		case nil:
+			params[i] = parser.Param{Kind: parser.ParamNull}
+		case json.Number:
+			params[i] = parser.Param{Kind: parser.ParamNumber, Value: text}
+		case bool:
+			params[i] = parser.Param{Kind: parser.ParamBool, Value: text}
+		default:
+			params[i] = parser.TextParam(text)
+		}
+	}
+	return params, nil
+}
+
+// orderedRow is a row as a JSON object whose keys keep the column order.
+type orderedRow struct {
+	columns []string
+	types   []database.ColumnType
+	values  []string
+}
+
+func rowsOf(result *actions.Result) []orderedRow {
+	rows := make([]orderedRow, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = orderedRow{columns: result.Columns, types: result.Types, values: row.Values}
+	}
+	return rows
+}
+
+// withID puts the id of each row in front of its fields, as the row
+// endpoints return whole records.
+func withID(result *actions.Result) *actions.Result {
+	rows := make([]actions.ResultRow, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = actions.ResultRow{ID: row.ID, Values: append([]string{strconv.Itoa(row.ID)}, row.Values...)}
+	}
+	return &actions.Result{
+		Columns: append([]string{"id"}, result.Columns...),
+		Types:   append([]database.ColumnType{database.TypeInt}, result.Types...),
+		Rows:    rows,
+	}
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range r.columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(column)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		columnType := database.TypeText
+		if i < len(r.types) {
+			columnType = r.types[i]
+		}
+		value, err := jsonValue(columnType, r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonValue writes numbers and booleans as JSON literals, NULL as null
+// and everything else as a string.
+func jsonValue(t database.ColumnType, value string) ([]byte, error) {
+	switch {
+	case value == "":
+		return []byte("null"), nil
+	case t == database.TypeInt || t == database.TypeFloat || t == database.TypeBool:
+		if json.Valid([]byte(value)) {
+			return []byte(value), nil
+		}
+	}
+	return json.Marshal(value)
+}
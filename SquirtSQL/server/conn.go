@@ -0,0 +1,638 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"v4/database"
+	"v4/database/actions"
+	"v4/database/parser"
+)
+
+var errTerminate = errors.New("соединение закрыто клиентом")
+
+// pgError is an error with its own SQLSTATE code.
+type pgError struct {
+	code    string
+	message string
+}
+
+func (e *pgError) Error() string {
+	return e.message
+}
+
+func newError(code, format string, args ...any) error {
+	return &pgError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// statement is a query prepared by Parse. probe is the query parsed with
+// empty parameters and tells its type before any values are bound.
+type statement struct {
+	query      string
+	paramTypes []int32
+	probe      *parser.Query
+}
+
+// portal is a statement bound to parameters by Bind. The rows of a query
+// are read from its result stream as Execute sends them, so a portal
+// suspended by the row limit has read no further than it sent.
+type portal struct {
+	query   *parser.Query
+	formats []int16
+	result  *actions.Result
+	// ahead is the row read past the limit to learn that rows are left.
+	ahead *actions.ResultRow
+}
+
+func (p *portal) close() {
+	if p.result != nil && p.result.Stream != nil {
+		p.result.Stream.Close()
+	}
+}
+
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	session *actions.Session
+	pid     int32
+	secret  int32
+
+	statements map[string]*statement
+	portals    map[string]*portal
+	// failed skips extended protocol messages after an error until Sync.
+	failed bool
+}
+
+func newConn(server *Server, netConn net.Conn, pid, secret int32) *conn {
+	return &conn{
+		server:     server,
+		netConn:    netConn,
+		r:          bufio.NewReader(netConn),
+		w:          bufio.NewWriter(netConn),
+		session:    server.DB.NewSession(),
+		pid:        pid,
+		secret:     secret,
+		statements: make(map[string]*statement),
+		portals:    make(map[string]*portal),
+	}
+}
+
+func (c *conn) serve() {
+	defer c.netConn.Close()
+	defer c.session.Close()
+	defer func() {
+		for _, p := range c.portals {
+			p.close()
+		}
+	}()
+
+	if err := c.startup(); err != nil {
+		return
+	}
+	for {
+		typ, body, err := readMessage(c.r)
+		if err != nil {
+			return
+		}
+		if err := c.handle(typ, body); err != nil {
+			if err != errTerminate && !errors.Is(err, io.EOF) {
+				_ = c.sendError(newError("08P01", "%v", err))
+				_ = c.w.Flush()
+			}
+			return
+		}
+	}
+}
+
+func (c *conn) startup() error {
+	for {
+		body, err := readStartup(c.r)
+		if err != nil {
+			return err
+		}
+		r := &reader{buf: body}
+		switch code := r.int32(); code {
+		case sslRequestCode, gssRequestCode:
+			if err := c.w.WriteByte('N'); err != nil {
+				return err
+			}
+			if err := c.w.Flush(); err != nil {
+				return err
+			}
+		case cancelCode:
+			// Statements run to completion, there is nothing to cancel.
+			return errTerminate
+		case protocolVersion:
+			params := make(map[string]string)
+			for {
+				key := r.string()
+				if key == "" || r.err != nil {
+					break
+				}
+				params[key] = r.string()
+			}
+			if r.err != nil {
+				return r.err
+			}
+			return c.sendWelcome(params)
+		default:
+			_ = c.sendError(newError("0A000", "неподдерживаемая версия протокола %d.%d", code>>16, code&0xffff))
+			_ = c.w.Flush()
+			return errProtocol
+		}
+	}
+}
+
+func (c *conn) sendWelcome(params map[string]string) error {
+	messages := []*message{newMessage('R').int32(0)}
+	status := [][2]string{
+		{"server_version", ServerVersion},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+		{"application_name", params["application_name"]},
+	}
+	for _, param := range status {
+		messages = append(messages, newMessage('S').string(param[0]).string(param[1]))
+	}
+	messages = append(messages, newMessage('K').int32(c.pid).int32(c.secret))
+	for _, m := range messages {
+		if err := m.writeTo(c.w); err != nil {
+			return err
+		}
+	}
+	return c.readyForQuery()
+}
+
+func (c *conn) handle(typ byte, body []byte) error {
+	if c.failed && typ != 'S' && typ != 'X' {
+		return nil
+	}
+
+	r := &reader{buf: body}
+	var err error
+	switch typ {
+	case 'Q':
+		return c.simpleQuery(r)
+	case 'P':
+		err = c.parse(r)
+	case 'B':
+		err = c.bind(r)
+	case 'D':
+		err = c.describe(r)
+	case 'E':
+		err = c.execute(r)
+	case 'C':
+		err = c.close(r)
+	case 'H':
+		return c.w.Flush()
+	case 'S':
+		c.failed = false
+		return c.readyForQuery()
+	case 'X':
+		return errTerminate
+	default:
+		return fmt.Errorf("неизвестный тип сообщения %q", typ)
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if err != nil {
+		c.failed = true
+		return c.sendError(err)
+	}
+	return nil
+}
+
+func (c *conn) readyForQuery() error {
+	status := byte('I')
+	if c.session.InTransaction() {
+		status = 'T'
+	}
+	if err := newMessage('Z').byte(status).writeTo(c.w); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *conn) simpleQuery(r *reader) error {
+	text := r.string()
+	if r.err != nil {
+		return r.err
+	}
+
+	if err := c.runSimpleQuery(text); err != nil {
+		if err := c.sendError(err); err != nil {
+			return err
+		}
+	}
+	return c.readyForQuery()
+}
+
+// runSimpleQuery runs the statements of a Query message one by one and
+// stops at the first error.
+func (c *conn) runSimpleQuery(text string) error {
+	statements, err := parser.SplitStatements(text)
+	if err != nil {
+		return err
+	}
+	if len(statements) == 0 {
+		return newMessage('I').writeTo(c.w)
+	}
+
+	for _, statement := range statements {
+		query, err := parser.ParseQuery(statement)
+		if err != nil {
+			return err
+		}
+		result, err := c.run(query)
+		if err != nil {
+			return err
+		}
+		if result.Stream != nil {
+			p := &portal{result: result}
+			err := c.sendRowDescription(result, nil)
+			if err == nil {
+				_, err = c.sendRows(p, 0)
+			}
+			p.close()
+			if err != nil {
+				return err
+			}
+		}
+		if err := c.sendCommandComplete(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run opens a statement in the session and checkpoints the database once
+// its log has grown large enough. The rows of the result are left in its
+// stream.
+func (c *conn) run(query *parser.Query) (*actions.Result, error) {
+	result, err := c.session.Open(query)
+	if err := c.server.DB.MaybeCheckpoint(); err != nil {
+		c.server.logf("ошибка контрольной точки: %v", err)
+	}
+	return result, err
+}
+
+func (c *conn) parse(r *reader) error {
+	name := r.string()
+	text := r.string()
+	paramTypes := make([]int32, r.int16())
+	for i := range paramTypes {
+		paramTypes[i] = r.int32()
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	statements, err := parser.SplitStatements(text)
+	if err != nil {
+		return err
+	}
+	if len(statements) > 1 {
+		return newError("42601", "подготовленный запрос может содержать только один оператор")
+	}
+	if _, exist := c.statements[name]; exist && name != "" {
+		return newError("42P05", "подготовленный оператор %s уже существует", name)
+	}
+
+	stmt := &statement{}
+	if len(statements) == 1 {
+		count, err := parser.CountParams(statements[0])
+		if err != nil {
+			return err
+		}
+		for len(paramTypes) < count {
+			paramTypes = append(paramTypes, oidUnknown)
+		}
+		params := make([]parser.Param, len(paramTypes))
+		probe, err := parser.ParseQueryParams(statements[0], params)
+		if err != nil {
+			return err
+		}
+		stmt.query, stmt.probe = statements[0], probe
+	}
+	stmt.paramTypes = paramTypes
+
+	c.statements[name] = stmt
+	return newMessage('1').writeTo(c.w)
+}
+
+func (c *conn) bind(r *reader) error {
+	portalName := r.string()
+	stmtName := r.string()
+	paramFormats := make([]int16, r.int16())
+	for i := range paramFormats {
+		paramFormats[i] = r.int16()
+	}
+	params := make([][]byte, r.int16())
+	for i := range params {
+		params[i] = r.bytes()
+	}
+	resultFormats := make([]int16, r.int16())
+	for i := range resultFormats {
+		resultFormats[i] = r.int16()
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	stmt, exist := c.statements[stmtName]
+	if !exist {
+		return newError("26000", "подготовленный оператор %s не найден", stmtName)
+	}
+	if len(params) != len(stmt.paramTypes) {
+		return newError("08P01", "передано параметров: %d, ожидалось: %d", len(params), len(stmt.paramTypes))
+	}
+
+	p := &portal{formats: resultFormats}
+	if stmt.probe != nil {
+		values := make([]parser.Param, len(params))
+		for i, data := range params {
+			if data == nil {
+				values[i] = parser.Param{Kind: parser.ParamNull}
+				continue
+			}
+			value, err := decodeParam(stmt.paramTypes[i], data, columnFormat(paramFormats, i))
+			if err != nil {
+				return newError("22P03", "параметр $%d: %v", i+1, err)
+			}
+			values[i] = parser.Param{Kind: paramKind(stmt.paramTypes[i]), Value: value}
+		}
+		query, err := parser.ParseQueryParams(stmt.query, values)
+		if err != nil {
+			return err
+		}
+		p.query = query
+	}
+
+	if old, exist := c.portals[portalName]; exist {
+		old.close()
+	}
+	c.portals[portalName] = p
+	return newMessage('2').writeTo(c.w)
+}
+
+func (c *conn) describe(r *reader) error {
+	kind := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return nil
+	}
+
+	switch kind {
+	case 'S':
+		stmt, exist := c.statements[name]
+		if !exist {
+			return newError("26000", "подготовленный оператор %s не найден", name)
+		}
+		m := newMessage('t').int16(int16(len(stmt.paramTypes)))
+		for _, oid := range stmt.paramTypes {
+			if oid == oidUnknown {
+				oid = oidText
+			}
+			m.int32(oid)
+		}
+		if err := m.writeTo(c.w); err != nil {
+			return err
+		}
+		if !returnsRows(stmt.probe) {
+			return newMessage('n').writeTo(c.w)
+		}
+		// The columns of a query do not depend on its parameters, so the
+		// probe tells them without the real values.
+		result, err := c.session.Columns(stmt.probe)
+		if err != nil {
+			return err
+		}
+		return c.sendRowDescription(result, nil)
+	case 'P':
+		p, exist := c.portals[name]
+		if !exist {
+			return newError("34000", "портал %s не найден", name)
+		}
+		if !returnsRows(p.query) {
+			return newMessage('n').writeTo(c.w)
+		}
+		if p.result == nil {
+			result, err := c.run(p.query)
+			if err != nil {
+				return err
+			}
+			p.result = result
+		}
+		return c.sendRowDescription(p.result, p.formats)
+	}
+	return newError("08P01", "неверный тип объекта %q в Describe", kind)
+}
+
+func returnsRows(query *parser.Query) bool {
+	if query == nil {
+		return false
+	}
+	switch query.Type {
+	case parser.QuerySelect, parser.QueryShowTables, parser.QueryDescribe, parser.QueryExplain:
+		return true
+	}
+	return false
+}
+
+func (c *conn) execute(r *reader) error {
+	name := r.string()
+	maxRows := int(r.int32())
+	if r.err != nil {
+		return nil
+	}
+
+	p, exist := c.portals[name]
+	if !exist {
+		return newError("34000", "портал %s не найден", name)
+	}
+	if p.query == nil {
+		return newMessage('I').writeTo(c.w)
+	}
+	if p.result == nil {
+		result, err := c.run(p.query)
+		if err != nil {
+			return err
+		}
+		p.result = result
+	}
+
+	if p.result.Stream != nil {
+		more, err := c.sendRows(p, maxRows)
+		if err != nil {
+			p.close()
+			return err
+		}
+		if more {
+			return newMessage('s').writeTo(c.w)
+		}
+	}
+	return c.sendCommandComplete(p.result)
+}
+
+func (c *conn) close(r *reader) error {
+	kind := r.byte()
+	name := r.string()
+	if r.err != nil {
+		return nil
+	}
+
+	switch kind {
+	case 'S':
+		delete(c.statements, name)
+	case 'P':
+		if p, exist := c.portals[name]; exist {
+			p.close()
+			delete(c.portals, name)
+		}
+	default:
+		return newError("08P01", "неверный тип объекта %q в Close", kind)
+	}
+	return newMessage('3').writeTo(c.w)
+}
+
+// columnFormat picks the format of column i: no codes mean text, a single
+// code applies to every column.
+func columnFormat(formats []int16, i int) int16 {
+	switch {
+	case len(formats) == 0:
+		return formatText
+	case len(formats) == 1:
+		return formats[0]
+	case i < len(formats):
+		return formats[i]
+	}
+	return formatText
+}
+
+func (c *conn) sendRowDescription(result *actions.Result, formats []int16) error {
+	m := newMessage('T').int16(int16(len(result.Columns)))
+	for i, column := range result.Columns {
+		oid := typeOID(resultType(result, i))
+		m.string(column).int32(0).int16(0).int32(oid).int16(typeSize(oid)).int32(-1).int16(columnFormat(formats, i))
+	}
+	return m.writeTo(c.w)
+}
+
+func resultType(result *actions.Result, i int) database.ColumnType {
+	if i < len(result.Types) {
+		return result.Types[i]
+	}
+	return database.TypeText
+}
+
+// sendRows sends the rows of the portal as they are read from its stream,
+// at most maxRows of them unless it is 0, and reports whether rows are
+// left. Affected counts the rows sent so far.
+func (c *conn) sendRows(p *portal, maxRows int) (bool, error) {
+	rows := p.result.Stream
+	for sent := 0; ; sent++ {
+		var row actions.ResultRow
+		switch {
+		case p.ahead != nil:
+			row, p.ahead = *p.ahead, nil
+		case rows.Next():
+			row = rows.Row()
+		default:
+			return false, rows.Err()
+		}
+		if maxRows > 0 && sent == maxRows {
+			p.ahead = &row
+			return true, nil
+		}
+		if err := c.sendRow(p.result, row, p.formats); err != nil {
+			return false, err
+		}
+		p.result.Affected++
+	}
+}
+
+func (c *conn) sendRow(result *actions.Result, row actions.ResultRow, formats []int16) error {
+	m := newMessage('D').int16(int16(len(row.Values)))
+	for i, value := range row.Values {
+		if value == "" {
+			m.bytes(nil)
+			continue
+		}
+		data, err := encodeValue(resultType(result, i), value, columnFormat(formats, i))
+		if err != nil {
+			return newError("22P03", "поле %s: %v", result.Columns[i], err)
+		}
+		m.bytes(data)
+	}
+	return m.writeTo(c.w)
+}
+
+func (c *conn) sendCommandComplete(result *actions.Result) error {
+	tag := result.Command
+	switch result.Command {
+	case "INSERT":
+		tag = "INSERT 0 " + strconv.Itoa(result.Affected)
+	case "SELECT", "UPDATE", "DELETE":
+		tag += " " + strconv.Itoa(result.Affected)
+	}
+	return newMessage('C').string(tag).writeTo(c.w)
+}
+
+func (c *conn) sendError(err error) error {
+	return newMessage('E').
+		byte('S').string("ERROR").
+		byte('V').string("ERROR").
+		byte('C').string(errorCode(err)).
+		byte('M').string(err.Error()).
+		byte(0).
+		writeTo(c.w)
+}
+
+// errorCode maps errors to SQLSTATE codes so that clients can tell them
+// apart.
+func errorCode(err error) string {
+	var pgErr *pgError
+	var syntaxErr *parser.SyntaxError
+	switch {
+	case errors.As(err, &pgErr):
+		return pgErr.code
+	case errors.As(err, &syntaxErr):
+		return "42601"
+	case errors.Is(err, database.ErrTableNotFound):
+		return "42P01"
+	case errors.Is(err, database.ErrUnknownField):
+		return "42703"
+	case errors.Is(err, database.ErrIndexNotFound):
+		return "42704"
+	case errors.Is(err, database.ErrNotNull):
+		return "23502"
+	case errors.Is(err, database.ErrUnique):
+		return "23505"
+	case errors.Is(err, database.ErrCheck):
+		return "23514"
+	case errors.Is(err, database.ErrTypeMismatch):
+		return "22P02"
+	case errors.Is(err, database.ErrRecordNotFound):
+		return "P0002"
+	case errors.Is(err, actions.ErrTxConflict):
+		return "40001"
+	case errors.Is(err, actions.ErrNoTransaction):
+		return "25P01"
+	case errors.Is(err, actions.ErrNestedTransaction):
+		return "25001"
+	case errors.Is(err, actions.ErrUnsupportedQuery):
+		return "0A000"
+	}
+	return "XX000"
+}
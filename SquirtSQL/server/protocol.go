@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"v4/database"
+	"v4/database/parser"
+)
+
+const (
+	protocolVersion = 196608 // 3.0
+	sslRequestCode  = 80877103
+	gssRequestCode  = 80877104
+	cancelCode      = 80877102
+
+	maxMessageSize = 1 << 26
+)
+
+// Type OIDs from the pg_type catalog.
+const (
+	oidUnknown = 0
+	oidBool    = 16
+	oidInt8    = 20
+	oidInt2    = 21
+	oidInt4    = 23
+	oidText    = 25
+	oidFloat4  = 700
+	oidFloat8  = 701
+	oidVarchar = 1043
+	oidDate    = 1082
+)
+
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+var errProtocol = errors.New("нарушение протокола")
+
+// pgEpoch is the zero of binary dates.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func typeOID(t database.ColumnType) int32 {
+	switch t {
+	case database.TypeInt:
+		return oidInt8
+	case database.TypeFloat:
+		return oidFloat8
+	case database.TypeBool:
+		return oidBool
+	case database.TypeDate:
+		return oidDate
+	}
+	return oidText
+}
+
+func typeSize(oid int32) int16 {
+	switch oid {
+	case oidBool:
+		return 1
+	case oidInt8, oidFloat8:
+		return 8
+	case oidDate:
+		return 4
+	}
+	return -1
+}
+
+// encodeValue converts a stored value to the wire format of its column.
+func encodeValue(t database.ColumnType, value string, format int16) ([]byte, error) {
+	if format == formatText {
+		if t == database.TypeBool {
+			return []byte(value[:1]), nil
+		}
+		return []byte(value), nil
+	}
+
+	buf := make([]byte, 0, 8)
+	switch t {
+	case database.TypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(buf, uint64(n)), nil
+	case database.TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(f)), nil
+	case database.TypeBool:
+		if value == "true" {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case database.TypeDate:
+		date, err := time.Parse(database.DateLayout, value)
+		if err != nil {
+			return nil, err
+		}
+		days := int32(date.Sub(pgEpoch).Hours() / 24)
+		return binary.BigEndian.AppendUint32(buf, uint32(days)), nil
+	}
+	return []byte(value), nil
+}
+
+func paramKind(oid int32) parser.ParamKind {
+	switch oid {
+	case oidInt2, oidInt4, oidInt8, oidFloat4, oidFloat8:
+		return parser.ParamNumber
+	}
+	return parser.ParamText
+}
+
+// decodeParam converts a bound parameter to the text the parser expects.
+func decodeParam(oid int32, data []byte, format int16) (string, error) {
+	if format == formatText {
+		return string(data), nil
+	}
+	switch oid {
+	case oidBool:
+		if len(data) != 1 {
+			break
+		}
+		return strconv.FormatBool(data[0] != 0), nil
+	case oidInt2:
+		if len(data) != 2 {
+			break
+		}
+		return strconv.Itoa(int(int16(binary.BigEndian.Uint16(data)))), nil
+	case oidInt4:
+		if len(data) != 4 {
+			break
+		}
+		return strconv.Itoa(int(int32(binary.BigEndian.Uint32(data)))), nil
+	case oidInt8:
+		if len(data) != 8 {
+			break
+		}
+		return strconv.FormatInt(int64(binary.BigEndian.Uint64(data)), 10), nil
+	case oidFloat4:
+		if len(data) != 4 {
+			break
+		}
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(data))), 'g', -1, 32), nil
+	case oidFloat8:
+		if len(data) != 8 {
+			break
+		}
+		return strconv.FormatFloat(math.Float64frombits(binary.BigEndian.Uint64(data)), 'g', -1, 64), nil
+	case oidDate:
+		if len(data) != 4 {
+			break
+		}
+		days := int32(binary.BigEndian.Uint32(data))
+		return pgEpoch.AddDate(0, 0, int(days)).Format(database.DateLayout), nil
+	case oidUnknown, oidText, oidVarchar:
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("двоичный формат типа %d не поддерживается", oid)
+	}
+	return "", fmt.Errorf("неверная длина двоичного значения типа %d", oid)
+}
+
+// message is an outgoing backend message.
+type message struct {
+	buf []byte
+}
+
+func newMessage(typ byte) *message {
+	return &message{buf: []byte{typ, 0, 0, 0, 0}}
+}
+
+func (m *message) byte(b byte) *message {
+	m.buf = append(m.buf, b)
+	return m
+}
+
+func (m *message) int16(n int16) *message {
+	m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(n))
+	return m
+}
+
+func (m *message) int32(n int32) *message {
+	m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(n))
+	return m
+}
+
+func (m *message) string(s string) *message {
+	m.buf = append(m.buf, s...)
+	m.buf = append(m.buf, 0)
+	return m
+}
+
+func (m *message) bytes(b []byte) *message {
+	if b == nil {
+		return m.int32(-1)
+	}
+	m.int32(int32(len(b)))
+	m.buf = append(m.buf, b...)
+	return m
+}
+
+func (m *message) writeTo(w *bufio.Writer) error {
+	binary.BigEndian.PutUint32(m.buf[1:5], uint32(len(m.buf)-1))
+	_, err := w.Write(m.buf)
+	return err
+}
+
+// readStartup reads a message without a type byte, as sent before the
+// session starts.
+func readStartup(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[:]))
+	if size < 8 || size > maxMessageSize {
+		return nil, errProtocol
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func readMessage(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[1:]))
+	if size < 4 || size > maxMessageSize {
+		return 0, nil, errProtocol
+	}
+	body := make([]byte, size-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// reader decodes the fields of an incoming message. The first error
+// sticks, so callers check it once at the end.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) int16() int16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = errProtocol
+		return 0
+	}
+	n := int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return n
+}
+
+func (r *reader) int32() int32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errProtocol
+		return 0
+	}
+	n := int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return n
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errProtocol
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, b := range r.buf {
+		if b == 0 {
+			s := string(r.buf[:i])
+			r.buf = r.buf[i+1:]
+			return s
+		}
+	}
+	r.err = errProtocol
+	return ""
+}
+
+// bytes reads a length-prefixed value; nil stands for NULL.
+func (r *reader) bytes() []byte {
+	n := r.int32()
+	if r.err != nil || n < 0 {
+		return nil
+	}
+	if int(n) > len(r.buf) {
+		r.err = errProtocol
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
@@ -0,0 +1,142 @@
+// Package server serves a database over the PostgreSQL v3 wire protocol,
+// so that psql, pgx and other PostgreSQL clients can query it.
+package server
+
+import (
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"v4/database/actions"
+)
+
+const ServerVersion = "14.0 (SquirtSQL)"
+
+var ErrServerClosed = errors.New("сервер остановлен")
+
+type Server struct {
+	DB *actions.Database
+	// ErrorLog receives errors that cannot be sent to a client. Nil means
+	// the standard logger.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]bool
+	nextPID  int32
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func New(db *actions.Database) *Server {
+	return &Server{
+		DB:    db,
+		conns: make(map[*conn]bool),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections until Close and serves each of them in its
+// own goroutine. It always returns a non-nil error.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c, ok := s.track(netConn)
+		if !ok {
+			_ = netConn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(c)
+			c.serve()
+		}()
+	}
+}
+
+// Addr returns the listening address once Serve has started.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, disconnects every client and waits
+// for their sessions to end. Open transactions are rolled back.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		_ = c.netConn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) track(netConn net.Conn) (*conn, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false
+	}
+	s.nextPID++
+	c := newConn(s, netConn, s.nextPID, rand.Int32())
+	s.conns[c] = true
+	s.wg.Add(1)
+	return c, true
+}
+
+func (s *Server) untrack(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
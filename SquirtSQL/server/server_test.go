@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"v4/database/actions"
+	"v4/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T) (*Server, string) {
+	db := actions.NewDatabase(storage.NewCSVStorage(t.TempDir()))
+	srv := New(db)
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(listener) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close())
+		assert.ErrorIs(t, <-done, ErrServerClosed)
+	})
+	return srv, listener.Addr().String()
+}
+
+// testClient speaks just enough of the protocol to check the server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// response collects the messages sent up to ReadyForQuery.
+type response struct {
+	columns []string
+	oids    []int32
+	formats []int16
+	rows    [][]*string
+	tags    []string
+	codes   []string
+	errors  []string
+	types   []byte
+	status  byte
+}
+
+func connect(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	c.sendStartup(binary.BigEndian.AppendUint32(nil, sslRequestCode))
+	answer, err := c.r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('N'), answer)
+
+	body := binary.BigEndian.AppendUint32(nil, protocolVersion)
+	body = append(body, "user\x00test\x00database\x00test\x00\x00"...)
+	c.sendStartup(body)
+
+	resp := c.read()
+	require.Empty(t, resp.errors)
+	require.Equal(t, byte('I'), resp.status)
+	assert.Equal(t, byte('R'), resp.types[0])
+	assert.Equal(t, []byte{'S', 'K', 'Z'}, resp.types[len(resp.types)-3:])
+	return c
+}
+
+func (c *testClient) sendStartup(body []byte) {
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(body)+4))
+	_, err := c.conn.Write(append(msg, body...))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) send(typ byte, body []byte) {
+	msg := binary.BigEndian.AppendUint32([]byte{typ}, uint32(len(body)+4))
+	_, err := c.conn.Write(append(msg, body...))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) read() *response {
+	resp := &response{}
+	for {
+		typ, body, err := readMessage(c.r)
+		require.NoError(c.t, err)
+		resp.types = append(resp.types, typ)
+		r := &reader{buf: body}
+		switch typ {
+		case 'T':
+			resp.columns, resp.oids, resp.formats = nil, nil, nil
+			for n := r.int16(); n > 0; n-- {
+				resp.columns = append(resp.columns, r.string())
+				r.int32()
+				r.int16()
+				resp.oids = append(resp.oids, r.int32())
+				r.int16()
+				r.int32()
+				resp.formats = append(resp.formats, r.int16())
+			}
+		case 'D':
+			var row []*string
+			for n := r.int16(); n > 0; n-- {
+				if value := r.bytes(); value != nil {
+					s := string(value)
+					row = append(row, &s)
+				} else {
+					row = append(row, nil)
+				}
+			}
+			resp.rows = append(resp.rows, row)
+		case 'C':
+			resp.tags = append(resp.tags, r.string())
+		case 'E':
+			for field := r.byte(); field != 0; field = r.byte() {
+				value := r.string()
+				switch field {
+				case 'C':
+					resp.codes = append(resp.codes, value)
+				case 'M':
+					resp.errors = append(resp.errors, value)
+				}
+			}
+		case 'Z':
+			resp.status = r.byte()
+			require.NoError(c.t, r.err)
+			return resp
+		}
+		require.NoError(c.t, r.err)
+	}
+}
+
+func (c *testClient) query(sql string) *response {
+	c.send('Q', append([]byte(sql), 0))
+	return c.read()
+}
+
+func text(rows [][]*string) [][]string {
+	var out [][]string
+	for _, row := range rows {
+		var values []string
+		for _, value := range row {
+			if value == nil {
+				values = append(values, "NULL")
+			} else {
+				values = append(values, *value)
+			}
+		}
+		out = append(out, values)
+	}
+	return out
+}
+
+func TestSimpleQuery(t *testing.T) {
+	_, addr := startServer(t)
+	c := connect(t, addr)
+
+	resp := c.query("CREATE TABLE users name TEXT NOT NULL, age INT, score FLOAT, active BOOL, born DATE")
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []string{"CREATE TABLE"}, resp.tags)
+
+	resp = c.query("INSERT INTO users (name, age, score, active, born) VALUES ('Ann', 30, 1.5, true, '1994-05-01'), ('Bob', NULL, 2, false, NULL); UPDATE users SET age = 41 WHERE name = 'Bob'")
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []string{"INSERT 0 2", "UPDATE 1"}, resp.tags)
+
+	resp = c.query("SELECT id, name, age, score, active, born FROM users ORDER BY id")
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []string{"id", "name", "age", "score", "active", "born"}, resp.columns)
+	assert.Equal(t, []int32{oidInt8, oidText, oidInt8, oidFloat8, oidBool, oidDate}, resp.oids)
+	assert.Equal(t, [][]string{
+		{"1", "Ann", "30", "1.5", "t", "1994-05-01"},
+		{"2", "Bob", "41", "2", "f", "NULL"},
+	}, text(resp.rows))
+	assert.Equal(t, []string{"SELECT 2"}, resp.tags)
+
+	resp = c.query("SELECT COUNT(*) AS total, AVG(age) FROM users")
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []int32{oidInt8, oidFloat8}, resp.oids)
+	assert.Equal(t, [][]string{{"2", "35.5"}}, text(resp.rows))
+
+	resp = c.query("SHOW TABLES")
+	require.Empty(t, resp.errors)
+	assert.Equal(t, [][]string{{"users"}}, text(resp.rows))
+
+	resp = c.query(" ; -- nothing")
+	assert.Equal(t, []byte{'I', 'Z'}, resp.types)
+
+	tests := []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{name: "Unknown table", query: "SELECT * FROM missing", code: "42P01"},
+		{name: "Syntax error", query: "SELEKT 1", code: "42601"},
+		{name: "Unknown field", query: "SELECT nope FROM users", code: "42703"},
+		{name: "Not null", query: "INSERT INTO users (age) VALUES (1)", code: "23502"},
+		{name: "Commit without transaction", query: "COMMIT", code: "25P01"},
+		{name: "Help is console only", query: "/help", code: "0A000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := c.query(tt.query)
+			assert.Equal(t, []string{tt.code}, resp.codes)
+			assert.Equal(t, byte('I'), resp.status)
+		})
+	}
+
+	resp = c.query("INSERT INTO users (name) VALUES ('Eve'); SELECT * FROM missing; INSERT INTO users (name) VALUES ('Joe')")
+	assert.Equal(t, []string{"INSERT 0 1"}, resp.tags)
+	assert.Equal(t, []string{"42P01"}, resp.codes)
+	resp = c.query("SELECT name FROM users WHERE name = 'Joe'")
+	assert.Empty(t, resp.rows)
+}
+
+func cstring(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func int16s(values ...int16) []byte {
+	var buf []byte
+	for _, v := range values {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(v))
+	}
+	return buf
+}
+
+func TestExtendedQuery(t *testing.T) {
+	_, addr := startServer(t)
+	c := connect(t, addr)
+	require.Empty(t, c.query("CREATE TABLE items name TEXT, price INT").errors)
+	require.Empty(t, c.query("INSERT INTO items (name, price) VALUES ('a', 10), ('b', 20), ('c', 30)").errors)
+
+	parse := cstring("byprice")
+	parse = append(parse, cstring("SELECT name, price FROM items WHERE price >= $1 ORDER BY price")...)
+	parse = append(parse, int16s(1)...)
+	parse = binary.BigEndian.AppendUint32(parse, oidInt8)
+	c.send('P', parse)
+	c.send('D', append([]byte{'S'}, cstring("byprice")...))
+	c.send('S', nil)
+	resp := c.read()
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []byte{'1', 't', 'T', 'Z'}, resp.types)
+	assert.Equal(t, []string{"name", "price"}, resp.columns)
+	assert.Equal(t, []int32{oidText, oidInt8}, resp.oids)
+
+	// A binary int8 parameter and binary results, read one row at a time.
+	bind := cstring("")
+	bind = append(bind, cstring("byprice")...)
+	bind = append(bind, int16s(1, formatBinary, 1)...)
+	bind = binary.BigEndian.AppendUint32(bind, 8)
+	bind = binary.BigEndian.AppendUint64(bind, 20)
+	bind = append(bind, int16s(2, formatText, formatBinary)...)
+	c.send('B', bind)
+	c.send('D', append([]byte{'P'}, cstring("")...))
+	c.send('E', binary.BigEndian.AppendUint32(cstring(""), 1))
+	c.send('E', binary.BigEndian.AppendUint32(cstring(""), 0))
+	c.send('S', nil)
+	resp = c.read()
+	require.Empty(t, resp.errors)
+	assert.Equal(t, []byte{'2', 'T', 'D', 's', 'D', 'C', 'Z'}, resp.types)
+	assert.Equal(t, []int16{formatText, formatBinary}, resp.formats)
+	require.Len(t, resp.rows, 2)
+	assert.Equal(t, "b", *resp.rows[0][0])
+	assert.Equal(t, uint64(20), binary.BigEndian.Uint64([]byte(*resp.rows[0][1])))
+	assert.Equal(t, uint64(30), binary.BigEndian.Uint64([]byte(*resp.rows[1][1])))
+	assert.Equal(t, []string{"SELECT 2"}, resp.tags)
+
+	// After an error the rest of the batch is skipped until Sync.
+	parse = append(cstring(""), cstring("INSERT INTO items (name, price) VALUES ($1, $2)")...)
+	c.send('P', append(parse, int16s(0)...))
+	bind = append(cstring(""), cstring("")...)
+	bind = append(bind, int16s(0, 2)...)
+	bind = binary.BigEndian.AppendUint32(bind, 1)
+	bind = append(bind, 'd')
+	bind = binary.BigEndian.AppendUint32(bind, 3)
+	bind = append(bind, "abc"...)
+	bind = append(bind, int16s(0)...)
+	c.send('B', bind)
+	c.send('E', binary.BigEndian.AppendUint32(cstring(""), 0))
+	c.send('E', binary.BigEndian.AppendUint32(cstring(""), 0))
+	c.send('S', nil)
+	resp = c.read()
+	assert.Equal(t, []byte{'1', '2', 'E', 'Z'}, resp.types)
+	assert.Equal(t, []string{"22P02"}, resp.codes)
+
+	resp = c.query("SELECT COUNT(*) FROM items")
+	assert.Equal(t, [][]string{{"3"}}, text(resp.rows))
+
+	c.send('C', append([]byte{'S'}, cstring("byprice")...))
+	c.send('B', append(append(cstring(""), cstring("byprice")...), int16s(0, 0, 0)...))
+	c.send('S', nil)
+	resp = c.read()
+	assert.Equal(t, []byte{'3', 'E', 'Z'}, resp.types)
+	assert.Equal(t, []string{"26000"}, resp.codes)
+}
+
+func TestConcurrentClients(t *testing.T) {
+	_, addr := startServer(t)
+	admin := connect(t, addr)
+	require.Empty(t, admin.query("CREATE TABLE events name TEXT").errors)
+
+	const clients, inserts = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		c := connect(t, addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < inserts; j++ {
+				resp := c.query(fmt.Sprintf("INSERT INTO events (name) VALUES ('c%d-%d')", i, j))
+				assert.Empty(t, resp.errors)
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp := admin.query("SELECT COUNT(*) FROM events")
+	assert.Equal(t, [][]string{{fmt.Sprint(clients * inserts)}}, text(resp.rows))
+}
+
+func TestSessionTransactions(t *testing.T) {
+	srv, addr := startServer(t)
+	a := connect(t, addr)
+	b := connect(t, addr)
+	require.Empty(t, a.query("CREATE TABLE accounts owner TEXT").errors)
+
+	resp := a.query("BEGIN")
+	assert.Equal(t, byte('T'), resp.status)
+	require.Empty(t, a.query("INSERT INTO accounts (owner) VALUES ('ann')").errors)
+
+	assert.Empty(t, b.query("SELECT * FROM accounts").rows)
+	assert.Len(t, a.query("SELECT * FROM accounts").rows, 1)
+
+	resp = a.query("COMMIT")
+	assert.Equal(t, []string{"COMMIT"}, resp.tags)
+	assert.Equal(t, byte('I'), resp.status)
+	assert.Len(t, b.query("SELECT * FROM accounts").rows, 1)
+
+	// A client that disconnects mid-transaction loses its changes.
+	require.Empty(t, b.query("BEGIN; INSERT INTO accounts (owner) VALUES ('bob')").errors)
+	b.send('X', nil)
+	_, err := b.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	assert.Len(t, a.query("SELECT * FROM accounts").rows, 1)
+	assert.NotNil(t, srv.Addr())
+}
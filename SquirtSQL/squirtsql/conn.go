@@ -0,0 +1,288 @@
+package squirtsql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"v4/database"
+	"v4/database/actions"
+	"v4/database/parser"
+)
+
+var errNoLastInsertID = errors.New("LastInsertId доступен только после INSERT")
+
+type conn struct {
+	dir     string
+	db      *actions.Database
+	session *actions.Session
+	closed  bool
+}
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	numInput, err := parser.CountParams(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, query: query, numInput: numInput}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.session.Close()
+	return release(c.dir)
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("уровень изоляции транзакции не поддерживается")
+	}
+	if _, err := c.execute(ctx, &parser.Query{Type: parser.QueryBegin}, false); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, err := c.run(ctx, query, args, false)
+	if err != nil {
+		return nil, err
+	}
+	return execResult{result}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.run(ctx, query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{result: result}, nil
+}
+
+func (c *conn) run(ctx context.Context, query string, args []driver.NamedValue, stream bool) (*actions.Result, error) {
+	params, err := toParams(args)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parser.ParseQueryParams(query, params)
+	if err != nil {
+		return nil, err
+	}
+	return c.execute(ctx, parsed, stream)
+}
+
+// execute runs a statement in the session and checkpoints the database
+// once its log has grown large enough. With stream the rows are left in
+// the result stream to be read as the caller scans them.
+func (c *conn) execute(ctx context.Context, query *parser.Query, stream bool) (*actions.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	run := c.session.Execute
+	if stream {
+		run = c.session.Open
+	}
+	result, err := run(query)
+	if err != nil {
+		return nil, err
+	}
+	// A failed checkpoint loses nothing: the log still holds the changes
+	// and the next statement tries again.
+	_ = c.db.MaybeCheckpoint()
+	return result, nil
+}
+
+// toParams converts the arguments, which database/sql has already reduced
+// to driver.Value types, into placeholder values.
+func toParams(args []driver.NamedValue) ([]parser.Param, error) {
+	params := make([]parser.Param, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("именованный параметр %s не поддерживается", arg.Name)
+		}
+		switch value := arg.Value.(type) {
+		case nil:
+			params[i] = parser.Param{Kind: parser.ParamNull}
+		case int64:
+			params[i] = parser.Param{Kind: parser.ParamNumber, Value: strconv.FormatInt(value, 10)}
+		case float64:
+			params[i] = parser.Param{Kind: parser.ParamNumber, Value: strconv.FormatFloat(value, 'g', -1, 64)}
+		case bool:
+			params[i] = parser.Param{Kind: parser.ParamBool, Value: strconv.FormatBool(value)}
+		case string:
+			params[i] = parser.TextParam(value)
+		case []byte:
+			params[i] = parser.TextParam(string(value))
+		case time.Time:
+			params[i] = parser.TextParam(value.Format(database.DateLayout))
+		default:
+			return nil, fmt.Errorf("неподдерживаемый тип параметра %T", arg.Value)
+		}
+	}
+	return params, nil
+}
+
+type stmt struct {
+	conn     *conn
+	query    string
+	numInput int
+}
+
+var (
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.numInput
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	values := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		values[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return values
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	_, err := t.conn.execute(context.Background(), &parser.Query{Type: parser.QueryCommit}, false)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.execute(context.Background(), &parser.Query{Type: parser.QueryRollback}, false)
+	return err
+}
+
+type execResult struct {
+	result *actions.Result
+}
+
+func (r execResult) LastInsertId() (int64, error) {
+	if r.result.Command != "INSERT" {
+		return 0, errNoLastInsertID
+	}
+	return int64(r.result.LastID), nil
+}
+
+func (r execResult) RowsAffected() (int64, error) {
+	return int64(r.result.Affected), nil
+}
+
+// rows reads the result stream of a query one row per call to Next.
+type rows struct {
+	result *actions.Result
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	if r.result.Stream != nil {
+		r.result.Stream.Close()
+	}
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	stream := r.result.Stream
+	if stream == nil {
+		return io.EOF
+	}
+	if !stream.Next() {
+		if err := stream.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	row := stream.Row()
+
+	for i, value := range row.Values {
+		converted, err := toValue(r.columnType(i), value)
+		if err != nil {
+			return fmt.Errorf("поле %s: %w", r.result.Columns[i], err)
+		}
+		dest[i] = converted
+	}
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return string(r.columnType(index))
+}
+
+func (r *rows) columnType(index int) database.ColumnType {
+	if index < len(r.result.Types) {
+		return r.result.Types[index]
+	}
+	return database.TypeText
+}
+
+// toValue converts a stored value to the Go type of its column; NULL
+// becomes nil.
+func toValue(t database.ColumnType, value string) (driver.Value, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch t {
+	case database.TypeInt:
+		return strconv.ParseInt(value, 10, 64)
+	case database.TypeFloat:
+		return strconv.ParseFloat(value, 64)
+	case database.TypeBool:
+		return strconv.ParseBool(value)
+	case database.TypeDate:
+		return time.Parse(database.DateLayout, value)
+	}
+	return value, nil
+}
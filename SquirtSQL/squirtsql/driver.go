@@ -0,0 +1,117 @@
+// Package squirtsql registers the database/sql driver "squirtsql", which
+// runs queries in-process. The DSN is the data directory:
+//
+//	db, err := sql.Open("squirtsql", "data")
+//
+// Values are passed with ? or $n placeholders.
+package squirtsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"v4/database/actions"
+	"v4/storage"
+)
+
+const DriverName = "squirtsql"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+type Driver struct{}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	connector, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	if dsn == "" {
+		return nil, errors.New("не указан каталог с данными")
+	}
+	dir, err := filepath.Abs(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{driver: d, dir: dir}, nil
+}
+
+type connector struct {
+	driver *Driver
+	dir    string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db, err := acquire(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{dir: c.dir, db: db, session: db.NewSession()}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Connections to one directory share a Database, which is loaded by the
+// first of them and checkpointed and closed by the last.
+var (
+	openMu sync.Mutex
+	opened = make(map[string]*shared)
+)
+
+type shared struct {
+	db   *actions.Database
+	refs int
+}
+
+func acquire(dir string) (*actions.Database, error) {
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	if s, exist := opened[dir]; exist {
+		s.refs++
+		return s.db, nil
+	}
+
+	stor := storage.NewCSVStorage(dir)
+	db := actions.NewDatabase(stor)
+	db.Out = io.Discard
+	if err := db.LoadTables(); err != nil {
+		_ = stor.Close()
+		return nil, err
+	}
+	opened[dir] = &shared{db: db, refs: 1}
+	return db, nil
+}
+
+func release(dir string) error {
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	s, exist := opened[dir]
+	if !exist {
+		return nil
+	}
+	if s.refs--; s.refs > 0 {
+		return nil
+	}
+	delete(opened, dir)
+	err := s.db.Checkpoint()
+	if closeErr := s.db.Storage.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+package squirtsql
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T, dir string) *sql.DB {
+	db, err := sql.Open(DriverName, dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestDriver(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	_, err := db.Exec("CREATE TABLE users name TEXT NOT NULL, age INT, score FLOAT, active BOOL, born DATE")
+	require.NoError(t, err)
+
+	born := time.Date(1990, 3, 4, 0, 0, 0, 0, time.UTC)
+	res, err := db.Exec("INSERT INTO users (name, age, score, active, born) VALUES (?, ?, ?, ?, ?)", "Ann", 30, 1.5, true, born)
+	require.NoError(t, err)
+	id, err := res.LastInsertId()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+
+	// Values are bound as whole tokens, so quotes cannot escape them.
+	injection := "x'); DROP TABLE users; --"
+	_, err = db.Exec("INSERT INTO users (name, age) VALUES (?, ?)", injection, nil)
+	require.NoError(t, err)
+
+	var (
+		name   string
+		age    sql.NullInt64
+		score  sql.NullFloat64
+		active sql.NullBool
+		date   sql.NullTime
+	)
+	err = db.QueryRow("SELECT name, age, score, active, born FROM users WHERE id = ?", 1).Scan(&name, &age, &score, &active, &date)
+	require.NoError(t, err)
+	assert.Equal(t, "Ann", name)
+	assert.Equal(t, sql.NullInt64{Int64: 30, Valid: true}, age)
+	assert.Equal(t, sql.NullFloat64{Float64: 1.5, Valid: true}, score)
+	assert.Equal(t, sql.NullBool{Bool: true, Valid: true}, active)
+	assert.Equal(t, sql.NullTime{Time: born, Valid: true}, date)
+
+	err = db.QueryRow("SELECT name, age FROM users WHERE name = ?", injection).Scan(&name, &age)
+	require.NoError(t, err)
+	assert.Equal(t, injection, name)
+	assert.False(t, age.Valid)
+
+	stmt, err := db.Prepare("UPDATE users SET age = $1 WHERE name = $2")
+	require.NoError(t, err)
+	defer stmt.Close()
+	for _, value := range []int{31, 32} {
+		res, err = stmt.Exec(value, "Ann")
+		require.NoError(t, err)
+		affected, err := res.RowsAffected()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), affected)
+	}
+	_, err = res.LastInsertId()
+	assert.ErrorIs(t, err, errNoLastInsertID)
+
+	rows, err := db.Query("SELECT name, age FROM users ORDER BY id")
+	require.NoError(t, err)
+	types, err := rows.ColumnTypes()
+	require.NoError(t, err)
+	assert.Equal(t, "INT", types[1].DatabaseTypeName())
+	var names []string
+	for rows.Next() {
+		require.NoError(t, rows.Scan(&name, &age))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"Ann", injection}, names)
+
+	_, err = db.Exec("SELECT * FROM users WHERE name = ?")
+	assert.Error(t, err)
+	_, err = db.Exec("SELECT * FROM users WHERE name = @name", sql.Named("name", "Ann"))
+	assert.ErrorContains(t, err, "именованный параметр")
+	_, err = db.Exec("SELECT * FROM missing")
+	assert.ErrorContains(t, err, "таблица не найдена")
+}
+
+func TestDriverNullAndBoolParams(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	_, err := db.Exec("CREATE TABLE flags name TEXT, active BOOL, note TEXT")
+	require.NoError(t, err)
+
+	_, err = db.Exec("INSERT INTO flags (name, active, note) VALUES (?, ?, ?), (?, ?, ?)", "a", true, nil, "b", nil, "x")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT flags ?, ?, ?", "c", false, nil)
+	require.NoError(t, err)
+
+	names := func(query string, args ...any) []string {
+		rows, err := db.Query(query, args...)
+		require.NoError(t, err)
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			require.NoError(t, rows.Scan(&name))
+			names = append(names, name)
+		}
+		require.NoError(t, rows.Err())
+		return names
+	}
+	assert.Equal(t, []string{"a"}, names("SELECT name FROM flags WHERE active = ?", true))
+	assert.Empty(t, names("SELECT name FROM flags WHERE active = ?", nil))
+	assert.Equal(t, []string{"a", "b"}, names("SELECT name FROM flags WHERE note = ? OR active IN (?, ?) ORDER BY name", "x", nil, true))
+
+	_, err = db.Exec("UPDATE flags SET active = ? WHERE name = ?", nil, "a")
+	require.NoError(t, err)
+	var active sql.NullBool
+	require.NoError(t, db.QueryRow("SELECT active FROM flags WHERE name = ?", "a").Scan(&active))
+	assert.False(t, active.Valid)
+}
+
+func TestDriverTransactions(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	_, err := db.Exec("CREATE TABLE accounts owner TEXT, balance INT")
+	require.NoError(t, err)
+
+	count := func() int {
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM accounts").Scan(&n))
+		return n
+	}
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("INSERT INTO accounts (owner, balance) VALUES (?, ?)", "ann", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count())
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, 1, count())
+
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("DELETE accounts WHERE owner = ?", "ann")
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	assert.Equal(t, 1, count())
+
+	_, err = db.BeginTx(t.Context(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	assert.Error(t, err)
+
+	// Closing the last connection checkpoints the data for the next open.
+	require.NoError(t, db.Close())
+	reopened := openTestDB(t, dir)
+	var owner string
+	require.NoError(t, reopened.QueryRow("SELECT owner FROM accounts").Scan(&owner))
+	assert.Equal(t, "ann", owner)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tempSuffix = ".tmp"
+
+// Hooks for tests that simulate failing disks.
+var (
+	wrapTempWriter = func(name string, w io.Writer) io.Writer { return w }
+	syncFile       = func(file *os.File) error { return file.Sync() }
+)
+
+// writeFileAtomic replaces name in dir with the output of write. The data
+// goes to a temp file that is synced and renamed over the old file, so a
+// crash leaves either the old or the new contents, never a mix.
+func writeFileAtomic(dir, name string, write func(io.Writer) error) (err error) {
+	temp, err := os.CreateTemp(dir, name+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = temp.Close()
+			_ = os.Remove(temp.Name())
+		}
+	}()
+
+	if err := write(wrapTempWriter(name, temp)); err != nil {
+		return err
+	}
+	if err := syncFile(temp); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a completed rename or removal durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return syncFile(dir)
+}
+
+// removeFiles deletes the files of a table, ignoring missing ones.
+func removeFiles(dir string, names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+// listFiles returns the names of the files in dir with the suffix, with
+// the suffix cut off.
+func listFiles(dir, suffix string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), suffix) {
+			names = append(names, strings.TrimSuffix(file.Name(), suffix))
+		}
+	}
+	return names, nil
+}
+
+// removeTempFiles deletes temp files left in dir by saves interrupted by
+// a crash and returns their names.
+func removeTempFiles(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), tempSuffix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file.Name())
+	}
+	return removed, nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"container/list"
+	"errors"
+)
+
+// DefaultPoolPages is the number of pages a page engine caches, 1 MiB.
+const DefaultPoolPages = 256
+
+type pageKey struct {
+	heap *heapFile
+	no   uint32
+}
+
+type frame struct {
+	key   pageKey
+	data  page
+	dirty bool
+	pins  int
+	elem  *list.Element
+}
+
+// bufferPool caches pages of all heap files of an engine and evicts the
+// least recently used unpinned page when full, writing it back if dirty.
+type bufferPool struct {
+	capacity int
+	frames   map[pageKey]*frame
+	lru      *list.List
+}
+
+func newBufferPool(capacity int) *bufferPool {
+	return &bufferPool{
+		capacity: capacity,
+		frames:   make(map[pageKey]*frame),
+		lru:      list.New(),
+	}
+}
+
+// fetch returns the pinned frame of a page, reading it on a miss.
+func (b *bufferPool) fetch(h *heapFile, no uint32) (*frame, error) {
+	key := pageKey{heap: h, no: no}
+	if f, exist := b.frames[key]; exist {
+		f.pins++
+		b.lru.MoveToFront(f.elem)
+		return f, nil
+	}
+
+	f, err := b.add(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.readPage(no, f.data); err != nil {
+		b.forget(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// allocate returns the pinned frame of a new empty page without reading
+// it from the file.
+func (b *bufferPool) allocate(h *heapFile, no uint32) (*frame, error) {
+	key := pageKey{heap: h, no: no}
+	if f, exist := b.frames[key]; exist {
+		b.forget(f)
+	}
+	f, err := b.add(key)
+	if err != nil {
+		return nil, err
+	}
+	copy(f.data, newPage())
+	f.dirty = true
+	return f, nil
+}
+
+func (b *bufferPool) add(key pageKey) (*frame, error) {
+	if len(b.frames) >= b.capacity {
+		if err := b.evict(); err != nil {
+			return nil, err
+		}
+	}
+	f := &frame{key: key, data: make(page, PageSize), pins: 1}
+	f.elem = b.lru.PushFront(f)
+	b.frames[key] = f
+	return f, nil
+}
+
+func (b *bufferPool) evict() error {
+	for elem := b.lru.Back(); elem != nil; elem = elem.Prev() {
+		f := elem.Value.(*frame)
+		if f.pins > 0 {
+			continue
+		}
+		if f.dirty {
+			if err := f.key.heap.writePage(f.key.no, f.data); err != nil {
+				return err
+			}
+		}
+		b.forget(f)
+		return nil
+	}
+	return errors.New("все страницы буфера закреплены")
+}
+
+func (b *bufferPool) unpin(f *frame, dirty bool) {
+	f.pins--
+	f.dirty = f.dirty || dirty
+}
+
+func (b *bufferPool) forget(f *frame) {
+	b.lru.Remove(f.elem)
+	delete(b.frames, f.key)
+}
+
+// flush writes the dirty pages of a heap file back.
+func (b *bufferPool) flush(h *heapFile) error {
+	for key, f := range b.frames {
+		if key.heap != h || !f.dirty {
+			continue
+		}
+		if err := h.writePage(key.no, f.data); err != nil {
+			return err
+		}
+		f.dirty = false
+	}
+	return nil
+}
+
+// drop forgets the pages of a heap file without writing them.
+func (b *bufferPool) drop(h *heapFile) {
+	for key, f := range b.frames {
+		if key.heap == h {
+			b.forget(f)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"v4/database"
+)
+
+const csvSuffix = ".csv"
+
+// CSVEngine saves each table as a CSV file of its records next to a JSON
+// file with its schema, named after the checksum of the CSV file.
+type CSVEngine struct {
+	Dir string
+}
+
+func NewCSVEngine(dir string) *CSVEngine {
+	makeDir(dir)
+	return &CSVEngine{Dir: dir}
+}
+
+func NewCSVStorage(basePath string) *Storage {
+	return New(NewCSVEngine(basePath), basePath)
+}
+
+// Save writes the schema under the checksum of the records first, then
+// replaces the CSV file and removes the schemas of the previous saves.
+func (e *CSVEngine) Save(table *database.Table) error {
+	var data bytes.Buffer
+	writer := csv.NewWriter(&data)
+
+	header := append([]string{"id"}, table.Fields...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for id, record := range table.Records {
+		row := make([]string, len(header))
+		row[0] = strconv.Itoa(id)
+		for i, field := range table.Fields {
+			row[i+1] = record[field]
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+
+	current := schemaFile(table.Name, crc32.ChecksumIEEE(data.Bytes()))
+	schema := csvSchema{tableSchema: newTableSchema(table), NextID: table.NextID}
+	if err := saveSchema(e.Dir, current, schema); err != nil {
+		return err
+	}
+	err := writeFileAtomic(e.Dir, table.Name+csvSuffix, func(w io.Writer) error {
+		_, err := w.Write(data.Bytes())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := schemaFiles(e.Dir, table.Name)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for _, file := range files {
+		if file != current {
+			stale = append(stale, file)
+		}
+	}
+	return removeFiles(e.Dir, stale...)
+}
+
+func (e *CSVEngine) Load(name string) (table *database.Table, err error) {
+	data, err := os.ReadFile(filepath.Join(e.Dir, name+csvSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 1 {
+		return nil, errors.New("файл с таблицей пуст")
+	}
+
+	userFields := records[0][1:]
+	columns, schema, err := e.loadSchema(name, crc32.ChecksumIEEE(data), userFields)
+	if err != nil {
+		return nil, err
+	}
+	table = database.NewTableFromColumns(name, columns)
+	table.NextID = max(schema.NextID, 1)
+
+	for _, row := range records[1:] {
+		if len(row) != len(records[0]) {
+			continue
+		}
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			continue
+		}
+
+		record := make(database.Record)
+		for i, field := range userFields {
+			record[field] = row[i+1]
+		}
+
+		table.Records[id] = record
+		if id >= table.NextID {
+			table.NextID = id + 1
+		}
+	}
+
+	addIndexes(table, schema.Indexes)
+	return table, nil
+}
+
+func (e *CSVEngine) List() ([]string, error) {
+	return listFiles(e.Dir, csvSuffix)
+}
+
+func (e *CSVEngine) Exists(name string) bool {
+	_, err := os.Stat(filepath.Join(e.Dir, name+csvSuffix))
+	return err == nil
+}
+
+func (e *CSVEngine) Delete(name string) error {
+	files, err := schemaFiles(e.Dir, name)
+	if err != nil {
+		return err
+	}
+	return removeFiles(e.Dir, append(files, name+csvSuffix)...)
+}
+
+func (e *CSVEngine) RemoveTempFiles() ([]string, error) {
+	return removeTempFiles(e.Dir)
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"v4/database"
+)
+
+// Engine keeps the tables saved at checkpoints. Storage logs every change
+// ahead of it, so an engine only needs to replace whole tables.
+type Engine interface {
+	// Save replaces the stored table with the same name.
+	Save(table *database.Table) error
+	// Load reads a saved table. A missing table is an error matching
+	// fs.ErrNotExist.
+	Load(name string) (*database.Table, error)
+	List() ([]string, error)
+	Exists(name string) bool
+	Delete(name string) error
+}
+
+// tempCleaner is an engine that may leave temp files behind on a crash.
+type tempCleaner interface {
+	RemoveTempFiles() ([]string, error)
+}
+
+const (
+	EngineCSV    = "csv"
+	EngineJSONL  = "jsonl"
+	EngineLog    = "log"
+	EngineMemory = "memory"
+	EnginePages  = "pages"
+)
+
+// Engines lists the names accepted by Open.
+var Engines = []string{EngineCSV, EngineJSONL, EngineLog, EngineMemory, EnginePages}
+
+// Open returns a storage in dir that saves tables with the named engine.
+// The memory engine keeps everything in memory and ignores dir; only the
+// log engine uses compactThreshold.
+func Open(engine, dir string, compactThreshold int64) (*Storage, error) {
+	switch engine {
+	case EngineCSV:
+		return NewCSVStorage(dir), nil
+	case EngineJSONL:
+		return NewJSONLStorage(dir), nil
+	case EngineLog:
+		if compactThreshold <= 0 {
+			return nil, fmt.Errorf("порог сжатия журнала должен быть положительным, получено %d", compactThreshold)
+		}
+		return New(NewLogEngine(dir, compactThreshold), dir), nil
+	case EngineMemory:
+		return NewMemoryStorage(), nil
+	case EnginePages:
+		return NewPageStorage(dir), nil
+	}
+	return nil, fmt.Errorf("неизвестный движок хранения %s, доступны %v", engine, Engines)
+}
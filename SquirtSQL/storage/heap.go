@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"v4/database"
+)
+
+// The first page of a heap file is its header: the checksum, the magic,
+// the next row id and the schema of the table as JSON.
+const (
+	heapMagic     = "SQPG"
+	schemaOffset  = 18
+	maxSchemaSize = PageSize - schemaOffset
+)
+
+type rowID struct {
+	page uint32
+	slot int
+}
+
+// heapFile stores the rows of a table in slotted pages, addressed by row
+// id. The row directory, the free space of each page and the free list of
+// empty pages live in memory and are rebuilt by the scan on open.
+type heapFile struct {
+	file    *os.File
+	pool    *bufferPool
+	schema  tableSchema
+	columns []string
+	pages   uint32
+	nextID  int
+
+	rows  map[int]rowID
+	space map[uint32]int
+	free  []uint32
+	last  uint32
+
+	// writes counts the pages written to the file.
+	writes   int
+	unsynced bool
+}
+
+func headerPage(nextID int, schema tableSchema) (page, error) {
+	p := make(page, PageSize)
+	copy(p[4:8], heapMagic)
+	binary.LittleEndian.PutUint64(p[8:16], uint64(nextID))
+	if err := putSchema(p, schema); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func putSchema(header page, schema tableSchema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSchemaSize {
+		return fmt.Errorf("схема таблицы не помещается в страницу: %d байт", len(data))
+	}
+	binary.LittleEndian.PutUint16(header[16:18], uint16(len(data)))
+	copy(header[schemaOffset:], data)
+	clear(header[schemaOffset+len(data):])
+	return nil
+}
+
+func openHeap(path string, pool *bufferPool) (*heapFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// A page cut short by a crash while the file grew was never part of
+	// a complete write, so it is dropped.
+	h := &heapFile{
+		file:  file,
+		pool:  pool,
+		pages: uint32(info.Size() / PageSize),
+		rows:  make(map[int]rowID),
+		space: make(map[uint32]int),
+	}
+	if err := h.open(); err != nil {
+		pool.drop(h)
+		_ = file.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *heapFile) open() error {
+	if h.pages == 0 {
+		return errors.New("файл с таблицей пуст")
+	}
+	header, err := h.pool.fetch(h, 0)
+	if err != nil {
+		return err
+	}
+	if string(header.data[4:8]) != heapMagic {
+		h.pool.unpin(header, false)
+		return errors.New("файл не является файлом страниц")
+	}
+	h.nextID = int(binary.LittleEndian.Uint64(header.data[8:16]))
+	size := int(binary.LittleEndian.Uint16(header.data[16:18]))
+	err = json.Unmarshal(header.data[schemaOffset:schemaOffset+min(size, maxSchemaSize)], &h.schema)
+	h.pool.unpin(header, false)
+	if err != nil {
+		return fmt.Errorf("схема таблицы: %w", err)
+	}
+	for _, column := range h.schema.Columns {
+		h.columns = append(h.columns, column.Name)
+	}
+
+	for no := uint32(1); no < h.pages; no++ {
+		f, err := h.pool.fetch(h, no)
+		if err != nil {
+			return err
+		}
+		dirty := false
+		for slot := 0; slot < f.data.slotCount(); slot++ {
+			cell := f.data.cell(slot)
+			if cell == nil {
+				continue
+			}
+			// A crash while a row moved between pages may leave both
+			// copies. The log still holds the change, and replaying it
+			// rewrites the copy that is kept.
+			id := decodeRowID(cell)
+			if _, exist := h.rows[id]; exist {
+				f.data.remove(slot)
+				dirty = true
+				continue
+			}
+			h.rows[id] = rowID{page: no, slot: slot}
+		}
+		h.account(no, f.data)
+		h.pool.unpin(f, dirty)
+	}
+	return nil
+}
+
+// account files a page under the free list or the free space map.
+func (h *heapFile) account(no uint32, p page) {
+	if p.empty() {
+		delete(h.space, no)
+		h.free = append(h.free, no)
+		return
+	}
+	h.space[no] = p.freeSpace()
+}
+
+// scan calls fn for every row in page order.
+func (h *heapFile) scan(fn func(id int, record database.Record)) error {
+	for no := uint32(1); no < h.pages; no++ {
+		f, err := h.pool.fetch(h, no)
+		if err != nil {
+			return err
+		}
+		for slot := 0; slot < f.data.slotCount(); slot++ {
+			cell := f.data.cell(slot)
+			if cell == nil {
+				continue
+			}
+			id, values, err := decodeRow(cell, len(h.columns))
+			if err != nil {
+				h.pool.unpin(f, false)
+				return fmt.Errorf("страница %d: %w", no, err)
+			}
+			record := make(database.Record, len(values))
+			for i, column := range h.columns {
+				record[column] = values[i]
+			}
+			fn(id, record)
+		}
+		h.pool.unpin(f, false)
+	}
+	return nil
+}
+
+// put inserts or replaces a row. A row that still fits stays on its page;
+// one that grew too much moves to another.
+func (h *heapFile) put(id int, record database.Record) error {
+	cell := encodeRecord(id, h.columns, record)
+	if len(cell) > maxCellSize {
+		return fmt.Errorf("запись %d не помещается в страницу: %d байт", id, len(cell))
+	}
+
+	if rid, exist := h.rows[id]; exist {
+		f, err := h.pool.fetch(h, rid.page)
+		if err != nil {
+			return err
+		}
+		f.data.remove(rid.slot)
+		delete(h.rows, id)
+		slot, err := f.data.insert(cell)
+		if err == nil {
+			h.rows[id] = rowID{page: rid.page, slot: slot}
+		}
+		h.account(rid.page, f.data)
+		h.pool.unpin(f, true)
+		if err != nil {
+			if err := h.insert(id, cell); err != nil {
+				return err
+			}
+		}
+	} else if err := h.insert(id, cell); err != nil {
+		return err
+	}
+
+	if id >= h.nextID {
+		return h.setNextID(id + 1)
+	}
+	return nil
+}
+
+func (h *heapFile) insert(id int, cell []byte) error {
+	f, err := h.pageFor(len(cell) + slotSize)
+	if err != nil {
+		return err
+	}
+	no := f.key.no
+	slot, err := f.data.insert(cell)
+	if err != nil {
+		h.pool.unpin(f, false)
+		return err
+	}
+	h.rows[id] = rowID{page: no, slot: slot}
+	h.space[no] = f.data.freeSpace()
+	h.last = no
+	h.pool.unpin(f, true)
+	return nil
+}
+
+// pageFor returns a pinned page with room for need bytes: the page of the
+// last insert, another page with room, an empty page from the free list
+// or a new page at the end of the file.
+func (h *heapFile) pageFor(need int) (*frame, error) {
+	if h.space[h.last] >= need {
+		return h.pool.fetch(h, h.last)
+	}
+	candidates := make([]uint32, 0)
+	for no, free := range h.space {
+		if free >= need {
+			candidates = append(candidates, no)
+		}
+	}
+	if len(candidates) > 0 {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+		return h.pool.fetch(h, candidates[0])
+	}
+
+	var no uint32
+	if n := len(h.free); n > 0 {
+		no, h.free = h.free[n-1], h.free[:n-1]
+	} else {
+		no = h.pages
+		h.pages++
+	}
+	return h.pool.allocate(h, no)
+}
+
+func (h *heapFile) delete(id int) error {
+	rid, exist := h.rows[id]
+	if !exist {
+		return nil
+	}
+	f, err := h.pool.fetch(h, rid.page)
+	if err != nil {
+		return err
+	}
+	f.data.remove(rid.slot)
+	delete(h.rows, id)
+	h.account(rid.page, f.data)
+	h.pool.unpin(f, true)
+	return nil
+}
+
+// truncate removes all rows and restarts the ids, keeping only the
+// header page. The cached pages are dropped unwritten, so the header is
+// written anew from the schema in memory, which may hold changes the file
+// does not have yet.
+func (h *heapFile) truncate() error {
+	header, err := headerPage(1, h.schema)
+	if err != nil {
+		return err
+	}
+	h.pool.drop(h)
+	if err := h.file.Truncate(PageSize); err != nil {
+		return err
+	}
+	h.pages = 1
+	h.rows = make(map[int]rowID)
+	h.space = make(map[uint32]int)
+	h.free = nil
+	h.last = 0
+
+	f, err := h.pool.allocate(h, 0)
+	if err != nil {
+		return err
+	}
+	copy(f.data, header)
+	h.nextID = 1
+	h.pool.unpin(f, true)
+	return nil
+}
+
+// setIndexes replaces the indexes in the schema.
+func (h *heapFile) setIndexes(indexes []indexSchema) error {
+	f, err := h.pool.fetch(h, 0)
+	if err != nil {
+		return err
+	}
+	schema := h.schema
+	schema.Indexes = indexes
+	if err := putSchema(f.data, schema); err != nil {
+		h.pool.unpin(f, false)
+		return err
+	}
+	h.schema = schema
+	h.pool.unpin(f, true)
+	return nil
+}
+
+func (h *heapFile) setNextID(id int) error {
+	f, err := h.pool.fetch(h, 0)
+	if err != nil {
+		return err
+	}
+	h.nextID = id
+	binary.LittleEndian.PutUint64(f.data[8:16], uint64(id))
+	h.pool.unpin(f, true)
+	return nil
+}
+
+func (h *heapFile) readPage(no uint32, p page) error {
+	if _, err := h.file.ReadAt(p, int64(no)*PageSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("страница %d за концом файла", no)
+		}
+		return err
+	}
+	return p.verify(no)
+}
+
+func (h *heapFile) writePage(no uint32, p page) error {
+	p.seal()
+	if _, err := h.file.WriteAt(p, int64(no)*PageSize); err != nil {
+		return err
+	}
+	h.writes++
+	h.unsynced = true
+	return nil
+}
+
+// flush writes the dirty pages and syncs the file, including the pages
+// written earlier by evictions.
+func (h *heapFile) flush() error {
+	if err := h.pool.flush(h); err != nil {
+		return err
+	}
+	if !h.unsynced {
+		return nil
+	}
+	if err := syncFile(h.file); err != nil {
+		return err
+	}
+	h.unsynced = false
+	return nil
+}
+
+func (h *heapFile) close() error {
+	err := h.flush()
+	h.pool.drop(h)
+	if closeErr := h.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeHeap writes a new heap file with the rows of table packed into
+// pages in id order.
+func writeHeap(w io.Writer, table *database.Table) error {
+	header, err := headerPage(table.NextID, newTableSchema(table))
+	if err != nil {
+		return err
+	}
+	header.seal()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(table.Records))
+	for id := range table.Records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	current := newPage()
+	for _, id := range ids {
+		cell := encodeRecord(id, table.Fields, table.Records[id])
+		if len(cell) > maxCellSize {
+			return fmt.Errorf("запись %d не помещается в страницу: %d байт", id, len(cell))
+		}
+		if _, err := current.insert(cell); err == nil {
+			continue
+		}
+		current.seal()
+		if _, err := w.Write(current); err != nil {
+			return err
+		}
+		current = newPage()
+		if _, err := current.insert(cell); err != nil {
+			return err
+		}
+	}
+	if current.empty() {
+		return nil
+	}
+	current.seal()
+	_, err = w.Write(current)
+	return err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"v4/database"
+)
+
+const jsonlSuffix = ".jsonl"
+
+// JSONLEngine saves each table as a JSON Lines file: a header line with
+// the schema followed by one line per record.
+type JSONLEngine struct {
+	Dir string
+}
+
+type jsonlHeader struct {
+	Schema tableSchema `json:"schema"`
+	NextID int         `json:"next_id"`
+}
+
+type jsonlRow struct {
+	ID     int             `json:"id"`
+	Record database.Record `json:"record"`
+}
+
+func NewJSONLEngine(dir string) *JSONLEngine {
+	makeDir(dir)
+	return &JSONLEngine{Dir: dir}
+}
+
+func NewJSONLStorage(basePath string) *Storage {
+	return New(NewJSONLEngine(basePath), basePath)
+}
+
+func (e *JSONLEngine) Save(table *database.Table) error {
+	return writeFileAtomic(e.Dir, table.Name+jsonlSuffix, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+		encoder := json.NewEncoder(writer)
+		if err := encoder.Encode(jsonlHeader{Schema: newTableSchema(table), NextID: table.NextID}); err != nil {
+			return err
+		}
+
+		ids := make([]int, 0, len(table.Records))
+		for id := range table.Records {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			if err := encoder.Encode(jsonlRow{ID: id, Record: table.Records[id]}); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
+
+func (e *JSONLEngine) Load(name string) (*database.Table, error) {
+	file, err := os.Open(filepath.Join(e.Dir, name+jsonlSuffix))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var header jsonlHeader
+	if err := decoder.Decode(&header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("файл с таблицей пуст")
+		}
+		return nil, err
+	}
+	columns, err := header.Schema.columns()
+	if err != nil {
+		return nil, err
+	}
+	table := database.NewTableFromColumns(name, columns)
+	table.NextID = header.NextID
+
+	for line := 2; ; line++ {
+		var row jsonlRow
+		err := decoder.Decode(&row)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s, строка %d: %w", name+jsonlSuffix, line, err)
+		}
+		if row.Record == nil {
+			row.Record = make(database.Record)
+		}
+		table.Records[row.ID] = row.Record
+		if row.ID >= table.NextID {
+			table.NextID = row.ID + 1
+		}
+	}
+
+	addIndexes(table, header.Schema.Indexes)
+	return table, nil
+}
+
+func (e *JSONLEngine) List() ([]string, error) {
+	return listFiles(e.Dir, jsonlSuffix)
+}
+
+func (e *JSONLEngine) Exists(name string) bool {
+	_, err := os.Stat(filepath.Join(e.Dir, name+jsonlSuffix))
+	return err == nil
+}
+
+func (e *JSONLEngine) Delete(name string) error {
+	return removeFiles(e.Dir, name+jsonlSuffix)
+}
+
+func (e *JSONLEngine) RemoveTempFiles() ([]string, error) {
+	return removeTempFiles(e.Dir)
+}
@@ -0,0 +1,609 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"v4/database"
+)
+
+const (
+	rlogSuffix = ".rlog"
+
+	// DefaultCompactThreshold is the amount of dead entries in a table log
+	// that makes it worth compacting.
+	DefaultCompactThreshold = 1 << 20
+
+	opSchema ChangeOp = "schema"
+)
+
+// logEntry is a line of a table log. The first entry of a log is its
+// schema; later schema entries replace it.
+type logEntry struct {
+	Op     ChangeOp        `json:"op"`
+	ID     int             `json:"id,omitempty"`
+	Record database.Record `json:"record,omitempty"`
+	Schema *tableSchema    `json:"schema,omitempty"`
+	NextID int             `json:"next_id,omitempty"`
+}
+
+// entryRef is where an entry lies in a table log.
+type entryRef struct {
+	offset int64
+	size   int64
+}
+
+// tableLog is an open table log with the index of its live records.
+type tableLog struct {
+	path   string
+	file   *os.File
+	size   int64
+	schema tableSchema
+	nextID int
+	index  map[int]entryRef
+	// live is the size of the entries still needed: the records in the
+	// index and the last schema.
+	live       int64
+	schemaSize int64
+
+	// gen changes whenever the file is replaced, which cancels a
+	// compaction that started before.
+	gen        int
+	compacting bool
+	closed     bool
+}
+
+// LogEngine saves each table as an append-only log of record versions. A
+// checkpoint appends the changed records, and a background goroutine
+// rewrites a log without its dead entries once they pass CompactThreshold
+// and make up at least half of it.
+type LogEngine struct {
+	Dir              string
+	CompactThreshold int64
+
+	mu     sync.Mutex
+	tables map[string]*tableLog
+
+	compactions chan string
+	done        chan struct{}
+	stop        sync.Once
+	wg          sync.WaitGroup
+	// compacted receives the name of every compacted table, for tests.
+	compacted func(name string, err error)
+}
+
+func NewLogEngine(dir string, compactThreshold int64) *LogEngine {
+	makeDir(dir)
+	e := &LogEngine{
+		Dir:              dir,
+		CompactThreshold: compactThreshold,
+		tables:           make(map[string]*tableLog),
+		compactions:      make(chan string, 16),
+		done:             make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.compactLoop()
+	return e
+}
+
+func NewLogStorage(basePath string) *Storage {
+	return New(NewLogEngine(basePath, DefaultCompactThreshold), basePath)
+}
+
+func (e *LogEngine) logPath(name string) string {
+	return filepath.Join(e.Dir, name+rlogSuffix)
+}
+
+func encodeEntry(entry logEntry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, "%08x %s\n", crc32.ChecksumIEEE(data), data), nil
+}
+
+func decodeEntry(line []byte) (logEntry, bool) {
+	var entry logEntry
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, data, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return entry, false
+	}
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(sum), "%08x", &checksum); err != nil || checksum != crc32.ChecksumIEEE(data) {
+		return entry, false
+	}
+	return entry, json.Unmarshal(data, &entry) == nil
+}
+
+// writeTableLog writes a compact log of the table: its schema followed by
+// its records in id order.
+func writeTableLog(w io.Writer, table *database.Table) error {
+	schema := newTableSchema(table)
+	line, err := encodeEntry(logEntry{Op: opSchema, Schema: &schema, NextID: table.NextID})
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(w)
+	if _, err := writer.Write(line); err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(table.Records))
+	for id := range table.Records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		line, err := encodeEntry(logEntry{Op: OpPut, ID: id, Record: table.Records[id]})
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// openTableLog scans a table log to rebuild its index. A torn last entry
+// left by a crash is cut off.
+func openTableLog(path string) (*tableLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	t := &tableLog{path: path, file: file, index: make(map[int]entryRef)}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		entry, ok := decodeEntry(line)
+		if !ok {
+			break
+		}
+		t.add(entry, entryRef{offset: t.size, size: int64(len(line))})
+	}
+	if t.schema.Columns == nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: нет схемы таблицы", filepath.Base(path))
+	}
+	if err := file.Truncate(t.size); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// add updates the index with an entry that lies at ref.
+func (t *tableLog) add(entry logEntry, ref entryRef) {
+	t.size = ref.offset + ref.size
+	switch entry.Op {
+	case opSchema:
+		t.schema = *entry.Schema
+		t.live += ref.size - t.schemaSize
+		t.schemaSize = ref.size
+		if entry.NextID > t.nextID {
+			t.nextID = entry.NextID
+		}
+	case OpPut:
+		if old, exist := t.index[entry.ID]; exist {
+			t.live -= old.size
+		}
+		t.index[entry.ID] = ref
+		t.live += ref.size
+		if entry.ID >= t.nextID {
+			t.nextID = entry.ID + 1
+		}
+	case OpDelete:
+		if old, exist := t.index[entry.ID]; exist {
+			t.live -= old.size
+			delete(t.index, entry.ID)
+		}
+	case OpTruncate:
+		t.index = make(map[int]entryRef)
+		t.live = t.schemaSize
+		t.nextID = 1
+	}
+}
+
+func (t *tableLog) append(entry logEntry) error {
+	line, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := t.file.WriteAt(line, t.size); err != nil {
+		return err
+	}
+	t.add(entry, entryRef{offset: t.size, size: int64(len(line))})
+	return nil
+}
+
+func (t *tableLog) read(ref entryRef) (logEntry, error) {
+	line := make([]byte, ref.size)
+	if _, err := t.file.ReadAt(line, ref.offset); err != nil {
+		return logEntry{}, err
+	}
+	entry, ok := decodeEntry(line)
+	if !ok {
+		return entry, fmt.Errorf("%s: повреждённая запись по смещению %d", filepath.Base(t.path), ref.offset)
+	}
+	return entry, nil
+}
+
+func (t *tableLog) close() error {
+	t.closed = true
+	t.gen++
+	return t.file.Close()
+}
+
+// table returns the open log of a table, opening it on first use.
+func (e *LogEngine) table(name string) (*tableLog, error) {
+	if t, exist := e.tables[name]; exist {
+		return t, nil
+	}
+	t, err := openTableLog(e.logPath(name))
+	if err != nil {
+		return nil, err
+	}
+	e.tables[name] = t
+	return t, nil
+}
+
+func (e *LogEngine) closeTable(name string) error {
+	t, exist := e.tables[name]
+	if !exist {
+		return nil
+	}
+	delete(e.tables, name)
+	return t.close()
+}
+
+// Save rewrites the whole table into a new log.
+func (e *LogEngine) Save(table *database.Table) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.save(table)
+}
+
+func (e *LogEngine) save(table *database.Table) error {
+	if err := e.closeTable(table.Name); err != nil {
+		return err
+	}
+	return writeFileAtomic(e.Dir, table.Name+rlogSuffix, func(w io.Writer) error {
+		return writeTableLog(w, table)
+	})
+}
+
+// Load reads the live records through the index.
+func (e *LogEngine) Load(name string) (*database.Table, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.load(name)
+}
+
+func (e *LogEngine) load(name string) (*database.Table, error) {
+	t, err := e.table(name)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := t.schema.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	table := database.NewTableFromColumns(name, columns)
+	table.NextID = t.nextID
+	for id, ref := range t.index {
+		entry, err := t.read(ref)
+		if err != nil {
+			return nil, err
+		}
+		record := make(database.Record, len(table.Fields))
+		for _, field := range table.Fields {
+			record[field] = entry.Record[field]
+		}
+		table.Records[id] = record
+	}
+	addIndexes(table, t.schema.Indexes)
+	return table, nil
+}
+
+func (e *LogEngine) List() ([]string, error) {
+	return listFiles(e.Dir, rlogSuffix)
+}
+
+func (e *LogEngine) Exists(name string) bool {
+	_, err := os.Stat(e.logPath(name))
+	return err == nil
+}
+
+func (e *LogEngine) Delete(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.closeTable(name); err != nil {
+		return err
+	}
+	return removeFiles(e.Dir, name+rlogSuffix)
+}
+
+func (e *LogEngine) RemoveTempFiles() ([]string, error) {
+	return removeTempFiles(e.Dir)
+}
+
+// Apply appends the changes to the table logs and syncs them. Like
+// replaying the log, it skips changes to tables that do not exist and may
+// run again over its own results.
+func (e *LogEngine) Apply(changes []Change) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	touched := make(map[*tableLog]bool)
+	for _, change := range changes {
+		t, err := e.apply(change)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			touched[t] = true
+		}
+	}
+
+	for t := range touched {
+		if t.closed {
+			continue
+		}
+		if err := syncFile(t.file); err != nil {
+			return err
+		}
+		if e.needsCompaction(t) {
+			e.scheduleCompaction(t)
+		}
+	}
+	return nil
+}
+
+// apply returns the table log it appended to, if any.
+func (e *LogEngine) apply(change Change) (*tableLog, error) {
+	switch change.Op {
+	case OpCreateTable:
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return nil, err
+		}
+		table := database.NewTableFromColumns(change.Table, columns)
+		addIndexes(table, change.Schema.Indexes)
+		return nil, e.save(table)
+	case OpPut, OpDelete, OpTruncate, OpCreateIndex, OpDropIndex:
+		t, err := e.table(change.Table)
+		if err != nil {
+			return nil, err
+		}
+		switch change.Op {
+		case OpPut:
+			return t, t.append(logEntry{Op: OpPut, ID: change.ID, Record: change.Record})
+		case OpDelete:
+			if _, exist := t.index[change.ID]; !exist {
+				return nil, nil
+			}
+			return t, t.append(logEntry{Op: OpDelete, ID: change.ID})
+		case OpTruncate:
+			return t, t.append(logEntry{Op: OpTruncate})
+		}
+		schema := t.schema
+		schema.Indexes = nil
+		for _, index := range t.schema.Indexes {
+			if index.Name != change.Index.Name {
+				schema.Indexes = append(schema.Indexes, index)
+			}
+		}
+		if change.Op == OpCreateIndex {
+			schema.Indexes = append(schema.Indexes, *change.Index)
+		}
+		return t, t.append(logEntry{Op: opSchema, Schema: &schema})
+	case OpAlterTable:
+		table, err := e.load(change.Table)
+		if err != nil {
+			return nil, err
+		}
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return nil, err
+		}
+		return nil, e.save(table.Migrate(columns, change.Renamed))
+	case OpRenameTable:
+		if !e.Exists(change.Table) {
+			return nil, nil
+		}
+		if err := e.closeTable(change.Table); err != nil {
+			return nil, err
+		}
+		if err := e.closeTable(change.NewName); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(e.logPath(change.Table), e.logPath(change.NewName)); err != nil {
+			return nil, err
+		}
+		return nil, syncDir(e.Dir)
+	case OpDropTable:
+		if err := e.closeTable(change.Table); err != nil {
+			return nil, err
+		}
+		return nil, removeFiles(e.Dir, change.Table+rlogSuffix)
+	}
+	return nil, fmt.Errorf("неизвестная операция журнала %s", change.Op)
+}
+
+func (e *LogEngine) needsCompaction(t *tableLog) bool {
+	dead := t.size - t.live
+	return dead >= e.CompactThreshold && dead >= t.live
+}
+
+func (e *LogEngine) scheduleCompaction(t *tableLog) {
+	if t.compacting {
+		return
+	}
+	name := filepath.Base(t.path)
+	name = name[:len(name)-len(rlogSuffix)]
+	select {
+	case e.compactions <- name:
+		t.compacting = true
+	default:
+		// The queue is full; the next checkpoint asks again.
+	}
+}
+
+func (e *LogEngine) compactLoop() {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.done:
+			return
+		case name := <-e.compactions:
+			err := e.compact(name)
+			if e.compacted != nil {
+				e.compacted(name, err)
+			}
+		}
+	}
+}
+
+// compact rewrites a table log with only its live entries. The entries
+// are copied without holding the lock, since appends never change them;
+// whatever was appended meanwhile is copied after, under the lock.
+func (e *LogEngine) compact(name string) error {
+	e.mu.Lock()
+	t, exist := e.tables[name]
+	if !exist || t.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	gen, size, schema, nextID := t.gen, t.size, t.schema, t.nextID
+	refs := make([]entryRef, 0, len(t.index))
+	for _, ref := range t.index {
+		refs = append(refs, ref)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].offset < refs[j].offset })
+	temp, err := os.CreateTemp(e.Dir, name+rlogSuffix+".*"+tempSuffix)
+	if err != nil {
+		e.finishCompaction(t)
+		return err
+	}
+	err = e.copyLive(t, temp, schema, nextID, refs)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t.compacting = false
+	if t.gen != gen {
+		// The table was rewritten, renamed or dropped in the meantime, which
+		// may also have failed the copy.
+		err = errCompactionCanceled
+	}
+	if err == nil {
+		err = e.swapCompacted(name, t, temp, size)
+	}
+	if err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		if errors.Is(err, errCompactionCanceled) {
+			return nil
+		}
+	}
+	return err
+}
+
+var errCompactionCanceled = errors.New("сжатие отменено")
+
+func (e *LogEngine) finishCompaction(t *tableLog) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t.compacting = false
+}
+
+func (e *LogEngine) copyLive(t *tableLog, w io.Writer, schema tableSchema, nextID int, refs []entryRef) error {
+	writer := bufio.NewWriter(w)
+	line, err := encodeEntry(logEntry{Op: opSchema, Schema: &schema, NextID: nextID})
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(line); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		line := make([]byte, ref.size)
+		if _, err := t.file.ReadAt(line, ref.offset); err != nil {
+			return err
+		}
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// swapCompacted appends the entries written since the copy started, puts
+// the new log in place of the old one and reopens it.
+func (e *LogEngine) swapCompacted(name string, t *tableLog, temp *os.File, copied int64) error {
+	if tail := t.size - copied; tail > 0 {
+		if _, err := io.Copy(temp, io.NewSectionReader(t.file, copied, tail)); err != nil {
+			return err
+		}
+	}
+	if err := syncFile(temp); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), t.path); err != nil {
+		return err
+	}
+	if err := syncDir(e.Dir); err != nil {
+		return err
+	}
+
+	_ = e.closeTable(name)
+	_, err := e.table(name)
+	return err
+}
+
+// Close stops compaction and closes the table logs.
+func (e *LogEngine) Close() error {
+	e.stop.Do(func() { close(e.done) })
+	e.wg.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var err error
+	for name := range e.tables {
+		if closeErr := e.closeTable(name); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
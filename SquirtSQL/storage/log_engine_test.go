@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"v4/database"
+)
+
+func TestLogEngine_AppendsAndRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewLogEngine(dir, DefaultCompactThreshold)
+	if err := engine.Save(pagesTable(100)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	path := filepath.Join(dir, "users"+rlogSuffix)
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []Change{
+		PutChange("users", 5, database.Record{"name": "renamed", "age": "5"}),
+		PutChange("users", 101, database.Record{"name": "new", "age": "1"}),
+		DeleteChange("users", 7),
+		DeleteChange("users", 1000),
+		DropIndexChange("users", "users_age"),
+	}
+	if err := engine.Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, saved) {
+		t.Fatal("Apply() rewrote the log instead of appending")
+	}
+	if lines := bytes.Count(data[len(saved):], []byte("\n")); lines != 4 {
+		t.Errorf("Apply() appended %d entries, want 4", lines)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened := NewLogEngine(dir, DefaultCompactThreshold)
+	defer reopened.Close()
+	loaded, err := reopened.Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	log := reopened.tables["users"]
+	if len(log.index) != 100 || log.size != int64(len(data)) {
+		t.Errorf("rebuilt index has %d rows over %d bytes, want 100 over %d", len(log.index), log.size, len(data))
+	}
+	if len(loaded.Records) != 100 || loaded.NextID != 102 {
+		t.Errorf("loaded %d records, NextID %d", len(loaded.Records), loaded.NextID)
+	}
+	if loaded.Records[5]["name"] != "renamed" || loaded.Records[101]["name"] != "new" {
+		t.Errorf("rows = %v, %v", loaded.Records[5], loaded.Records[101])
+	}
+	if _, exist := loaded.Records[7]; exist {
+		t.Error("deleted row is back")
+	}
+	if len(loaded.Indexes) != 0 {
+		t.Errorf("indexes = %v, want none", loaded.Indexes)
+	}
+}
+
+func TestLogEngine_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewLogEngine(dir, DefaultCompactThreshold)
+	if err := engine.Save(pagesTable(10)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := engine.Apply([]Change{PutChange("users", 3, database.Record{"name": "kept", "age": "3"})}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	path := filepath.Join(dir, "users"+rlogSuffix)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := encodeEntry(logEntry{Op: OpPut, ID: 4, Record: database.Record{"name": "torn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, line[:len(line)/2]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	engine = NewLogEngine(dir, DefaultCompactThreshold)
+	defer engine.Close()
+	loaded, err := engine.Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Records[3]["name"] != "kept" || loaded.Records[4]["name"] != "user4" {
+		t.Errorf("rows = %v, %v", loaded.Records[3], loaded.Records[4])
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Errorf("log size after open = %d, want torn entry cut to %d", info.Size(), len(data))
+	}
+}
+
+func TestLogEngine_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewLogEngine(dir, 4096)
+	defer engine.Close()
+	done := make(chan error, 1)
+	engine.compacted = func(name string, err error) {
+		select {
+		case done <- err:
+		default:
+		}
+	}
+
+	if err := engine.Save(pagesTable(50)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	path := filepath.Join(dir, "users"+rlogSuffix)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating the same rows over and over leaves only dead entries behind,
+	// until the background compaction drops them.
+	for round := 1; round <= 20; round++ {
+		var changes []Change
+		for id := 1; id <= 50; id++ {
+			changes = append(changes, PutChange("users", id, database.Record{"name": "round" + strconv.Itoa(round), "age": "1"}))
+		}
+		if err := engine.Apply(changes); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("compaction error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("log was not compacted")
+	}
+	// Later rounds may have left new dead entries behind.
+	if err := engine.compact("users"); err != nil {
+		t.Fatalf("compact() error = %v", err)
+	}
+
+	compacted, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.Size() > 2*info.Size() {
+		t.Errorf("log is %d bytes after compaction, saved table was %d", compacted.Size(), info.Size())
+	}
+	loaded, err := engine.Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Records) != 50 || loaded.NextID != 51 || loaded.Records[1]["name"] == "user1" {
+		t.Errorf("compacted table: %d records, NextID %d, row 1 %v", len(loaded.Records), loaded.NextID, loaded.Records[1])
+	}
+	if _, exist := loaded.Indexes["users_age"]; !exist {
+		t.Error("compaction lost the index")
+	}
+}
+
+func TestLogEngine_CompactionCatchesUp(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewLogEngine(dir, DefaultCompactThreshold)
+	defer engine.Close()
+	if err := engine.Save(pagesTable(20)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := engine.Apply([]Change{DeleteChange("users", 1), DeleteChange("users", 2)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// Entries appended after the live ones were copied are moved over when
+	// the logs are swapped.
+	engine.mu.Lock()
+	log := engine.tables["users"]
+	copied := log.size
+	temp, err := os.CreateTemp(dir, "users"+rlogSuffix+".*"+tempSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := make([]entryRef, 0, len(log.index))
+	for _, ref := range log.index {
+		refs = append(refs, ref)
+	}
+	if err := engine.copyLive(log, temp, log.schema, log.nextID, refs); err != nil {
+		t.Fatalf("copyLive() error = %v", err)
+	}
+	engine.mu.Unlock()
+
+	changes := []Change{
+		PutChange("users", 21, database.Record{"name": "late", "age": "1"}),
+		DeleteChange("users", 3),
+		TruncateChange("users"),
+		PutChange("users", 1, database.Record{"name": "after truncate", "age": "2"}),
+	}
+	if err := engine.Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	engine.mu.Lock()
+	err = engine.swapCompacted("users", log, temp, copied)
+	engine.mu.Unlock()
+	if err != nil {
+		t.Fatalf("swapCompacted() error = %v", err)
+	}
+	loaded, err := engine.Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Records) != 1 || loaded.Records[1]["name"] != "after truncate" || loaded.NextID != 2 {
+		t.Errorf("records = %v, NextID %d", loaded.Records, loaded.NextID)
+	}
+}
+
+func TestLogEngine_ApplyAgain(t *testing.T) {
+	engine := NewLogEngine(t.TempDir(), DefaultCompactThreshold)
+	defer engine.Close()
+
+	table := pagesTable(0)
+	index := database.NewIndex("users_name", "name", database.IndexHash, database.TypeText)
+	changes := []Change{
+		CreateTableChange(table),
+		PutChange("users", 1, database.Record{"name": "a", "age": "1"}),
+		PutChange("users", 2, database.Record{"name": "b", "age": "2"}),
+		CreateIndexChange("users", index),
+		DeleteChange("users", 1),
+		RenameTableChange("users", "people"),
+		PutChange("people", 3, database.Record{"name": "c", "age": "3"}),
+		DropIndexChange("people", "users_age"),
+	}
+	for run := 1; run <= 2; run++ {
+		if err := engine.Apply(changes); err != nil {
+			t.Fatalf("Apply() run %d error = %v", run, err)
+		}
+	}
+
+	if engine.Exists("users") {
+		t.Error("renamed table still exists")
+	}
+	loaded, err := engine.Load("people")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Records) != 2 || loaded.Records[3]["name"] != "c" || loaded.NextID != 4 {
+		t.Errorf("records = %v, NextID %d", loaded.Records, loaded.NextID)
+	}
+	if _, exist := loaded.Indexes["users_name"]; !exist || len(loaded.Indexes) != 1 {
+		t.Errorf("indexes = %v, want only users_name", loaded.Indexes)
+	}
+}
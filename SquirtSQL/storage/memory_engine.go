@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"v4/database"
+)
+
+// MemoryEngine keeps tables in memory, for tests and throwaway databases.
+// It stores copies, so later changes to a saved table do not leak in.
+type MemoryEngine struct {
+	mu     sync.Mutex
+	tables map[string]*database.Table
+}
+
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{tables: make(map[string]*database.Table)}
+}
+
+// NewMemoryStorage returns a storage that keeps both the tables and the
+// write-ahead log in memory.
+func NewMemoryStorage() *Storage {
+	return New(NewMemoryEngine(), "")
+}
+
+func (e *MemoryEngine) Save(table *database.Table) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.tables[table.Name] = table.Clone()
+	return nil
+}
+
+func (e *MemoryEngine) Load(name string) (*database.Table, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	table, exist := e.tables[name]
+	if !exist {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+	return table.Clone(), nil
+}
+
+func (e *MemoryEngine) List() ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (e *MemoryEngine) Exists(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, exist := e.tables[name]
+	return exist
+}
+
+func (e *MemoryEngine) Delete(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.tables, name)
+	return nil
+}
+
+// memoryLog is the write-ahead log of a storage without a directory.
+// Writes always append, so Seek only reports the end.
+type memoryLog struct {
+	data []byte
+}
+
+func (l *memoryLog) Write(p []byte) (int, error) {
+	l.data = append(l.data, p...)
+	return len(p), nil
+}
+
+func (l *memoryLog) Truncate(size int64) error {
+	l.data = l.data[:size]
+	return nil
+}
+
+func (l *memoryLog) Seek(offset int64, whence int) (int64, error) {
+	return int64(len(l.data)), nil
+}
+
+func (l *memoryLog) Sync() error {
+	return nil
+}
+
+func (l *memoryLog) Close() error {
+	return nil
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"v4/database"
+)
+
+const (
+	PageSize = 4096
+
+	// A page starts with the checksum of the rest of it, the number of
+	// slots and the offset of the lowest cell.
+	pageHeaderSize = 8
+	slotSize       = 4
+
+	// maxCellSize is the largest row that fits in an empty page.
+	maxCellSize = PageSize - pageHeaderSize - slotSize
+)
+
+var errPageFull = errors.New("страница заполнена")
+
+// page is a slotted page. The slot array grows from the header and the
+// cells from the end of the page; a slot holds the offset and length of
+// its cell, and length 0 marks a free slot.
+type page []byte
+
+func newPage() page {
+	p := make(page, PageSize)
+	p.setCellStart(PageSize)
+	return p
+}
+
+func (p page) slotCount() int {
+	return int(binary.LittleEndian.Uint16(p[4:6]))
+}
+
+func (p page) setSlotCount(n int) {
+	binary.LittleEndian.PutUint16(p[4:6], uint16(n))
+}
+
+func (p page) cellStart() int {
+	return int(binary.LittleEndian.Uint16(p[6:8])) + 1
+}
+
+// setCellStart stores offset-1, since PageSize itself does not fit.
+func (p page) setCellStart(offset int) {
+	binary.LittleEndian.PutUint16(p[6:8], uint16(offset-1))
+}
+
+func (p page) slot(i int) (offset, length int) {
+	at := pageHeaderSize + i*slotSize
+	return int(binary.LittleEndian.Uint16(p[at:])), int(binary.LittleEndian.Uint16(p[at+2:]))
+}
+
+func (p page) setSlot(i, offset, length int) {
+	at := pageHeaderSize + i*slotSize
+	binary.LittleEndian.PutUint16(p[at:], uint16(offset))
+	binary.LittleEndian.PutUint16(p[at+2:], uint16(length))
+}
+
+// cell returns the data of slot i, or nil for a free slot.
+func (p page) cell(i int) []byte {
+	offset, length := p.slot(i)
+	if length == 0 {
+		return nil
+	}
+	return p[offset : offset+length]
+}
+
+// freeSpace is the number of bytes that a new cell and its slot may take
+// once the page is compacted.
+func (p page) freeSpace() int {
+	used := pageHeaderSize + p.slotCount()*slotSize
+	for i := 0; i < p.slotCount(); i++ {
+		_, length := p.slot(i)
+		used += length
+	}
+	return PageSize - used
+}
+
+func (p page) empty() bool {
+	return p.slotCount() == 0
+}
+
+// insert stores data in a free slot or a new one and returns the slot.
+func (p page) insert(data []byte) (int, error) {
+	slot := p.slotCount()
+	for i := 0; i < p.slotCount(); i++ {
+		if _, length := p.slot(i); length == 0 {
+			slot = i
+			break
+		}
+	}
+	need := len(data)
+	if slot == p.slotCount() {
+		need += slotSize
+	}
+	if need > p.freeSpace() {
+		return 0, errPageFull
+	}
+	if p.cellStart()-(pageHeaderSize+p.slotCount()*slotSize) < need {
+		p.compact()
+	}
+
+	offset := p.cellStart() - len(data)
+	copy(p[offset:], data)
+	p.setCellStart(offset)
+	if slot == p.slotCount() {
+		p.setSlotCount(slot + 1)
+	}
+	p.setSlot(slot, offset, len(data))
+	return slot, nil
+}
+
+// remove frees slot i. Trailing free slots are dropped; the space of the
+// cell is reclaimed by the next compaction.
+func (p page) remove(i int) {
+	p.setSlot(i, 0, 0)
+	n := p.slotCount()
+	for n > 0 {
+		if _, length := p.slot(n - 1); length != 0 {
+			break
+		}
+		n--
+	}
+	p.setSlotCount(n)
+	if n == 0 {
+		p.setCellStart(PageSize)
+	}
+}
+
+// compact moves the cells to the end of the page, leaving the free space
+// in one piece between the slots and the cells.
+func (p page) compact() {
+	cells := make([][]byte, p.slotCount())
+	for i := range cells {
+		if cell := p.cell(i); cell != nil {
+			cells[i] = append([]byte(nil), cell...)
+		}
+	}
+	offset := PageSize
+	for i, cell := range cells {
+		if cell == nil {
+			continue
+		}
+		offset -= len(cell)
+		copy(p[offset:], cell)
+		p.setSlot(i, offset, len(cell))
+	}
+	p.setCellStart(offset)
+}
+
+func (p page) seal() {
+	binary.LittleEndian.PutUint32(p[0:4], crc32.ChecksumIEEE(p[4:]))
+}
+
+// verify checks the checksum, so a page torn by a crash is not read as
+// rows. A page of zeroes is a page that was allocated but never written.
+func (p page) verify(no uint32) error {
+	if binary.LittleEndian.Uint32(p[0:4]) == crc32.ChecksumIEEE(p[4:]) {
+		return nil
+	}
+	for _, b := range p {
+		if b != 0 {
+			return fmt.Errorf("страница %d повреждена: неверная контрольная сумма", no)
+		}
+	}
+	p.setCellStart(PageSize)
+	return nil
+}
+
+// encodeRecord packs the id and the values of a record, in the order of
+// columns, into a cell.
+func encodeRecord(id int, columns []string, record database.Record) []byte {
+	size := binary.MaxVarintLen64
+	for _, column := range columns {
+		size += binary.MaxVarintLen64 + len(record[column])
+	}
+	cell := make([]byte, 0, size)
+	cell = binary.AppendUvarint(cell, uint64(id))
+	for _, column := range columns {
+		cell = binary.AppendUvarint(cell, uint64(len(record[column])))
+		cell = append(cell, record[column]...)
+	}
+	return cell
+}
+
+func decodeRow(cell []byte, fields int) (int, []string, error) {
+	errCell := errors.New("повреждённая запись на странице")
+	id, n := binary.Uvarint(cell)
+	if n <= 0 {
+		return 0, nil, errCell
+	}
+	cell = cell[n:]
+	values := make([]string, fields)
+	for i := range values {
+		length, n := binary.Uvarint(cell)
+		if n <= 0 || uint64(len(cell)-n) < length {
+			return 0, nil, errCell
+		}
+		values[i] = string(cell[n : n+int(length)])
+		cell = cell[n+int(length):]
+	}
+	return int(id), values, nil
+}
+
+// decodeRowID reads only the id of a cell.
+func decodeRowID(cell []byte) int {
+	id, _ := binary.Uvarint(cell)
+	return int(id)
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"v4/database"
+)
+
+const pagesSuffix = ".pages"
+
+// ChangeEngine is an engine that applies logged changes in place, so a
+// checkpoint writes only what changed instead of whole tables.
+type ChangeEngine interface {
+	Engine
+	Apply(changes []Change) error
+}
+
+// PageEngine saves each table as a heap file of fixed-size pages, with
+// the schema in the first page. Pages go through a buffer pool shared by
+// all tables, and a checkpoint rewrites only the pages of changed rows.
+//
+// Pages are written in place. A crash during a checkpoint is repaired by
+// the log, which is emptied only after the pages are synced; a page torn
+// by the crash fails its checksum and has to be restored by hand.
+type PageEngine struct {
+	Dir string
+
+	mu    sync.Mutex
+	pool  *bufferPool
+	heaps map[string]*heapFile
+}
+
+func NewPageEngine(dir string, poolPages int) *PageEngine {
+	makeDir(dir)
+	return &PageEngine{
+		Dir:   dir,
+		pool:  newBufferPool(poolPages),
+		heaps: make(map[string]*heapFile),
+	}
+}
+
+func NewPageStorage(basePath string) *Storage {
+	return New(NewPageEngine(basePath, DefaultPoolPages), basePath)
+}
+
+func (e *PageEngine) pagesPath(name string) string {
+	return filepath.Join(e.Dir, name+pagesSuffix)
+}
+
+// Save rewrites the whole table into a new file.
+func (e *PageEngine) Save(table *database.Table) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.save(table)
+}
+
+func (e *PageEngine) save(table *database.Table) error {
+	if err := e.closeHeap(table.Name); err != nil {
+		return err
+	}
+	return writeFileAtomic(e.Dir, table.Name+pagesSuffix, func(w io.Writer) error {
+		return writeHeap(w, table)
+	})
+}
+
+func (e *PageEngine) Load(name string) (*database.Table, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.load(name)
+}
+
+func (e *PageEngine) load(name string) (*database.Table, error) {
+	h, err := e.heap(name)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := h.schema.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	table := database.NewTableFromColumns(name, columns)
+	table.NextID = h.nextID
+	err = h.scan(func(id int, record database.Record) {
+		table.Records[id] = record
+		if id >= table.NextID {
+			table.NextID = id + 1
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	addIndexes(table, h.schema.Indexes)
+	return table, nil
+}
+
+// heap returns the open heap file of a table, opening it on first use.
+func (e *PageEngine) heap(name string) (*heapFile, error) {
+	if h, exist := e.heaps[name]; exist {
+		return h, nil
+	}
+	h, err := openHeap(e.pagesPath(name), e.pool)
+	if err != nil {
+		return nil, err
+	}
+	e.heaps[name] = h
+	return h, nil
+}
+
+func (e *PageEngine) closeHeap(name string) error {
+	h, exist := e.heaps[name]
+	if !exist {
+		return nil
+	}
+	delete(e.heaps, name)
+	return h.close()
+}
+
+func (e *PageEngine) List() ([]string, error) {
+	return listFiles(e.Dir, pagesSuffix)
+}
+
+func (e *PageEngine) Exists(name string) bool {
+	_, err := os.Stat(e.pagesPath(name))
+	return err == nil
+}
+
+func (e *PageEngine) Delete(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.closeHeap(name); err != nil {
+		return err
+	}
+	return removeFiles(e.Dir, name+pagesSuffix)
+}
+
+func (e *PageEngine) RemoveTempFiles() ([]string, error) {
+	return removeTempFiles(e.Dir)
+}
+
+// Apply makes the changes in the files and syncs the pages they touched.
+// Like replaying the log, it skips changes to tables that do not exist and
+// may run again over its own results.
+func (e *PageEngine) Apply(changes []Change) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, change := range changes {
+		err := e.apply(change)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range e.heaps {
+		if err := h.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *PageEngine) apply(change Change) error {
+	switch change.Op {
+	case OpCreateTable:
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return err
+		}
+		table := database.NewTableFromColumns(change.Table, columns)
+		addIndexes(table, change.Schema.Indexes)
+		return e.save(table)
+	case OpPut, OpDelete, OpTruncate, OpCreateIndex, OpDropIndex:
+		h, err := e.heap(change.Table)
+		if err != nil {
+			return err
+		}
+		switch change.Op {
+		case OpPut:
+			return h.put(change.ID, change.Record)
+		case OpDelete:
+			return h.delete(change.ID)
+		case OpTruncate:
+			return h.truncate()
+		}
+		var indexes []indexSchema
+		for _, index := range h.schema.Indexes {
+			if index.Name != change.Index.Name {
+				indexes = append(indexes, index)
+			}
+		}
+		if change.Op == OpCreateIndex {
+			indexes = append(indexes, *change.Index)
+		}
+		return h.setIndexes(indexes)
+	case OpAlterTable:
+		table, err := e.load(change.Table)
+		if err != nil {
+			return err
+		}
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return err
+		}
+		return e.save(table.Migrate(columns, change.Renamed))
+	case OpRenameTable:
+		if !e.Exists(change.Table) {
+			return nil
+		}
+		if err := e.closeHeap(change.Table); err != nil {
+			return err
+		}
+		if err := e.closeHeap(change.NewName); err != nil {
+			return err
+		}
+		if err := os.Rename(e.pagesPath(change.Table), e.pagesPath(change.NewName)); err != nil {
+			return err
+		}
+		return syncDir(e.Dir)
+	case OpDropTable:
+		if err := e.closeHeap(change.Table); err != nil {
+			return err
+		}
+		return removeFiles(e.Dir, change.Table+pagesSuffix)
+	}
+	return fmt.Errorf("неизвестная операция журнала %s", change.Op)
+}
+
+// Close writes back the cached pages and closes the files.
+func (e *PageEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var err error
+	for name := range e.heaps {
+		if closeErr := e.closeHeap(name); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"v4/database"
+)
+
+func pagesTable(rows int) *database.Table {
+	table := database.NewTableFromColumns("users", []database.Column{
+		{Name: "name", Type: database.TypeText},
+		{Name: "age", Type: database.TypeInt},
+	})
+	for id := 1; id <= rows; id++ {
+		table.Records[id] = database.Record{"name": "user" + strconv.Itoa(id), "age": strconv.Itoa(id % 90)}
+	}
+	table.NextID = rows + 1
+	table.AddIndex(database.NewIndex("users_age", "age", database.IndexOrdered, database.TypeInt))
+	return table
+}
+
+func TestPage_InsertRemoveCompact(t *testing.T) {
+	p := newPage()
+	cell := bytes.Repeat([]byte("x"), 100)
+	count := 0
+	for {
+		if _, err := p.insert(cell); err != nil {
+			break
+		}
+		count++
+	}
+	if want := (PageSize - pageHeaderSize) / (len(cell) + slotSize); count != want {
+		t.Fatalf("insert() fit %d cells, want %d", count, want)
+	}
+
+	// Two freed neighbours make room for a bigger cell only once the page
+	// is compacted.
+	p.remove(3)
+	p.remove(4)
+	big := bytes.Repeat([]byte("y"), 150)
+	slot, err := p.insert(big)
+	if err != nil {
+		t.Fatalf("insert() after remove error = %v", err)
+	}
+	if slot != 3 || !bytes.Equal(p.cell(3), big) {
+		t.Errorf("insert() slot = %d, cell = %q", slot, p.cell(3))
+	}
+	for _, i := range []int{0, 5, count - 1} {
+		if !bytes.Equal(p.cell(i), cell) {
+			t.Errorf("cell %d changed by compaction", i)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		p.remove(i)
+	}
+	if !p.empty() || p.freeSpace() != PageSize-pageHeaderSize {
+		t.Errorf("page after removing all cells: slots %d, free %d", p.slotCount(), p.freeSpace())
+	}
+}
+
+func TestPageEngine_ApplyTouchesChangedPages(t *testing.T) {
+	dir := t.TempDir()
+	storage := NewPageStorage(dir)
+	engine := storage.Engine.(*PageEngine)
+	if err := storage.SaveTable(pagesTable(2000)); err != nil {
+		t.Fatalf("SaveTable() error = %v", err)
+	}
+	if _, err := storage.LoadSnapshot("users"); err != nil {
+		t.Fatalf("LoadSnapshot() error = %v", err)
+	}
+	heap := engine.heaps["users"]
+	if heap.pages < 10 {
+		t.Fatalf("table takes %d pages, want more to test", heap.pages)
+	}
+
+	tests := []struct {
+		name       string
+		changes    []Change
+		wantWrites int
+	}{
+		{"update", []Change{PutChange("users", 1500, database.Record{"name": "renamed", "age": "1"})}, 1},
+		{"insert", []Change{PutChange("users", 2001, database.Record{"name": "new", "age": "2"})}, 2},
+		{"delete", []Change{DeleteChange("users", 10)}, 1},
+		{"rows on one page", []Change{DeleteChange("users", 11), DeleteChange("users", 12), DeleteChange("users", 13)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := storage.Log(tt.changes...); err != nil {
+				t.Fatalf("Log() error = %v", err)
+			}
+			before := heap.writes
+			applied, err := storage.ApplyLog()
+			if !applied || err != nil {
+				t.Fatalf("ApplyLog() = %v, %v", applied, err)
+			}
+			if got := heap.writes - before; got != tt.wantWrites {
+				t.Errorf("pages written = %d, want %d", got, tt.wantWrites)
+			}
+			if size := storage.LogSize(); size != 0 {
+				t.Errorf("LogSize() after ApplyLog() = %d, want 0", size)
+			}
+		})
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	loaded, err := NewPageStorage(dir).LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if len(loaded.Records) != 1997 || loaded.NextID != 2002 {
+		t.Errorf("reopened table: %d records, NextID %d", len(loaded.Records), loaded.NextID)
+	}
+	if loaded.Records[1500]["name"] != "renamed" || loaded.Records[2001]["name"] != "new" {
+		t.Errorf("reopened rows = %v, %v", loaded.Records[1500], loaded.Records[2001])
+	}
+	if _, exist := loaded.Records[10]; exist {
+		t.Error("deleted row is back")
+	}
+	if ids, _ := loaded.Indexes["users_age"].Lookup("2"); len(ids) == 0 {
+		t.Error("index was not rebuilt")
+	}
+}
+
+func TestPageEngine_FreeListAndMoves(t *testing.T) {
+	engine := NewPageEngine(t.TempDir(), DefaultPoolPages)
+	defer engine.Close()
+	if err := engine.Save(pagesTable(500)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := engine.Load("users"); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	heap := engine.heaps["users"]
+	pages := heap.pages
+
+	var changes []Change
+	for id, rid := range heap.rows {
+		if rid.page == 1 {
+			changes = append(changes, DeleteChange("users", id))
+		}
+	}
+	if err := engine.Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(heap.free) != 1 || heap.free[0] != 1 {
+		t.Fatalf("free list = %v, want [1]", heap.free)
+	}
+
+	// A row that outgrows its page moves, and new rows fill the freed page
+	// before the file grows.
+	grown := database.Record{"name": strings.Repeat("n", 3000), "age": "1"}
+	if err := engine.Apply([]Change{PutChange("users", 400, grown)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if rid := heap.rows[400]; rid.page == 1 {
+		t.Errorf("moved row went to page %d", rid.page)
+	}
+	last := 500
+	for len(heap.free) > 0 && last < 2000 {
+		last++
+		if err := engine.Apply([]Change{PutChange("users", last, database.Record{"name": "late", "age": "3"})}); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	if heap.pages != pages || len(heap.free) != 0 {
+		t.Errorf("pages = %d (was %d), free list = %v", heap.pages, pages, heap.free)
+	}
+
+	loaded, err := engine.Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.Records[400]["name"] != grown["name"] || loaded.Records[last]["name"] != "late" {
+		t.Errorf("moved row %.10v, new row %v", loaded.Records[400], loaded.Records[last])
+	}
+}
+
+func TestPageEngine_SmallBufferPool(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewPageEngine(dir, 2)
+	if err := engine.Save(pagesTable(3000)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	var changes []Change
+	for id := 1; id <= 3000; id += 7 {
+		changes = append(changes, PutChange("users", id, database.Record{"name": "changed", "age": "7"}))
+	}
+	if err := engine.Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(engine.pool.frames) > 2 {
+		t.Errorf("buffer pool holds %d pages, capacity 2", len(engine.pool.frames))
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	loaded, err := NewPageEngine(dir, 2).Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for id := 1; id <= 3000; id++ {
+		want := "user" + strconv.Itoa(id)
+		if (id-1)%7 == 0 {
+			want = "changed"
+		}
+		if got := loaded.Records[id]["name"]; got != want {
+			t.Fatalf("row %d name = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestPageEngine_ApplyAgain(t *testing.T) {
+	engine := NewPageEngine(t.TempDir(), DefaultPoolPages)
+	defer engine.Close()
+
+	table := pagesTable(0)
+	index := database.NewIndex("users_name", "name", database.IndexHash, database.TypeText)
+	changes := []Change{
+		CreateTableChange(table),
+		PutChange("users", 1, database.Record{"name": "a", "age": "1"}),
+		PutChange("users", 2, database.Record{"name": "b", "age": "2"}),
+		CreateIndexChange("users", index),
+		DeleteChange("users", 1),
+		RenameTableChange("users", "people"),
+		PutChange("people", 3, database.Record{"name": "c", "age": "3"}),
+		DropIndexChange("people", "users_age"),
+	}
+	// A crash before the log is emptied applies the same changes again.
+	for run := 1; run <= 2; run++ {
+		if err := engine.Apply(changes); err != nil {
+			t.Fatalf("Apply() run %d error = %v", run, err)
+		}
+	}
+
+	if engine.Exists("users") {
+		t.Error("renamed table still exists")
+	}
+	loaded, err := engine.Load("people")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Records) != 2 || loaded.Records[3]["name"] != "c" || loaded.NextID != 4 {
+		t.Errorf("records = %v, NextID %d", loaded.Records, loaded.NextID)
+	}
+	if _, exist := loaded.Indexes["users_name"]; !exist || len(loaded.Indexes) != 1 {
+		t.Errorf("indexes = %v, want only users_name", loaded.Indexes)
+	}
+}
+
+func TestPageEngine_TruncateKeepsSchema(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewPageEngine(dir, DefaultPoolPages)
+	if err := engine.Save(pagesTable(100)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// The new index is only in the cached header page when the table is
+	// truncated in the same checkpoint.
+	index := database.NewIndex("users_name", "name", database.IndexHash, database.TypeText)
+	changes := []Change{
+		CreateIndexChange("users", index),
+		TruncateChange("users"),
+		PutChange("users", 1, database.Record{"name": "a", "age": "1"}),
+	}
+	if err := engine.Apply(changes); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	loaded, err := NewPageEngine(dir, DefaultPoolPages).Load("users")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded.Records) != 1 || loaded.Records[1]["name"] != "a" || loaded.NextID != 2 {
+		t.Errorf("records = %v, NextID %d", loaded.Records, loaded.NextID)
+	}
+	if _, exist := loaded.Indexes["users_name"]; !exist || len(loaded.Indexes) != 2 {
+		t.Errorf("indexes = %v, want users_age and users_name", loaded.Indexes)
+	}
+}
+
+func TestPageEngine_Errors(t *testing.T) {
+	dir := t.TempDir()
+	engine := NewPageEngine(dir, DefaultPoolPages)
+	if err := engine.Save(pagesTable(100)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	huge := database.Record{"name": strings.Repeat("x", PageSize)}
+	err := engine.Apply([]Change{PutChange("users", 1, huge)})
+	if err == nil || !strings.Contains(err.Error(), "не помещается") {
+		t.Errorf("Apply() with a huge row error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	path := filepath.Join(dir, "users"+pagesSuffix)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[PageSize+100] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPageEngine(dir, DefaultPoolPages).Load("users")
+	if err == nil || !strings.Contains(err.Error(), "повреждена") {
+		t.Errorf("Load() of a torn page error = %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"v4/database"
+)
+
+type tableSchema struct {
+	Columns []columnSchema `json:"columns"`
+	Indexes []indexSchema  `json:"indexes,omitempty"`
+}
+
+// csvSchema is the schema file of the CSV engine. It also keeps NextID,
+// which the records cannot tell once the last of them are deleted.
+type csvSchema struct {
+	tableSchema
+	NextID int `json:"next_id,omitempty"`
+}
+
+type indexSchema struct {
+	Name   string             `json:"name"`
+	Column string             `json:"column"`
+	Kind   database.IndexKind `json:"kind"`
+}
+
+type columnSchema struct {
+	Name    string              `json:"name"`
+	Type    database.ColumnType `json:"type"`
+	NotNull bool                `json:"not_null,omitempty"`
+	Unique  bool                `json:"unique,omitempty"`
+	Default *string             `json:"default,omitempty"`
+	Check   string              `json:"check,omitempty"`
+}
+
+const schemaSuffix = ".schema.json"
+
+func newTableSchema(table *database.Table) tableSchema {
+	schema := tableSchema{}
+	for _, column := range table.Schema() {
+		stored := columnSchema{
+			Name:    column.Name,
+			Type:    column.Type,
+			NotNull: column.NotNull,
+			Unique:  column.Unique,
+			Check:   column.Check,
+		}
+		if column.HasDefault {
+			value := column.Default
+			stored.Default = &value
+		}
+		schema.Columns = append(schema.Columns, stored)
+	}
+	for _, index := range table.IndexList() {
+		schema.Indexes = append(schema.Indexes, indexSchema{
+			Name:   index.Name,
+			Column: index.Column,
+			Kind:   index.Kind,
+		})
+	}
+	return schema
+}
+
+// schemaFile names the schema of the CSV file with the given checksum.
+// The CSV file is replaced by a single rename that switches it to the
+// schema written before it, so the pair cannot be torn by a crash.
+func schemaFile(name string, sum uint32) string {
+	return fmt.Sprintf("%s.%08x%s", name, sum, schemaSuffix)
+}
+
+// schemaFiles returns the schema files of a table: its versions and the
+// file of older saves, named after the table alone.
+func schemaFiles(dir, name string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.Name() == name+schemaSuffix {
+			names = append(names, file.Name())
+			continue
+		}
+		version, ok := strings.CutPrefix(file.Name(), name+".")
+		if !ok {
+			continue
+		}
+		sum, ok := strings.CutSuffix(version, schemaSuffix)
+		if _, err := strconv.ParseUint(sum, 16, 32); ok && len(sum) == 8 && err == nil {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+func saveSchema(dir, file string, schema csvSchema) error {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, file, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func readSchema(dir, file string) (csvSchema, error) {
+	var schema csvSchema
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return schema, err
+	}
+	err = json.Unmarshal(data, &schema)
+	return schema, err
+}
+
+// loadSchema returns the schema of a CSV file with the given fields and
+// checksum. A file without a schema has only TEXT columns.
+func (e *CSVEngine) loadSchema(name string, sum uint32, fields []string) ([]database.Column, csvSchema, error) {
+	columns := database.TextColumns(fields)
+
+	schema, err := readSchema(e.Dir, schemaFile(name, sum))
+	if errors.Is(err, os.ErrNotExist) {
+		schema, err = readSchema(e.Dir, name+schemaSuffix)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return columns, csvSchema{}, nil
+	}
+	if err != nil {
+		return nil, csvSchema{}, err
+	}
+	stored, err := schema.columns()
+	if err != nil {
+		return nil, csvSchema{}, err
+	}
+	byName := make(map[string]database.Column, len(stored))
+	for _, column := range stored {
+		byName[column.Name] = column
+	}
+	for i := range columns {
+		if column, ok := byName[columns[i].Name]; ok {
+			columns[i] = column
+		}
+	}
+	return columns, schema, nil
+}
+
+func (schema tableSchema) columns() ([]database.Column, error) {
+	columns := make([]database.Column, 0, len(schema.Columns))
+	for _, column := range schema.Columns {
+		columnType, err := database.ParseColumnType(string(column.Type))
+		if err != nil {
+			return nil, err
+		}
+		loaded := database.Column{
+			Name:    column.Name,
+			Type:    columnType,
+			NotNull: column.NotNull,
+			Unique:  column.Unique,
+			Check:   column.Check,
+		}
+		if column.Default != nil {
+			loaded.HasDefault = true
+			loaded.Default = *column.Default
+		}
+		columns = append(columns, loaded)
+	}
+	return columns, nil
+}
+
+func addIndexes(table *database.Table, indexes []indexSchema) {
+	for _, index := range indexes {
+		if !table.HasField(index.Column) {
+			continue
+		}
+		table.AddIndex(database.NewIndex(index.Name, index.Column, index.Kind, table.Column(index.Column).Type))
+	}
+}
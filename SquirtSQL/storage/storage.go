@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"v4/database"
+)
+
+// Storage keeps the tables of a database: an engine holds them as of the
+// last checkpoint and the write-ahead log holds the changes since.
+type Storage struct {
+	// BasePath is the directory of the write-ahead log. Without one the
+	// log is kept in memory.
+	BasePath string
+	Engine   Engine
+	Mu       sync.Mutex
+
+	wal    logFile
+	memLog *memoryLog
+}
+
+func New(engine Engine, basePath string) *Storage {
+	s := &Storage{BasePath: basePath, Engine: engine}
+	if basePath == "" {
+		s.memLog = &memoryLog{}
+	} else {
+		makeDir(basePath)
+	}
+	return s
+}
+
+func makeDir(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		_ = os.MkdirAll(path, 0755)
+	}
+}
+
+// SaveTable replaces the snapshot of the table with its current state.
+func (s *Storage) SaveTable(table *database.Table) error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.Engine.Save(table)
+}
+
+// LoadTable returns the table as of the last logged change: its snapshot
+// with the write-ahead log applied on top.
+func (s *Storage) LoadTable(name string) (*database.Table, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	changes, _, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make(map[string]*database.Table)
+	snapshotErr := fmt.Errorf("%w: %s", database.ErrTableNotFound, name)
+	if source := snapshotOf(name, changes); source != "" {
+		table, err := s.Engine.Load(source)
+		if err == nil {
+			tables[source] = table
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		} else {
+			snapshotErr = err
+		}
+	}
+	for _, change := range changes {
+		if err := applyChange(tables, change); err != nil {
+			return nil, err
+		}
+	}
+	if table, exist := tables[name]; exist {
+		return table, nil
+	}
+	return nil, snapshotErr
+}
+
+// LoadSnapshot reads the table as it was at the last checkpoint, without
+// the changes logged since.
+func (s *Storage) LoadSnapshot(name string) (*database.Table, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.Engine.Load(name)
+}
+
+func (s *Storage) TableExist(name string) bool {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	names, _ := s.tableNames()
+	for _, table := range names {
+		if table == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) ListTables() ([]string, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.tableNames()
+}
+
+// ListSnapshots lists the tables saved at the last checkpoint.
+func (s *Storage) ListSnapshots() ([]string, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.Engine.List()
+}
+
+// RemoveSnapshot deletes the snapshot of a table that no longer exists.
+func (s *Storage) RemoveSnapshot(name string) error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.Engine.Delete(name)
+}
+
+// RemoveTempFiles deletes temp files left by saves interrupted by a crash
+// and returns their names.
+func (s *Storage) RemoveTempFiles() ([]string, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	if cleaner, ok := s.Engine.(tempCleaner); ok {
+		return cleaner.RemoveTempFiles()
+	}
+	return nil, nil
+}
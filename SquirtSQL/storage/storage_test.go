@@ -0,0 +1,580 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"v4/database"
+)
+
+type testEngine struct {
+	name string
+	open func(dir string) *Storage
+}
+
+// The storage tests run against every engine; the tests of files and
+// crashes only against those that keep tables in files.
+var (
+	fileEngines = []testEngine{
+		{EngineCSV, NewCSVStorage},
+		{EngineJSONL, NewJSONLStorage},
+		{EngineLog, NewLogStorage},
+		{EnginePages, NewPageStorage},
+	}
+	engines = append([]testEngine{
+		{EngineMemory, func(string) *Storage { return NewMemoryStorage() }},
+	}, fileEngines...)
+)
+
+func TestOpen(t *testing.T) {
+	for _, name := range Engines {
+		storage, err := Open(name, t.TempDir(), DefaultCompactThreshold)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", name, err)
+		}
+		if storage.Engine == nil {
+			t.Errorf("Open(%s) has no engine", name)
+		}
+		_ = storage.Close()
+	}
+	if _, err := Open("xml", t.TempDir(), DefaultCompactThreshold); err == nil {
+		t.Error("Open() expected error for an unknown engine")
+	}
+
+	storage, err := Open(EngineLog, t.TempDir(), 4096)
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", EngineLog, err)
+	}
+	defer storage.Close()
+	if got := storage.Engine.(*LogEngine).CompactThreshold; got != 4096 {
+		t.Errorf("CompactThreshold = %d, want 4096", got)
+	}
+	if _, err := Open(EngineLog, t.TempDir(), 0); err == nil {
+		t.Error("Open() expected error for a zero compaction threshold")
+	}
+}
+
+func TestNewCSVStorage(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testPath := filepath.Join(tempDir, "newdir")
+	storage := NewCSVStorage(testPath)
+
+	if _, err := os.Stat(testPath); os.IsNotExist(err) {
+		t.Errorf("NewCSVStorage() didn't create directory")
+	}
+
+	if storage.BasePath != testPath {
+		t.Errorf("NewCSVStorage() BasePath = %v, want %v", storage.BasePath, testPath)
+	}
+}
+
+func TestStorage_SaveAndLoadTable(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			fields := []string{"name", "age"}
+			table := database.NewTable("users", fields)
+
+			table.Records[1] = database.Record{"name": "Kolya", "age": "22"}
+			table.Records[2] = database.Record{"name": "Anonim", "age": "25"}
+			table.NextID = 3
+
+			err := storage.SaveTable(table)
+			if err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+
+			if !storage.Engine.Exists("users") {
+				t.Errorf("SaveTable() didn't save the table")
+			}
+
+			loadedTable, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+
+			if loadedTable.Name != "users" {
+				t.Errorf("LoadTable() table name = %v, want %v", loadedTable.Name, "users")
+			}
+
+			if len(loadedTable.Fields) != len(fields) {
+				t.Errorf("LoadTable() fields count = %v, want %v", len(loadedTable.Fields), len(fields))
+			}
+
+			if len(loadedTable.Records) != 2 {
+				t.Errorf("LoadTable() records count = %v, want %v", len(loadedTable.Records), 2)
+			}
+
+			if loadedTable.NextID != 3 {
+				t.Errorf("LoadTable() NextID = %v, want %v", loadedTable.NextID, 3)
+			}
+		})
+	}
+}
+
+func TestStorage_NextIDAfterDeletes(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			storage := engine.open(t.TempDir())
+
+			table := database.NewTable("users", []string{"name"})
+			table.Records[1] = database.Record{"name": "Kolya"}
+			table.NextID = 5
+			if err := storage.SaveTable(table); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+
+			loaded, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+			if loaded.NextID != 5 {
+				t.Errorf("LoadTable() NextID = %d, want 5: ids of deleted rows must not be reused", loaded.NextID)
+			}
+		})
+	}
+}
+
+func TestStorage_TableExist(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			if storage.TableExist("nonexistent") {
+				t.Errorf("TableExist() returned true for nonexistent table")
+			}
+
+			table := database.NewTable("test", []string{"field"})
+			err := storage.SaveTable(table)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !storage.TableExist("test") {
+				t.Errorf("TableExist() returned false for existing table")
+			}
+		})
+	}
+}
+
+func TestStorage_ListTables(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			tables := []string{"users", "products", "orders"}
+			for _, name := range tables {
+				table := database.NewTable(name, []string{"field"})
+				if err := storage.SaveTable(table); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			list, err := storage.ListTables()
+			if err != nil {
+				t.Fatalf("ListTables() error = %v", err)
+			}
+
+			if len(list) != len(tables) {
+				t.Errorf("ListTables() returned %d tables, want %d", len(list), len(tables))
+			}
+
+			for _, name := range tables {
+				found := false
+				for _, tableName := range list {
+					if tableName == name {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("ListTables() missing table %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestStorage_SchemaRoundTrip(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			table := database.NewTableFromColumns("users", []database.Column{
+				{Name: "name", Type: database.TypeText, NotNull: true, Unique: true},
+				{Name: "age", Type: database.TypeInt, HasDefault: true, Default: "18", Check: "age >= 0"},
+				{Name: "born", Type: database.TypeDate, HasDefault: true, Default: ""},
+			})
+			table.Records[1] = database.Record{"name": "Kolya", "age": "22", "born": "2002-02-02"}
+
+			if err := storage.SaveTable(table); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+
+			loaded, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+			for _, column := range table.Schema() {
+				if got := loaded.Column(column.Name); got != column {
+					t.Errorf("LoadTable() column = %v, want %v", got, column)
+				}
+			}
+
+			list, err := storage.ListTables()
+			if err != nil {
+				t.Fatalf("ListTables() error = %v", err)
+			}
+			if len(list) != 1 {
+				t.Errorf("ListTables() should ignore schema files, got %v", list)
+			}
+		})
+	}
+}
+
+func TestCSVStorage_LoadWithoutSchema(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := NewCSVStorage(tempDir)
+
+	content := "id,name,age\n1,Kolya,22\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "legacy.csv"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.LoadTable("legacy")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if got := loaded.Column("age").Type; got != database.TypeText {
+		t.Errorf("LoadTable() untyped column type = %v, want %v", got, database.TypeText)
+	}
+}
+
+func TestCSVStorage_SchemaMatchesRecords(t *testing.T) {
+	tempDir := t.TempDir()
+	storage := NewCSVStorage(tempDir)
+
+	table := database.NewTable("users", []string{"age"})
+	table.Records[1] = database.Record{"age": "old"}
+	if err := storage.SaveTable(table); err != nil {
+		t.Fatalf("SaveTable() error = %v", err)
+	}
+
+	// The new schema is written, but the records are not.
+	defer func(defaultWriter func(string, io.Writer) io.Writer) { wrapTempWriter = defaultWriter }(wrapTempWriter)
+	wrapTempWriter = func(name string, w io.Writer) io.Writer {
+		if strings.HasSuffix(name, csvSuffix) {
+			return &failingWriter{w: w}
+		}
+		return w
+	}
+	typed := database.NewTableFromColumns("users", []database.Column{{Name: "age", Type: database.TypeInt}})
+	typed.Records[1] = database.Record{"age": "22"}
+	if err := storage.SaveTable(typed); err == nil {
+		t.Fatal("SaveTable() expected error")
+	}
+
+	loaded, err := storage.LoadTable("users")
+	if err != nil {
+		t.Fatalf("LoadTable() error = %v", err)
+	}
+	if got := loaded.Column("age").Type; got != database.TypeText || loaded.Records[1]["age"] != "old" {
+		t.Errorf("LoadTable() = %s column with %v, want the TEXT column of the saved records", got, loaded.Records)
+	}
+
+	wrapTempWriter = func(name string, w io.Writer) io.Writer { return w }
+	if err := storage.SaveTable(typed); err != nil {
+		t.Fatalf("SaveTable() error = %v", err)
+	}
+	if files, _ := schemaFiles(tempDir, "users"); len(files) != 1 {
+		t.Errorf("schema files = %v, want only the current one", files)
+	}
+	if err := storage.Engine.Delete("users"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if files, _ := os.ReadDir(tempDir); len(files) != 0 {
+		t.Errorf("Delete() left %d files", len(files))
+	}
+}
+
+func TestStorage_IndexRoundTrip(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			table := database.NewTableFromColumns("users", []database.Column{
+				{Name: "email", Type: database.TypeText},
+				{Name: "age", Type: database.TypeInt},
+			})
+			table.Records[1] = database.Record{"email": "a@mail.ru", "age": "22"}
+			table.Records[2] = database.Record{"email": "b@mail.ru", "age": "19"}
+			table.AddIndex(database.NewIndex("users_email", "email", database.IndexHash, database.TypeText))
+			table.AddIndex(database.NewIndex("users_age", "age", database.IndexOrdered, database.TypeInt))
+
+			if err := storage.SaveTable(table); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+			loaded, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+
+			if len(loaded.Indexes) != 2 {
+				t.Fatalf("LoadTable() indexes = %v, want 2", loaded.Indexes)
+			}
+			if index := loaded.Indexes["users_email"]; index.Kind != database.IndexHash || index.Column != "email" {
+				t.Errorf("users_email = %+v", index)
+			}
+			ids, ok := loaded.Indexes["users_age"].Range(&database.Bound{Value: "20"}, nil)
+			if !ok || len(ids) != 1 || ids[0] != 1 {
+				t.Errorf("rebuilt users_age Range() = %v, %v, want [1]", ids, ok)
+			}
+		})
+	}
+}
+
+func TestStorage_WriteAheadLog(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+			defer storage.Close()
+
+			table := database.NewTableFromColumns("users", []database.Column{
+				{Name: "name", Type: database.TypeText},
+				{Name: "age", Type: database.TypeInt},
+			})
+			if err := storage.Log(CreateTableChange(table)); err != nil {
+				t.Fatalf("Log() error = %v", err)
+			}
+			if err := storage.Log(
+				PutChange("users", 1, database.Record{"name": "a", "age": "20"}),
+				PutChange("users", 2, database.Record{"name": "b", "age": "30"}),
+				PutChange("users", 3, database.Record{"name": "c", "age": "40"}),
+			); err != nil {
+				t.Fatalf("Log() error = %v", err)
+			}
+			index := database.NewIndex("users_age", "age", database.IndexOrdered, database.TypeInt)
+			if err := storage.Log(DeleteChange("users", 2), CreateIndexChange("users", index)); err != nil {
+				t.Fatalf("Log() error = %v", err)
+			}
+
+			if !storage.TableExist("users") {
+				t.Error("TableExist() = false for a table created in the log")
+			}
+			if tables, _ := storage.ListTables(); len(tables) != 1 || tables[0] != "users" {
+				t.Errorf("ListTables() = %v, want [users]", tables)
+			}
+			loaded, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+			if len(loaded.Records) != 2 || loaded.NextID != 4 || loaded.Column("age").Type != database.TypeInt {
+				t.Errorf("LoadTable() = %v records, NextID %d", loaded.Records, loaded.NextID)
+			}
+			if ids, _ := loaded.Indexes["users_age"].Lookup("40"); len(ids) != 1 || ids[0] != 3 {
+				t.Errorf("replayed index Lookup() = %v, want [3]", ids)
+			}
+
+			t.Run("torn batch is ignored and cut off", func(t *testing.T) {
+				if err := storage.Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+				torn := `00000000 [{"op":"delete","table":"users","id":1}`
+				if storage.memLog != nil {
+					_, _ = storage.memLog.Write([]byte(torn))
+				} else {
+					file, err := os.OpenFile(filepath.Join(tempDir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+					if err != nil {
+						t.Fatalf("OpenFile() error = %v", err)
+					}
+					_, _ = file.WriteString(torn)
+					_ = file.Close()
+				}
+
+				loaded, err := storage.LoadTable("users")
+				if err != nil {
+					t.Fatalf("LoadTable() error = %v", err)
+				}
+				if _, exist := loaded.Records[1]; !exist {
+					t.Error("torn batch was applied")
+				}
+
+				if err := storage.Log(DeleteChange("users", 3)); err != nil {
+					t.Fatalf("Log() error = %v", err)
+				}
+				loaded, err = storage.LoadTable("users")
+				if err != nil {
+					t.Fatalf("LoadTable() error = %v", err)
+				}
+				if _, exist := loaded.Records[3]; exist || len(loaded.Records) != 1 {
+					t.Errorf("records after appending past a torn batch = %v", loaded.Records)
+				}
+			})
+
+			t.Run("replay over a newer snapshot", func(t *testing.T) {
+				loaded, err := storage.LoadTable("users")
+				if err != nil {
+					t.Fatalf("LoadTable() error = %v", err)
+				}
+				if err := storage.SaveTable(loaded); err != nil {
+					t.Fatalf("SaveTable() error = %v", err)
+				}
+
+				snapshot, err := storage.LoadSnapshot("users")
+				if err != nil {
+					t.Fatalf("LoadSnapshot() error = %v", err)
+				}
+				tables := map[string]*database.Table{"users": snapshot}
+				if err := storage.Replay(tables); err != nil {
+					t.Fatalf("Replay() error = %v", err)
+				}
+				if len(tables["users"].Records) != len(loaded.Records) {
+					t.Errorf("Replay() records = %v, want %v", tables["users"].Records, loaded.Records)
+				}
+
+				if err := storage.TruncateLog(); err != nil {
+					t.Fatalf("TruncateLog() error = %v", err)
+				}
+				if size := storage.LogSize(); size != 0 {
+					t.Errorf("LogSize() after TruncateLog() = %d, want 0", size)
+				}
+				if !storage.TableExist("users") {
+					t.Error("table lost after checkpoint")
+				}
+			})
+		})
+	}
+}
+
+type failingWriter struct {
+	w     io.Writer
+	limit int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.limit {
+		n, _ := f.w.Write(p[:f.limit])
+		f.limit = 0
+		return n, errors.New("диск переполнен")
+	}
+	f.limit -= len(p)
+	return f.w.Write(p)
+}
+
+func TestStorage_AtomicSave(t *testing.T) {
+	for _, engine := range fileEngines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			table := database.NewTable("users", []string{"name"})
+			table.Records[1] = database.Record{"name": "old"}
+			if err := storage.SaveTable(table); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+
+			updated := database.NewTable("users", []string{"name", "email"})
+			for id := 1; id <= 100; id++ {
+				updated.Records[id] = database.Record{"name": "new", "email": "new@mail.ru"}
+			}
+
+			failures := []struct {
+				name    string
+				install func()
+			}{
+				{"write fails immediately", func() {
+					wrapTempWriter = func(name string, w io.Writer) io.Writer { return &failingWriter{w: w} }
+				}},
+				{"table write fails midway", func() {
+					wrapTempWriter = func(name string, w io.Writer) io.Writer {
+						if !strings.HasSuffix(name, schemaSuffix) {
+							return &failingWriter{w: w, limit: 50}
+						}
+						return w
+					}
+				}},
+				{"sync fails", func() {
+					syncFile = func(*os.File) error { return errors.New("ошибка ввода-вывода") }
+				}},
+			}
+			for _, tt := range failures {
+				t.Run(tt.name, func(t *testing.T) {
+					defaultWriter, defaultSync := wrapTempWriter, syncFile
+					defer func() { wrapTempWriter, syncFile = defaultWriter, defaultSync }()
+					tt.install()
+
+					if err := storage.SaveTable(updated); err == nil {
+						t.Fatal("SaveTable() expected error")
+					}
+					loaded, err := storage.LoadTable("users")
+					if err != nil {
+						t.Fatalf("LoadTable() error = %v", err)
+					}
+					if len(loaded.Records) != 1 || loaded.Records[1]["name"] != "old" || len(loaded.Fields) != 1 {
+						t.Errorf("previous contents lost: fields %v, records %v", loaded.Fields, loaded.Records)
+					}
+					matches, _ := filepath.Glob(filepath.Join(tempDir, "*"+tempSuffix))
+					if len(matches) != 0 {
+						t.Errorf("temp files left behind: %v", matches)
+					}
+				})
+			}
+
+			if err := storage.SaveTable(updated); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+			loaded, err := storage.LoadTable("users")
+			if err != nil {
+				t.Fatalf("LoadTable() error = %v", err)
+			}
+			if len(loaded.Records) != 100 {
+				t.Errorf("LoadTable() records = %d, want 100", len(loaded.Records))
+			}
+		})
+	}
+}
+
+func TestStorage_RemoveTempFiles(t *testing.T) {
+	for _, engine := range fileEngines {
+		t.Run(engine.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			storage := engine.open(tempDir)
+
+			if err := storage.SaveTable(database.NewTable("users", []string{"name"})); err != nil {
+				t.Fatalf("SaveTable() error = %v", err)
+			}
+			stray := filepath.Join(tempDir, "users.123456"+tempSuffix)
+			if err := os.WriteFile(stray, []byte("id,na"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			if tables, _ := storage.ListTables(); len(tables) != 1 {
+				t.Errorf("ListTables() = %v, want only users", tables)
+			}
+			removed, err := storage.RemoveTempFiles()
+			if err != nil {
+				t.Fatalf("RemoveTempFiles() error = %v", err)
+			}
+			if len(removed) != 1 || removed[0] != filepath.Base(stray) {
+				t.Errorf("RemoveTempFiles() = %v, want [%s]", removed, filepath.Base(stray))
+			}
+			if _, err := os.Stat(stray); !os.IsNotExist(err) {
+				t.Error("stray temp file still exists")
+			}
+			if !storage.TableExist("users") {
+				t.Error("RemoveTempFiles() removed the table")
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"v4/database"
+)
+
+const walFile = "wal.log"
+
+type ChangeOp string
+
+const (
+	OpCreateTable ChangeOp = "create_table"
+	OpPut         ChangeOp = "put"
+	OpDelete      ChangeOp = "delete"
+	OpCreateIndex ChangeOp = "create_index"
+	OpDropIndex   ChangeOp = "drop_index"
+	OpAlterTable  ChangeOp = "alter_table"
+	OpRenameTable ChangeOp = "rename_table"
+	OpDropTable   ChangeOp = "drop_table"
+	OpTruncate    ChangeOp = "truncate"
+)
+
+// Change is a single logged mutation. Every change sets its target to an
+// absolute state, so replaying the log over a newer snapshot is harmless.
+type Change struct {
+	Op     ChangeOp        `json:"op"`
+	Table  string          `json:"table"`
+	ID     int             `json:"id,omitempty"`
+	Record database.Record `json:"record,omitempty"`
+	Schema *tableSchema    `json:"schema,omitempty"`
+	Index  *indexSchema    `json:"index,omitempty"`
+
+	NewName string            `json:"new_name,omitempty"`
+	Renamed map[string]string `json:"renamed,omitempty"`
+}
+
+func CreateTableChange(table *database.Table) Change {
+	schema := newTableSchema(table)
+	return Change{Op: OpCreateTable, Table: table.Name, Schema: &schema}
+}
+
+func PutChange(table string, id int, record database.Record) Change {
+	return Change{Op: OpPut, Table: table, ID: id, Record: record}
+}
+
+func DeleteChange(table string, id int) Change {
+	return Change{Op: OpDelete, Table: table, ID: id}
+}
+
+func CreateIndexChange(table string, index *database.Index) Change {
+	return Change{Op: OpCreateIndex, Table: table, Index: &indexSchema{
+		Name:   index.Name,
+		Column: index.Column,
+		Kind:   index.Kind,
+	}}
+}
+
+func DropIndexChange(table, name string) Change {
+	return Change{Op: OpDropIndex, Table: table, Index: &indexSchema{Name: name}}
+}
+
+// AlterTableChange records the new columns of a table; renamed maps old
+// column names to new ones so that values follow their column.
+func AlterTableChange(table *database.Table, renamed map[string]string) Change {
+	schema := newTableSchema(table)
+	return Change{Op: OpAlterTable, Table: table.Name, Schema: &schema, Renamed: renamed}
+}
+
+func RenameTableChange(table, newName string) Change {
+	return Change{Op: OpRenameTable, Table: table, NewName: newName}
+}
+
+func DropTableChange(table string) Change {
+	return Change{Op: OpDropTable, Table: table}
+}
+
+func TruncateChange(table string) Change {
+	return Change{Op: OpTruncate, Table: table}
+}
+
+// logFile is the open write-ahead log: an *os.File or a memoryLog.
+type logFile interface {
+	io.Writer
+	Truncate(size int64) error
+	Seek(offset int64, whence int) (int64, error)
+	Sync() error
+	Close() error
+}
+
+func (s *Storage) walPath() string {
+	return s.BasePath + "/" + walFile
+}
+
+// Log appends the changes to the write-ahead log as one batch and syncs
+// it to disk. A batch is a single line with a checksum, so a torn write
+// loses the whole batch and never half of it.
+func (s *Storage) Log(changes ...Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	if err := s.openLog(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	if _, err := io.WriteString(s.wal, line); err != nil {
+		return err
+	}
+	return s.wal.Sync()
+}
+
+// openLog opens the log for appending and cuts off a torn last batch.
+func (s *Storage) openLog() error {
+	if s.wal != nil {
+		return nil
+	}
+	_, valid, err := s.readLog()
+	if err != nil {
+		return err
+	}
+	var file logFile = s.memLog
+	if s.memLog == nil {
+		if file, err = os.OpenFile(s.walPath(), os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return err
+		}
+	}
+	if err := file.Truncate(valid); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if _, err := file.Seek(valid, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.wal = file
+	return nil
+}
+
+// readLog returns the changes of all complete batches and the length of
+// the log prefix that holds them.
+func (s *Storage) readLog() ([]Change, int64, error) {
+	if s.memLog != nil {
+		return readBatches(bytes.NewReader(s.memLog.data))
+	}
+	file, err := os.Open(s.walPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	return readBatches(file)
+}
+
+func readBatches(r io.Reader) ([]Change, int64, error) {
+	var changes []Change
+	var valid int64
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return changes, valid, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		batch, ok := decodeBatch(line)
+		if !ok {
+			return changes, valid, nil
+		}
+		changes = append(changes, batch...)
+		valid += int64(len(line))
+	}
+}
+
+func decodeBatch(line []byte) ([]Change, bool) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, data, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return nil, false
+	}
+	var checksum uint32
+	if _, err := fmt.Sscanf(string(sum), "%08x", &checksum); err != nil || checksum != crc32.ChecksumIEEE(data) {
+		return nil, false
+	}
+	var batch []Change
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, false
+	}
+	return batch, true
+}
+
+// Replay applies the logged changes to tables loaded from snapshots.
+func (s *Storage) Replay(tables map[string]*database.Table) error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	changes, _, err := s.readLog()
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := applyChange(tables, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyChange(tables map[string]*database.Table, change Change) error {
+	if change.Op == OpCreateTable {
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return err
+		}
+		table := database.NewTableFromColumns(change.Table, columns)
+		addIndexes(table, change.Schema.Indexes)
+		tables[change.Table] = table
+		return nil
+	}
+
+	table, exist := tables[change.Table]
+	if !exist {
+		return nil
+	}
+	switch change.Op {
+	case OpPut:
+		table.SetRecord(change.ID, change.Record)
+		if change.ID >= table.NextID {
+			table.NextID = change.ID + 1
+		}
+	case OpDelete:
+		table.DeleteRecord(change.ID)
+	case OpCreateIndex:
+		addIndexes(table, []indexSchema{*change.Index})
+	case OpDropIndex:
+		table.RemoveIndex(change.Index.Name)
+	case OpAlterTable:
+		columns, err := change.Schema.columns()
+		if err != nil {
+			return err
+		}
+		tables[change.Table] = table.Migrate(columns, change.Renamed)
+	case OpRenameTable:
+		table.Name = change.NewName
+		delete(tables, change.Table)
+		tables[change.NewName] = table
+	case OpDropTable:
+		delete(tables, change.Table)
+	case OpTruncate:
+		table.Truncate()
+	default:
+		return fmt.Errorf("неизвестная операция журнала %s", change.Op)
+	}
+	return nil
+}
+
+// snapshotOf follows renames in the log back to the snapshot that holds
+// the records of table name. An empty result means the snapshot does not
+// matter: the table was created or dropped in the log.
+func snapshotOf(name string, changes []Change) string {
+	for i := len(changes) - 1; i >= 0; i-- {
+		switch change := changes[i]; {
+		case change.Op == OpRenameTable && change.NewName == name:
+			name = change.Table
+		case (change.Op == OpCreateTable || change.Op == OpDropTable) && change.Table == name:
+			return ""
+		}
+	}
+	return name
+}
+
+// tableNames lists the tables that exist once the log is applied to the
+// snapshots.
+func (s *Storage) tableNames() ([]string, error) {
+	names, err := s.Engine.List()
+	if err != nil {
+		return nil, err
+	}
+	changes, _, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		switch change.Op {
+		case OpCreateTable:
+			names = append(removeName(names, change.Table), change.Table)
+		case OpRenameTable:
+			names = append(removeName(names, change.Table), change.NewName)
+		case OpDropTable:
+			names = removeName(names, change.Table)
+		}
+	}
+	return names, nil
+}
+
+func removeName(names []string, name string) []string {
+	for i, existing := range names {
+		if existing == name {
+			return append(names[:i], names[i+1:]...)
+		}
+	}
+	return names
+}
+
+func (s *Storage) LogSize() int64 {
+	if s.memLog != nil {
+		s.Mu.Lock()
+		defer s.Mu.Unlock()
+		return int64(len(s.memLog.data))
+	}
+	info, err := os.Stat(s.walPath())
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// TruncateLog empties the log once every table has been saved.
+func (s *Storage) TruncateLog() error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	return s.truncateLog()
+}
+
+// ApplyLog hands the logged changes to an engine that applies them in
+// place and empties the log. It reports false, doing nothing, for other
+// engines, whose tables have to be saved whole.
+func (s *Storage) ApplyLog() (bool, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	engine, ok := s.Engine.(ChangeEngine)
+	if !ok {
+		return false, nil
+	}
+	changes, _, err := s.readLog()
+	if err != nil {
+		return true, err
+	}
+	if err := engine.Apply(changes); err != nil {
+		return true, err
+	}
+	return true, s.truncateLog()
+}
+
+func (s *Storage) truncateLog() error {
+	if err := s.openLog(); err != nil {
+		return err
+	}
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return s.wal.Sync()
+}
+
+// Close closes the log and an engine that holds open files.
+func (s *Storage) Close() error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	var err error
+	if closer, ok := s.Engine.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if s.wal == nil {
+		return err
+	}
+	if closeErr := s.wal.Close(); err == nil {
+		err = closeErr
+	}
+	s.wal = nil
+	return err
+}